@@ -0,0 +1,118 @@
+package statsd
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// adaptive samples each key to a target number of metrics per interval
+type adaptive struct {
+	target   float64
+	interval time.Duration
+	minRate  float64
+	maxRate  float64
+	maxKeys  int
+	mu       sync.Mutex
+	keys     map[string]*list.Element
+	lru      *list.List // of *adaptiveKey, the most recently used first
+}
+
+// adaptiveKey is the traffic observed for a key
+type adaptiveKey struct {
+	stat   string
+	start  time.Time // of the current interval
+	count  float64   // metrics in the current interval
+	recent float64   // moving average of the metrics per interval, 0 until the first one ends
+}
+
+// SetAdaptiveSampling makes the client sample every counter and timing key so
+// that about target metrics are sent per interval: the rate of each key is
+// tracked, and the sample rate applied (and reported with |@) is adjusted to
+// it within [minRate, maxRate]. The traffic of at most maxKeys keys is
+// tracked, the least recently used ones are forgotten. It takes precedence
+// over SetSampleRate, a target <= 0 disables it
+func (c *StatsdClient) SetAdaptiveSampling(target int, interval time.Duration, minRate float64, maxRate float64, maxKeys int) {
+	if target <= 0 || interval <= 0 {
+		c.adaptive.Store((*adaptive)(nil))
+		return
+	}
+	if maxRate <= 0 || maxRate > 1 {
+		maxRate = 1
+	}
+	if minRate > maxRate {
+		minRate = maxRate
+	}
+	if maxKeys <= 0 {
+		maxKeys = 1
+	}
+	c.adaptive.Store(&adaptive{
+		target:   float64(target),
+		interval: interval,
+		minRate:  minRate,
+		maxRate:  maxRate,
+		maxKeys:  maxKeys,
+		keys:     make(map[string]*list.Element),
+		lru:      list.New(),
+	})
+}
+
+// rate records a metric of stat, and returns the sample rate to apply to it
+func (a *adaptive) rate(stat string, now time.Time) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var k *adaptiveKey
+	if el, ok := a.keys[stat]; ok {
+		a.lru.MoveToFront(el)
+		k = el.Value.(*adaptiveKey)
+	} else {
+		k = &adaptiveKey{stat: stat, start: now}
+		a.keys[stat] = a.lru.PushFront(k)
+		if a.lru.Len() > a.maxKeys {
+			oldest := a.lru.Back()
+			a.lru.Remove(oldest)
+			delete(a.keys, oldest.Value.(*adaptiveKey).stat)
+		}
+	}
+	if elapsed := now.Sub(k.start); elapsed >= a.interval {
+		// the metrics per interval, for the time actually elapsed
+		observed := k.count * float64(a.interval) / float64(elapsed)
+		if k.recent == 0 {
+			k.recent = observed
+		} else {
+			k.recent = (k.recent + observed) / 2
+		}
+		k.start, k.count = now, 0
+	}
+	k.count++
+	// react right away to a burst above the average
+	expected := k.recent
+	if k.count > expected {
+		expected = k.count
+	}
+	rate := a.target / expected
+	if rate > a.maxRate {
+		rate = a.maxRate
+	}
+	if rate < a.minRate {
+		rate = a.minRate
+	}
+	return rate
+}
+
+// sampleAdaptive is sample for the adaptive sampling
+func (c *StatsdClient) sampleAdaptive(a *adaptive, kind MetricKind, stat string) (string, bool) {
+	rate := a.rate(stat, c.now())
+	if rate >= 1 {
+		return "", true
+	}
+	if c.randomFloat() >= rate {
+		atomic.AddInt64(&c.sampledOut[kind], 1)
+		c.count(kind, outcomeDropped, 1)
+		return "", false
+	}
+	return string(wire.AppendSampleRate(nil, rate)), true
+}
@@ -0,0 +1,98 @@
+package statsd
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestAdaptiveSampling(t *testing.T) {
+	for _, perSecond := range []int{10, 100000} {
+		client, conn := newPacketClient(t, "")
+		clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+		client.SetClock(clock)
+		client.random = rand.New(rand.NewSource(1)).Float64
+		const target = 5
+		client.SetAdaptiveSampling(target, time.Second, 0.00001, 1, 10)
+
+		const seconds = 10
+		step := time.Second / time.Duration(perSecond)
+		sent := make([]int, seconds)
+		var scaled float64
+		for s := 0; s < seconds; s++ {
+			conn.packets = nil
+			for i := 0; i < perSecond; i++ {
+				client.Incr("c", 1)
+				clock.Advance(step)
+			}
+			sent[s] = len(conn.packets)
+			for _, p := range conn.packets {
+				rate := 1.0
+				if i := strings.Index(p, "|@"); i >= 0 {
+					rate, _ = strconv.ParseFloat(p[i+2:], 64)
+				}
+				if s > 1 {
+					scaled += 1 / rate
+				}
+			}
+		}
+		// the first interval is spent learning the rate
+		total := 0
+		for _, n := range sent[2:] {
+			total += n
+		}
+		if avg := float64(total) / (seconds - 2); avg < target/2 || avg > target*2 {
+			t.Errorf("%d/s: %.1f metrics sent per second, expected about %d (%v)", perSecond, avg, target, sent)
+		}
+		// the server scales the samples back to the actual traffic
+		if actual := scaled / (seconds - 2); actual < float64(perSecond)/2 || actual > float64(perSecond)*2 {
+			t.Errorf("%d/s: traffic estimated by the server %.0f/s", perSecond, actual)
+		}
+	}
+}
+
+func TestAdaptiveSamplingBounds(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	client.SetAdaptiveSampling(1, time.Second, 0.5, 1, 2)
+
+	// the sample rate never goes below the minimum
+	for i := 0; i < 100; i++ {
+		client.Incr("c", 1)
+	}
+	for _, p := range conn.packets[1:] {
+		if !strings.HasSuffix(p, "|@0.5") {
+			t.Fatalf("expected the minimum rate, actual %q", p)
+		}
+	}
+	// gauges are never sampled
+	conn.packets = nil
+	for i := 0; i < 100; i++ {
+		client.Gauge("g", 1)
+	}
+	if len(conn.packets) != 100 {
+		t.Errorf("gauges sampled: %d sent", len(conn.packets))
+	}
+
+	// the least recently used keys are forgotten
+	client.Incr("a", 1)
+	client.Incr("b", 1)
+	a, _ := client.adaptive.Load().(*adaptive)
+	if len(a.keys) != 2 || a.keys["c"] != nil || a.lru.Len() != 2 {
+		t.Errorf("expected only a and b to be tracked, actual %v", a.keys)
+	}
+
+	client.SetAdaptiveSampling(0, time.Second, 0, 1, 0)
+	conn.packets = nil
+	for i := 0; i < 10; i++ {
+		client.Incr("c", 1)
+	}
+	if len(conn.packets) != 10 {
+		t.Errorf("sampling not disabled: %d sent", len(conn.packets))
+	}
+}
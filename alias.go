@@ -0,0 +1,90 @@
+package statsd
+
+import "strings"
+
+// aliases is an immutable alias table, swapped atomically
+type aliases struct {
+	names    map[string]string
+	prefixes map[string]string // without the trailing wildcard
+	newOnly  bool
+}
+
+// AddAlias emits the metrics sent under oldName under newName as well, e.g. to
+// switch the dashboards gradually while migrating names: the buffered client
+// aggregates both names separately. An oldName ending with "*" matches all
+// the names with that prefix, which is replaced by newName without its
+// trailing "*": with AddAlias("api.v1.*", "http.*") api.v1.requests is also
+// emitted as http.requests. The longest matching prefix wins. The names are
+// matched as given by the application, before the mapper (see SetNameMapper).
+// It doesn't apply to the events sent with SendEvent and SendEvents
+func (c *StatsdClient) AddAlias(oldName string, newName string) {
+	c.updateAliases(func(a *aliases) {
+		if strings.HasSuffix(oldName, "*") {
+			a.prefixes[strings.TrimSuffix(oldName, "*")] = strings.TrimSuffix(newName, "*")
+		} else {
+			a.names[oldName] = newName
+		}
+	})
+}
+
+// RemoveAlias stops emitting the metrics sent under oldName (as given to
+// AddAlias) under the new name
+func (c *StatsdClient) RemoveAlias(oldName string) {
+	c.updateAliases(func(a *aliases) {
+		if strings.HasSuffix(oldName, "*") {
+			delete(a.prefixes, strings.TrimSuffix(oldName, "*"))
+		} else {
+			delete(a.names, oldName)
+		}
+	})
+}
+
+// SetAliasesNewOnly switches the aliased metrics to their new name only, once
+// the migration is complete: they aren't emitted under the old name anymore
+func (c *StatsdClient) SetAliasesNewOnly(newOnly bool) {
+	c.updateAliases(func(a *aliases) {
+		a.newOnly = newOnly
+	})
+}
+
+// updateAliases atomically replaces the alias table with a modified copy
+func (c *StatsdClient) updateAliases(update func(a *aliases)) {
+	c.aliasMu.Lock()
+	defer c.aliasMu.Unlock()
+	a := &aliases{names: make(map[string]string), prefixes: make(map[string]string)}
+	if old, _ := c.aliases.Load().(*aliases); old != nil {
+		for k, v := range old.names {
+			a.names[k] = v
+		}
+		for k, v := range old.prefixes {
+			a.prefixes[k] = v
+		}
+		a.newOnly = old.newOnly
+	}
+	update(a)
+	c.aliases.Store(a)
+}
+
+// aliasNames returns the names a stat is emitted under, or nil if it has no alias
+func (c *StatsdClient) aliasNames(stat string) []string {
+	a, _ := c.aliases.Load().(*aliases)
+	if a == nil {
+		return nil
+	}
+	alias, ok := a.names[stat]
+	if !ok {
+		longest := ""
+		for from, to := range a.prefixes {
+			if strings.HasPrefix(stat, from) && (!ok || len(from) > len(longest)) {
+				longest, alias, ok = from, to+stat[len(from):], true
+			}
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if a.newOnly {
+		return []string{alias}
+	}
+	return []string{stat, alias}
+}
@@ -0,0 +1,117 @@
+package statsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+func TestAlias(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.AddAlias("old.requests", "new.requests")
+	client.AddAlias("api.v1.*", "http.*")
+	client.AddAlias("api.v1.slow.*", "slow.*")
+
+	client.Incr("old.requests", 1)
+	client.Gauge("api.v1.queue", -2)
+	client.Timing("api.v1.slow.db", 3)
+	client.IncrMap(map[string]int64{"old.requests": 4})
+	client.Incr("other", 5)
+	client.RemoveAlias("old.requests")
+	client.Incr("old.requests", 6)
+	client.SetAliasesNewOnly(true)
+	client.Incr("api.v1.calls", 7)
+
+	expected := []string{
+		"myproject.old.requests:1|c",
+		"myproject.new.requests:1|c",
+		"myproject.api.v1.queue:0|g",
+		"myproject.api.v1.queue:-2|g",
+		"myproject.http.queue:0|g",
+		"myproject.http.queue:-2|g",
+		"myproject.api.v1.slow.db:3|ms",
+		"myproject.slow.db:3|ms",
+		"myproject.old.requests:4|c\nmyproject.new.requests:4|c",
+		"myproject.other:5|c",
+		"myproject.old.requests:6|c",
+		"myproject.http.calls:7|c",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestBufferAlias(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.AddAlias("old.*", "new.*")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("old.requests", 1)
+	buffered.Incr("old.requests", 2)
+	buffered.Timing("old.latency", 10)
+	buffered.Timing("old.latency", 20)
+	buffered.IncrMap(map[string]int64{"old.batch": 3})
+	buffered.Close()
+
+	expected := []string{
+		"myproject.new.batch:3|c\n" +
+			"myproject.new.latency.avg:15|a\nmyproject.new.latency.min:10|a\nmyproject.new.latency.max:20|a\n" +
+			"myproject.new.requests:3|c\n" +
+			"myproject.old.batch:3|c\n" +
+			"myproject.old.latency.avg:15|a\nmyproject.old.latency.min:10|a\nmyproject.old.latency.max:20|a\n" +
+			"myproject.old.requests:3|c",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestBufferAliasRemoved(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.AddAlias("old", "new")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("old", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 2 })
+	client.RemoveAlias("old")
+	buffered.Incr("old", 2)
+	buffered.Close()
+
+	// the events aggregated before the removal are still flushed
+	expected := []string{"myproject.new:1|c\nmyproject.old:3|c"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+// opaqueEvent hides the Copy method of the event it wraps
+type opaqueEvent struct {
+	event.Event
+}
+
+func TestBufferAliasNotCopier(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.AddAlias("old", "new")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	logger := &recordingLogger{}
+	buffered.Logger = logger
+	buffered.SendEvent(opaqueEvent{&event.Increment{Name: "old", Value: 1}})
+	buffered.Incr("old", 2)
+	buffered.Close()
+
+	expected := []string{"myproject.new:2|c\nmyproject.old:3|c"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+	if n := buffered.Stats().DroppedAliases; n != 1 {
+		t.Errorf("expected 1 alias dropped, actual %d", n)
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if !strings.Contains(strings.Join(logger.lines, ""), "Dropping the alias new of old") {
+		t.Errorf("the drop wasn't logged: %q", logger.lines)
+	}
+}
@@ -0,0 +1,29 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// incrAllocBudget is the maximum number of allocations of an Incr on the plain
+// client: raise it only deliberately, see the benchmarks in internal/benchmarks
+const incrAllocBudget = 1
+
+func TestIncrAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not measurable with the race detector")
+	}
+	client := NewStatsdClient("discard:8125", "myproject.")
+	client.SetDialer((&statsdtest.DiscardSender{}).Dial)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	allocs := testing.AllocsPerRun(1000, func() {
+		client.Incr("requests", 1)
+	})
+	if allocs > incrAllocBudget {
+		t.Errorf("Incr allocates %v times per call, the budget is %d", allocs, incrAllocBudget)
+	}
+}
@@ -0,0 +1,102 @@
+package statsd
+
+import (
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// modulePath is the path of the module of the package in the build info
+const modulePath = "github.com/CrowdSurge/statsd"
+
+// Version is the version of the package, reported by SetAnnounceOnStart. It's
+// taken from the build info of the binary, or "devel" when the package isn't
+// built as a versioned module dependency, e.g. from a checkout
+var Version = buildVersion(debug.ReadBuildInfo())
+
+// buildVersion returns the version of the module of the package in info,
+// without the leading v
+func buildVersion(info *debug.BuildInfo, ok bool) string {
+	if !ok {
+		return "devel"
+	}
+	version := ""
+	if info.Main.Path == modulePath {
+		version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			version = dep.Version
+		}
+	}
+	if version == "" || version == "(devel)" {
+		return "devel"
+	}
+	return strings.TrimPrefix(version, "v")
+}
+
+// announceName is the name of the gauge sent by SetAnnounceOnStart
+const announceName = "statsd.client.info"
+
+// SetAnnounceOnStart makes the client send, every time it connects to a
+// server, a gauge describing itself, e.g. to find the processes running an old
+// version across a fleet. Plain StatsD has no tags, so the description is
+// encoded into the name, after the prefix:
+//
+//	statsd.client.info.version.1_6_0.transport.udp.format.statsd.mode.buffered:1|g
+//
+// It's sent on the first connection, when SetAddress switches to another
+// server, and when a unixstream connection is reestablished, but not when
+// CreateSocket replaces a live connection. It must be called before CreateSocket
+func (c *StatsdClient) SetAnnounceOnStart(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&c.announce, v)
+}
+
+// announcement returns the line announcing the client to the server of t,
+// or nil if SetAnnounceOnStart isn't enabled
+func (c *StatsdClient) announcement(t Target) []byte {
+	if atomic.LoadInt32(&c.announce) == 0 {
+		return nil
+	}
+	format, mode := "statsd", "direct"
+	if c.isGraphite() {
+		format = "graphite"
+	}
+	if atomic.LoadInt32(&c.buffered) != 0 {
+		mode = "buffered"
+	}
+	// in a fixed order rather than sorted, see withPairs
+	name := pairsName(announceName, []event.Tag{
+		{Key: "version", Value: Version}, {Key: "transport", Value: t.Scheme},
+		{Key: "format", Value: format}, {Key: "mode", Value: mode},
+	})
+	prefix, _ := c.currentPrefix()
+	line := []byte(Escape(FieldName, prefix+name))
+	if c.isGraphite() {
+		line = append(append(line, " 1 "...), strconv.FormatInt(c.now().Unix(), 10)...)
+		return append(line, '\n')
+	}
+	return append(line, ":1|g"...)
+}
+
+// announceTo writes the announcement to a connection to addr, just
+// established. It must be called with mu held, before the connection is used
+func (c *StatsdClient) announceTo(conn net.Conn, addr string) {
+	t, err := ParseAddr(addr)
+	if err != nil {
+		return
+	}
+	if line := c.announcement(t); line != nil {
+		if _, err := conn.Write(line); err != nil {
+			c.Logger.Println("Error announcing the client:", err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package statsd
+
+import (
+	"net"
+	"reflect"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// withVersion sets the version of the package for the duration of the test
+func withVersion(t *testing.T, version string) {
+	previous := Version
+	Version = version
+	t.Cleanup(func() { Version = previous })
+}
+
+func TestAnnounceOnStart(t *testing.T) {
+	withVersion(t, "1.6.0")
+	tests := []struct {
+		addr     string
+		graphite bool
+		buffered bool
+		expected string
+	}{
+		{addr: "localhost:8125",
+			expected: "myproject.statsd.client.info.version.1_6_0.transport.udp.format.statsd.mode.direct:1|g"},
+		{addr: "tcp://localhost:2003", graphite: true, buffered: true,
+			expected: "myproject.statsd.client.info.version.1_6_0.transport.tcp.format.graphite.mode.buffered 1 1000\n"},
+	}
+	for _, tt := range tests {
+		conn := &packetConn{}
+		client := NewStatsdClient(tt.addr, "myproject.")
+		client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return conn, nil
+		}
+		client.SetClock(statsdtest.NewFakeClock(time.Unix(1000, 0)))
+		client.SetGraphite(tt.graphite)
+		client.SetAnnounceOnStart(true)
+		if tt.buffered {
+			buffered := NewStatsdBuffer(time.Hour, client)
+			buffered.Logger = discardLogger{}
+			defer buffered.Close()
+		}
+		// replacing the live connection doesn't announce the client again
+		for i := 0; i < 3; i++ {
+			if err := client.CreateSocket(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if actual := conn.sent(); !reflect.DeepEqual([]string{tt.expected}, actual) {
+			t.Errorf("%s: expected %q, actual %q", tt.addr, tt.expected, actual)
+		}
+		// switching to another server does
+		if err := client.SetAddress(tt.addr); err != nil {
+			t.Fatal(err)
+		}
+		if actual := conn.sent(); !reflect.DeepEqual([]string{tt.expected, tt.expected}, actual) {
+			t.Errorf("%s: expected 2 announcements, actual %q", tt.addr, actual)
+		}
+	}
+
+	// disabled by default
+	client, conn := newPacketClient(t, "myproject.")
+	client.Incr("a", 1)
+	if actual := conn.sent(); !reflect.DeepEqual([]string{"myproject.a:1|c"}, actual) {
+		t.Errorf("unexpected packets %q", actual)
+	}
+}
+
+func TestAnnounceUnixStreamReconnect(t *testing.T) {
+	withVersion(t, "1.6.0")
+	srv := newStreamServer(t)
+	defer srv.ln.Close()
+
+	client := NewStatsdClient("unixstream://"+srv.ln.Addr().String(), "")
+	client.SetAnnounceOnStart(true)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	const announcement = "statsd.client.info.version.1_6_0.transport.unixstream.format.statsd.mode.direct:1|g"
+	if p := srv.next(t); p != announcement {
+		t.Errorf("unexpected payload %q", p)
+	}
+
+	// the agent restarts, dropping the connection
+	(<-srv.conns).Close()
+	time.Sleep(10 * time.Millisecond)
+	if err := client.Incr("after", 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{announcement, "after:1|c"} {
+		if p := srv.next(t); p != expected {
+			t.Errorf("expected %q, actual %q", expected, p)
+		}
+	}
+}
+
+func TestBuildVersion(t *testing.T) {
+	tests := []struct {
+		info     *debug.BuildInfo
+		ok       bool
+		expected string
+	}{
+		{expected: "devel"},
+		{info: &debug.BuildInfo{Main: debug.Module{Path: "example.com/app", Version: "v2.0.0"}}, ok: true, expected: "devel"},
+		{info: &debug.BuildInfo{
+			Main: debug.Module{Path: "example.com/app", Version: "v2.0.0"},
+			Deps: []*debug.Module{{Path: "example.com/lib", Version: "v0.1.0"}, {Path: modulePath, Version: "v1.7.2"}},
+		}, ok: true, expected: "1.7.2"},
+		{info: &debug.BuildInfo{Main: debug.Module{Path: modulePath, Version: "(devel)"}}, ok: true, expected: "devel"},
+		{info: &debug.BuildInfo{Main: debug.Module{Path: modulePath, Version: "v1.8.0-rc.1"}}, ok: true, expected: "1.8.0-rc.1"},
+	}
+	for _, tt := range tests {
+		if actual := buildVersion(tt.info, tt.ok); actual != tt.expected {
+			t.Errorf("expected %q, actual %q", tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// BackpressurePolicy selects what the buffered client does with a flush when
+// the client it wraps can't keep up
+type BackpressurePolicy int
+
+const (
+	// DelayFlush skips the flush and keeps aggregating until the next interval,
+	// which is safe for counters, timings and totals
+	DelayFlush BackpressurePolicy = iota
+	// DropGauges skips the flush like DelayFlush, and drops the pending gauges,
+	// which would be overwritten by newer values anyway
+	DropGauges
+)
+
+// backpressure is an immutable backpressure configuration, swapped atomically
+type backpressure struct {
+	highWater int
+	policy    BackpressurePolicy
+}
+
+// BufferStats is a snapshot of the internal counters of a StatsdBuffer
+type BufferStats struct {
+	Pending        int64 // aggregated events waiting for the next flush
+	DelayedFlushes int64 // flushes skipped because of backpressure, or a flush still in progress
+	DroppedGauges  int64 // gauges dropped by the DropGauges policy
+	DroppedOnClose int64 // events of the final flush dropped at the close deadline
+	DroppedAliases int64 // aliases of the events which can't be copied, see AddAlias
+	// intervals whose failed events are currently retained, see SetMaxRetainedIntervals
+	CarriedIntervals int64
+	// time of the last successful write of the client, see LastSendTime
+	LastSend time.Time
+	// metrics dropped because the queue was full, per policy (see SetQueuePolicy)
+	QueueDrops map[QueuePolicy]int64
+	// metrics dropped because the queue was full, per priority (see WithPriority)
+	PriorityDrops map[Priority]int64
+	// time spent serializing and sending the last flush, see FlushReport
+	LastFlushDuration time.Duration
+	// how late the last tick of the flush interval came, and the highest
+	// skew so far, e.g. under CPU starvation (see SetTelemetry)
+	LastFlushSkew time.Duration
+	MaxFlushSkew  time.Duration
+}
+
+// QueueDepth returns the number of payloads waiting to be sent: with retries
+// enabled (see EnableRetry), the payloads whose write failed
+func (c *StatsdClient) QueueDepth() int {
+	return c.Stats().RetryPending
+}
+
+// SetBackpressure makes the buffered client check, before every flush, the
+// QueueDepth of the client it wraps: at or above highWater, the flush is handled
+// according to the policy instead of queueing more payloads. The skipped flushes
+// are counted in Stats(). A highWater <= 0 disables the check
+func (sb *buffer) SetBackpressure(highWater int, policy BackpressurePolicy) {
+	if highWater <= 0 {
+		sb.backpressure.Store((*backpressure)(nil))
+		return
+	}
+	sb.backpressure.Store(&backpressure{highWater: highWater, policy: policy})
+}
+
+// Stats returns a snapshot of the buffer's internal counters
+func (sb *buffer) Stats() BufferStats {
+	stats := BufferStats{
+		Pending:          atomic.LoadInt64(&sb.pending),
+		DelayedFlushes:   atomic.LoadInt64(&sb.delayedFlushes),
+		DroppedGauges:    atomic.LoadInt64(&sb.droppedGauges),
+		DroppedOnClose:   atomic.LoadInt64(&sb.droppedOnClose),
+		DroppedAliases:   atomic.LoadInt64(&sb.droppedAliases),
+		CarriedIntervals: atomic.LoadInt64(&sb.carried),
+		LastSend:         sb.statsd.LastSendTime(),
+		QueueDrops:       make(map[QueuePolicy]int64),
+		PriorityDrops:    make(map[Priority]int64),
+	}
+	stats.LastFlushDuration = time.Duration(atomic.LoadInt64(&sb.lastFlushDuration))
+	stats.LastFlushSkew = time.Duration(atomic.LoadInt64(&sb.lastSkew))
+	stats.MaxFlushSkew = time.Duration(atomic.LoadInt64(&sb.maxSkew))
+	for policy := range sb.queueDrops {
+		if n := atomic.LoadInt64(&sb.queueDrops[policy]); n > 0 {
+			stats.QueueDrops[QueuePolicy(policy)] = n
+		}
+	}
+	for priority := range sb.priorityDrops {
+		if n := atomic.LoadInt64(&sb.priorityDrops[priority]); n > 0 {
+			stats.PriorityDrops[Priority(priority)] = n
+		}
+	}
+	return stats
+}
+
+// LastSendTime returns the time of the last successful write of the client
+// wrapped, see StatsdClient.LastSendTime
+func (sb *buffer) LastSendTime() time.Time {
+	return sb.statsd.LastSendTime()
+}
+
+// backpressured tells whether the flush must be skipped, applying the policy.
+// It's only called from within the collector
+func (sb *buffer) backpressured() bool {
+	bp, _ := sb.backpressure.Load().(*backpressure)
+	if bp == nil || sb.statsd.QueueDepth() < bp.highWater {
+		return false
+	}
+	atomic.AddInt64(&sb.delayedFlushes, 1)
+	if bp.policy == DropGauges {
+		for k, e := range sb.events {
+			if kindOf(e) == KindGauge {
+				delete(sb.events, k)
+				atomic.AddInt64(&sb.droppedGauges, 1)
+			}
+		}
+		atomic.StoreInt64(&sb.pending, int64(len(sb.events)))
+	}
+	return true
+}
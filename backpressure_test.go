@@ -0,0 +1,84 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// waitUntil polls cond until it's true or the timeout expires
+func waitUntil(t *testing.T, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	for _, policy := range []BackpressurePolicy{DelayFlush, DropGauges} {
+		conn := &flakyConn{until: time.Now().Add(time.Hour)}
+		clock := statsdtest.NewFakeClock(time.Now())
+		client := NewStatsdClient("localhost:8125", "myproject.")
+		client.SetClock(clock)
+		client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return conn, nil
+		}
+		client.EnableRetry(5, time.Hour)
+		if err := client.CreateSocket(); err != nil {
+			t.Fatal(err)
+		}
+		// the stalled sender saturates the queue
+		for i := 0; i < 5; i++ {
+			client.Incr("direct", 1)
+		}
+		buffered := NewStatsdBuffer(time.Second, client)
+		buffered.Logger = discardLogger{}
+		buffered.SetBackpressure(5, policy)
+
+		for i := int64(1); i <= 10; i++ {
+			buffered.Incr("a", 1)
+			buffered.Gauge("g", i)
+			clock.Advance(time.Second)
+			waitUntil(t, time.Second, func() bool { return buffered.Stats().DelayedFlushes == i })
+
+			expected := int64(2)
+			if policy == DropGauges {
+				expected = 1
+			}
+			if stats := buffered.Stats(); stats.Pending != expected {
+				t.Errorf("policy %d, interval %d: expected %d pending events, actual %+v", policy, i, expected, stats)
+			}
+			if depth := client.QueueDepth(); depth > 5 {
+				t.Errorf("policy %d: queue grew to %d", policy, depth)
+			}
+		}
+		if policy == DropGauges && buffered.Stats().DroppedGauges != 10 {
+			t.Errorf("expected 10 dropped gauges, actual %+v", buffered.Stats())
+		}
+
+		// the sender recovers: the aggregated counter is flushed whole
+		conn.mu.Lock()
+		conn.until = time.Now()
+		conn.mu.Unlock()
+		waitUntil(t, 3*time.Second, func() bool { return client.QueueDepth() == 0 })
+		clock.Advance(time.Second)
+		waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 0 })
+		found := false
+		for _, line := range conn.lines() {
+			found = found || line == "myproject.a:10|c"
+		}
+		if !found {
+			t.Errorf("policy %d: aggregated counter not flushed, sent %q", policy, conn.lines())
+		}
+		buffered.Close()
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Println(v ...interface{}) {}
@@ -0,0 +1,199 @@
+package statsd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// DefaultMaxPacketSize is the default maximum size of a packet holding several
+// metrics, small enough to avoid IP fragmentation on most networks
+const DefaultMaxPacketSize = 1432
+
+// MapError reports which keys of a batch call could not be sent, and why
+type MapError struct {
+	Errors map[string]error
+	// the groups of lines of the keys failed, and the keys partly delivered,
+	// when the packer knows them, see packer.settle
+	lost    map[string][][]byte
+	partial map[string]bool
+}
+
+// Error lists the keys which failed
+func (e *MapError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for k := range e.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("statsd: failed to send %d keys: %s (%v)", len(keys), strings.Join(keys, ", "), e.Errors[keys[0]])
+}
+
+// batchItem is a stat with its formatted values (value|type), one per line
+type batchItem struct {
+	stat   string
+	values []string
+}
+
+// SetMaxPacketSize sets the maximum size of the packets built by the batch calls
+// (IncrMap, GaugeMap, TimingSlices, SendEvents), DefaultMaxPacketSize by default.
+// It's a ceiling: the size is lowered automatically if the network rejects the
+// packets as too long
+func (c *StatsdClient) SetMaxPacketSize(size int) {
+	c.mu.Lock()
+	c.packetSize = size
+	c.mu.Unlock()
+}
+
+// IncrMap increments all the counters in the map, packing them in as few packets as possible
+func (c *StatsdClient) IncrMap(counts map[string]int64) error {
+	items := make([]batchItem, 0, len(counts))
+	for stat, count := range counts {
+		if !c.skipCount(count) {
+			items = append(items, batchItem{stat: stat, values: []string{fmt.Sprintf("%d|c", count)}})
+		}
+	}
+	return c.sendBatch(KindCounter, items)
+}
+
+// GaugeMap sets all the gauges in the map, packing them in as few packets as possible
+func (c *StatsdClient) GaugeMap(values map[string]int64) error {
+	items := make([]batchItem, 0, len(values))
+	for stat, value := range values {
+		item := batchItem{stat: stat, values: []string{fmt.Sprintf("%d|g", value)}}
+		if value < 0 {
+			item.values = []string{"0|g", fmt.Sprintf("%d|g", value)}
+		}
+		items = append(items, item)
+	}
+	return c.sendBatch(KindGauge, items)
+}
+
+// TimingSlices tracks all the durations in the map, packing them in as few packets as possible
+func (c *StatsdClient) TimingSlices(timings map[string][]time.Duration) error {
+	items := make([]batchItem, 0, len(timings))
+	for stat, deltas := range timings {
+		item := batchItem{stat: stat, values: make([]string, 0, len(deltas))}
+		for _, delta := range deltas {
+			item.values = append(item.values, c.milliseconds(delta)+"|ms")
+		}
+		items = append(items, item)
+	}
+	return c.sendBatch(KindTiming, items)
+}
+
+// sendBatch writes all the items under a single lock, packed in as few packets
+// as the maximum packet size allows. The lines of an item are never split
+// across packets. Failures are reported per key with a MapError.
+// The items are sorted by stat, so that the same batch always produces the
+// same packets whatever the iteration order of the map it was built from
+func (c *StatsdClient) sendBatch(kind MetricKind, items []batchItem) error {
+	sort.Slice(items, func(i, j int) bool { return items[i].stat < items[j].stat })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	switch {
+	case c.closed:
+		err = ErrClosed
+	case c.isGraphite():
+		err = c.unsupported(FeatureDirectSend, 1, nil)
+	case !c.writable():
+		err = fmt.Errorf("not connected")
+	}
+	if err != nil {
+		c.count(kind, outcomeOf(err), int64(len(items)))
+		return err
+	}
+	p := c.newPacker()
+	for _, item := range items {
+		if len(item.values) == 0 || !c.allowed(kind, item.stat) {
+			continue
+		}
+		suffix, ok := c.sample(kind, item.stat)
+		if !ok {
+			continue
+		}
+		names := c.aliasNames(item.stat)
+		if names == nil {
+			names = []string{item.stat}
+		}
+		for _, stat := range names {
+			name, err := c.metricName(stat)
+			if err != nil {
+				p.fail(item.stat, err)
+				continue
+			}
+			c.scratch = c.scratch[:0]
+			for i, v := range item.values {
+				if i > 0 {
+					c.scratch = append(c.scratch, '\n')
+				}
+				c.scratch = append(append(append(append(c.scratch, name...), ':'), v...), suffix...)
+			}
+			p.add(item.stat, c.scratch)
+		}
+		p.count(item.stat, kind)
+	}
+	return p.flush()
+}
+
+// IncrMap increments all the counters in the map, handing them over to the collector at once
+func (sb *buffer) IncrMap(counts map[string]int64) error {
+	events := make([]event.Event, 0, len(counts))
+	for stat, count := range counts {
+		if !sb.statsd.skipCount(count) {
+			events = append(events, &event.Increment{Name: stat, Value: count})
+		}
+	}
+	return sb.enqueueBatch(events)
+}
+
+// GaugeMap sets all the gauges in the map, handing them over to the collector at once
+func (sb *buffer) GaugeMap(values map[string]int64) error {
+	events := make([]event.Event, 0, len(values))
+	for stat, value := range values {
+		events = append(events, &event.Gauge{Name: stat, Value: value})
+	}
+	return sb.enqueueBatch(events)
+}
+
+// TimingSlices tracks all the durations in the map, handing them over to the collector at once
+func (sb *buffer) TimingSlices(timings map[string][]time.Duration) error {
+	events := make([]event.Event, 0, len(timings))
+	for stat, deltas := range timings {
+		for _, delta := range deltas {
+			events = append(events, sb.newPrecisionTiming(stat, delta))
+		}
+	}
+	return sb.enqueueBatch(events)
+}
+
+// enqueueBatch hands a batch of events over to the collector with a single channel
+// send. Invalid names are reported per key with a MapError, the rest is still sent
+func (sb *buffer) enqueueBatch(events []event.Event) error {
+	if atomic.LoadInt32(&sb.closed) != 0 {
+		return ErrClosed
+	}
+	failed := make(map[string]error)
+	accepted := events[:0]
+	for _, e := range events {
+		if _, err := sb.statsd.eventName(e); err != nil {
+			failed[e.Key()] = sb.statsd.countRejected(kindOf(e), err)
+		} else if sb.statsd.allowed(kindOf(e), e.Key()) {
+			accepted = append(accepted, e)
+		}
+	}
+	if len(accepted) > 0 {
+		if err := sb.pushBatch(accepted); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return &MapError{Errors: failed}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// packetConn records the packets written to it
+type packetConn struct {
+	net.Conn
+	mu      sync.Mutex
+	packets []string
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.packets = append(c.packets, string(b))
+	c.mu.Unlock()
+	return len(b), nil
+}
+
+// sent returns a copy of the packets written so far
+func (c *packetConn) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.packets...)
+}
+
+func (c *packetConn) Close() error { return nil }
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func newPacketClient(t testing.TB, prefix string) (*StatsdClient, *packetConn) {
+	conn := &packetConn{}
+	client := NewStatsdClient("localhost:8125", prefix)
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	return client, conn
+}
+
+func TestIncrMapPacking(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetMaxPacketSize(100)
+
+	counts := make(map[string]int64)
+	for i := 0; i < 200; i++ {
+		counts[fmt.Sprintf("key%d", i)] = int64(i + 1)
+	}
+	if err := client.IncrMap(counts); err != nil {
+		t.Fatal(err)
+	}
+	received := make(map[string]bool)
+	for _, p := range conn.packets {
+		if len(p) > 100 {
+			t.Errorf("packet of %d bytes exceeds the maximum size", len(p))
+		}
+		for _, line := range strings.Split(p, "\n") {
+			received[line] = true
+		}
+	}
+	for k, v := range counts {
+		line := fmt.Sprintf("myproject.%s:%d|c", k, v)
+		if !received[line] {
+			t.Errorf("missing %q", line)
+		}
+	}
+	if len(received) != len(counts) {
+		t.Errorf("expected %d lines, actual %d", len(counts), len(received))
+	}
+	// 200 lines of ~20 bytes can't fit in less than 40 packets of 100 bytes
+	if len(conn.packets) > 50 {
+		t.Errorf("poorly packed: %d packets", len(conn.packets))
+	}
+}
+
+func TestGaugeMapKeepsNegativeGaugesTogether(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.SetMaxPacketSize(20)
+
+	if err := client.GaugeMap(map[string]int64{"aaaa": -1, "bbbb": -2, "cccc": 3}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"aaaa:0|g\naaaa:-1|g": true, "bbbb:0|g\nbbbb:-2|g": true, "cccc:3|g": true}
+	if len(conn.packets) != len(expected) {
+		t.Fatalf("expected %d packets, actual %q", len(expected), conn.packets)
+	}
+	for _, p := range conn.packets {
+		if !expected[p] {
+			t.Errorf("unexpected packet %q", p)
+		}
+	}
+}
+
+func TestTimingSlices(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	err := client.TimingSlices(map[string][]time.Duration{"t": {time.Millisecond, 1500 * time.Microsecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.packets) != 1 || conn.packets[0] != "t:1|ms\nt:1.5|ms" {
+		t.Errorf("unexpected packets %q", conn.packets)
+	}
+}
+
+func TestMapErrorReportsKeys(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.SetStrictNames(true)
+
+	err := client.IncrMap(map[string]int64{"good": 1, "bad|name": 1, "bad\nname": 1})
+	mapErr, ok := err.(*MapError)
+	if !ok {
+		t.Fatalf("expected a MapError, actual %v", err)
+	}
+	if len(mapErr.Errors) != 2 || mapErr.Errors["bad|name"] != ErrInvalidName || mapErr.Errors["bad\nname"] != ErrInvalidName {
+		t.Errorf("unexpected errors %v", mapErr.Errors)
+	}
+	if len(conn.packets) != 1 || conn.packets[0] != "good:1|c" {
+		t.Errorf("valid keys not sent: %q", conn.packets)
+	}
+
+	buffered := NewStatsdBuffer(time.Hour, client)
+	defer buffered.Close()
+	err = buffered.GaugeMap(map[string]int64{"good": 1, "bad|name": 1})
+	if mapErr, ok := err.(*MapError); !ok || len(mapErr.Errors) != 1 || mapErr.Errors["bad|name"] == nil {
+		t.Errorf("expected a MapError for bad|name, actual %v", err)
+	}
+}
+
+func TestBufferBatch(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	buffered.IncrMap(map[string]int64{"a": 1, "b": 2})
+	buffered.IncrMap(map[string]int64{"a": 3})
+	buffered.GaugeMap(map[string]int64{"g": 7})
+	buffered.TimingSlices(map[string][]time.Duration{"t": {time.Millisecond, 3 * time.Millisecond}})
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"myproject.a": "4", "myproject.b": "2", "myproject.g": "7", "myproject.t.max": "3"} {
+		metrics, err := srv.WaitFor(name, 1, time.Second)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if metrics[0].Value != expected {
+			t.Errorf("%s: expected %s, actual %s", name, expected, metrics[0].Value)
+		}
+	}
+}
+
+func benchmarkCounts() map[string]int64 {
+	counts := make(map[string]int64, 10000)
+	for i := 0; i < 10000; i++ {
+		counts[fmt.Sprintf("bench.key%d", i)] = int64(i)
+	}
+	return counts
+}
+
+func BenchmarkIncrLoop(b *testing.B) {
+	client, _ := newPacketClient(b, "myproject.")
+	counts := benchmarkCounts()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k, v := range counts {
+			client.Incr(k, v)
+		}
+	}
+}
+
+func BenchmarkIncrMap(b *testing.B) {
+	client, _ := newPacketClient(b, "myproject.")
+	counts := benchmarkCounts()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.IncrMap(counts)
+	}
+}
+
+func BenchmarkBufferIncrLoop(b *testing.B) {
+	client, _ := newPacketClient(b, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	defer buffered.Close()
+	counts := benchmarkCounts()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k, v := range counts {
+			buffered.Incr(k, v)
+		}
+	}
+}
+
+func BenchmarkBufferIncrMap(b *testing.B) {
+	client, _ := newPacketClient(b, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	defer buffered.Close()
+	counts := benchmarkCounts()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffered.IncrMap(counts)
+	}
+}
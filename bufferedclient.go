@@ -1,9 +1,15 @@
 package statsd
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math/rand"
 	"os"
-	"strings"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/CrowdSurge/statsd/event"
@@ -11,189 +17,982 @@ import (
 
 // request to close the buffered statsd collector
 type closeRequest struct {
-	reply chan error
+	reply    chan error
+	deadline time.Time // of the final flush, if not zero
+	ctx      context.Context
+	progress func(drained, remaining int) // see CloseWithProgress
 }
 
+// DefaultCloseTimeout bounds the final flush of Close, so that a black-holed
+// network can't hang the shutdown, unless the client sets another timeout,
+// see SetCloseTimeout
+const DefaultCloseTimeout = 5 * time.Second
+
 // StatsdBuffer is a client library to aggregate events in memory before
 // flushing aggregates to StatsD, useful if the frequency of events is extremely high
-// and sampling is not desirable
+// and sampling is not desirable. Its Logger field logs the errors and the
+// shutdown
 type StatsdBuffer struct {
+	// the collector only refers to the buffer, which holds the state and the
+	// Logger, so that a client dropped without Close can be garbage collected
+	// (see SetLeakHandler)
+	*buffer
+}
+
+// buffer is the state of a StatsdBuffer
+type buffer struct {
 	statsd        *StatsdClient
 	flushInterval time.Duration
-	eventChannel  chan event.Event
+	eventQueue    *priorityQueue
+	batchChannel  chan []event.Event
 	events        map[string]event.Event
 	closeChannel  chan closeRequest
-	Logger        Logger
+	peekChannel   chan pendingRequest
+	flushDone     chan *flushJob // the flush in progress, see startFlush
+	done          chan struct{}  // closed when the collector exits
+	leaked        chan struct{}  // closed when the StatsdBuffer is garbage collected, see SetLeakHandler
+	terminated    chan struct{}  // see Done
+	workers       sync.WaitGroup // the goroutines besides the collector, see ConnectInBackground
+	closeOnce     sync.Once
+	closed        int32        // set atomically when Close() is called
+	connecting    int32        // set atomically, see ConnectInBackground
+	reservoir     int32        // set atomically, see SetReservoirSize
+	resized       int32        // the size the pending timings have, only used within the collector
+	maxAbsolute   int32        // set atomically, see SetMaxAbsoluteValues
+	backpressure  atomic.Value // *backpressure, see SetBackpressure
+	queuePolicy   int32        // set atomically, see SetQueuePolicy
+	// metrics dropped from the queue per policy and per priority, updated atomically
+	queueDrops    [numQueuePolicies]int64
+	priorityDrops [numPriorities]int64
+	// updated atomically, see Stats
+	pending         int64
+	delayedFlushes  int64
+	droppedGauges   int64
+	droppedOnClose  int64
+	droppedAliases  int64
+	flushing        int64                 // events being sent by the current flush
+	errorHandler    atomic.Value          // func(error), see SetErrorHandler
+	errorChannel    atomic.Value          // *errorChannel, see SetErrorChannel
+	flushObserver   atomic.Value          // func(FlushReport), see SetFlushObserver
+	maxRetained     int32                 // set atomically, see SetMaxRetainedIntervals
+	carried         int64                 // updated atomically, see Stats
+	abandoned       int32                 // set when CloseContext gives up on the final flush
+	closeTimeout    int64                 // set atomically, see SetCloseTimeout
+	emitRates       int32                 // set atomically, see SetEmitRates
+	rateNames       atomic.Value          // *rateNames, see SetRateNames
+	bucketsMu       sync.Mutex            // serializes SetHistogramBuckets
+	buckets         atomic.Value          // map[string][]float64, see SetHistogramBuckets
+	histograms      map[string]*histogram // only used within the collector
+	uniquePrecision int32                 // set atomically, see SetUniqueEstimation
+	sketches        map[string]*sketch    // only used within the collector
+	uniqueSeed      uint64                // of the sketches, see hashValue
+	totalDeltas     int32                 // set atomically, see SetTotalDeltas
+	lastFlush       time.Time             // only used within the collector
+	closing         *closeRequest         // of the final flush, only used within the collector
+	inflight        *flushJob             // the flush in progress, only used within the collector
+	pacing          int64                 // set atomically, see SetPacing
+	flushSockets    int32                 // set atomically, see SetFlushSockets
+	next            Statsd                // the downstream client, see NewBufferedStatter
+	negativePolicy  int32                 // set atomically, see SetNegativeCounters
+	overflows       map[string]bool       // the counters saturated in the interval, only used within the collector
+	// the remainders of the clamped counters, only used within the collector,
+	// and the intervals they're carried over, set atomically (see
+	// SetRemainderIntervals)
+	remainders         map[string]*negativeRemainder
+	remainderIntervals int32
+	// the values of the totals sent as deltas, only used within the collector,
+	// and the intervals they're kept, set atomically (see SetTotalIntervals)
+	totals         map[string]*totalValue
+	totalIntervals int32
+	// the last tick of the flush ticker and how late it came, only used
+	// within the collector, and the last and highest skews, updated atomically
+	// (see measureSkew)
+	lastTick  time.Time
+	skew      time.Duration
+	lastSkew  int64
+	maxSkew   int64
+	telemetry int32 // set atomically, see SetTelemetry
+	// the contributions to the gauges per name and source, only used within
+	// the collector, see GaugeContribution
+	contributions   map[string]map[string]contributor
+	contributionTTL int64 // set atomically, see SetContributionTTL
+	// the lines of the failed flush sent as is by the next one, only used
+	// within the collector, see retain
+	retainedLines []retainedLines
+	// of the last flush, updated atomically, see Stats
+	lastFlushDuration int64
+	Logger            Logger
 }
 
 // NewStatsdBuffer Factory
 func NewStatsdBuffer(interval time.Duration, client *StatsdClient) *StatsdBuffer {
-	sb := &StatsdBuffer{
+	return newStatsdBuffer(interval, client, nil)
+}
+
+// newStatsdBuffer creates a buffered client flushing to next if not nil, see
+// NewBufferedStatter, or else through client. The options are applied before
+// it starts collecting
+func newStatsdBuffer(interval time.Duration, client *StatsdClient, next Statsd, opts ...Option) *StatsdBuffer {
+	sb := &StatsdBuffer{&buffer{
 		flushInterval: interval,
 		statsd:        client,
-		eventChannel:  make(chan event.Event, 100),
+		next:          next,
+		eventQueue:    newPriorityQueue(100),
+		batchChannel:  make(chan []event.Event, 10),
 		events:        make(map[string]event.Event, 0),
 		closeChannel:  make(chan closeRequest, 0),
+		peekChannel:   make(chan pendingRequest),
+		flushDone:     make(chan *flushJob, 1),
+		done:          make(chan struct{}),
+		leaked:        make(chan struct{}),
+		terminated:    make(chan struct{}),
+		lastFlush:     client.now(),
+		lastTick:      client.now(),
+		maxRetained:   DefaultMaxRetainedIntervals,
+		uniqueSeed:    rand.Uint64(),
 		Logger:        log.New(os.Stdout, "[BufferedStatsdClient] ", log.Ldate|log.Ltime),
+	}}
+	for _, opt := range opts {
+		opt(sb)
 	}
-	go sb.collector()
+	atomic.StoreInt32(&client.buffered, 1)
+	// the ticker is created before returning, so that a fake clock can be advanced right away
+	tick, stop := client.newTicker(interval)
+	b := sb.buffer
+	runtime.SetFinalizer(sb, func(*StatsdBuffer) { b.collected() })
+	atomic.AddInt64(&activeClients, 1)
+	go b.collector(tick, stop)
 	return sb
 }
 
-// CreateSocket creates a UDP connection to a StatsD server
-func (sb *StatsdBuffer) CreateSocket() error {
+// rawMarker returns the marker of the raw names of the underlying client, see
+// StatsdClient.SetRawMarker
+func (sb *buffer) rawMarker() string {
+	return sb.statsd.rawMarker()
+}
+
+// CreateSocket creates a UDP connection to a StatsD server, or connects the
+// downstream client of NewBufferedStatter
+func (sb *buffer) CreateSocket() error {
+	if sb.next != nil {
+		return sb.next.CreateSocket()
+	}
 	return sb.statsd.CreateSocket()
 }
 
+// SetAddress switches the underlying client to a new address at runtime, see
+// StatsdClient.SetAddress. The aggregated stats are flushed to the new address
+func (sb *buffer) SetAddress(addr string) error {
+	return sb.statsd.SetAddress(addr)
+}
+
 // Incr - Increment a counter metric. Often used to note a particular event
-func (sb *StatsdBuffer) Incr(stat string, count int64) error {
-	if 0 != count {
-		sb.eventChannel <- &event.Increment{Name: stat, Value: count}
-	}
-	return nil
+func (sb *buffer) Incr(stat string, count int64) error {
+	return sb.incrAt(stat, count, PriorityNormal)
 }
 
 // Decr - Decrement a counter metric. Often used to note a particular event
-func (sb *StatsdBuffer) Decr(stat string, count int64) error {
-	if 0 != count {
-		sb.eventChannel <- &event.Increment{Name: stat, Value: -count}
+func (sb *buffer) Decr(stat string, count int64) error {
+	return sb.incrAt(stat, -count, PriorityNormal)
+}
+
+// incrAt is Incr at a priority, see WithPriority
+func (sb *buffer) incrAt(stat string, count int64, priority Priority) error {
+	if sb.statsd.skipCount(count) {
+		return nil
 	}
-	return nil
+	return sb.enqueueAt(&event.Increment{Name: stat, Value: count}, priority)
+}
+
+// SetReservoirSize sets the maximum number of samples retained per timing key
+// and interval to estimate percentiles (event.DefaultReservoirSize by default).
+// It applies to the keys created after the call, and to the pending ones from
+// the next flush, which drops their samples beyond the size
+func (sb *buffer) SetReservoirSize(size int) {
+	atomic.StoreInt32(&sb.reservoir, int32(size))
 }
 
 // Timing - Track a duration event
-func (sb *StatsdBuffer) Timing(stat string, delta int64) error {
-	sb.eventChannel <- event.NewTiming(stat, delta)
-	return nil
+func (sb *buffer) Timing(stat string, delta int64) error {
+	return sb.enqueue(sb.newTiming(stat, delta))
 }
 
 // PrecisionTiming - Track a duration event
 // the time delta has to be a duration
-func (sb *StatsdBuffer) PrecisionTiming(stat string, delta time.Duration) error {
-	sb.eventChannel <- event.NewPrecisionTiming(stat, time.Duration(float64(delta)/float64(time.Millisecond)))
-	return nil
+func (sb *buffer) PrecisionTiming(stat string, delta time.Duration) error {
+	return sb.enqueue(sb.newPrecisionTiming(stat, delta))
+}
+
+// TimingMicroseconds - Track a duration event given in microseconds
+func (sb *buffer) TimingMicroseconds(stat string, us float64) error {
+	return sb.timingMicrosecondsAt(stat, us, PriorityNormal)
+}
+
+// timingMicrosecondsAt is TimingMicroseconds at a priority, see WithPriority
+func (sb *buffer) timingMicrosecondsAt(stat string, us float64, priority Priority) error {
+	return sb.enqueueFinite(us, sb.newPrecisionTiming(stat, time.Duration(us*float64(time.Microsecond))), priority)
+}
+
+// FTiming - Track a duration event given in floating point milliseconds
+func (sb *buffer) FTiming(stat string, ms float64) error {
+	return sb.enqueueFinite(ms, sb.newFTiming(stat, ms), PriorityNormal)
+}
+
+// newTiming, newPrecisionTiming and newFTiming create the timing events with
+// the reservoir size of the client, see SetReservoirSize
+func (sb *buffer) newTiming(stat string, delta int64) *event.Timing {
+	e := event.NewTiming(stat, delta)
+	e.ReservoirSize = int(atomic.LoadInt32(&sb.reservoir))
+	return e
+}
+
+func (sb *buffer) newPrecisionTiming(stat string, delta time.Duration) *event.PrecisionTiming {
+	e := event.NewPrecisionTiming(stat, delta)
+	e.ReservoirSize = int(atomic.LoadInt32(&sb.reservoir))
+	return e
+}
+
+func (sb *buffer) newFTiming(stat string, ms float64) *event.FTiming {
+	e := event.NewFTiming(stat, ms)
+	e.ReservoirSize = int(atomic.LoadInt32(&sb.reservoir))
+	return e
 }
 
 // Gauge - Gauges are a constant data type. They are not subject to averaging,
 // and they don’t change unless you change them. That is, once you set a gauge value,
 // it will be a flat line on the graph until you change it again
-func (sb *StatsdBuffer) Gauge(stat string, value int64) error {
-	sb.eventChannel <- &event.Gauge{Name: stat, Value: value}
-	return nil
+func (sb *buffer) Gauge(stat string, value int64) error {
+	return sb.enqueue(&event.Gauge{Name: stat, Value: value})
 }
 
 // GaugeDelta records a delta from the previous value (as int64)
-func (sb *StatsdBuffer) GaugeDelta(stat string, value int64) error {
-	sb.eventChannel <- &event.GaugeDelta{Name: stat, Value: value}
-	return nil
+func (sb *buffer) GaugeDelta(stat string, value int64) error {
+	return sb.enqueue(&event.GaugeDelta{Name: stat, Value: value})
 }
 
 // FGauge is a Gauge working with float64 values
-func (sb *StatsdBuffer) FGauge(stat string, value float64) error {
-	sb.eventChannel <- &event.FGauge{Name: stat, Value: value}
-	return nil
+func (sb *buffer) FGauge(stat string, value float64) error {
+	return sb.enqueueFinite(value, &event.FGauge{Name: stat, Value: value}, PriorityNormal)
 }
 
 // FGaugeDelta records a delta from the previous value (as float64)
-func (sb *StatsdBuffer) FGaugeDelta(stat string, value float64) error {
-	sb.eventChannel <- &event.FGaugeDelta{Name: stat, Value: value}
-	return nil
+func (sb *buffer) FGaugeDelta(stat string, value float64) error {
+	return sb.enqueueFinite(value, &event.FGaugeDelta{Name: stat, Value: value}, PriorityNormal)
 }
 
 // Absolute - Send absolute-valued metric (not averaged/aggregated)
-func (sb *StatsdBuffer) Absolute(stat string, value int64) error {
-	sb.eventChannel <- &event.Absolute{Name: stat, Values: []int64{value}}
-	return nil
+func (sb *buffer) Absolute(stat string, value int64) error {
+	return sb.enqueue(sb.newAbsolute(stat, value))
 }
 
 // FAbsolute - Send absolute-valued metric (not averaged/aggregated)
-func (sb *StatsdBuffer) FAbsolute(stat string, value float64) error {
-	sb.eventChannel <- &event.FAbsolute{Name: stat, Values: []float64{value}}
-	return nil
+func (sb *buffer) FAbsolute(stat string, value float64) error {
+	return sb.enqueueFinite(value, sb.newFAbsolute(stat, value), PriorityNormal)
+}
+
+// SetMaxAbsoluteValues bounds the number of values an absolute key retains
+// per interval, to bound the memory: the further values of the interval are
+// dropped. 0, the default, means no limit. It applies from the next interval
+func (sb *buffer) SetMaxAbsoluteValues(max int) {
+	if max < 0 {
+		max = 0
+	}
+	atomic.StoreInt32(&sb.maxAbsolute, int32(max))
+}
+
+// newAbsolute and newFAbsolute create the absolute events with the cap of the
+// client, see SetMaxAbsoluteValues
+func (sb *buffer) newAbsolute(stat string, value int64) *event.Absolute {
+	return &event.Absolute{Name: stat, Values: []int64{value}, MaxValues: int(atomic.LoadInt32(&sb.maxAbsolute))}
+}
+
+func (sb *buffer) newFAbsolute(stat string, value float64) *event.FAbsolute {
+	return &event.FAbsolute{Name: stat, Values: []float64{value}, MaxValues: int(atomic.LoadInt32(&sb.maxAbsolute))}
 }
 
 // Total - Send a metric that is continously increasing, e.g. read operations since boot
-func (sb *StatsdBuffer) Total(stat string, value int64) error {
-	sb.eventChannel <- &event.Total{Name: stat, Value: value}
-	return nil
+func (sb *buffer) Total(stat string, value int64) error {
+	return sb.enqueue(&event.Total{Name: stat, Value: value})
+}
+
+// Unique - Count the unique values of a set: each distinct value is sent once
+// per interval, or only the estimated count if SetUniqueEstimation is enabled.
+// In Graphite mode the values are dropped unless they're estimated, the first
+// drop is reported to the error handler
+func (sb *buffer) Unique(stat string, value string) error {
+	return sb.uniqueAt(stat, value, PriorityNormal)
+}
+
+// uniqueAt is Unique at a priority, see WithPriority
+func (sb *buffer) uniqueAt(stat string, value string, priority Priority) error {
+	if !sb.Supports(FeatureSets) {
+		sb.statsd.unsupported(FeatureSets, 1, sb.handleError)
+		return nil
+	}
+	return sb.enqueueAt(event.NewSet(stat, Escape(FieldSetMember, value)), priority)
+}
+
+// enqueue hands the event over to the collector, unless the buffer is closed.
+// A send racing with Close either makes it into the final flush or is dropped,
+// it never blocks on a collector that has already exited
+func (sb *buffer) enqueue(e event.Event) error {
+	return sb.enqueueAt(e, PriorityNormal)
+}
+
+// enqueueAt is enqueue with a priority, see WithPriority
+func (sb *buffer) enqueueAt(e event.Event, priority Priority) error {
+	if atomic.LoadInt32(&sb.closed) != 0 {
+		return ErrClosed
+	}
+	if _, err := sb.statsd.eventName(e); err != nil {
+		return sb.statsd.countRejected(kindOf(e), err)
+	}
+	if !sb.statsd.allowed(kindOf(e), e.Key()) {
+		return nil
+	}
+	return sb.pushAt(e, priority)
+}
+
+// enqueueFinite is enqueueAt for an event of a floating point value, which is
+// rejected with ErrInvalidValue unless it's finite
+func (sb *buffer) enqueueFinite(value float64, e event.Event, priority Priority) error {
+	if !event.IsFinite(value) {
+		return ErrInvalidValue
+	}
+	return sb.enqueueAt(e, priority)
 }
 
-// handle flushes and updates in one single thread (instead of locking the events map)
-func (sb *StatsdBuffer) collector() {
+// collector handles the flushes and the updates in one single thread (instead
+// of locking the events map)
+func (sb *buffer) collector(tick <-chan time.Time, stop func()) {
 	// on a panic event, flush all the pending stats before panicking
-	defer func(sb *StatsdBuffer) {
+	defer func() {
 		if r := recover(); r != nil {
 			sb.Logger.Println("Caught panic, flushing stats before throwing the panic again")
+			sb.awaitFlush()
 			sb.flush()
 			panic(r)
 		}
-	}(sb)
+	}()
+	defer close(sb.done)
+	defer atomic.AddInt64(&activeClients, -1)
 
-	ticker := time.NewTicker(sb.flushInterval)
+	defer stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			//sb.Logger.Println("Flushing stats")
-			sb.flush()
-		case e := <-sb.eventChannel:
+		case <-tick:
+			if sb.onTick() {
+				return
+			}
+		case job := <-sb.flushDone:
+			if sb.onFlushDone(job) {
+				return
+			}
+		case e := <-sb.eventQueue.channels[PriorityHigh]:
+			sb.eventQueue.received()
+			sb.add(e)
+		case e := <-sb.eventQueue.channels[PriorityNormal]:
 			//sb.Logger.Println("Received ", e.String())
-			// convert %HOST% in key
-			k := strings.Replace(e.Key(), "%HOST%", Hostname, 1)
-			e.SetKey(k)
-
-			if e2, ok := sb.events[k]; ok {
-				//sb.Logger.Println("Updating existing event")
-				e2.Update(e)
-				sb.events[k] = e2
-			} else {
-				//sb.Logger.Println("Adding new event")
-				sb.events[k] = e
+			sb.eventQueue.received()
+			sb.add(e)
+		case e := <-sb.eventQueue.channels[PriorityLow]:
+			sb.eventQueue.received()
+			sb.add(e)
+		case events := <-sb.batchChannel:
+			for _, e := range events {
+				sb.add(e)
 			}
+		case req := <-sb.peekChannel:
+			sb.peek(req)
 		case c := <-sb.closeChannel:
-			sb.Logger.Println("Asked to terminate. Flushing stats before returning.")
-			c.reply <- sb.flush()
+			sb.onClose(c)
+			return
+		case <-sb.leaked:
 			return
 		}
 	}
 }
 
-// Close sends a close event to the collector asking to stop & flush pending stats
-// and closes the statsd client
-func (sb *StatsdBuffer) Close() (err error) {
-	// 1. send a close event to the collector
-	req := closeRequest{reply: make(chan error, 0)}
-	sb.closeChannel <- req
-	// 2. wait for the collector to drain the queue and respond
-	err = <-req.reply
-	// 3. close the statsd client
-	err2 := sb.statsd.Close()
+// onTick starts a flush, it returns true if the collector must exit
+func (sb *buffer) onTick() bool {
+	//sb.Logger.Println("Flushing stats")
+	// include the events queued before the tick
+	sb.drain()
+	sb.measureSkew()
+	sb.reportUniqueNames()
+	if sb.isConnecting() || sb.backpressured() {
+		return false
+	}
+	// a flush slower than the interval skips the tick instead of blocking the
+	// intake, the events are sent by the next one
+	if sb.inflight != nil {
+		atomic.AddInt64(&sb.delayedFlushes, 1)
+		return false
+	}
+	sb.startFlush()
+	return false
+}
+
+// onFlushDone completes a flush, it returns true if the collector must exit
+func (sb *buffer) onFlushDone(job *flushJob) bool {
+	if sb.finishFlush(job) == ErrClosed {
+		sb.clientClosed()
+		return true
+	}
+	return false
+}
+
+// onClose runs the final flush, see Close
+func (sb *buffer) onClose(c closeRequest) {
+	sb.Logger.Println("Asked to terminate. Flushing stats before returning.")
+	if sb.inflight != nil && sb.inflight.pace != nil {
+		close(sb.inflight.pace.hurry)
+	}
+	if !c.deadline.IsZero() {
+		// bounds the flush in progress as well
+		sb.statsd.setWriteDeadline(c.deadline)
+	}
+	sb.awaitFlush()
+	sb.drain()
+	c.reply <- sb.finalFlush(c)
+}
+
+// clientClosed stops accepting events once the underlying client was closed,
+// there's no point in flushing again
+func (sb *buffer) clientClosed() {
+	sb.Logger.Println("StatsD client closed, stopping the collector")
+	atomic.StoreInt32(&sb.closed, 1)
+}
+
+// add merges the event into the pending ones with the same key, and a copy
+// of it under each alias of the key (see AddAlias)
+func (sb *buffer) add(e event.Event) {
+	if raw, ok := e.(*rawEvent); ok {
+		sb.addRaw(raw)
+		return
+	}
+	e, qualified := unqualified(e)
+	names := sb.statsd.aliasNames(e.Key())
+	if names == nil {
+		sb.addEvent(e, qualified)
+		return
+	}
+	// copied before the event is merged into the pending ones
+	events := []event.Event{e}
+	for range names[1:] {
+		events = append(events, event.Copy(e))
+	}
+	for i, e := range events {
+		if e == nil {
+			atomic.AddInt64(&sb.droppedAliases, 1)
+			sb.Logger.Println("Dropping the alias", names[i], "of", names[0]+":", fmt.Errorf("can't copy an event of type %T", events[0]))
+			continue
+		}
+		e.SetKey(names[i])
+		sb.addEvent(e, qualified)
+	}
+}
+
+// addEvent merges the event into the pending ones with the same key, see
+// resolveKey for qualified
+func (sb *buffer) addEvent(e event.Event, qualified bool) {
+	// convert %HOST% in key and escape reserved characters
+	stat := e.Key()
+	// the name was already checked, and counted if malformed, by enqueue
+	k, err := sb.statsd.resolveKey(stat, qualified)
 	if err != nil {
-		return err
+		sb.Logger.Println(err)
+		return
+	}
+	e.SetKey(k)
+	if c, ok := e.(*contribution); ok {
+		sb.contribute(k, c)
+		return
+	}
+	if set, ok := e.(*event.Set); ok && sb.observeUnique(k, set) {
+		return
+	}
+	sb.observeHistogram(stat, k, e)
+
+	if e2, ok := sb.events[k]; ok && !overridesGauge(e2, e) {
+		//sb.Logger.Println("Updating existing event")
+		sb.merge(k, e2, e)
+		sb.events[k] = e2
+	} else {
+		//sb.Logger.Println("Adding new event")
+		sb.events[k] = e
+	}
+	atomic.StoreInt64(&sb.pending, int64(len(sb.events)))
+}
+
+// overridesGauge tells whether e is a plain gauge value, which resets whatever
+// gauge operations (values or deltas) are pending for the same key
+func overridesGauge(pending event.Event, e event.Event) bool {
+	switch e.Type() {
+	case event.EventGauge, event.EventFGauge:
+		switch pending.Type() {
+		case event.EventGauge, event.EventGaugeDelta, event.EventFGauge, event.EventFGaugeDelta:
+			return true
+		}
+	}
+	return false
+}
+
+// drain merges all the events still queued in the event queue,
+// so that nothing sent before Close() is lost
+func (sb *buffer) drain() {
+	for {
+		if e, ok := sb.eventQueue.pop(); ok {
+			sb.add(e)
+			continue
+		}
+		select {
+		case events := <-sb.batchChannel:
+			for _, e := range events {
+				sb.add(e)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Close sends a close event to the collector asking to stop & flush pending stats
+// and closes the statsd client. It is safe to call Close more than once:
+// only the first call flushes, subsequent calls return ErrClosed.
+// Metrics sent after Close are dropped, and ErrClosed is returned.
+// The final flush is abandoned after the close timeout, see SetCloseTimeout
+// and CloseContext
+func (sb *buffer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sb.closeTimeoutValue())
+	defer cancel()
+	return sb.CloseContext(ctx)
+}
+
+// SetCloseTimeout sets the bound of the final flush of Close, see
+// CloseContext. 0 or less restores DefaultCloseTimeout
+func (sb *buffer) SetCloseTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	atomic.StoreInt64(&sb.closeTimeout, int64(timeout))
+}
+
+// closeTimeoutValue returns the close timeout, see SetCloseTimeout
+func (sb *buffer) closeTimeoutValue() time.Duration {
+	if timeout := time.Duration(atomic.LoadInt64(&sb.closeTimeout)); timeout > 0 {
+		return timeout
+	}
+	return DefaultCloseTimeout
+}
+
+// CloseContext is Close with the final flush bounded by ctx: the writes still
+// blocked at the deadline fail, and if ctx is done before the flush completes
+// it's abandoned. Either way the events which couldn't be sent are dropped,
+// counted in Stats().DroppedOnClose, and an error is returned
+func (sb *buffer) CloseContext(ctx context.Context) error {
+	return sb.closeContext(ctx, nil)
+}
+
+func (sb *buffer) closeContext(ctx context.Context, progress func(drained, remaining int)) (err error) {
+	err = ErrClosed
+	sb.closeOnce.Do(func() {
+		atomic.StoreInt32(&sb.closed, 1)
+		// 1. send a close event to the collector (unless it's already gone)
+		req := closeRequest{reply: make(chan error, 1), ctx: ctx, progress: progress}
+		req.deadline, _ = ctx.Deadline()
+		select {
+		case sb.closeChannel <- req:
+			// 2. wait for the collector to drain the queue and respond
+			select {
+			case err = <-req.reply:
+			case <-ctx.Done():
+				if !atomic.CompareAndSwapInt32(&sb.abandoned, 0, 1) {
+					// the collector stopped the flush itself, see sendWithProgress
+					err = <-req.reply
+					break
+				}
+				// the flush is stuck on a write ignoring the deadline: give up on
+				// it, and close the client once it returns
+				dropped := atomic.LoadInt64(&sb.pending) + atomic.LoadInt64(&sb.flushing)
+				atomic.AddInt64(&sb.droppedOnClose, dropped)
+				go sb.closeAbandoned(req, true)
+				err = fmt.Errorf("statsd: final flush abandoned on close, %d events dropped: %v", dropped, ctx.Err())
+				return
+			}
+		case <-sb.done:
+			err = nil
+		case <-ctx.Done():
+			go sb.closeAbandoned(req, false)
+			err = fmt.Errorf("statsd: close abandoned: %v", ctx.Err())
+			return
+		}
+		// 3. close the statsd client
+		err2 := sb.closeClient()
+		if err == nil {
+			err = err2
+		}
+		go sb.terminate()
+	})
+	return err
+}
+
+// closeAbandoned completes a close given up on by CloseContext in the
+// background: it hands the close request over to the collector unless sent,
+// and closes the client once the collector exits. It's bounded by the close
+// timeout (see SetCloseTimeout): if the final flush is still stuck then, its
+// connection is closed under the write to unblock it, and if even that isn't
+// enough after another timeout, the client is left as is (and Done is never
+// closed) rather than leaking the goroutine
+func (sb *buffer) closeAbandoned(req closeRequest, sent bool) {
+	timeout := sb.closeTimeoutValue()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	exited := false
+	if sent {
+		select {
+		case <-sb.done:
+			exited = true
+		case <-timer.C:
+		}
+	} else {
+		// the collector replies to the request with an expired deadline quickly,
+		// unless it's stuck in a write
+		select {
+		case sb.closeChannel <- req:
+			select {
+			case <-sb.done:
+				exited = true
+			case <-timer.C:
+			}
+		case <-sb.done:
+			exited = true
+		case <-timer.C:
+		}
+	}
+	if !exited {
+		sb.statsd.abortConn()
+		timer.Reset(timeout)
+		select {
+		case <-sb.done:
+		case <-timer.C:
+			sb.Logger.Println("The final flush is still stuck, giving up on closing the StatsD client")
+			return
+		}
+	}
+	sb.closeClient()
+	sb.terminate()
+}
+
+// flushJob is a flush detached from the collector: the aggregated events are
+// serialized and sent by another goroutine, while the collector keeps merging
+// the new events into a fresh map. The fields from renamed on are set by runFlush
+type flushJob struct {
+	now      time.Time
+	elapsed  time.Duration // since the previous flush
+	skew     time.Duration // of the tick starting the flush, see measureSkew
+	detached map[string]event.Event
+	events   []event.Event
+	// the key in detached of the events derived from the aggregated ones
+	derived map[string]string
+	// the events sent again every flush anyway (see GaugeContribution), and
+	// the ones sent in place of an aggregated event (see SetTotalDeltas)
+	persistent map[event.Event]bool
+	standIns   map[event.Event]bool
+	// the lines kept by the previous flush, sent first, see retain
+	retained []retainedLines
+	closing  *closeRequest // of the final flush
+	final    bool
+	pace     *pace // nil if the packets aren't paced
+	renamed  map[event.Event]string
+	report   FlushReport
+	failed   map[string]failedKey
+	// of the retained lines, kept apart since they may share the keys of the events
+	failedRetained map[string]failedKey
+	err            error
+}
+
+// failedDetached returns the keys in detached of the events which failed to
+// be sent, the keys sent being renamed by the prefix provider (see applyPrefix)
+func (job *flushJob) failedDetached() map[string]bool {
+	if len(job.failed) == 0 {
+		return nil
+	}
+	keys := make(map[string]bool, len(job.failed))
+	for key := range job.failed {
+		if k, ok := job.derived[key]; ok {
+			key = k
+		}
+		keys[key] = true
+	}
+	return keys
+}
+
+// flush sends the events to StatsD and resets them, without detaching the send
+// from the collector. This function is NOT thread-safe, so it must only be
+// invoked synchronously from within the collector() goroutine
+func (sb *buffer) flush() error {
+	job := sb.prepareFlush()
+	if job == nil {
+		return nil
+	}
+	job.final = true
+	sb.runFlush(job)
+	return sb.finishFlush(job)
+}
+
+// startFlush detaches the pending events and sends them in the background,
+// the collector picks up the outcome from flushDone. It's only called from
+// within the collector, once the previous flush is complete
+func (sb *buffer) startFlush() {
+	job := sb.prepareFlush()
+	if job == nil {
+		return
 	}
-	return err2
+	if window := time.Duration(atomic.LoadInt64(&sb.pacing)); window > 0 {
+		job.pace = &pace{window: window, hurry: make(chan struct{})}
+	}
+	job.skew = sb.skew
+	sb.inflight = job
+	go func() {
+		sb.runFlush(job)
+		sb.flushDone <- job
+	}()
 }
 
-// send the events to StatsD and reset them.
-// This function is NOT thread-safe, so it must only be invoked synchronously
-// from within the collector() goroutine
-func (sb *StatsdBuffer) flush() (err error) {
+// awaitFlush waits for the flush in progress, if any, to complete
+func (sb *buffer) awaitFlush() error {
+	if sb.inflight == nil {
+		return nil
+	}
+	return sb.finishFlush(<-sb.flushDone)
+}
+
+// prepareFlush swaps the pending events for a fresh map and lists the events
+// to send, with the rates, histograms and estimates derived from them. It
+// returns nil if there's nothing to send
+func (sb *buffer) prepareFlush() *flushJob {
+	sb.reportDropped()
+	// every interval, even without anything to send
+	sb.expireRemainders(sb.events)
+	sb.expireTotals(sb.events)
+	now := sb.statsd.now()
+	elapsed := now.Sub(sb.lastFlush)
+	sb.lastFlush = now
 	n := len(sb.events)
-	if n == 0 {
+	if n == 0 && len(sb.sketches) == 0 && len(sb.contributions) == 0 && len(sb.retainedLines) == 0 {
 		return nil
 	}
-	err = sb.statsd.CreateSocket()
+	job := &flushJob{
+		now:      now,
+		elapsed:  elapsed,
+		detached: sb.events,
+		events:   make([]event.Event, 0, n),
+		derived:  make(map[string]string),
+		retained: sb.retainedLines,
+		closing:  sb.closing,
+	}
+	sb.events = make(map[string]event.Event, n)
+	sb.retainedLines = nil
+	// the timings buffered before SetReservoirSize
+	reservoir := atomic.LoadInt32(&sb.reservoir)
+	resize := reservoir != sb.resized
+	sb.resized = reservoir
+	for k, v := range job.detached {
+		if e, _ := unqualified(v); resize {
+			if r, ok := e.(event.Resizer); ok {
+				r.Resize(int(reservoir))
+			}
+		}
+		if inc, ok := v.(*event.Increment); ok && !sb.checkNegative(k, inc) {
+			continue
+		}
+		if rates := sb.rateEvents(v, elapsed); rates != nil {
+			for _, e := range rates {
+				job.derived[e.Key()] = k
+			}
+			job.events = append(job.events, rates...)
+		} else if total, ok := v.(*event.Total); ok && atomic.LoadInt32(&sb.totalDeltas) != 0 {
+			if delta := sb.totalDelta(total); delta != nil {
+				if job.standIns == nil {
+					job.standIns = make(map[event.Event]bool)
+				}
+				job.standIns[delta] = true
+				job.events = append(job.events, delta)
+			}
+		} else {
+			job.events = append(job.events, v)
+		}
+	}
+	// after the remainders carried over, see checkNegative
+	sb.overflows = nil
+	job.events = append(job.events, sb.histogramEvents()...)
+	job.events = append(job.events, sb.uniqueEvents()...)
+	if contributions := sb.contributionEvents(now); len(contributions) > 0 {
+		job.persistent = make(map[event.Event]bool, len(contributions))
+		for _, e := range contributions {
+			job.persistent[e] = true
+		}
+		job.events = append(job.events, contributions...)
+	}
+	atomic.StoreInt64(&sb.pending, 0)
+	atomic.StoreInt64(&sb.flushing, int64(len(job.events)))
+	return job
+}
+
+// runFlush serializes and sends the events of the job. It only touches the
+// job, the underlying client and the atomic counters, so that it can run
+// outside of the collector
+func (sb *buffer) runFlush(job *flushJob) {
+	start := time.Now()
+	job.report = FlushReport{Time: job.now, Keys: len(job.events), Skew: job.skew}
+	var err error
+	if sb.next == nil {
+		err = sb.statsd.CreateSocket()
+	}
+	if ErrClosed == err {
+		job.report.Keys = len(job.detached)
+		job.err, job.report.Err = err, err
+		atomic.StoreInt64(&sb.flushing, 0)
+		return
+	}
 	if nil != err {
 		sb.Logger.Println("Error establishing UDP connection for sending statsd events:", err)
 	}
-	for k, v := range sb.events {
-		err := sb.statsd.SendEvent(v)
+	if len(job.retained) > 0 {
+		job.failedRetained = failedKeys(sb.statsd.sendLines(job.retained, &job.report), nil, true)
+		sb.statsd.countRaw(job.retained, job.failedRetained)
+	}
+	job.renamed = sb.applyPrefix(job.events, job.derived)
+	// sorted, so that the same aggregates always produce the same packets, and
+	// stably, so that the events sharing a key keep the order they were listed in
+	sort.SliceStable(job.events, func(i, j int) bool { return job.events[i].Key() < job.events[j].Key() })
+	if job.closing != nil && job.closing.progress != nil {
+		job.failed, job.err = sb.sendWithProgress(job.events, job.now, &job.report, job.closing)
+	} else {
+		job.failed, job.err = sb.send(job.events, job.now, &job.report, job.pace)
+	}
+	if job.err == nil && len(job.failedRetained) > 0 {
+		job.err = fmt.Errorf("statsd: failed to send %d keys retained by the previous flush", len(job.failedRetained))
+	}
+	job.report.Duration = time.Since(start)
+	job.report.Serialization = job.report.Duration - job.report.Sending - job.report.Pacing
+	job.report.Err = job.err
+	atomic.StoreInt64(&sb.flushing, 0)
+}
+
+// finishFlush merges the events of the job which couldn't be sent back into
+// the pending ones, and reports the flush. It's only called from within the
+// collector
+func (sb *buffer) finishFlush(job *flushJob) error {
+	sb.inflight = nil
+	atomic.StoreInt64(&sb.lastFlushDuration, int64(job.report.Duration))
+	if job.err == ErrClosed {
+		sb.observeFlush(job.report)
+		return ErrClosed
+	}
+	sb.recordTotals(job)
+
+	// the events which couldn't be sent are retained for the next interval (see
+	// retain), for at most maxRetained intervals in a row and unless this is
+	// the final flush
+	retained := job.err != nil && !job.final &&
+		atomic.LoadInt64(&sb.carried) < int64(atomic.LoadInt32(&sb.maxRetained))
+	unretained := 0
+	if retained {
+		unretained = sb.retain(job)
+		// the rates of the retained counters span all the intervals carried
+		sb.lastFlush = job.now.Add(-job.elapsed)
+		atomic.AddInt64(&sb.carried, 1)
+	} else {
+		atomic.StoreInt64(&sb.carried, 0)
+	}
+	atomic.StoreInt64(&sb.pending, int64(len(sb.events)))
+	if job.err != nil {
+		sb.handleError(&FlushError{Events: len(job.failed) + len(job.failedRetained), Retained: retained, Unretained: unretained, Err: job.err})
+	}
+	sb.observeFlush(job.report)
+
+	return nil
+}
+
+// finalFlush flushes the pending stats before closing, making the writes still
+// blocked at the deadline fail
+func (sb *buffer) finalFlush(req closeRequest) error {
+	if !req.deadline.IsZero() {
+		sb.statsd.setWriteDeadline(req.deadline)
+	}
+	dropped := atomic.LoadInt64(&sb.droppedOnClose)
+	sb.closing = &req
+	err := sb.flush()
+	sb.closing = nil
+	if !req.deadline.IsZero() {
+		// the deadline only bounds the final flush
+		sb.statsd.setWriteDeadline(time.Time{})
+	}
+	if n := atomic.LoadInt64(&sb.droppedOnClose) - dropped; n > 0 && err == nil {
+		err = fmt.Errorf("statsd: close deadline exceeded, %d events dropped from the final flush", n)
+	}
+	return err
+}
+
+// countTimeouts counts the events whose write failed at the deadline of the
+// final flush
+func (sb *buffer) countTimeouts(err error) {
+	if atomic.LoadInt32(&sb.abandoned) != 0 {
+		// already counted by CloseContext
+		return
+	}
+	if mapErr, ok := err.(*MapError); ok {
+		for _, err := range mapErr.Errors {
+			if isTimeout(err) {
+				atomic.AddInt64(&sb.droppedOnClose, 1)
+			}
+		}
+	} else if isTimeout(err) {
+		atomic.AddInt64(&sb.droppedOnClose, 1)
+	}
+}
+
+// send the aggregated events, packed so that a packet never splits the lines of
+// a group (see event.Grouper), logging the errors. It returns the keys of the
+// events which failed, and the first error
+func (sb *buffer) send(events []event.Event, now time.Time, report *FlushReport, pace *pace) (failed map[string]failedKey, err error) {
+	if !sb.statsd.isGraphite() {
+		sockets := int(atomic.LoadInt32(&sb.flushSockets))
+		switch {
+		case sb.next != nil:
+			err = sb.forward(events)
+		case pace != nil:
+			err = sb.statsd.sendEventsPaced(events, report, pace)
+		case sockets > 1:
+			err = sb.statsd.sendEventsStriped(events, report, sockets)
+		default:
+			err = sb.statsd.sendEvents(events, true, report)
+		}
 		if nil != err {
+			sb.countTimeouts(err)
 			sb.Logger.Println(err)
 		}
-		//sb.Logger.Println("Sent", v.String())
-		delete(sb.events, k)
+		// the lines lost downstream are formatted by the downstream client
+		return failedKeys(err, events, sb.next == nil), err
 	}
-
-	return nil
+	failed = make(map[string]failedKey)
+	for _, e := range events {
+		if set, ok := e.(*event.Set); ok {
+			// buffered before the estimation was disabled
+			sb.statsd.unsupported(FeatureSets, int64(len(set.Members)), sb.handleError)
+			continue
+		}
+		if err2 := sb.statsd.sendGraphite(e, now, report); nil != err2 {
+			sb.countTimeouts(err2)
+			sb.Logger.Println(err2)
+			failed[e.Key()] = failedKey{}
+			if err == nil {
+				err = err2
+			}
+		}
+	}
+	return failed, err
 }
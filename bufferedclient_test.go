@@ -0,0 +1,668 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestBufferDoubleClose(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	buffered.Incr("a", 1)
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffered.Close(); err != ErrClosed {
+		t.Errorf("expected ErrClosed on second Close, actual %v", err)
+	}
+	if err := buffered.Incr("a", 1); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, actual %v", err)
+	}
+	if err := buffered.Gauge("b", 1); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, actual %v", err)
+	}
+}
+
+func TestBufferProducerRacingClose(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for buffered.Incr("a", 1) == nil {
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+func TestBufferSendsRacingRepeatedClose(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 10; j++ {
+				buffered.Incr("a", 1)
+				buffered.Gauge("b", 1)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			buffered.Close()
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestBufferSendAfterClose(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for method, err := range callAll(buffered) {
+		if err != ErrClosed {
+			t.Errorf("%s: expected ErrClosed, actual %v", method, err)
+		}
+	}
+}
+
+func TestBufferStopsWhenClientClosed(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	clock := statsdtest.NewFakeClock(time.Now())
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	client.Close()
+	buffered.Incr("a", 1)
+	clock.Advance(time.Second)
+	select {
+	case <-buffered.done:
+	case <-time.After(time.Second):
+		t.Fatal("collector still running after the client was closed")
+	}
+	if err := buffered.Incr("a", 1); err != ErrClosed {
+		t.Errorf("expected ErrClosed, actual %v", err)
+	}
+}
+
+func TestBufferFlushOnTick(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	clock := statsdtest.NewFakeClock(time.Now())
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	defer buffered.Close()
+
+	start := clock.Now()
+	buffered.Incr("a", 1)
+	buffered.Incr("a", 2)
+	clock.Advance(1500 * time.Millisecond)
+	buffered.Since("elapsed", start)
+	clock.Advance(5 * time.Second)
+	if _, err := srv.WaitFor("myproject.a", 1, 50*time.Millisecond); err == nil {
+		t.Fatal("stats flushed before the flush interval")
+	}
+	clock.Advance(5 * time.Second)
+	metrics, err := srv.WaitFor("myproject.a", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[0].Value != "3" {
+		t.Errorf("expected 3, actual %s", metrics[0].Value)
+	}
+	metrics, err = srv.WaitFor("myproject.elapsed.max", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[0].Value != "1500" {
+		t.Errorf("expected 1500, actual %s", metrics[0].Value)
+	}
+}
+
+func TestBufferPrefixNormalization(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myapp"))
+	buffered.Incr("counter", 1)
+	buffered.Incr(".counter", 2)
+	buffered.Close()
+
+	metrics, err := srv.WaitFor("myapp.counter", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[0].Value != "3" {
+		t.Errorf("expected the two stats to be merged, actual %+v", srv.Metrics())
+	}
+}
+
+func TestBufferRawNames(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	buffered.Incr("orders.created", 1)
+	buffered.Incr(RawName("business.orders.created"), 2)
+	buffered.Incr(RawName("business.orders.created"), 3)
+	// a raw name equal to a prefixed one is the same metric
+	buffered.Incr(RawName("myproject.orders.created"), 4)
+	buffered.Close()
+
+	for name, value := range map[string]string{"myproject.orders.created": "5", "business.orders.created": "5"} {
+		metrics, err := srv.WaitFor(name, 1, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(metrics) != 1 || metrics[0].Value != value {
+			t.Errorf("%s: expected %s, actual %+v", name, value, metrics)
+		}
+	}
+	if n := len(srv.Metrics()); n != 2 {
+		t.Errorf("expected 2 metrics, actual %+v", srv.Metrics())
+	}
+}
+
+func TestBufferAbsoluteNotMerged(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	for i := int64(1); i <= 5; i++ {
+		buffered.Absolute("abs", i)
+		buffered.FAbsolute("fabs", float64(i)/2)
+	}
+	buffered.Close()
+
+	for _, name := range []string{"myproject.abs", "myproject.fabs"} {
+		metrics, err := srv.WaitFor(name, 5, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(metrics) != 5 {
+			t.Errorf("%s: expected 5 lines, actual %d", name, len(metrics))
+		}
+	}
+}
+
+func TestMaxAbsoluteValues(t *testing.T) {
+	buffered, flush := newContributionClient(t)
+	buffered.SetMaxAbsoluteValues(2)
+	for i := int64(1); i <= 3; i++ {
+		buffered.Absolute("abs", i)
+		buffered.FAbsolute("fabs", float64(i)/2)
+	}
+	expectLines(t, []string{"myproject.abs:1|a", "myproject.abs:2|a", "myproject.fabs:0.5|a", "myproject.fabs:1|a"}, flush())
+}
+
+// samplesRecorder records the number of samples of the timings forwarded to it
+type samplesRecorder struct {
+	*StatsdClient
+	mu      sync.Mutex
+	samples map[string]int
+}
+
+func (r *samplesRecorder) SendEvents(events ...event.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range events {
+		if timing, ok := e.(*event.Timing); ok {
+			r.samples[timing.Name] = len(timing.Samples)
+		}
+	}
+	return nil
+}
+
+// the pending timings are resized at the next flush
+func TestSetReservoirSize(t *testing.T) {
+	next := &samplesRecorder{StatsdClient: NewStatsdClient("localhost:8125", ""), samples: make(map[string]int)}
+	buffered := NewBufferedStatter(next, time.Hour, WithLogger(discardLogger{}))
+	for i := int64(0); i < 50; i++ {
+		buffered.Timing("before", i)
+	}
+	buffered.SetReservoirSize(10)
+	for i := int64(0); i < 50; i++ {
+		buffered.Timing("after", i)
+	}
+	buffered.Close()
+	next.mu.Lock()
+	defer next.mu.Unlock()
+	if expected := map[string]int{"before": 10, "after": 10}; !reflect.DeepEqual(expected, next.samples) {
+		t.Errorf("expected %v samples, actual %v", expected, next.samples)
+	}
+}
+
+// permutations returns all the orderings of the given gauge operations
+func permutations(ops []event.Event) [][]event.Event {
+	if len(ops) <= 1 {
+		return [][]event.Event{ops}
+	}
+	var ret [][]event.Event
+	for i := range ops {
+		rest := append(append([]event.Event{}, ops[:i]...), ops[i+1:]...)
+		for _, p := range permutations(rest) {
+			ret = append(ret, append([]event.Event{ops[i]}, p...))
+		}
+	}
+	return ret
+}
+
+func TestBufferGaugeInterleaving(t *testing.T) {
+	ops := []event.Event{
+		&event.Gauge{Name: "x", Value: 10},
+		&event.GaugeDelta{Name: "x", Value: 5},
+		&event.Gauge{Name: "x", Value: 3},
+		&event.GaugeDelta{Name: "x", Value: -20},
+	}
+	for _, seq := range permutations(ops) {
+		sb := &buffer{statsd: NewStatsdClient("localhost:8125", ""), events: make(map[string]event.Event)}
+		// model: the last set, plus all the deltas that follow it
+		var set *int64
+		var delta int64
+		desc := make([]string, 0, len(seq))
+		for _, op := range seq {
+			switch o := op.(type) {
+			case *event.Gauge:
+				v := o.Value
+				set, delta = &v, 0
+				desc = append(desc, fmt.Sprintf("set %d", v))
+				sb.add(&event.Gauge{Name: o.Name, Value: o.Value})
+			case *event.GaugeDelta:
+				delta += o.Value
+				desc = append(desc, fmt.Sprintf("delta %+d", o.Value))
+				sb.add(&event.GaugeDelta{Name: o.Name, Value: o.Value})
+			}
+		}
+		var expected []string
+		switch {
+		case set == nil:
+			expected = (&event.GaugeDelta{Name: "x", Value: delta}).Stats()
+		case *set+delta < 0:
+			expected = []string{"x:0|g", fmt.Sprintf("x:%d|g", *set+delta)}
+		default:
+			expected = []string{fmt.Sprintf("x:%d|g", *set+delta)}
+		}
+		if actual := sb.events["x"].Stats(); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("%s: expected %v, actual %v", strings.Join(desc, ", "), expected, actual)
+		}
+	}
+}
+
+func TestBufferFGaugeInterleaving(t *testing.T) {
+	tests := []struct {
+		ops      []event.Event
+		expected []string
+	}{
+		{[]event.Event{&event.FGauge{Name: "x", Value: 1.5}, &event.FGaugeDelta{Name: "x", Value: 0.25}}, []string{"x:1.75|g"}},
+		{[]event.Event{&event.FGaugeDelta{Name: "x", Value: 0.25}, &event.FGauge{Name: "x", Value: 1.5}}, []string{"x:1.5|g"}},
+		{[]event.Event{&event.FGaugeDelta{Name: "x", Value: 0.25}, &event.FGaugeDelta{Name: "x", Value: 0.5}}, []string{"x:+0.75|g"}},
+		{[]event.Event{&event.FGauge{Name: "x", Value: 1}, &event.FGauge{Name: "x", Value: 2}}, []string{"x:2|g"}},
+		{[]event.Event{&event.FGauge{Name: "x", Value: 1}, &event.FGaugeDelta{Name: "x", Value: -3}}, []string{"x:0|g", "x:-2|g"}},
+	}
+	for _, tt := range tests {
+		sb := &buffer{statsd: NewStatsdClient("localhost:8125", ""), events: make(map[string]event.Event)}
+		for _, op := range tt.ops {
+			sb.add(op)
+		}
+		if actual := sb.events["x"].Stats(); !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("expected %v, actual %v", tt.expected, actual)
+		}
+	}
+}
+
+func TestBufferFlushKeepsGaugeGroups(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetMaxPacketSize(64)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	for i := 0; i < 20; i++ {
+		buffered.Gauge(fmt.Sprintf("gauge%d", i), -int64(i+1))
+		buffered.Incr(fmt.Sprintf("counter%d", i), 1)
+	}
+	buffered.Close()
+
+	lines := 0
+	for _, p := range conn.packets {
+		packet := strings.Split(p, "\n")
+		for i, line := range packet {
+			lines++
+			if !strings.HasSuffix(line, ":0|g") {
+				continue
+			}
+			name := strings.TrimSuffix(line, ":0|g")
+			if i+1 == len(packet) || !strings.HasPrefix(packet[i+1], name+":-") {
+				t.Errorf("gauge %s split from its reset in %q", name, conn.packets)
+			}
+		}
+	}
+	if lines != 60 {
+		t.Errorf("expected 60 lines, actual %d in %q", lines, conn.packets)
+	}
+}
+
+// stuckConn is a net.Conn never draining: the writes block until the write
+// deadline, if honored, or until it's released
+type stuckConn struct {
+	net.Conn
+	honorDeadline bool
+	mu            sync.Mutex
+	deadline      time.Time
+	release       chan struct{}
+}
+
+func (c *stuckConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if c.honorDeadline && !deadline.IsZero() {
+		timeout = time.After(time.Until(deadline))
+	}
+	select {
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.release:
+		return len(b), nil
+	}
+}
+
+func (c *stuckConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *stuckConn) Close() error { return nil }
+
+func TestBufferCloseContext(t *testing.T) {
+	for _, honorDeadline := range []bool{true, false} {
+		conn := &stuckConn{honorDeadline: honorDeadline, release: make(chan struct{})}
+		client := NewStatsdClient("localhost:8125", "myproject.")
+		client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return conn, nil
+		}
+		buffered := NewStatsdBuffer(time.Hour, client)
+		buffered.Logger = discardLogger{}
+		buffered.Incr("a", 1)
+		buffered.Incr("b", 1)
+		buffered.Gauge("c", 1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		err := buffered.CloseContext(ctx)
+		elapsed := time.Since(start)
+		cancel()
+		if err == nil {
+			t.Errorf("honorDeadline %v: expected an error", honorDeadline)
+		} else if !strings.Contains(err.Error(), "deadline") {
+			t.Errorf("honorDeadline %v: undescriptive error %q", honorDeadline, err)
+		}
+		if elapsed > time.Second {
+			t.Errorf("honorDeadline %v: Close took %s", honorDeadline, elapsed)
+		}
+		if dropped := buffered.Stats().DroppedOnClose; dropped != 3 {
+			t.Errorf("honorDeadline %v: expected 3 events dropped, actual %d", honorDeadline, dropped)
+		}
+		close(conn.release)
+	}
+}
+
+// abortableConn is a stuckConn whose Close releases the writes
+type abortableConn struct {
+	stuckConn
+	once sync.Once
+}
+
+func (c *abortableConn) Close() error {
+	c.once.Do(func() { close(c.release) })
+	return nil
+}
+
+func TestCloseAbandoned(t *testing.T) {
+	newStuckBuffer := func(conn net.Conn) *StatsdBuffer {
+		client := NewStatsdClient("localhost:8125", "myproject.")
+		client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return conn, nil
+		}
+		buffered := NewStatsdBuffer(time.Hour, client)
+		buffered.Logger = discardLogger{}
+		buffered.SetCloseTimeout(20 * time.Millisecond)
+		buffered.Incr("a", 1)
+		waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+		return buffered
+	}
+
+	// the connection is closed under the stuck write once the close timeout passes
+	conn := &abortableConn{stuckConn: stuckConn{release: make(chan struct{})}}
+	buffered := newStuckBuffer(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := buffered.CloseContext(ctx); err == nil {
+		t.Fatal("expected the final flush to be abandoned")
+	}
+	select {
+	case <-buffered.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done once the connection was aborted")
+	}
+
+	// the background close gives up on a write nothing unblocks
+	stuck := &stuckConn{release: make(chan struct{})}
+	defer close(stuck.release)
+	buffered = newStuckBuffer(stuck)
+	logger := &recordingLogger{}
+	buffered.Logger = logger
+	if err := buffered.Close(); err == nil {
+		t.Fatal("expected the final flush to be abandoned")
+	}
+	waitUntil(t, time.Second, func() bool {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		return len(logger.lines) > 0 && strings.Contains(logger.lines[len(logger.lines)-1], "giving up")
+	})
+}
+
+func TestCloseClearsDeadline(t *testing.T) {
+	conn := &stuckConn{honorDeadline: true, release: make(chan struct{})}
+	close(conn.release)
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("a", 1)
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.deadline.IsZero() {
+		t.Errorf("the write deadline %s was left set", conn.deadline)
+	}
+}
+
+// the same aggregates must produce byte-identical packets, whatever the order of
+// the sends and the iteration order of the maps
+func TestBufferDeterministicFlush(t *testing.T) {
+	flush := func(reversed bool) []string {
+		client, conn := newPacketClient(t, "myproject.")
+		client.SetMaxPacketSize(200)
+		buffered := NewStatsdBuffer(time.Hour, client)
+		buffered.Logger = discardLogger{}
+		buffered.SetHistogramBuckets("t1", []float64{10})
+		for i := 0; i < 50; i++ {
+			n := i
+			if reversed {
+				n = 49 - i
+			}
+			buffered.Incr(fmt.Sprintf("c%d", n), int64(n))
+			buffered.Gauge(fmt.Sprintf("g%d", n), int64(-n))
+			buffered.Timing(fmt.Sprintf("t%d", n%3), int64(n))
+			buffered.Unique("u", fmt.Sprintf("v%d", n))
+		}
+		buffered.Close()
+		return conn.packets
+	}
+	expected := flush(false)
+	if len(expected) < 2 {
+		t.Fatalf("expected several packets, actual %q", expected)
+	}
+	for run := 0; run < 10; run++ {
+		if actual := flush(run%2 == 1); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("run %d: expected %q, actual %q", run, expected, actual)
+		}
+	}
+}
+
+// blockedConn is a packetConn whose first write blocks until it's released,
+// stalling a flush
+type blockedConn struct {
+	packetConn
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockedConn) Write(b []byte) (int, error) {
+	c.once.Do(func() {
+		close(c.entered)
+		<-c.release
+	})
+	return c.packetConn.Write(b)
+}
+
+// the intake must not wait on a flush in progress, however large
+func TestBufferDetachedFlush(t *testing.T) {
+	conn := &blockedConn{entered: make(chan struct{}), release: make(chan struct{})}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	reports := make(chan FlushReport, 1)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+
+	for i := 0; i < 20000; i++ {
+		buffered.Incr(fmt.Sprintf("key%05d", i), 1)
+	}
+	clock.Advance(time.Second)
+	<-conn.entered
+	// many more sends than the queue holds (100 events)
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			buffered.Incr("during", 1)
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("the sends blocked behind the flush")
+	}
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	if dropped := buffered.Stats().QueueDrops; len(dropped) > 0 {
+		t.Errorf("unexpected drops %v", dropped)
+	}
+
+	close(conn.release)
+	report := <-reports
+	if report.Keys != 20000 || report.Duration <= 0 || report.Duration < report.Sending {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if d := buffered.Stats().LastFlushDuration; d != report.Duration {
+		t.Errorf("expected a flush duration of %s, actual %s", report.Duration, d)
+	}
+	buffered.Close()
+	var during []string
+	for _, packet := range conn.sent() {
+		for _, line := range strings.Split(packet, "\n") {
+			if strings.HasPrefix(line, "myproject.during") {
+				during = append(during, line)
+			}
+		}
+	}
+	if len(during) != 1 || during[0] != "myproject.during:1000|c" {
+		t.Errorf("unexpected counters %q", during)
+	}
+}
+
+// a tick coming during a flush is skipped, it doesn't block the intake
+func TestBufferTickDuringFlush(t *testing.T) {
+	conn := &blockedConn{entered: make(chan struct{}), release: make(chan struct{})}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+
+	buffered.Incr("before", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	clock.Advance(time.Second)
+	<-conn.entered
+	buffered.Incr("during", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	clock.Advance(time.Second)
+	for i := 0; i < 1000; i++ {
+		buffered.Incr("after", 1)
+	}
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 2 })
+	if delayed := buffered.Stats().DelayedFlushes; delayed != 1 {
+		t.Errorf("expected 1 delayed flush, actual %d", delayed)
+	}
+	close(conn.release)
+	buffered.Close()
+	var lines []string
+	for _, packet := range conn.sent() {
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	expected := []string{"myproject.before:1|c", "myproject.after:1000|c", "myproject.during:1|c"}
+	if !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+}
@@ -0,0 +1,149 @@
+package statsd
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// burstAttempts is the number of times in a row the parked packets are written
+// again before they're dropped, see SetBurstBuffer
+const burstAttempts = 5
+
+// errBurstFull is returned when a packet can't be parked, see SetBurstBuffer
+var errBurstFull = errors.New("statsd: socket buffer full and burst buffer full, packet dropped")
+
+// burstBuffer holds the packets the kernel couldn't take on a burst, until
+// there's room in the socket buffer again
+type burstBuffer struct {
+	mu         sync.Mutex
+	packets    [][]byte
+	maxPackets int
+	delay      time.Duration
+	parked     int32 // len(packets), read atomically on every write
+	recovered  int64 // updated atomically
+	dropped    int64 // updated atomically
+	wake       chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+// isNoBufferSpace tells whether a write failed because the socket buffer of
+// the kernel is full, which a burst of UDP packets may cause on some systems
+func isNoBufferSpace(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN)
+}
+
+// SetBurstBuffer makes the client park up to maxPackets packets whose write
+// failed with ENOBUFS or EAGAIN (the socket buffer of the kernel is full), and
+// write them again after delay, in the order they were sent: meanwhile the new
+// packets are parked behind them. The packets still failing after 5 attempts,
+// and the ones which don't fit, are dropped; all of them are counted in Stats().
+// Close writes the packets still parked one last time.
+// Unlike EnableRetry it only handles this transient error, on the time scale
+// of a burst. It must be called before the client is used
+func (c *StatsdClient) SetBurstBuffer(maxPackets int, delay time.Duration) {
+	b := &burstBuffer{
+		maxPackets: maxPackets,
+		delay:      delay,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	c.mu.Lock()
+	c.burst = b
+	c.mu.Unlock()
+	c.loops.Add(1)
+	go b.loop(c)
+}
+
+// active tells whether packets are parked, so that the new ones must queue up
+func (b *burstBuffer) active() bool {
+	return atomic.LoadInt32(&b.parked) > 0
+}
+
+// park keeps a copy of a packet, unless the buffer is full
+func (b *burstBuffer) park(payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.packets) >= b.maxPackets {
+		atomic.AddInt64(&b.dropped, 1)
+		return errBurstFull
+	}
+	b.packets = append(b.packets, append([]byte(nil), payload...))
+	atomic.StoreInt32(&b.parked, int32(len(b.packets)))
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// stop terminates the loop, the parked packets are dropped
+func (b *burstBuffer) stop() {
+	b.stopOnce.Do(func() { close(b.done) })
+}
+
+func (b *burstBuffer) loop(c *StatsdClient) {
+	defer c.loops.Done()
+	attempts := 0
+	for {
+		if !b.active() {
+			select {
+			case <-b.wake:
+			case <-b.done:
+				return
+			}
+		}
+		select {
+		case <-time.After(b.delay):
+		case <-b.done:
+			return
+		}
+		if b.flush(c) {
+			attempts = 0
+		} else if attempts++; attempts >= burstAttempts {
+			b.drop()
+			attempts = 0
+		}
+	}
+}
+
+// flush writes the parked packets in order, until one fails. It returns
+// false if a write failed, true once they are all written
+func (b *burstBuffer) flush(c *StatsdClient) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return b.writeParked(c)
+}
+
+// writeParked is flush, the caller must hold c.mu
+func (b *burstBuffer) writeParked(c *StatsdClient) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.packets) > 0 {
+		if c.closed || c.conn == nil {
+			return false
+		}
+		packet := b.packets[0]
+		if _, err := c.conn.Write(packet); err != nil {
+			return false
+		}
+		c.markSent()
+		c.mirrorPacket(packet)
+		b.packets = b.packets[1:]
+		atomic.StoreInt32(&b.parked, int32(len(b.packets)))
+		atomic.AddInt64(&b.recovered, 1)
+	}
+	return true
+}
+
+// drop gives up on the parked packets
+func (b *burstBuffer) drop() {
+	b.mu.Lock()
+	atomic.AddInt64(&b.dropped, int64(len(b.packets)))
+	b.packets = nil
+	atomic.StoreInt32(&b.parked, 0)
+	b.mu.Unlock()
+}
@@ -0,0 +1,106 @@
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// nobufsConn is a packetConn whose next writes fail with ENOBUFS, as many as
+// scripted in failures (-1 for all of them)
+type nobufsConn struct {
+	packetConn
+	failMu   sync.Mutex
+	failures int
+}
+
+func (c *nobufsConn) Write(b []byte) (int, error) {
+	c.failMu.Lock()
+	if c.failures != 0 {
+		if c.failures > 0 {
+			c.failures--
+		}
+		c.failMu.Unlock()
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: os.NewSyscallError("write", syscall.ENOBUFS)}
+	}
+	c.failMu.Unlock()
+	return c.packetConn.Write(b)
+}
+
+func newBurstClient(t *testing.T, failures int, maxPackets int, delay time.Duration) (*StatsdClient, *nobufsConn) {
+	conn := &nobufsConn{failures: failures}
+	client := NewStatsdClient("localhost:8125", "")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	client.SetBurstBuffer(maxPackets, delay)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	return client, conn
+}
+
+func TestBurstBuffer(t *testing.T) {
+	// the kernel rejects the first packet, then the 2 first retries
+	client, conn := newBurstClient(t, 3, 10, time.Millisecond)
+	defer client.Close()
+	var expected []string
+	for i := 0; i < 8; i++ {
+		if err := client.Incr(fmt.Sprintf("c%d", i), 1); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, fmt.Sprintf("c%d:1|c", i))
+	}
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == 8 })
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	stats := client.Stats()
+	if stats.BurstPending != 0 || stats.BurstRecovered != 8 || stats.BurstDropped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	// the writes go straight to the socket again
+	client.Incr("after", 1)
+	if sent := conn.sent(); len(sent) != 9 || sent[8] != "after:1|c" {
+		t.Errorf("unexpected packets %q", sent)
+	}
+}
+
+func TestBurstBufferOverflow(t *testing.T) {
+	client, conn := newBurstClient(t, -1, 2, time.Millisecond)
+	defer client.Close()
+	for i := 0; i < 5; i++ {
+		err := client.Incr(fmt.Sprintf("c%d", i), 1)
+		if (i < 2) != (err == nil) {
+			t.Errorf("packet %d: unexpected error %v", i, err)
+		}
+	}
+	// the parked packets are given up on after 5 attempts
+	waitUntil(t, time.Second, func() bool { return client.Stats().BurstDropped == 5 })
+	if stats := client.Stats(); stats.BurstPending != 0 || stats.BurstRecovered != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if sent := conn.sent(); len(sent) != 0 {
+		t.Errorf("unexpected packets %q", sent)
+	}
+}
+
+func TestBurstBufferClose(t *testing.T) {
+	client, conn := newBurstClient(t, 1, 10, time.Hour)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("a", 1)
+	buffered.Gauge("b", 2)
+	// the final flush is parked, and written by Close
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if actual := conn.sent(); !reflect.DeepEqual([]string{"a:1|c\nb:2|g"}, actual) {
+		t.Errorf("unexpected packets %q", actual)
+	}
+}
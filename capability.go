@@ -0,0 +1,99 @@
+package statsd
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Feature identifies a capability of the client which depends on its output
+// mode, see Supports
+type Feature int
+
+// features depending on the output mode
+const (
+	// FeatureDirectSend is sending the stats without a buffered client, which
+	// aggregates them first: not supported in Graphite mode
+	FeatureDirectSend Feature = iota
+	// FeatureSets is counting unique values with Unique: Carbon has no sets,
+	// so in Graphite mode the buffered client must estimate them (see
+	// SetUniqueEstimation)
+	FeatureSets
+	numFeatures
+)
+
+// ErrSetsUnsupported is reported when the unique values of sets are dropped
+// because the output mode has no sets
+var ErrSetsUnsupported = errors.New("statsd: in Graphite mode the unique values are dropped, unless the buffered client estimates them (see SetUniqueEstimation)")
+
+// the error reported on the first use of each unsupported feature
+var unsupportedErrors = [numFeatures]error{
+	FeatureDirectSend: ErrGraphiteDirect,
+	FeatureSets:       ErrSetsUnsupported,
+}
+
+func (f Feature) String() string {
+	switch f {
+	case FeatureDirectSend:
+		return "direct sends"
+	case FeatureSets:
+		return "sets"
+	}
+	return "unknown feature"
+}
+
+// Supports tells whether the feature is available in the output mode of the
+// client, so that libraries can adapt: the calls using an unsupported feature
+// are dropped, logging an error the first time, and counted in
+// Stats().Unsupported
+func (c *StatsdClient) Supports(f Feature) bool {
+	switch f {
+	case FeatureDirectSend, FeatureSets:
+		return !c.isGraphite()
+	}
+	return false
+}
+
+// Supports tells whether the feature is available through the buffered
+// client, see StatsdClient.Supports
+func (sb *buffer) Supports(f Feature) bool {
+	switch f {
+	case FeatureDirectSend:
+		return true
+	case FeatureSets:
+		return sb.statsd.Supports(f) || atomic.LoadInt32(&sb.uniquePrecision) != 0
+	}
+	return false
+}
+
+// SetErrorHandler sets a function called the first time a call of the client
+// is dropped because the output mode doesn't support it (see Supports),
+// besides logging the error. The drops of a buffered client are reported to
+// its own handler instead, see StatsdBuffer.SetErrorHandler. The handler runs
+// on a goroutine of its own, since the drops are detected under the lock of
+// the client
+func (c *StatsdClient) SetErrorHandler(handler func(error)) {
+	c.handler.Store(handler)
+}
+
+// handleError passes an error to the error handler, if any
+func (c *StatsdClient) handleError(err error) {
+	if handler, _ := c.handler.Load().(func(error)); handler != nil {
+		handler(err)
+	}
+}
+
+// unsupported counts n metrics dropped because the feature isn't supported,
+// and returns the error describing it. The first time only, the error is
+// logged and passed to report, or to the error handler of the client if nil
+func (c *StatsdClient) unsupported(f Feature, n int64, report func(error)) error {
+	err := unsupportedErrors[f]
+	if atomic.AddInt64(&c.unsupportedDrops[f], n) == n {
+		c.Logger.Println(err)
+		if report != nil {
+			report(err)
+		} else {
+			go c.handleError(err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package statsd
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupports(t *testing.T) {
+	client, _ := newPacketClient(t, "")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+	if !client.Supports(FeatureDirectSend) || !client.Supports(FeatureSets) || !buffered.Supports(FeatureSets) {
+		t.Error("features unsupported in the statsd mode")
+	}
+	client.SetGraphite(true)
+	if client.Supports(FeatureDirectSend) || client.Supports(FeatureSets) {
+		t.Error("features supported in Graphite mode")
+	}
+	if !buffered.Supports(FeatureDirectSend) || buffered.Supports(FeatureSets) {
+		t.Error("unexpected features of the buffered client in Graphite mode")
+	}
+	buffered.SetUniqueEstimation(10)
+	if !buffered.Supports(FeatureSets) {
+		t.Error("estimated sets unsupported in Graphite mode")
+	}
+}
+
+func TestUnsupportedWarnsOnce(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	logger := &recordingLogger{}
+	client.Logger = logger
+	client.SetGraphite(true)
+	direct := make(chan error, 10)
+	client.SetErrorHandler(func(err error) { direct <- err })
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	var mu sync.Mutex
+	var reported []error
+	buffered.SetErrorHandler(func(err error) {
+		mu.Lock()
+		reported = append(reported, err)
+		mu.Unlock()
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := buffered.Unique("visitors", "joe"); err != nil {
+			t.Errorf("Unique: %v", err)
+		}
+		if err := client.Incr("direct", 1); err != ErrGraphiteDirect {
+			t.Errorf("Incr: expected ErrGraphiteDirect, actual %v", err)
+		}
+	}
+	client.Unique("direct", "joe")
+	buffered.Close()
+
+	expected := []string{ErrGraphiteDirect.Error() + "\n", ErrSetsUnsupported.Error() + "\n"}
+	if !reflect.DeepEqual(expected, logger.lines) && !reflect.DeepEqual([]string{expected[1], expected[0]}, logger.lines) {
+		t.Errorf("expected a single warning per feature, actual %q", logger.lines)
+	}
+	mu.Lock()
+	if len(reported) != 1 || reported[0] != ErrSetsUnsupported {
+		t.Errorf("expected a single ErrSetsUnsupported, actual %v", reported)
+	}
+	mu.Unlock()
+	// the direct drops go to the handler of the client
+	if err := <-direct; err != ErrGraphiteDirect {
+		t.Errorf("expected ErrGraphiteDirect, actual %v", err)
+	}
+	select {
+	case err := <-direct:
+		t.Errorf("expected a single error, actual %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	drops := map[Feature]int64{FeatureDirectSend: 4, FeatureSets: 3}
+	if actual := client.Stats().Unsupported; !reflect.DeepEqual(drops, actual) {
+		t.Errorf("expected %v drops, actual %v", drops, actual)
+	}
+	if len(conn.packets) != 0 {
+		t.Errorf("unexpected packets %q", conn.packets)
+	}
+}
@@ -0,0 +1,113 @@
+package statsd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// uniqueNamesName is the gauge of the distinct names, see SetTelemetry
+const uniqueNamesName = "statsd.client.unique_names"
+
+// NameCount is the number of distinct stat names seen under a prefix
+type NameCount struct {
+	Prefix string
+	Names  int
+}
+
+// cardinality tracks the distinct stat names, in bounded memory
+type cardinality struct {
+	mu       sync.Mutex
+	names    map[string]struct{}
+	prefixes map[string]int
+	maxNames int
+	depth    int
+	overflow int64 // metrics not tracked because maxNames was reached
+}
+
+// TrackCardinality makes the client count the distinct metric names it sends
+// (with the client prefix, as they're sent, also through the buffered client),
+// grouped by their first depth segments, to catch cardinality explosions.
+// At most maxNames names are remembered: the metrics with a new name once the
+// limit is reached are only counted as overflow in Stats(). With SetTelemetry,
+// the buffered clients also send their number as the gauge
+// statsd.client.unique_names. It must be called before the client is used
+func (c *StatsdClient) TrackCardinality(maxNames int, depth int) {
+	c.cardinality = &cardinality{
+		names:    make(map[string]struct{}),
+		prefixes: make(map[string]int),
+		maxNames: maxNames,
+		depth:    depth,
+	}
+}
+
+// CardinalityReport returns the number of distinct names seen per prefix,
+// the top offenders first, or nil if the tracking is not enabled
+func (c *StatsdClient) CardinalityReport() []NameCount {
+	t := c.cardinality
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	report := make([]NameCount, 0, len(t.prefixes))
+	for prefix, n := range t.prefixes {
+		report = append(report, NameCount{Prefix: prefix, Names: n})
+	}
+	t.mu.Unlock()
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Names != report[j].Names {
+			return report[i].Names > report[j].Names
+		}
+		return report[i].Prefix < report[j].Prefix
+	})
+	return report
+}
+
+// unique returns the number of distinct names seen
+func (t *cardinality) unique() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.names)
+}
+
+// reportUniqueNames adds the number of distinct names sent so far to the
+// stats, when both the telemetry and the tracking are on. It's only called
+// from within the collector, at every tick
+func (sb *buffer) reportUniqueNames() {
+	t := sb.statsd.cardinality
+	if t == nil || atomic.LoadInt32(&sb.telemetry) == 0 {
+		return
+	}
+	sb.add(&event.Gauge{Name: uniqueNamesName, Value: int64(t.unique())})
+}
+
+// observe records a metric name
+func (t *cardinality) observe(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.names[name]; ok {
+		return
+	}
+	if len(t.names) >= t.maxNames {
+		t.overflow++
+		return
+	}
+	t.names[name] = struct{}{}
+	t.prefixes[namePrefix(name, t.depth)]++
+}
+
+// namePrefix returns the first depth segments of a name
+func namePrefix(name string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		next := strings.IndexByte(name[end:], '.')
+		if next < 0 {
+			return name
+		}
+		end += next + 1
+	}
+	return strings.TrimSuffix(name[:end], ".")
+}
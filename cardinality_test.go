@@ -0,0 +1,105 @@
+package statsd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestCardinalityReport(t *testing.T) {
+	client, _ := newPacketClient(t, "myproject.")
+	client.TrackCardinality(1000, 2)
+	buffered := NewStatsdBuffer(time.Hour, client)
+
+	for i := 0; i < 3; i++ {
+		// repeated names are counted once
+		for user := 0; user < 300; user++ {
+			buffered.Incr(fmt.Sprintf("users.u%d.logins", user), 1)
+		}
+		for shard := 0; shard < 50; shard++ {
+			client.Gauge(fmt.Sprintf("shards.s%d.lag", shard), 1)
+		}
+		client.Incr("requests", 1)
+		client.IncrMap(map[string]int64{"http.200": 1, "http.500": 1})
+	}
+	buffered.Close()
+
+	expected := []NameCount{
+		{Prefix: "myproject.users", Names: 300},
+		{Prefix: "myproject.shards", Names: 50},
+		{Prefix: "myproject.http", Names: 2},
+		{Prefix: "myproject.requests", Names: 1},
+	}
+	if report := client.CardinalityReport(); !reflect.DeepEqual(expected, report) {
+		t.Errorf("expected %+v, actual %+v", expected, report)
+	}
+	if stats := client.Stats(); stats.UniqueNames != 353 || stats.CardinalityOverflow != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// memory is bounded
+	capped, _ := newPacketClient(t, "")
+	capped.TrackCardinality(10, 1)
+	for i := 0; i < 100; i++ {
+		capped.Incr(fmt.Sprintf("n%d", i), 1)
+	}
+	if stats := capped.Stats(); stats.UniqueNames != 10 || stats.CardinalityOverflow != 90 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCardinalityTelemetry(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetClock(clock)
+	client.TrackCardinality(1000, 1)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+	buffered.SetTelemetry(true)
+	reports := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+
+	for i := 0; i < 3; i++ {
+		buffered.Incr(fmt.Sprintf("hits.h%d", i), 1)
+		clock.Advance(10 * time.Second)
+		<-reports
+	}
+	// the names of the flush are counted, the gauge itself from the next one
+	expected := []string{"2", "4", "5"}
+	metrics, err := srv.WaitFor("myproject."+uniqueNamesName, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range metrics {
+		if m.Type != "g" || m.Value != expected[i] {
+			t.Errorf("expected the gauge %s, actual %q", expected[i], m.Raw)
+		}
+	}
+
+	// the telemetry alone has no names to report
+	quiet := NewStatsdClient(srv.Addr(), "quiet.")
+	quiet.SetClock(clock)
+	qbuffered := NewStatsdBuffer(10*time.Second, quiet)
+	qbuffered.Logger = discardLogger{}
+	defer qbuffered.Close()
+	qbuffered.SetTelemetry(true)
+	qbuffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+	clock.Advance(10 * time.Second)
+	<-reports
+	<-reports
+	if _, err := srv.WaitFor("quiet."+flushSkewName+".max", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range srv.Metrics() {
+		if m.Name == "quiet."+uniqueNamesName {
+			t.Errorf("unexpected %q", m.Raw)
+		}
+	}
+}
@@ -1,14 +1,20 @@
 package statsd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
 )
 
 // Logger interface compatible with log.Logger
@@ -16,6 +22,20 @@ type Logger interface {
 	Println(v ...interface{})
 }
 
+// ErrClosed is returned when sending stats through a client that has been closed
+var ErrClosed = errors.New("statsd: client is closed")
+
+// errNotConnected is returned when sending stats before CreateSocket succeeded
+var errNotConnected = errors.New("cannot send stats, not connected to StatsD server")
+
+// ErrInvalidValue is returned when sending a NaN or infinite floating point value
+var ErrInvalidValue = errors.New("statsd: NaN and infinite values can't be sent")
+
+// ErrEmptyName is returned when a stat name is empty once trimmed, mapped and
+// normalized (e.g. "" or "..."): the metric is dropped, and counted as such in
+// StatsByKind
+var ErrEmptyName = errors.New("statsd: empty stat name")
+
 // note Hostname is exported so clients can set it to something different than the default
 var Hostname string
 
@@ -26,12 +46,108 @@ func init() {
 	}
 }
 
-// StatsdClient is a client library to send events to StatsD
+// StatsdClient is a client library to send events to StatsD.
+// It is safe for concurrent use by multiple goroutines: every metric
+// (including multi-line ones, like negative gauges) is formatted and
+// written to the socket atomically
 type StatsdClient struct {
-	conn   net.Conn
-	addr   string
-	prefix string
-	Logger Logger
+	mu        sync.Mutex // guards conn and the writes to it
+	conn      net.Conn
+	closed    bool
+	strict    int32 // set atomically, see SetStrictNames
+	zeroes    int32 // set atomically, see SetSendZeroCounts
+	normalize int32 // set atomically, see SetNormalizeNames
+	graphite  int32 // set atomically, see SetGraphite
+	announce  int32 // set atomically, see SetAnnounceOnStart
+	buffered  int32 // set when wrapped by a StatsdBuffer
+	addr      string
+	prefix    string
+	// maximum size of the packets of the batch calls, lowered by the
+	// downshifts (see SetMaxPacketSize), guarded by mu
+	packetSize int
+	downshifts int64
+	// scratch buffers the payloads and the lines packed into them are
+	// built into, guarded by mu
+	buf     []byte
+	scratch []byte
+	packer  wire.Packer
+	dial    func(network, address string, timeout time.Duration) (net.Conn, error)
+	// the sizes of the groups of the event packed, guarded by mu, see
+	// packer.addEvent
+	groupSizes []int
+	// the timeout of the connections, set atomically (see SetDialTimeout)
+	dialTimeout int64
+	// the dialer and the socket control hook dial is made of, see SetNetDialer
+	// and SetSocketControl
+	netDialer     *net.Dialer
+	socketControl func(network, address string, conn syscall.RawConn) error
+	// the maximum number of decimal digits of the floating point values, set
+	// atomically (see SetFloatPrecision)
+	floatPrecision int32
+	// the running values of the gauges in Graphite mode, guarded by mu, see
+	// setGraphiteGauge
+	graphiteGauges map[string]float64
+	// the sockets of the striped flushes, see SetFlushSockets
+	stripes stripePool
+	retry   *retryQueue
+	burst   *burstBuffer // see SetBurstBuffer
+	warmup  *warmup      // see SetWarmupBuffer
+	mirror  *mirror      // see SetMirror
+	filter  atomic.Value // *metricFilter
+	// the seed of the sampling of the mirrors, if set, see SetMirrorSeed
+	mirrorSeed *int64
+	// serializes the updates to the filter
+	filterMu sync.Mutex
+	filtered int64        // updated atomically
+	rawLines int64        // updated atomically, see WriteRaw
+	lastSend int64        // unix nanoseconds, updated atomically, see LastSendTime
+	mapper   atomic.Value // func(string) string, see SetNameMapper
+	marker   atomic.Value // string, see SetRawMarker
+	current  atomic.Value // connRef, conn readable without mu, see abortConn
+	handler  atomic.Value // func(error), see SetErrorHandler
+	aliases  atomic.Value // *aliases, see AddAlias
+	aliasMu  sync.Mutex   // serializes the updates to the aliases
+	origin   atomic.Value // *origin, see SetContainerID
+	originMu sync.Mutex   // serializes the updates to the origin fields
+	clock    atomic.Value // clockValue, see SetClock
+	sampling atomic.Value // *sampling, see SetSampleRate
+	adaptive atomic.Value // *adaptive, see SetAdaptiveSampling
+	observe  atomic.Value // *observeNames, see SetObserveNames
+	dynamic  atomic.Value // *dynamicPrefix, see SetPrefixProvider
+	schema   atomic.Value // *nameSchema, see SetNameSchema
+	// names violating the schema, updated atomically
+	schemaViolations int64
+	// the payloads dropped by the retry queues replaced, updated atomically
+	// (see EnableRetry)
+	retryDropped int64
+	// stops the refresh of the dynamic prefix, guarded by mu
+	prefixStop chan struct{}
+	// the background loops (retries, burst buffer, prefix refresh), stopped by Close
+	loops sync.WaitGroup
+	// the enable switch, and the metrics it dropped, updated atomically (see
+	// SetEnabledFunc)
+	enabled    atomic.Value // *enabledFunc
+	suppressed int64
+	// the compression of the stream transports, see SetCompression
+	compression atomic.Value // *compression
+	// the admin interface of the server, guarded by mu (see SetAdminAddress)
+	adminAddr string
+	// whether the packets are numbered and the last number, updated
+	// atomically, and the buffer of the numbered packets, guarded by mu (see
+	// SetSequenceTrailer)
+	numbered int32
+	sequence int64
+	seqBuf   []byte
+	// metrics skipped by sampling per kind, updated atomically
+	sampledOut  [numKinds]int64
+	random      func() float64 // rand.Float64 if nil
+	cardinality *cardinality   // see TrackCardinality
+	malformed   *malformed     // see TrackMalformedNames
+	// metrics per kind and outcome, updated atomically (see StatsByKind)
+	outcomes [numKinds][numOutcomes]int64
+	// metrics dropped per unsupported feature, updated atomically
+	unsupportedDrops [numFeatures]int64
+	Logger           Logger
 }
 
 // NewStatsdClient - Factory
@@ -39,33 +155,408 @@ func NewStatsdClient(addr string, prefix string) *StatsdClient {
 	// allow %HOST% in the prefix string
 	prefix = strings.Replace(prefix, "%HOST%", Hostname, 1)
 	return &StatsdClient{
-		addr:   addr,
-		prefix: prefix,
-		Logger: log.New(os.Stdout, "[StatsdClient] ", log.Ldate|log.Ltime),
+		addr:       addr,
+		prefix:     normalizePrefix(prefix),
+		packetSize: DefaultMaxPacketSize,
+		buf:        make([]byte, 0, DefaultMaxPacketSize),
+		dial:       net.DialTimeout,
+		Logger:     log.New(os.Stdout, "[StatsdClient] ", log.Ldate|log.Ltime),
+	}
+}
+
+// normalizePrefix makes sure a non-empty prefix ends with exactly one separator,
+// whether or not the caller included it: "myapp" and "myapp." are equivalent
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimRight(prefix, ".")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "."
+}
+
+// trimStat strips any leading separator from a stat name,
+// so that it's joined to the prefix with a single one
+func trimStat(stat string) string {
+	return strings.TrimLeft(stat, ".")
+}
+
+// SetStrictNames selects how stat names containing characters reserved by the
+// wire format are handled: by default they are escaped, in strict mode the
+// metric is rejected with ErrInvalidName
+func (c *StatsdClient) SetStrictNames(strict bool) {
+	var v int32
+	if strict {
+		v = 1
+	}
+	atomic.StoreInt32(&c.strict, v)
+}
+
+// SetNormalizeNames makes the client normalize the stat names with NormalizeName,
+// so that e.g. "HTTP.Requests" and "http.requests" are the same metric (and are
+// merged by the buffered client). The client prefix is not normalized
+func (c *StatsdClient) SetNormalizeNames(normalize bool) {
+	var v int32
+	if normalize {
+		v = 1
+	}
+	atomic.StoreInt32(&c.normalize, v)
+}
+
+// SetSendZeroCounts makes Incr and Decr send counters with a count of 0
+// (skipped by default), e.g. as heartbeats telling "no events" apart from "no data".
+// The buffered client honours the setting of the client it wraps
+func (c *StatsdClient) SetSendZeroCounts(send bool) {
+	var v int32
+	if send {
+		v = 1
+	}
+	atomic.StoreInt32(&c.zeroes, v)
+}
+
+// skipCount tells whether a counter update must not be sent
+func (c *StatsdClient) skipCount(count int64) bool {
+	return 0 == count && atomic.LoadInt32(&c.zeroes) == 0
+}
+
+// SetNameMapper installs a function translating stat names on the fly, e.g. while
+// migrating naming conventions. It receives the full name, prefixed (after %HOST%
+// expansion, without the raw marker; a raw name isn't prefixed), and returns the
+// full name: a name mapped out of the prefix is sent without it. With a prefix
+// provider, it's the prefix current when the stat is sent. It's applied before
+// sanitization and before the buffered client aggregates, so stats mapped onto
+// the same name are merged. nil removes the mapper
+func (c *StatsdClient) SetNameMapper(mapper func(string) string) {
+	c.mapper.Store(mapper)
+}
+
+// NameMapper builds a mapper for SetNameMapper: names found in the names map are
+// replaced, otherwise the longest matching prefix in the prefixes map is rewritten
+func NameMapper(names map[string]string, prefixes map[string]string) func(string) string {
+	return func(stat string) string {
+		if mapped, ok := names[stat]; ok {
+			return mapped
+		}
+		longest := ""
+		for from := range prefixes {
+			if strings.HasPrefix(stat, from) && len(from) > len(longest) {
+				longest = from
+			}
+		}
+		if longest == "" {
+			return stat
+		}
+		return prefixes[longest] + stat[len(longest):]
+	}
+}
+
+// DefaultRawMarker is the leading marker of the stat names sent verbatim,
+// without the client prefix, e.g. "=business.orders.created", unless the
+// client sets another one, see SetRawMarker
+const DefaultRawMarker = "="
+
+// RawName marks a stat name to be sent without the client prefix by the
+// clients with DefaultRawMarker, see StatsdClient.RawName for the other ones
+func RawName(stat string) string {
+	return DefaultRawMarker + stat
+}
+
+// SetRawMarker sets the leading marker of the stat names the client sends
+// verbatim, without its prefix (DefaultRawMarker by default), e.g. when "="
+// starts some regular names. "" disables the raw names, e.g. to send the
+// names received from another system as is under the prefix
+func (c *StatsdClient) SetRawMarker(marker string) {
+	c.marker.Store(marker)
+}
+
+// rawMarker returns the marker of the raw names, see SetRawMarker
+func (c *StatsdClient) rawMarker() string {
+	if marker, ok := c.marker.Load().(string); ok {
+		return marker
+	}
+	return DefaultRawMarker
+}
+
+// rawMarked is implemented by the clients and the views with a marker of the
+// raw names, see SetRawMarker
+type rawMarked interface {
+	rawMarker() string
+}
+
+// rawMarkerOf returns the marker of the raw names of client, DefaultRawMarker
+// if it doesn't tell
+func rawMarkerOf(client Statsd) string {
+	if m, ok := client.(rawMarked); ok {
+		return m.rawMarker()
+	}
+	return DefaultRawMarker
+}
+
+// RawName marks a stat name to be sent without the prefix of the client, with
+// its marker (see SetRawMarker). With the raw names disabled, it returns the
+// name as is
+func (c *StatsdClient) RawName(stat string) string {
+	return c.rawMarker() + stat
+}
+
+// metricName expands %HOST% in the stat name, maps it, makes it safe to send
+// and prepends the client prefix, unless the name starts with the raw marker
+// (see SetRawMarker)
+func (c *StatsdClient) metricName(stat string) (string, error) {
+	return c.resolveName(stat, true)
+}
+
+// resolveName is metricName, counting the malformed names only if track is true
+// (see TrackMalformedNames), so that a name resolved twice is counted once
+func (c *StatsdClient) resolveName(stat string, track bool) (string, error) {
+	return c.resolveNameAs(stat, track, false, false)
+}
+
+// resolveKey is resolveName for the keys aggregated by the buffered client:
+// while a prefix provider is set, they start with deferredPrefix instead of
+// the prefix, applied at flush time. The keys of the qualified events (see
+// event.PreQualified) are never prefixed
+func (c *StatsdClient) resolveKey(stat string, qualified bool) (string, error) {
+	return c.resolveNameAs(stat, false, true, qualified)
+}
+
+// resolveNameAs is resolveName, prefixing the name with deferredPrefix if
+// deferred is true and a prefix provider is set, and without any prefix if
+// qualified is true
+func (c *StatsdClient) resolveNameAs(stat string, track bool, deferred bool, qualified bool) (string, error) {
+	prefix, dynamic := c.currentPrefix()
+	key := prefix
+	if deferred && dynamic {
+		key = deferredPrefix
+	}
+	original := stat
+	stat = strings.Replace(stat, "%HOST%", Hostname, 1)
+	if qualified {
+		prefix, key = "", ""
+	} else if marker := c.rawMarker(); marker != "" && strings.HasPrefix(stat, marker) {
+		stat = stat[len(marker):]
+		prefix, key = "", ""
+	}
+	stat = trimStat(stat)
+	if mapper, _ := c.mapper.Load().(func(string) string); mapper != nil {
+		if full := mapper(prefix + stat); strings.HasPrefix(full, prefix) {
+			stat = trimStat(full[len(prefix):])
+		} else {
+			// mapped out of the prefix, sent as is
+			stat, prefix, key = trimStat(full), "", ""
+		}
+	}
+	mapped := stat
+	if atomic.LoadInt32(&c.normalize) != 0 {
+		stat = NormalizeName(stat)
+	}
+	if stat == "" {
+		return key, ErrEmptyName
 	}
+	if atomic.LoadInt32(&c.strict) != 0 {
+		if err := Validate(FieldName, stat); err != nil {
+			if track && c.malformed != nil {
+				c.malformed.observe(c.Logger, original, false)
+			}
+			return key + stat, err
+		}
+	} else {
+		stat = Escape(FieldName, stat)
+	}
+	if track && c.malformed != nil && stat != mapped {
+		c.malformed.observe(c.Logger, original, true)
+	}
+	if err := c.checkSchema(prefix, stat, track); err != nil {
+		return key + stat, err
+	}
+	if c.cardinality != nil {
+		c.cardinality.observe(prefix + stat)
+	}
+	return key + stat, nil
 }
 
 // String returns the StatsD server address
 func (c *StatsdClient) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.addr
 }
 
-// CreateSocket creates a UDP connection to a StatsD server
+// SetAddress switches the client to a new address (see ParseAddr) at runtime:
+// the new connection is dialed, swapped with the current one between two
+// sends, and the old connection is closed. The payloads waiting to be retried
+// are sent to the new address. If the new address can't be dialed, the client
+// keeps sending to the current one and the error is returned
+func (c *StatsdClient) SetAddress(addr string) error {
+	conn, err := c.dialTarget(addr, c.connectTimeout())
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	old := c.conn
+	c.announceTo(conn, addr)
+	c.addr, c.conn = addr, conn
+	c.current.Store(connRef{conn})
+	c.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// SetDialer replaces the function used by CreateSocket to open the connection,
+// e.g. with statsdtest.DiscardSender.Dial in benchmarks. It must be called
+// before CreateSocket
+func (c *StatsdClient) SetDialer(dial func(network, address string, timeout time.Duration) (net.Conn, error)) {
+	c.dial = dial
+}
+
+// DefaultDialTimeout is the timeout of the connections the client opens, see
+// SetDialTimeout
+const DefaultDialTimeout = 5 * time.Second
+
+// SetDialTimeout sets the timeout of the connections the client opens: by
+// CreateSocket, SetAddress and DialMirror, and the sockets of the striped
+// flushes (see StatsdBuffer.SetFlushSockets). 0 or less restores
+// DefaultDialTimeout
+func (c *StatsdClient) SetDialTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	atomic.StoreInt64(&c.dialTimeout, int64(timeout))
+}
+
+// connectTimeout returns the dial timeout, see SetDialTimeout
+func (c *StatsdClient) connectTimeout() time.Duration {
+	if timeout := time.Duration(atomic.LoadInt64(&c.dialTimeout)); timeout > 0 {
+		return timeout
+	}
+	return DefaultDialTimeout
+}
+
+// SetNetDialer makes CreateSocket open the connections of every transport with
+// d, e.g. to bind the source address with d.LocalAddr or to set socket options
+// with d.Control. The timeout of CreateSocket applies when d.Timeout is zero
+// or longer. It composes with SetSocketControl, whichever is called first. It
+// must be called before CreateSocket
+func (c *StatsdClient) SetNetDialer(d *net.Dialer) {
+	c.netDialer = d
+	c.useNetDialer()
+}
+
+// SetSocketControl makes CreateSocket call control on every socket it creates,
+// before connecting it, to set options such as SO_SNDBUF or SO_MARK (see
+// net.Dialer.Control). The dialer of SetNetDialer is kept: its own Control,
+// if any, is called first. It must be called before CreateSocket
+func (c *StatsdClient) SetSocketControl(control func(network, address string, conn syscall.RawConn) error) {
+	c.socketControl = control
+	c.useNetDialer()
+}
+
+// useNetDialer makes the dialer of SetNetDialer, with the hook of
+// SetSocketControl, the one of CreateSocket
+func (c *StatsdClient) useNetDialer() {
+	var base net.Dialer
+	if c.netDialer != nil {
+		base = *c.netDialer
+	}
+	if control := c.socketControl; control != nil {
+		if own := base.Control; own != nil {
+			base.Control = func(network, address string, conn syscall.RawConn) error {
+				if err := own(network, address, conn); err != nil {
+					return err
+				}
+				return control(network, address, conn)
+			}
+		} else {
+			base.Control = control
+		}
+	}
+	c.SetDialer(func(network, address string, timeout time.Duration) (net.Conn, error) {
+		dialer := base
+		if dialer.Timeout == 0 || dialer.Timeout > timeout {
+			dialer.Timeout = timeout
+		}
+		return dialer.Dial(network, address)
+	})
+}
+
+// CreateSocket creates a connection to a StatsD server: UDP by default, or
+// the transport selected by the scheme of the address, see ParseAddr.
+// If the client is already connected, the previous connection is closed
+// once the new one is in place, so calling it repeatedly doesn't leak sockets.
+// A closed client can't be reconnected
 func (c *StatsdClient) CreateSocket() error {
-	conn, err := net.DialTimeout("udp", c.addr, 5*time.Second)
+	c.mu.Lock()
+	addr := c.addr
+	c.mu.Unlock()
+	conn, err := c.dialTarget(addr, c.connectTimeout())
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	if c.addr != addr {
+		// SetAddress swapped in a connection to the new address meanwhile
+		c.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	old := c.conn
+	if old == nil {
+		c.announceTo(conn, addr)
+	}
 	c.conn = conn
+	c.current.Store(connRef{conn})
+	if c.warmingUp() {
+		c.replayWarmup()
+	}
+	c.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
 	return nil
 }
 
-// Close the UDP connection
+// Close the UDP connection. Any later call on the client returns ErrClosed
 func (c *StatsdClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.burst != nil {
+		c.burst.writeParked(c)
+		c.burst.stop()
+	}
+	if c.warmingUp() {
+		c.replayWarmup()
+	}
+	c.closed = true
+	if c.retry != nil {
+		c.retry.stop()
+	}
+	if c.prefixStop != nil {
+		close(c.prefixStop)
+		c.prefixStop = nil
+	}
+	c.closeMirror()
+	c.stripes.close()
 	if nil == c.conn {
 		return nil
 	}
-	return c.conn.Close()
+	err := c.conn.Close()
+	c.conn = nil
+	c.current.Store(connRef{})
+	return err
 }
 
 // See statsd data types here: http://statsd.readthedocs.org/en/latest/types.html
@@ -73,30 +564,94 @@ func (c *StatsdClient) Close() error {
 
 // Incr - Increment a counter metric. Often used to note a particular event
 func (c *StatsdClient) Incr(stat string, count int64) error {
-	if 0 != count {
-		return c.send(stat, "%d|c", count)
+	if c.skipCount(count) {
+		return nil
 	}
-	return nil
+	return c.send(KindCounter, stat, "%d|c", count)
 }
 
 // Decr - Decrement a counter metric. Often used to note a particular event
 func (c *StatsdClient) Decr(stat string, count int64) error {
-	if 0 != count {
-		return c.send(stat, "%d|c", -count)
+	if c.skipCount(count) {
+		return nil
 	}
-	return nil
+	return c.send(KindCounter, stat, "%d|c", -count)
 }
 
 // Timing - Track a duration event
 // the time delta must be given in milliseconds
 func (c *StatsdClient) Timing(stat string, delta int64) error {
-	return c.send(stat, "%d|ms", delta)
+	return c.send(KindTiming, stat, "%d|ms", delta)
+}
+
+// SetFloatPrecision sets the maximum number of decimal digits of the floating
+// point values the client sends, including the ones of the events aggregated
+// by a buffered client, from its next flush. The values smaller than that are
+// rounded to 0. 0 or less restores event.DefaultFloatPrecision
+func (c *StatsdClient) SetFloatPrecision(digits int) {
+	if digits < 0 {
+		digits = 0
+	}
+	atomic.StoreInt32(&c.floatPrecision, int32(digits))
+}
+
+// precision returns the maximum number of decimal digits, see SetFloatPrecision
+func (c *StatsdClient) precision() int {
+	return int(atomic.LoadInt32(&c.floatPrecision))
+}
+
+// formatFloat renders a value with the precision of the client, see
+// SetFloatPrecision
+func (c *StatsdClient) formatFloat(v float64) string {
+	return event.FormatFloatPrecision(v, c.precision())
+}
+
+// milliseconds renders a duration as fractional milliseconds like
+// event.Milliseconds, with the precision of the client
+func (c *StatsdClient) milliseconds(d time.Duration) string {
+	return c.formatFloat(float64(d) / float64(time.Millisecond))
 }
 
 // PrecisionTiming - Track a duration event
-// the time delta has to be a duration
+// the time delta has to be a duration, it's sent as fractional milliseconds
+// with up to event.DefaultFloatPrecision decimal digits (314µs is sent as
+// 0.314), see SetFloatPrecision
 func (c *StatsdClient) PrecisionTiming(stat string, delta time.Duration) error {
-	return c.send(stat, fmt.Sprintf("%.6f%s|ms", float64(delta)/float64(time.Millisecond), "%d"), 0)
+	return c.send(KindTiming, stat, "%s|ms", c.milliseconds(delta))
+}
+
+// TimingMicroseconds - Track a duration event given in microseconds,
+// sent as fractional milliseconds like PrecisionTiming
+func (c *StatsdClient) TimingMicroseconds(stat string, us float64) error {
+	if !event.IsFinite(us) {
+		return ErrInvalidValue
+	}
+	return c.send(KindTiming, stat, "%s|ms", c.formatFloat(us/1000))
+}
+
+// FTiming - Track a duration event given in floating point milliseconds
+func (c *StatsdClient) FTiming(stat string, ms float64) error {
+	if !event.IsFinite(ms) {
+		return ErrInvalidValue
+	}
+	return c.send(KindTiming, stat, "%s|ms", c.formatFloat(ms))
+}
+
+// fTimer is implemented by the clients which track the floating point timings
+type fTimer interface {
+	FTiming(stat string, ms float64) error
+}
+
+// fTiming is the FTiming of s if it has one, or else a PrecisionTiming of the
+// duration rounded to the nanosecond
+func fTiming(s Statsd, stat string, ms float64) error {
+	if c, ok := s.(fTimer); ok {
+		return c.FTiming(stat, ms)
+	}
+	if !event.IsFinite(ms) {
+		return ErrInvalidValue
+	}
+	return s.PrecisionTiming(stat, time.Duration(ms*float64(time.Millisecond)))
 }
 
 // Gauge - Gauges are a constant data type. They are not subject to averaging,
@@ -107,75 +662,326 @@ func (c *StatsdClient) PrecisionTiming(stat string, delta time.Duration) error {
 // first setting it to zero.
 func (c *StatsdClient) Gauge(stat string, value int64) error {
 	if value < 0 {
-		c.send(stat, "%d|g", 0)
-		return c.send(stat, "%d|g", value)
+		return c.sendNegativeGauge(stat, "%d|g", value)
 	}
-	return c.send(stat, "%d|g", value)
+	return c.send(KindGauge, stat, "%d|g", value)
 }
 
 // GaugeDelta -- Send a change for a gauge
 func (c *StatsdClient) GaugeDelta(stat string, value int64) error {
 	// Gauge Deltas are always sent with a leading '+' or '-'. The '-' takes care of itself but the '+' must added by hand
 	if value < 0 {
-		return c.send(stat, "%d|g", value)
+		return c.send(KindGauge, stat, "%d|g", value)
 	}
-	return c.send(stat, "+%d|g", value)
+	return c.send(KindGauge, stat, "+%d|g", value)
 }
 
 // FGauge -- Send a floating point value for a gauge
 func (c *StatsdClient) FGauge(stat string, value float64) error {
+	if !event.IsFinite(value) {
+		return ErrInvalidValue
+	}
 	if value < 0 {
-		c.send(stat, "%d|g", 0)
-		return c.send(stat, "%g|g", value)
+		return c.sendNegativeGauge(stat, "%s|g", c.formatFloat(value))
 	}
-	return c.send(stat, "%g|g", value)
+	return c.send(KindGauge, stat, "%s|g", c.formatFloat(value))
 }
 
 // FGaugeDelta -- Send a floating point change for a gauge
 func (c *StatsdClient) FGaugeDelta(stat string, value float64) error {
+	if !event.IsFinite(value) {
+		return ErrInvalidValue
+	}
 	if value < 0 {
-		return c.send(stat, "%g|g", value)
+		return c.send(KindGauge, stat, "%s|g", c.formatFloat(value))
 	}
-	return c.send(stat, "+%g|g", value)
+	return c.send(KindGauge, stat, "+%s|g", c.formatFloat(value))
 }
 
 // Absolute - Send absolute-valued metric (not averaged/aggregated)
 func (c *StatsdClient) Absolute(stat string, value int64) error {
-	return c.send(stat, "%d|a", value)
+	return c.send(KindAbsolute, stat, "%d|a", value)
 }
 
 // FAbsolute - Send absolute-valued floating point metric (not averaged/aggregated)
 func (c *StatsdClient) FAbsolute(stat string, value float64) error {
-	return c.send(stat, "%g|a", value)
+	if !event.IsFinite(value) {
+		return ErrInvalidValue
+	}
+	return c.send(KindAbsolute, stat, "%s|a", c.formatFloat(value))
 }
 
 // Total - Send a metric that is continously increasing, e.g. read operations since boot
 func (c *StatsdClient) Total(stat string, value int64) error {
-	return c.send(stat, "%d|t", value)
+	return c.send(KindTotal, stat, "%d|t", value)
+}
+
+// Unique - Count the unique values of a set, e.g. the visitors of a page.
+// The reserved characters of the value are escaped
+func (c *StatsdClient) Unique(stat string, value string) error {
+	return c.send(KindSet, stat, "%s|s", Escape(FieldSetMember, value))
+}
+
+// uniqueCounter is implemented by the clients which count unique values
+type uniqueCounter interface {
+	Unique(stat string, value string) error
+}
+
+// unique is the Unique of s if it has one, the other clients can't count the
+// unique values
+func unique(s Statsd, stat string, value string) error {
+	if c, ok := s.(uniqueCounter); ok {
+		return c.Unique(stat, value)
+	}
+	return fmt.Errorf("statsd: %T doesn't count unique values", s)
 }
 
 // write a UDP packet with the statsd event
-func (c *StatsdClient) send(stat string, format string, value interface{}) error {
-	if c.conn == nil {
+func (c *StatsdClient) send(kind MetricKind, stat string, format string, value interface{}) error {
+	if !c.allowed(kind, stat) {
+		return nil
+	}
+	suffix, ok := c.sample(kind, stat)
+	if !ok {
+		return nil
+	}
+	return c.sendSampled(kind, stat, format+suffix, value)
+}
+
+// sendSampled writes the line of a metric allowed and sampled, under all the
+// aliases of stat
+func (c *StatsdClient) sendSampled(kind MetricKind, stat string, format string, value interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if names := c.aliasNames(stat); names != nil {
+		for _, name := range names {
+			if err := c.write(name, format, value); err != nil {
+				return c.countResult(kind, err)
+			}
+		}
+		return c.countResult(kind, nil)
+	}
+	return c.countResult(kind, c.write(stat, format, value))
+}
+
+// a negative gauge is sent as a reset to 0 followed by a negative delta:
+// the two writes must not be interleaved with other sends for the same stat
+func (c *StatsdClient) sendNegativeGauge(stat string, format string, value interface{}) error {
+	if !c.allowed(KindGauge, stat) {
+		return nil
+	}
+	names := c.aliasNames(stat)
+	if names == nil {
+		names = []string{stat}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		if err := c.write(name, "%d|g", 0); err != nil {
+			return c.countResult(KindGauge, err)
+		}
+		if err := c.write(name, format, value); err != nil {
+			return c.countResult(KindGauge, err)
+		}
+	}
+	return c.countResult(KindGauge, nil)
+}
+
+// write formats the stat and writes it to the socket.
+// The caller must hold c.mu
+func (c *StatsdClient) write(stat string, format string, value interface{}) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if c.isGraphite() {
+		return c.unsupported(FeatureDirectSend, 1, nil)
+	}
+	if !c.writable() {
 		return fmt.Errorf("not connected")
 	}
-	stat = strings.Replace(stat, "%HOST%", Hostname, 1)
-	format = fmt.Sprintf("%s%s:%s", c.prefix, stat, format)
-	_, err := fmt.Fprintf(c.conn, format, value)
+	stat, err := c.metricName(stat)
+	if err != nil {
+		return err
+	}
+	c.buf = fmt.Appendf(append(append(c.buf[:0], stat...), ':'), format, value)
+	c.buf = append(c.buf, c.originSuffix()...)
+	return c.writeLine(c.buf)
+}
+
+// writeLine writes a serialized payload to the socket. If the write fails and
+// retries are enabled, a copy of the lines not delivered is queued for retrying
+// and nil is returned, the same goes for the burst buffer (see SetBurstBuffer). The
+// caller must hold c.mu
+func (c *StatsdClient) writeLine(payload []byte) error {
+	payload = c.withSequence(payload)
+	if c.warmingUp() {
+		return c.writeWarmup(payload)
+	}
+	var err error
+	if c.burst != nil && c.burst.active() {
+		// behind the packets parked, to keep the order
+		err = c.burst.park(payload)
+	} else if _, err = c.conn.Write(payload); err != nil && c.burst != nil && isNoBufferSpace(err) {
+		err = c.burst.park(payload)
+	} else if err == nil {
+		c.markSent()
+		c.mirrorPacket(payload)
+	}
+	if err != nil && isMessageTooLong(err) {
+		err = c.downshift(payload, err)
+	}
+	if err != nil && c.retry != nil {
+		// only the lines not delivered by a downshift
+		for _, s := range undelivered(payload, err) {
+			c.retry.push(string(payload[s.start:s.end]))
+		}
+		return nil
+	}
 	return err
 }
 
+// markSent records the time of a successful write, see LastSendTime
+func (c *StatsdClient) markSent() {
+	atomic.StoreInt64(&c.lastSend, c.now().UnixNano())
+}
+
+// setWriteDeadline makes the writes fail once the deadline passes, see
+// StatsdBuffer.CloseContext
+func (c *StatsdClient) setWriteDeadline(deadline time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.SetWriteDeadline(deadline)
+	}
+}
+
+// connRef holds the connection of the client, see abortConn
+type connRef struct {
+	net.Conn
+}
+
+// abortConn closes the connection without taking c.mu, which may be held by
+// a write blocked despite its deadline: closing the connection unblocks most
+// writes. The client is still closed by Close, see StatsdBuffer.closeAbandoned
+func (c *StatsdClient) abortConn() {
+	if ref, _ := c.current.Load().(connRef); ref.Conn != nil {
+		ref.Close()
+	}
+}
+
+// isTimeout tells whether a write failed because its deadline passed
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // SendEvent - Sends stats from an event object
 func (c *StatsdClient) SendEvent(e event.Event) error {
-	if c.conn == nil {
-		return fmt.Errorf("cannot send stats, not connected to StatsD server")
+	return c.sendEvent(e, false)
+}
+
+// sendEvent sends the stats of an event. If named is true, the event key has
+// already been filtered and turned into a prefixed metric name (by the buffered
+// client), otherwise the event is renamed while it's written, then its key is
+// restored
+func (c *StatsdClient) sendEvent(e event.Event, named bool) error {
+	kind := kindOf(e)
+	if !named && !c.allowed(kind, e.Key()) {
+		return nil
 	}
-	for _, stat := range e.Stats() {
-		//fmt.Printf("SENDING EVENT %s%s\n", c.prefix, stat)
-		_, err := fmt.Fprintf(c.conn, "%s%s", c.prefix, stat)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.countResult(kind, c.writeEvent(e, named))
+}
+
+// writeEvent writes the stats of an event, see sendEvent. The caller must hold c.mu
+func (c *StatsdClient) writeEvent(e event.Event, named bool) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if !named && c.isGraphite() {
+		return c.unsupported(FeatureDirectSend, 1, nil)
+	}
+	if !c.writable() {
+		return errNotConnected
+	}
+	if !named {
+		name, err := c.eventName(e)
+		if err != nil {
+			return err
+		}
+		e, _ = unqualified(e)
+		defer e.SetKey(e.Key())
+		e.SetKey(name)
+	}
+	c.scratch = event.AppendStatsPrecision(c.scratch[:0], "", e, c.precision())
+	for lines := c.scratch; len(lines) > 0; {
+		i := bytes.IndexByte(lines, '\n')
+		c.buf = append(append(c.buf[:0], lines[:i]...), c.originSuffix()...)
+		err := c.writeLine(c.buf)
 		if nil != err {
 			return err
 		}
+		lines = lines[i+1:]
 	}
 	return nil
 }
+
+// SendEvents sends the stats of several events under a single lock, packed in
+// as few packets as the maximum packet size allows. Failures are reported per
+// event key with a MapError
+func (c *StatsdClient) SendEvents(events ...event.Event) error {
+	return c.sendEvents(events, false, nil)
+}
+
+// sendEvents packs the stats of the events, see SendEvents. If named is true,
+// the event keys are already prefixed metric names (see sendEvent). The
+// packets written are accounted for in the report, if not nil
+func (c *StatsdClient) sendEvents(events []event.Event, named bool, report *FlushReport) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := ErrClosed
+	switch {
+	case c.closed:
+	case !named && c.isGraphite():
+		err = c.unsupported(FeatureDirectSend, 1, nil)
+	case !c.writable():
+		err = errNotConnected
+	default:
+		return c.packEvents(events, named, report)
+	}
+	for _, e := range events {
+		c.countResult(kindOf(e), err)
+	}
+	return err
+}
+
+// packEvents packs and writes the stats of the events, see sendEvents.
+// The caller must hold c.mu
+func (c *StatsdClient) packEvents(events []event.Event, named bool, report *FlushReport) error {
+	p := c.newPacker()
+	p.report = report
+	for _, e := range events {
+		key := e.Key()
+		kind := kindOf(e)
+		if !named {
+			if !c.allowed(kind, key) {
+				continue
+			}
+			name, err := c.eventName(e)
+			if err != nil {
+				p.fail(key, err)
+				p.count(key, kind)
+				continue
+			}
+			// the events are renamed while they're packed
+			e, _ = unqualified(e)
+			defer e.SetKey(key)
+			e.SetKey(name)
+		}
+		// each group of lines goes in a single packet
+		p.addEvent(key, e)
+		p.count(key, kind)
+	}
+	return p.flush()
+}
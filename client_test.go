@@ -2,36 +2,36 @@ package statsd
 
 import (
 	"fmt"
+	"math"
 	"net"
 	"os"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/statsdtest"
 )
 
-func newLocalListenerUDP(t *testing.T) (*net.UDPConn, *net.UDPAddr) {
-	udpAddr, err := net.ResolveUDPAddr("udp", ":1200")
-	if err != nil {
-		t.Fatal(err)
-	}
-	ln, err := net.ListenUDP("udp", udpAddr)
+func newTestServer(t *testing.T) *statsdtest.Server {
+	srv, err := statsdtest.NewServer()
 	if err != nil {
 		t.Fatal(err)
 	}
-	return ln, udpAddr
+	return srv
 }
 
 func TestTotal(t *testing.T) {
-	ln, udpAddr := newLocalListenerUDP(t)
-	defer ln.Close()
+	srv := newTestServer(t)
+	defer srv.Close()
 
 	prefix := "myproject."
 
-	client := NewStatsdClient(udpAddr.String(), prefix)
-
-	ch := make(chan string, 0)
+	client := NewStatsdClient(srv.Addr(), prefix)
 
 	s := map[string]int64{
 		"a:b:c": 5,
@@ -50,8 +50,6 @@ func TestTotal(t *testing.T) {
 	hostname, err := os.Hostname()
 	expected["zz."+hostname] = 1
 
-	go doListenUDP(ln, ch, len(s))
-
 	err = client.CreateSocket()
 	if nil != err {
 		t.Fatal(err)
@@ -64,24 +62,20 @@ func TestTotal(t *testing.T) {
 
 	actual := make(map[string]int64)
 
-	re := regexp.MustCompile(`^(.*)\:(\d+)\|(\w).*$`)
-
-	for i := len(s); i > 0; i-- {
-		x := <-ch
-		x = strings.TrimSpace(x)
-		//fmt.Println(x)
-		if !strings.HasPrefix(x, prefix) {
-			t.Errorf("Metric without expected prefix: expected '%s', actual '%s'", prefix, x)
+	for k := range expected {
+		metrics, err := srv.WaitFor(prefix+k, 1, time.Second)
+		if err != nil {
+			t.Fatal(err)
 		}
-		vv := re.FindStringSubmatch(x)
-		if vv[3] != "t" {
-			t.Errorf("Metric without expected suffix: expected 't', actual '%s'", vv[3])
+		m := metrics[0]
+		if m.Type != "t" {
+			t.Errorf("Metric without expected suffix: expected 't', actual '%s'", m.Type)
 		}
-		v, err := strconv.ParseInt(vv[2], 10, 64)
+		v, err := strconv.ParseInt(m.Value, 10, 64)
 		if err != nil {
 			t.Error(err)
 		}
-		actual[vv[1][len(prefix):]] = v
+		actual[strings.TrimPrefix(m.Name, prefix)] = v
 	}
 
 	if !reflect.DeepEqual(expected, actual) {
@@ -89,21 +83,568 @@ func TestTotal(t *testing.T) {
 	}
 }
 
-func doListenUDP(conn *net.UDPConn, ch chan string, n int) {
-	for n > 0 {
-		// Handle the connection in a new goroutine.
-		// The loop then returns to accepting, so that
-		// multiple connections may be served concurrently.
-		go func(c *net.UDPConn, ch chan string) {
-			buffer := make([]byte, 1024)
-			size, err := c.Read(buffer)
-			// size, address, err := sock.ReadFrom(buffer) <- This starts printing empty and nil values below immediatly
+func TestConcurrentSends(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			for j := int64(0); j < 10; j++ {
+				client.Incr("incr", i)
+				client.Decr("decr", i)
+				client.Timing("timing", i)
+				client.PrecisionTiming("ptiming", time.Duration(i)*time.Microsecond)
+				client.Gauge("gauge", i-25)
+				client.GaugeDelta("gaugedelta", i-25)
+				client.FGauge("fgauge", float64(i)-25.5)
+				client.FGaugeDelta("fgaugedelta", float64(i)-25.5)
+				client.Absolute("absolute", i)
+				client.FAbsolute("fabsolute", float64(i)/3)
+				client.Total("total", i)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	// wait for the last sends to be received
+	if _, err := srv.WaitFor("myproject.total", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range srv.Metrics() {
+		if m.Err != nil {
+			t.Errorf("malformed line: %v", m.Err)
+		}
+	}
+}
+
+// fakeConn is a net.Conn discarding writes and counting closes
+type fakeConn struct {
+	net.Conn
+	closes *int32
+}
+
+func (c fakeConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c fakeConn) Close() error                { atomic.AddInt32(c.closes, 1); return nil }
+
+func (c fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestCreateSocketClosesPreviousConnection(t *testing.T) {
+	var opened, closed int32
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		atomic.AddInt32(&opened, 1)
+		return fakeConn{closes: &closed}, nil
+	}
+	for i := 0; i < 100; i++ {
+		if err := client.CreateSocket(); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Incr("a", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if open := opened - closed; open != 1 {
+		t.Errorf("expected a single open connection, actual %d", open)
+	}
+	client.Close()
+	if opened != closed {
+		t.Errorf("leaked %d connections", opened-closed)
+	}
+}
+
+// callAll invokes every metric method of the Statsd interface
+func callAll(c Statsd) map[string]error {
+	return map[string]error{
+		"Incr":               c.Incr("a", 1),
+		"Decr":               c.Decr("a", 1),
+		"Timing":             c.Timing("a", 1),
+		"PrecisionTiming":    c.PrecisionTiming("a", time.Millisecond),
+		"TimingMicroseconds": c.TimingMicroseconds("a", 1),
+		"FTiming":            fTiming(c, "a", 1),
+		"Since":              since(c, "a", time.Now()),
+		"Gauge":              c.Gauge("a", -1),
+		"GaugeDelta":         c.GaugeDelta("a", 1),
+		"Absolute":           c.Absolute("a", 1),
+		"Total":              c.Total("a", 1),
+		"FGauge":             c.FGauge("a", 1),
+		"FGaugeDelta":        c.FGaugeDelta("a", 1),
+		"FAbsolute":          c.FAbsolute("a", 1),
+		"IncrMap":            c.IncrMap(map[string]int64{"a": 1}),
+		"GaugeMap":           c.GaugeMap(map[string]int64{"a": 1}),
+		"TimingSlices":       c.TimingSlices(map[string][]time.Duration{"a": {time.Millisecond}}),
+	}
+}
+
+func TestSendAfterClose(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for method, err := range callAll(client) {
+		if err != ErrClosed {
+			t.Errorf("%s: expected ErrClosed, actual %v", method, err)
+		}
+	}
+	if err := client.SendEvent(&event.Increment{Name: "a", Value: 1}); err != ErrClosed {
+		t.Errorf("SendEvent: expected ErrClosed, actual %v", err)
+	}
+	if err := client.CreateSocket(); err != ErrClosed {
+		t.Errorf("CreateSocket: expected ErrClosed, actual %v", err)
+	}
+	if err := client.Close(); err != ErrClosed {
+		t.Errorf("Close: expected ErrClosed, actual %v", err)
+	}
+}
+
+func TestPrefixNormalization(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		stat     string
+		expected string
+	}{
+		{prefix: "", stat: "counter", expected: "counter"},
+		{prefix: "", stat: ".counter", expected: "counter"},
+		{prefix: "myapp", stat: "counter", expected: "myapp.counter"},
+		{prefix: "myapp.", stat: "counter", expected: "myapp.counter"},
+		{prefix: "myapp..", stat: "counter", expected: "myapp.counter"},
+		{prefix: "myapp", stat: ".counter", expected: "myapp.counter"},
+		{prefix: "myapp.", stat: "..counter", expected: "myapp.counter"},
+		{prefix: "my.app", stat: "a.b", expected: "my.app.a.b"},
+	}
+	for _, tt := range tests {
+		srv := newTestServer(t)
+		client := NewStatsdClient(srv.Addr(), tt.prefix)
+		if err := client.CreateSocket(); err != nil {
+			t.Fatal(err)
+		}
+		client.Incr(tt.stat, 1)
+		client.SendEvent(&event.Gauge{Name: tt.stat, Value: 1})
+		metrics, err := srv.WaitFor(tt.expected, 2, time.Second)
+		if err != nil {
+			t.Errorf("prefix %q, stat %q: %v, received %+v", tt.prefix, tt.stat, err, srv.Metrics())
+		}
+		if len(metrics) == 2 && (metrics[0].Type != "c" || metrics[1].Type != "g") {
+			t.Errorf("unexpected metrics %+v", metrics)
+		}
+		client.Close()
+		srv.Close()
+	}
+}
+
+// the payloads built in the scratch buffer must not leak into each other
+func TestGoldenOutput(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.Incr("a.long.counter.name", 1000000)
+	client.Decr("b", 2)
+	client.Timing("c", 3)
+	client.PrecisionTiming("d", 314*time.Microsecond)
+	client.Gauge("e", -5)
+	client.GaugeDelta("f", 6)
+	client.FGauge("g", 7.25)
+	client.FGaugeDelta("h", -0.5)
+	client.Absolute("i", 9)
+	client.FAbsolute("j", 1.5)
+	client.Total("k", 11)
+	client.SendEvent(&event.Gauge{Name: "l", Value: -12})
+	expected := []string{
+		"myproject.a.long.counter.name:1000000|c",
+		"myproject.b:-2|c",
+		"myproject.c:3|ms",
+		"myproject.d:0.314|ms",
+		"myproject.e:0|g",
+		"myproject.e:-5|g",
+		"myproject.f:+6|g",
+		"myproject.g:7.25|g",
+		"myproject.h:-0.5|g",
+		"myproject.i:9|a",
+		"myproject.j:1.5|a",
+		"myproject.k:11|t",
+		"myproject.l:0|g",
+		"myproject.l:-12|g",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestSendEvents(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetMaxPacketSize(40)
+	client.SetStrictNames(true)
+	err := client.SendEvents(
+		&event.Increment{Name: "a", Value: 1},
+		&event.Gauge{Name: "b", Value: -2},
+		&event.Increment{Name: "bad|name", Value: 1},
+		&event.Total{Name: "c", Value: 3},
+	)
+	if mapErr, ok := err.(*MapError); !ok || len(mapErr.Errors) != 1 || mapErr.Errors["bad|name"] != ErrInvalidName {
+		t.Errorf("expected a MapError for bad|name, actual %v", err)
+	}
+	expected := []string{"myproject.a:1|c", "myproject.b:0|g\nmyproject.b:-2|g", "myproject.c:3|t"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestRawNames(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.Incr("orders", 1)
+	client.Incr(RawName("business.orders.created"), 1)
+	client.Gauge("=business|queue", 2)
+	expected := []string{"myproject.orders:1|c", "business.orders.created:1|c", "business_queue:2|g"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestRawMarker(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetRawMarker("@")
+	client.Incr("=orders", 1)
+	client.Incr(client.RawName("business.orders"), 1)
+	// the views keep the raw names of the client
+	client.WithSource("plugin").Incr("@business.plugins", 1)
+	client.SetRawMarker("")
+	client.Incr("@orders", 1)
+	expected := []string{"myproject.=orders:1|c", "business.orders:1|c", "business.plugins:1|c", "myproject.@orders:1|c"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestInvalidFloats(t *testing.T) {
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if err := client.FGauge("a", v); err != ErrInvalidValue {
+			t.Errorf("FGauge(%v): expected ErrInvalidValue, actual %v", v, err)
+		}
+		if err := client.FGaugeDelta("a", v); err != ErrInvalidValue {
+			t.Errorf("FGaugeDelta(%v): expected ErrInvalidValue, actual %v", v, err)
+		}
+		if err := client.FAbsolute("a", v); err != ErrInvalidValue {
+			t.Errorf("FAbsolute(%v): expected ErrInvalidValue, actual %v", v, err)
+		}
+		if err := client.TimingMicroseconds("a", v); err != ErrInvalidValue {
+			t.Errorf("TimingMicroseconds(%v): expected ErrInvalidValue, actual %v", v, err)
+		}
+		if err := client.FTiming("a", v); err != ErrInvalidValue {
+			t.Errorf("FTiming(%v): expected ErrInvalidValue, actual %v", v, err)
+		}
+	}
+}
+
+func TestFloatPrecision(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.SetFloatPrecision(2)
+	client.FGauge("g", 0.123456)
+	client.PrecisionTiming("t", 314*time.Microsecond)
+	client.SendEvents(&event.FAbsolute{Name: "a", Values: []float64{1.0051}})
+	client.SetFloatPrecision(0)
+	client.FGauge("g", 0.123456)
+	expected := []string{"g:0.12|g", "t:0.31|ms", "a:1.01|a", "g:0.123456|g"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+
+	// the buffered client serializes the pending events with the precision of the flush
+	buffered, flush := newContributionClient(t)
+	buffered.FGauge("g", 0.123456)
+	buffered.statsd.SetFloatPrecision(1)
+	expectLines(t, []string{"myproject.g:0.1|g"}, flush())
+}
+
+func TestSince(t *testing.T) {
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client, conn := newPacketClient(t, "")
+	client.SetClock(clock)
+	start := clock.Now()
+	clock.Advance(250 * time.Microsecond)
+	client.Since("t", start)
+	// the views measure with the clock of the client
+	NewRouter(client).WithSource("view").Since("t", start)
+	if expected := []string{"t:0.25|ms", "view.t:0.25|ms"}; !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestFTiming(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.FTiming("t", 3.275)
+	client.FTiming("t", 0.001)
+	// a Statsd without FTiming gets a PrecisionTiming
+	NewRouter(methodsOnly{client}).FTiming("t", 2.5)
+	if expected := []string{"t:3.275|ms", "t:0.001|ms", "t:2.5|ms"}; !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+
+	srv := newTestServer(t)
+	defer srv.Close()
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), ""))
+	buffered.FTiming("t", 0.001)
+	buffered.FTiming("t", 0.003)
+	buffered.FTiming("t", 2.5)
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"t.avg": "0.834667", "t.min": "0.001", "t.max": "2.5"} {
+		metrics, err := srv.WaitFor(name, 1, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metrics[0].Value != expected {
+			t.Errorf("%s: expected %s, actual %s", name, expected, metrics[0].Value)
+		}
+	}
+}
+
+var subMillisecondTimings = []struct {
+	delta    time.Duration
+	expected string
+}{
+	{time.Microsecond, "0.001"},
+	{999 * time.Microsecond, "0.999"},
+	{1500 * time.Microsecond, "1.5"},
+	{2 * time.Second, "2000"},
+}
+
+func TestSubMillisecondTimings(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	for _, tt := range subMillisecondTimings {
+		conn.packets = nil
+		client.PrecisionTiming("t", tt.delta)
+		client.TimingMicroseconds("t", float64(tt.delta)/float64(time.Microsecond))
+		expected := "t:" + tt.expected + "|ms"
+		if len(conn.packets) != 2 || conn.packets[0] != expected || conn.packets[1] != expected {
+			t.Errorf("%s: expected %q twice, actual %q", tt.delta, expected, conn.packets)
+		}
+	}
+
+	// the buffered client aggregates the full-precision values
+	srv := newTestServer(t)
+	defer srv.Close()
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), ""))
+	for i, tt := range subMillisecondTimings {
+		buffered.PrecisionTiming(fmt.Sprintf("t%d", i), tt.delta)
+		buffered.TimingMicroseconds(fmt.Sprintf("t%d", i), float64(tt.delta)/float64(time.Microsecond))
+	}
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range subMillisecondTimings {
+		for _, stat := range []string{"avg", "min", "max"} {
+			name := fmt.Sprintf("t%d.%s", i, stat)
+			metrics, err := srv.WaitFor(name, 1, time.Second)
 			if err != nil {
-				fmt.Println(string(buffer), size, err)
-				panic(err)
+				t.Error(err)
+				continue
 			}
-			ch <- string(buffer)
-		}(conn, ch)
-		n--
+			if metrics[0].Value != tt.expected {
+				t.Errorf("%s: expected %s, actual %s", name, tt.expected, metrics[0].Value)
+			}
+		}
+	}
+}
+
+func TestZeroCounts(t *testing.T) {
+	for _, send := range []bool{false, true} {
+		srv := newTestServer(t)
+		client := NewStatsdClient(srv.Addr(), "myproject.")
+		client.SetSendZeroCounts(send)
+		if err := client.CreateSocket(); err != nil {
+			t.Fatal(err)
+		}
+		client.Incr("zero", 0)
+		client.Decr("zero", 0)
+		client.Incr("sentinel", 1)
+		buffered := NewStatsdBuffer(time.Hour, client)
+		buffered.Incr("buffered.zero", 0)
+		buffered.Incr("buffered.sentinel", 1)
+		buffered.Close()
+
+		if _, err := srv.WaitFor("myproject.buffered.sentinel", 1, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		direct, buf := 0, 0
+		for _, m := range srv.Metrics() {
+			switch m.Name {
+			case "myproject.zero":
+				direct++
+			case "myproject.buffered.zero":
+				buf++
+			default:
+				continue
+			}
+			if m.Value != "0" || m.Type != "c" {
+				t.Errorf("unexpected zero count %s", m.Raw)
+			}
+		}
+		if expected := map[bool]int{false: 0, true: 2}[send]; direct != expected {
+			t.Errorf("SendZeroCounts(%v): expected %d direct zero counts, actual %d", send, expected, direct)
+		}
+		if expected := map[bool]int{false: 0, true: 1}[send]; buf != expected {
+			t.Errorf("SendZeroCounts(%v): expected %d buffered zero counts, actual %d", send, expected, buf)
+		}
+		srv.Close()
+	}
+}
+
+func TestSendEventsKeepsGroups(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetMaxPacketSize(50)
+	// the reset of the gauge would fit the first packet, its value wouldn't
+	err := client.SendEvents(
+		&event.Increment{Name: "a.rather.long.name", Value: 1},
+		&event.Gauge{Name: "b", Value: -2},
+		&event.Absolute{Name: "c", Values: []int64{1, 2, 3}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"myproject.a.rather.long.name:1|c",
+		"myproject.b:0|g\nmyproject.b:-2|g\nmyproject.c:1|a",
+		"myproject.c:2|a\nmyproject.c:3|a",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestSetAddress(t *testing.T) {
+	listeners := map[string]*packetConn{"10.0.0.1:8125": {}, "10.0.0.2:8125": {}}
+	client := NewStatsdClient("10.0.0.1:8125", "")
+	client.SetDialer(func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return listeners[address], nil
+	})
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	const n = 10000
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			if err := client.Incr("seq", int64(i+1)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	if err := client.SetAddress("10.0.0.2:8125"); err != nil {
+		t.Fatal(err)
+	}
+	if client.String() != "10.0.0.2:8125" {
+		t.Errorf("unexpected address %s", client.String())
+	}
+	wg.Wait()
+
+	// no gaps: the sequence continues on the second listener
+	var packets []string
+	for _, addr := range []string{"10.0.0.1:8125", "10.0.0.2:8125"} {
+		l := listeners[addr]
+		l.mu.Lock()
+		packets = append(packets, l.packets...)
+		l.mu.Unlock()
+	}
+	if len(packets) != n {
+		t.Fatalf("%d metrics received, expected %d", len(packets), n)
+	}
+	for i, p := range packets {
+		if expected := fmt.Sprintf("seq:%d|c", i+1); p != expected {
+			t.Fatalf("expected %s, actual %s", expected, p)
+		}
+	}
+
+	if err := client.SetAddress("http://nowhere"); err == nil {
+		t.Error("expected an error for a bad address")
+	}
+	if client.String() != "10.0.0.2:8125" {
+		t.Errorf("address changed by a failed SetAddress: %s", client.String())
+	}
+}
+
+func TestBufferSetAddress(t *testing.T) {
+	srv1, srv2 := newTestServer(t), newTestServer(t)
+	defer srv1.Close()
+	defer srv2.Close()
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv1.Addr(), ""))
+	for i := 0; i < 100; i++ {
+		buffered.Incr("a", 1)
+	}
+	if err := buffered.SetAddress(srv2.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		buffered.Incr("a", 1)
+	}
+	buffered.Close()
+	metrics, err := srv2.WaitFor("a", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[0].Value != "200" || len(srv1.Metrics()) != 0 {
+		t.Errorf("expected the aggregate to be flushed to the new address, actual %+v %+v", metrics, srv1.Metrics())
+	}
+}
+
+func TestLastSendTime(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	clock := statsdtest.NewFakeClock(time.Unix(1700000000, 0))
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.SetClock(clock)
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+
+	if err := client.Incr("a", 1); err == nil {
+		t.Error("expected the write to fail")
+	}
+	if last := client.LastSendTime(); !last.IsZero() {
+		t.Errorf("expected no successful send, actual %v", last)
+	}
+
+	conn.mu.Lock()
+	conn.until = time.Time{}
+	conn.mu.Unlock()
+	client.Incr("a", 1)
+	if last := client.Stats().LastSend; !last.Equal(clock.Now()) {
+		t.Errorf("expected %v, actual %v", clock.Now(), last)
+	}
+
+	sent := clock.Now()
+	clock.Advance(time.Minute)
+	conn.mu.Lock()
+	conn.until = time.Now().Add(time.Hour)
+	conn.mu.Unlock()
+	client.Incr("a", 1)
+	if last := buffered.LastSendTime(); !last.Equal(sent) {
+		t.Errorf("advanced on a failed write: expected %v, actual %v", sent, last)
+	}
+	if last := buffered.Stats().LastSend; !last.Equal(sent) {
+		t.Errorf("expected %v in the buffer stats, actual %v", sent, last)
 	}
 }
@@ -0,0 +1,77 @@
+package statsd
+
+import "time"
+
+// Clock is the source of time of a client: the timing helpers, the retry expiry
+// and the flush ticker of the buffered client all use it, so that tests can drive
+// them with a fake clock, such as statsdtest.FakeClock
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a channel delivering ticks every d, and a function stopping them
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+// realClock is the default Clock, backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// clockValue wraps a Clock into the same concrete type for atomic.Value
+type clockValue struct {
+	Clock
+}
+
+// SetClock replaces the clock of the client, nil restores the real one.
+// A buffered client takes its flush ticker from the clock of the client it
+// wraps: set the clock before calling NewStatsdBuffer
+func (c *StatsdClient) SetClock(clock Clock) {
+	if clock == nil {
+		clock = realClock{}
+	}
+	c.clock.Store(clockValue{clock})
+}
+
+// now returns the current time according to the clock of the client
+func (c *StatsdClient) now() time.Time {
+	if clock, ok := c.clock.Load().(clockValue); ok {
+		return clock.Now()
+	}
+	return time.Now()
+}
+
+// newTicker returns a ticker from the clock of the client
+func (c *StatsdClient) newTicker(d time.Duration) (<-chan time.Time, func()) {
+	if clock, ok := c.clock.Load().(clockValue); ok {
+		return clock.NewTicker(d)
+	}
+	return realClock{}.NewTicker(d)
+}
+
+// Since - Track the time elapsed since start, sent as a PrecisionTiming
+func (c *StatsdClient) Since(stat string, start time.Time) error {
+	return c.PrecisionTiming(stat, c.now().Sub(start))
+}
+
+// Since - Track the time elapsed since start, aggregated as a PrecisionTiming
+func (sb *buffer) Since(stat string, start time.Time) error {
+	return sb.PrecisionTiming(stat, sb.statsd.now().Sub(start))
+}
+
+// sincer is implemented by the clients which measure Since with their clock
+type sincer interface {
+	Since(stat string, start time.Time) error
+}
+
+// since is the Since of s if it has one, or else a PrecisionTiming of the time
+// elapsed on the real clock
+func since(s Statsd, stat string, start time.Time) error {
+	if c, ok := s.(sincer); ok {
+		return c.Since(stat, start)
+	}
+	return s.PrecisionTiming(stat, time.Since(start))
+}
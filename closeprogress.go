@@ -0,0 +1,123 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// closeProgressEvents is the number of events sent by the final flush of
+// CloseWithProgress between two calls of the progress callback, and between
+// two checks of its context
+var closeProgressEvents = 100
+
+// closeProgressInterval is the longest time between two calls of the progress
+// callback of CloseWithProgress, on the clock of the client
+var closeProgressInterval = 100 * time.Millisecond
+
+// CloseWithProgress is CloseContext calling progress while the final flush of a
+// large backlog is sent, with the number of events sent so far and the number
+// still to send, every 100 events (packed together as usual), and every 100ms
+// on the clock of the client while the packets are slow to go out.
+// If ctx is done before the flush completes the remaining events are dropped,
+// progress is called one last time with them, and they are counted in
+// Stats().DroppedOnClose and in the returned error.
+// progress is never called concurrently, and must not block
+func (sb *buffer) CloseWithProgress(ctx context.Context, progress func(drained, remaining int)) error {
+	if progress == nil {
+		progress = func(int, int) {}
+	}
+	return sb.closeContext(ctx, progress)
+}
+
+// sendWithProgress sends the events of the final flush in chunks, reporting
+// the progress between them and stopping once req.ctx is done
+func (sb *buffer) sendWithProgress(events []event.Event, now time.Time, report *FlushReport, req *closeRequest) (failed map[string]failedKey, err error) {
+	failed = make(map[string]failedKey)
+	total := len(events)
+	p := &closeProgress{callback: req.progress, remaining: total}
+	stop := p.tick(sb.statsd.newTicker(closeProgressInterval))
+	defer stop()
+	for sent := 0; sent < total; {
+		if ctxErr := req.ctx.Err(); ctxErr != nil {
+			remaining := total - sent
+			for _, e := range events[sent:] {
+				failed[e.Key()] = failedKey{}
+			}
+			// unless CloseContext gave up on the flush first, and counted them
+			if atomic.CompareAndSwapInt32(&sb.abandoned, 0, 1) {
+				atomic.AddInt64(&sb.droppedOnClose, int64(remaining))
+			}
+			stop()
+			p.report(sent, remaining)
+			return failed, fmt.Errorf("statsd: final flush cancelled, %d events abandoned: %v", remaining, ctxErr)
+		}
+		end := sent + closeProgressEvents
+		if end > total {
+			end = total
+		}
+		chunk, err2 := sb.send(events[sent:end], now, report, nil)
+		for k, f := range chunk {
+			failed[k] = f
+		}
+		if err == nil {
+			err = err2
+		}
+		sent = end
+		atomic.StoreInt64(&sb.flushing, int64(total-sent))
+		if sent == total {
+			stop()
+		}
+		p.report(sent, total-sent)
+	}
+	return failed, err
+}
+
+// closeProgress serializes the calls of the progress callback of
+// CloseWithProgress, made by the final flush and by its ticker
+type closeProgress struct {
+	mu        sync.Mutex
+	callback  func(drained, remaining int)
+	drained   int
+	remaining int
+}
+
+// report calls the callback with the progress of the final flush
+func (p *closeProgress) report(drained, remaining int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.drained, p.remaining = drained, remaining
+	p.callback(drained, remaining)
+}
+
+// tick calls the callback again with the last progress on every tick, until
+// the function returned is called: once it returns, the ticks are over
+func (p *closeProgress) tick(ticks <-chan time.Time, stopTicker func()) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-ticks:
+				p.mu.Lock()
+				p.callback(p.drained, p.remaining)
+				p.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stopTicker()
+			close(done)
+			<-exited
+		})
+	}
+}
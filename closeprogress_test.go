@@ -0,0 +1,145 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// slowConn is a packetConn taking a while to write each packet
+type slowConn struct {
+	packetConn
+	delay time.Duration
+}
+
+func (c *slowConn) Write(b []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.packetConn.Write(b)
+}
+
+func newSlowBuffer(t *testing.T, events int) (*StatsdBuffer, *slowConn) {
+	conn := &slowConn{delay: time.Millisecond}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	for i := 0; i < events; i++ {
+		buffered.Incr(fmt.Sprintf("c%03d", i), 1)
+	}
+	return buffered, conn
+}
+
+func TestCloseWithProgress(t *testing.T) {
+	buffered, conn := newSlowBuffer(t, 500)
+	var calls [][2]int
+	err := buffered.CloseWithProgress(context.Background(), func(drained, remaining int) {
+		calls = append(calls, [2]int{drained, remaining})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 5 {
+		t.Fatalf("expected a call every 100 events, actual %v", calls)
+	}
+	for i, c := range calls {
+		if c[0] != (i+1)*100 || c[0]+c[1] != 500 {
+			t.Errorf("call %d: unexpected progress %v", i, c)
+		}
+	}
+	lines := 0
+	for _, p := range conn.sent() {
+		lines += strings.Count(p, "\n") + 1
+	}
+	if lines != 500 {
+		t.Errorf("expected 500 lines, actual %d", lines)
+	}
+}
+
+func TestCloseWithProgressCancelled(t *testing.T) {
+	buffered, conn := newSlowBuffer(t, 500)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last [2]int
+	err := buffered.CloseWithProgress(ctx, func(drained, remaining int) {
+		if drained < last[0] {
+			t.Errorf("progress went back from %d to %d", last[0], drained)
+		}
+		last = [2]int{drained, remaining}
+		if drained >= 200 {
+			cancel()
+		}
+	})
+	// CloseWithProgress may return before the collector stops
+	<-buffered.done
+	if err == nil || !strings.Contains(err.Error(), "300 events") {
+		t.Errorf("expected an error counting the abandoned events, actual %v", err)
+	}
+	if last != [2]int{200, 300} {
+		t.Errorf("expected the last call to report 200 drained and 300 abandoned, actual %v", last)
+	}
+	if dropped := buffered.Stats().DroppedOnClose; dropped != 300 {
+		t.Errorf("expected 300 events dropped, actual %d", dropped)
+	}
+	lines := 0
+	for _, p := range conn.sent() {
+		lines += strings.Count(p, "\n") + 1
+	}
+	if lines != 200 {
+		t.Errorf("expected 200 lines, actual %d", lines)
+	}
+}
+
+// the progress is reported every 100ms as well while the packets are slow to go out
+func TestCloseWithProgressInterval(t *testing.T) {
+	conn := &stuckConn{release: make(chan struct{})}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("a", 1)
+	calls := make(chan [2]int, 10)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- buffered.CloseWithProgress(context.Background(), func(drained, remaining int) {
+			calls <- [2]int{drained, remaining}
+		})
+	}()
+	// the ticker starts with the final flush
+	deadline := time.After(time.Second)
+	for reported := 0; reported < 2; {
+		clock.Advance(closeProgressInterval)
+		select {
+		case c := <-calls:
+			if c != [2]int{0, 1} {
+				t.Errorf("unexpected progress %v", c)
+			}
+			reported++
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the progress wasn't reported on the ticks")
+		}
+	}
+	close(conn.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if c := <-calls; c != [2]int{1, 0} {
+		t.Errorf("unexpected final progress %v", c)
+	}
+	select {
+	case c := <-calls:
+		t.Errorf("unexpected progress %v after the flush", c)
+	default:
+	}
+}
@@ -0,0 +1,90 @@
+package statsd
+
+import (
+	"compress/gzip"
+	"io"
+	"net"
+)
+
+// Compression selects how the stream transports compress the payloads, see
+// SetCompression
+type Compression int
+
+const (
+	// NoCompression sends the payloads as they are
+	NoCompression Compression = iota
+	// Gzip sends a gzip stream per connection
+	Gzip
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	}
+	return "unknown compression"
+}
+
+// compression is an immutable compression setting, swapped atomically
+type compression struct {
+	kind  Compression
+	level int
+}
+
+// SetCompression compresses what the client writes to the stream transports
+// (tcp://, unix:// and Graphite mode), e.g. to save on an expensive link to a
+// relay decompressing it: every connection is a single gzip stream, at the
+// given level (e.g. gzip.DefaultCompression), flushed after every payload so
+// that nothing waits in the compressor, and terminated when the connection is
+// closed (a buffered client reconnects at every flush, which makes every flush
+// a stream of its own). The datagram
+// transports, and unixstream whose agents don't decompress, are left uncompressed.
+// It returns an error for an invalid level. It must be called before CreateSocket
+func (c *StatsdClient) SetCompression(kind Compression, level int) error {
+	if kind == NoCompression {
+		c.compression.Store((*compression)(nil))
+		return nil
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return err
+	}
+	c.compression.Store(&compression{kind: kind, level: level})
+	return nil
+}
+
+// compress wraps a stream connection into the compressor, if enabled
+func (c *StatsdClient) compress(conn net.Conn) net.Conn {
+	comp, _ := c.compression.Load().(*compression)
+	if comp == nil {
+		return conn
+	}
+	zw, _ := gzip.NewWriterLevel(conn, comp.level)
+	return &gzipConn{Conn: conn, zw: zw}
+}
+
+// gzipConn compresses the payloads written to a connection, see SetCompression
+type gzipConn struct {
+	net.Conn
+	zw *gzip.Writer
+}
+
+func (c *gzipConn) Write(b []byte) (int, error) {
+	if _, err := c.zw.Write(b); err != nil {
+		return 0, err
+	}
+	if err := c.zw.Flush(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close terminates the gzip stream before closing the connection
+func (c *gzipConn) Close() error {
+	err := c.zw.Close()
+	if cerr := c.Conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
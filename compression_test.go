@@ -0,0 +1,187 @@
+package statsd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// gzipServer decompresses the lines it receives over TCP, on every connection
+type gzipServer struct {
+	ln    net.Listener
+	lines chan string
+	ends  chan error // the errors ending the streams, nil for a clean end
+}
+
+func newGzipServer(t *testing.T) *gzipServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &gzipServer{ln: ln, lines: make(chan string, 100), ends: make(chan error, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				zr, err := gzip.NewReader(conn)
+				if err != nil {
+					s.ends <- err
+					return
+				}
+				scanner := bufio.NewScanner(zr)
+				for scanner.Scan() {
+					s.lines <- scanner.Text()
+				}
+				s.ends <- scanner.Err()
+			}()
+		}
+	}()
+	return s
+}
+
+// end waits for the end of a stream
+func (s *gzipServer) end(t *testing.T) {
+	select {
+	case err := <-s.ends:
+		if err != nil {
+			t.Errorf("the gzip stream wasn't terminated: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the gzip stream didn't end")
+	}
+}
+
+// receive waits for n lines
+func (s *gzipServer) receive(t *testing.T, n int) []string {
+	var lines []string
+	for len(lines) < n {
+		select {
+		case line := <-s.lines:
+			lines = append(lines, line)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d lines out of %d: %q", len(lines), n, lines)
+		}
+	}
+	return lines
+}
+
+func TestCompression(t *testing.T) {
+	srv := newGzipServer(t)
+	defer srv.ln.Close()
+	client := NewStatsdClient("tcp://"+srv.ln.Addr().String(), "myproject.")
+	client.Logger = discardLogger{}
+	if err := client.SetCompression(Gzip, gzip.BestCompression); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("hits", 1)
+	client.Gauge("queue", -2)
+	client.IncrMap(map[string]int64{"a": 1})
+
+	// every payload is flushed, nothing waits in the compressor until Close
+	expected := []string{"myproject.hits:1|c", "myproject.queue:0|g", "myproject.queue:-2|g", "myproject.a:1|c"}
+	if lines := srv.receive(t, 4); !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+	client.Close()
+	srv.end(t)
+}
+
+func TestCompressionGraphite(t *testing.T) {
+	srv := newGzipServer(t)
+	defer srv.ln.Close()
+	client := NewStatsdClient(srv.ln.Addr().String(), "myproject.")
+	client.Logger = discardLogger{}
+	client.SetGraphite(true)
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	client.SetCompression(Gzip, gzip.DefaultCompression)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("hits", 3)
+	clock.Advance(time.Hour)
+	buffered.Close()
+	lines := srv.receive(t, 1)
+	if lines[0] != "myproject.hits 3 4600" {
+		t.Errorf("unexpected lines %q", lines)
+	}
+	// the flush reconnects: the connection of CreateSocket ended empty
+	srv.end(t)
+	srv.end(t)
+}
+
+// the datagrams are left uncompressed
+func TestCompressionDatagrams(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetCompression(Gzip, gzip.DefaultCompression)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Incr("hits", 1)
+	if _, err := srv.WaitFor("myproject.hits", 1, time.Second); err != nil {
+		t.Error(err)
+	}
+	if err := client.Config().Validate(); err == nil || !strings.Contains(err.Error(), "compression") {
+		t.Errorf("expected a configuration problem, actual %v", err)
+	}
+	if err := client.SetCompression(Gzip, 42); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+}
+
+// the configuration check agrees with the connections compressed
+func TestCompressionValidate(t *testing.T) {
+	tests := []struct {
+		addr       string
+		graphite   bool
+		compressed bool
+	}{
+		{addr: "tcp://localhost:8125", compressed: true},
+		{addr: "unix:///tmp/statsd.sock", compressed: true},
+		{addr: "localhost:2003", graphite: true, compressed: true},
+		{addr: "localhost:8125"},
+		{addr: "unixgram:///tmp/statsd.sock", graphite: true},
+		{addr: "unixstream:///tmp/statsd.sock"},
+	}
+	for _, tt := range tests {
+		client := NewStatsdClient(tt.addr, "")
+		client.SetGraphite(tt.graphite)
+		client.SetCompression(Gzip, gzip.DefaultCompression)
+		client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return &packetConn{}, nil
+		}
+		conn, err := client.dialTarget(tt.addr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := false
+		if line, ok := conn.(*lineConn); ok {
+			_, compressed = line.Conn.(*gzipConn)
+		}
+		if compressed != tt.compressed {
+			t.Errorf("%s: expected compressed %v, actual %v", tt.addr, tt.compressed, compressed)
+		}
+		err = client.Config().Validate()
+		if problem := err != nil && strings.Contains(err.Error(), "compression"); problem == tt.compressed {
+			t.Errorf("%s: unexpected configuration check %v", tt.addr, err)
+		}
+	}
+}
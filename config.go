@@ -0,0 +1,259 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// maxUDPPayload is the largest payload of a UDP datagram over IPv4
+const maxUDPPayload = 65507
+
+// Config is an immutable snapshot of the effective configuration of a client,
+// after the defaults and the normalizations, see StatsdClient.Config. Its
+// String method renders it on a single line, to log it at startup
+type Config struct {
+	Addr           string
+	Prefix         string // normalized, with %HOST% expanded
+	Graphite       bool
+	MaxPacketSize  int     // effective, after the downshifts (see SetMaxPacketSize)
+	SampleRate     float64 // 1 when not sampling
+	Adaptive       bool    // see SetAdaptiveSampling
+	StrictNames    bool
+	NormalizeNames bool
+	SendZeroCounts bool
+	NameMapper     bool // see SetNameMapper
+	FilterFunc     bool // see SetFilter
+	EnabledFunc    bool // see SetEnabledFunc
+	DisabledKinds  []MetricKind
+	DeniedPrefixes []string
+	RetryEntries   int // 0 when retries are disabled, see EnableRetry
+	RetryMaxAge    time.Duration
+	MirrorRate     float64 // 0 without a mirror, see SetMirror
+	ContainerID    string
+	ExternalData   string
+	Compression    Compression // see SetCompression
+	FloatPrecision int         // effective, see SetFloatPrecision
+	RawMarker      string      // "" when the raw names are disabled, see SetRawMarker
+
+	// the configuration of the buffered client, if Buffered
+	Buffered             bool
+	FlushInterval        time.Duration
+	MaxRetainedIntervals int
+	EmitRates            bool
+	TotalDeltas          bool
+	UniquePrecision      int // 0 when the unique values are sent as sets
+	ReservoirSize        int // 0 for event.DefaultReservoirSize
+	MaxAbsoluteValues    int // 0 without a limit, see SetMaxAbsoluteValues
+	HighWater            int // 0 without backpressure, see SetBackpressure
+	QueuePolicy          QueuePolicy
+	Pacing               time.Duration // 0 without pacing, see SetPacing
+	FlushSockets         int           // 1 without striping, see SetFlushSockets
+	NegativeCounters     NegativePolicy
+	RemainderIntervals   int           // effective, see SetRemainderIntervals
+	TotalIntervals       int           // effective, see SetTotalIntervals
+	Telemetry            bool          // see SetTelemetry
+	ContributionTTL      time.Duration // 0 when the contributions don't expire, see SetContributionTTL
+	CloseTimeout         time.Duration // effective, see SetCloseTimeout
+}
+
+// Config returns a snapshot of the effective configuration of the client
+func (c *StatsdClient) Config() Config {
+	c.mu.Lock()
+	cfg := Config{
+		Addr:          c.addr,
+		MaxPacketSize: c.packetSize,
+		SampleRate:    1,
+	}
+	if c.retry != nil {
+		cfg.RetryEntries, cfg.RetryMaxAge = c.retry.maxEntries, c.retry.maxAge
+	}
+	if c.mirror != nil {
+		cfg.MirrorRate = c.mirror.rate
+	}
+	c.mu.Unlock()
+	cfg.Prefix, _ = c.currentPrefix()
+	cfg.Graphite = c.isGraphite()
+	cfg.StrictNames = atomic.LoadInt32(&c.strict) != 0
+	cfg.NormalizeNames = atomic.LoadInt32(&c.normalize) != 0
+	cfg.SendZeroCounts = atomic.LoadInt32(&c.zeroes) != 0
+	cfg.RawMarker = c.rawMarker()
+	if cfg.FloatPrecision = c.precision(); cfg.FloatPrecision == 0 {
+		cfg.FloatPrecision = event.DefaultFloatPrecision
+	}
+	if mapper, _ := c.mapper.Load().(func(string) string); mapper != nil {
+		cfg.NameMapper = true
+	}
+	if s, _ := c.sampling.Load().(*sampling); s != nil {
+		cfg.SampleRate = s.rate
+	}
+	if a, _ := c.adaptive.Load().(*adaptive); a != nil {
+		cfg.Adaptive = true
+	}
+	if f, _ := c.filter.Load().(*metricFilter); f != nil {
+		cfg.FilterFunc = f.fn != nil
+		for kind, disabled := range f.disabled {
+			if disabled {
+				cfg.DisabledKinds = append(cfg.DisabledKinds, MetricKind(kind))
+			}
+		}
+		cfg.DeniedPrefixes = append([]string(nil), f.denied...)
+	}
+	if f, _ := c.enabled.Load().(*enabledFunc); f != nil {
+		cfg.EnabledFunc = true
+	}
+	if comp, _ := c.compression.Load().(*compression); comp != nil {
+		cfg.Compression = comp.kind
+	}
+	if o, _ := c.origin.Load().(*origin); o != nil {
+		cfg.ContainerID, cfg.ExternalData = o.containerID, o.externalData
+	}
+	return cfg
+}
+
+// Config returns a snapshot of the effective configuration of the buffered
+// client, including the one of its underlying client
+func (sb *buffer) Config() Config {
+	cfg := sb.statsd.Config()
+	cfg.Buffered = true
+	cfg.FlushInterval = sb.flushInterval
+	cfg.MaxRetainedIntervals = int(atomic.LoadInt32(&sb.maxRetained))
+	cfg.EmitRates = atomic.LoadInt32(&sb.emitRates) != 0
+	cfg.TotalDeltas = atomic.LoadInt32(&sb.totalDeltas) != 0
+	cfg.UniquePrecision = int(atomic.LoadInt32(&sb.uniquePrecision))
+	cfg.ReservoirSize = int(atomic.LoadInt32(&sb.reservoir))
+	cfg.MaxAbsoluteValues = int(atomic.LoadInt32(&sb.maxAbsolute))
+	cfg.QueuePolicy = QueuePolicy(atomic.LoadInt32(&sb.queuePolicy))
+	cfg.Pacing = time.Duration(atomic.LoadInt64(&sb.pacing))
+	if cfg.FlushSockets = int(atomic.LoadInt32(&sb.flushSockets)); cfg.FlushSockets < 1 {
+		cfg.FlushSockets = 1
+	}
+	cfg.NegativeCounters = NegativePolicy(atomic.LoadInt32(&sb.negativePolicy))
+	cfg.RemainderIntervals = sb.remainderIntervalsValue()
+	cfg.TotalIntervals = sb.totalIntervalsValue()
+	cfg.Telemetry = atomic.LoadInt32(&sb.telemetry) != 0
+	cfg.ContributionTTL = time.Duration(atomic.LoadInt64(&sb.contributionTTL))
+	cfg.CloseTimeout = sb.closeTimeoutValue()
+	if bp, _ := sb.backpressure.Load().(*backpressure); bp != nil {
+		cfg.HighWater = bp.highWater
+	}
+	return cfg
+}
+
+// ConfigError lists all the problems found by Config.Validate
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("statsd: %d configuration problems: %s", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// Validate checks the configuration for values out of range and for options
+// which can't work together, returning a *ConfigError listing all of them
+func (cfg Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if t, err := ParseAddr(cfg.Addr); err != nil {
+		add("%v", err)
+	} else {
+		if t.Network == "udp" && cfg.MaxPacketSize > maxUDPPayload && !cfg.Graphite {
+			add("packets of %d bytes exceed the maximum UDP payload (%d)", cfg.MaxPacketSize, maxUDPPayload)
+		}
+		stream := isStream(dialNetwork(t, cfg.Graphite))
+		if cfg.Compression != NoCompression && !compresses(t, cfg.Graphite) {
+			add("compression only applies to the tcp:// and unix:// transports and Graphite mode, not %s", t.Scheme)
+		}
+		if cfg.Buffered && cfg.FlushSockets > 1 && (stream || t.Network != "udp" && t.Network != "unixgram") {
+			add("the flushes are only striped across sockets on the udp:// and unixgram:// transports, not %s", t.Scheme)
+		}
+	}
+	if cfg.MaxPacketSize <= 0 {
+		add("the maximum packet size must be positive, not %d", cfg.MaxPacketSize)
+	}
+	if cfg.SampleRate <= 0 {
+		add("a sample rate of %v drops all the counters and timings", cfg.SampleRate)
+	}
+	if cfg.Adaptive && cfg.SampleRate < 1 {
+		add("the sample rate is ignored with adaptive sampling")
+	}
+	if cfg.Graphite {
+		if cfg.SampleRate < 1 || cfg.Adaptive {
+			add("sampling only applies to the direct sends, which Graphite mode doesn't support")
+		}
+		if cfg.ContainerID != "" || cfg.ExternalData != "" {
+			add("the origin fields (container ID, external data) aren't sent in Graphite mode")
+		}
+		if cfg.Buffered && cfg.UniquePrecision == 0 {
+			add("in Graphite mode the unique values are dropped, unless they are estimated (see SetUniqueEstimation)")
+		}
+	}
+	if cfg.Buffered && cfg.FlushInterval <= 0 {
+		add("the flush interval must be positive, not %s", cfg.FlushInterval)
+	}
+	if cfg.Buffered && cfg.Pacing >= cfg.FlushInterval && cfg.Pacing > 0 {
+		add("the pacing window (%s) must be shorter than the flush interval (%s)", cfg.Pacing, cfg.FlushInterval)
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// String renders the configuration as space-separated key=value pairs
+func (cfg Config) String() string {
+	var b strings.Builder
+	field := func(key string, value interface{}) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%v", key, value)
+	}
+	field("addr", cfg.Addr)
+	field("prefix", fmt.Sprintf("%q", cfg.Prefix))
+	field("graphite", cfg.Graphite)
+	field("max_packet_size", cfg.MaxPacketSize)
+	field("sample_rate", cfg.SampleRate)
+	field("adaptive_sampling", cfg.Adaptive)
+	field("strict_names", cfg.StrictNames)
+	field("normalize_names", cfg.NormalizeNames)
+	field("send_zero_counts", cfg.SendZeroCounts)
+	field("name_mapper", cfg.NameMapper)
+	field("filter_func", cfg.FilterFunc)
+	field("enabled_func", cfg.EnabledFunc)
+	field("disabled_kinds", cfg.DisabledKinds)
+	field("denied_prefixes", cfg.DeniedPrefixes)
+	field("retry_entries", cfg.RetryEntries)
+	field("retry_max_age", cfg.RetryMaxAge)
+	field("mirror_rate", cfg.MirrorRate)
+	field("container_id", fmt.Sprintf("%q", cfg.ContainerID))
+	field("external_data", fmt.Sprintf("%q", cfg.ExternalData))
+	field("compression", cfg.Compression)
+	field("float_precision", cfg.FloatPrecision)
+	field("raw_marker", fmt.Sprintf("%q", cfg.RawMarker))
+	if cfg.Buffered {
+		field("flush_interval", cfg.FlushInterval)
+		field("max_retained_intervals", cfg.MaxRetainedIntervals)
+		field("emit_rates", cfg.EmitRates)
+		field("total_deltas", cfg.TotalDeltas)
+		field("unique_precision", cfg.UniquePrecision)
+		field("reservoir_size", cfg.ReservoirSize)
+		field("max_absolute_values", cfg.MaxAbsoluteValues)
+		field("high_water", cfg.HighWater)
+		field("queue_policy", fmt.Sprintf("%q", cfg.QueuePolicy))
+		field("pacing", cfg.Pacing)
+		field("flush_sockets", cfg.FlushSockets)
+		field("negative_counters", cfg.NegativeCounters)
+		field("remainder_intervals", cfg.RemainderIntervals)
+		field("total_intervals", cfg.TotalIntervals)
+		field("telemetry", cfg.Telemetry)
+		field("contribution_ttl", cfg.ContributionTTL)
+		field("close_timeout", cfg.CloseTimeout)
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package statsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	client := NewStatsdClient("localhost:8125", "myproject")
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+	if err := buffered.Config().Validate(); err != nil {
+		t.Errorf("default configuration: %v", err)
+	}
+
+	client.SetGraphite(true)
+	client.SetSampleRate(0.5)
+	client.SetContainerID("abc")
+	client.SetMaxPacketSize(0)
+	err := buffered.Config().Validate()
+	cfgErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("expected a ConfigError, actual %v", err)
+	}
+	for i, expected := range []string{"packet size", "sampling", "origin fields", "unique values"} {
+		if i >= len(cfgErr.Problems) || !strings.Contains(cfgErr.Problems[i], expected) {
+			t.Errorf("expected a problem about the %s, actual %q", expected, cfgErr.Problems)
+		}
+	}
+	if len(cfgErr.Problems) != 4 {
+		t.Errorf("expected 4 problems, actual %q", cfgErr.Problems)
+	}
+
+	buffered.SetUniqueEstimation(12)
+	client.SetSampleRate(1)
+	client.SetContainerID("")
+	client.SetMaxPacketSize(DefaultMaxPacketSize)
+	if err := buffered.Config().Validate(); err != nil {
+		t.Errorf("fixed configuration: %v", err)
+	}
+}
+
+func TestConfigSnapshot(t *testing.T) {
+	client := NewStatsdClient("localhost:8125", "myproject")
+	client.DisableKind(KindTiming)
+	client.DenyPrefix("debug.")
+	cfg := client.Config()
+	if cfg.Prefix != "myproject." || cfg.SampleRate != 1 || cfg.MaxPacketSize != DefaultMaxPacketSize {
+		t.Errorf("unexpected defaults %s", cfg)
+	}
+	if s := cfg.String(); !strings.Contains(s, "disabled_kinds=[timing]") || !strings.Contains(s, `prefix="myproject."`) {
+		t.Errorf("unexpected rendering %s", s)
+	}
+
+	// neither changing the snapshot nor the client affects the other
+	expected := client.Config()
+	cfg.DisabledKinds[0] = KindGauge
+	cfg.DeniedPrefixes[0] = "other."
+	if actual := client.Config(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("snapshot shares the state of the client: expected %s, actual %s", expected, actual)
+	}
+	snapshot := client.Config()
+	client.DenyPrefix("tmp.")
+	client.SetSampleRate(0.1)
+	if !reflect.DeepEqual(expected, snapshot) {
+		t.Errorf("snapshot changed along with the client: %s", snapshot)
+	}
+}
@@ -0,0 +1,49 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConnectInBackground makes the buffered client own the creation of the
+// socket, for the environments where the StatsD server can't be resolved at
+// startup yet (e.g. containers waiting for DNS): CreateSocket is attempted in
+// the background with an exponential backoff, every failed attempt being
+// logged and passed to the error handler, and the flushes are delayed until it
+// succeeds. Meanwhile the events keep being aggregated, in memory bounded by
+// the number of keys. Close stops the attempts. It must be called at most
+// once, right after NewStatsdBuffer, instead of CreateSocket
+func (sb *buffer) ConnectInBackground() {
+	atomic.StoreInt32(&sb.connecting, 1)
+	sb.workers.Add(1)
+	go sb.connect()
+}
+
+// isConnecting tells whether the flushes are delayed until ConnectInBackground
+// succeeds
+func (sb *buffer) isConnecting() bool {
+	return atomic.LoadInt32(&sb.connecting) != 0
+}
+
+// connect attempts to create the socket until it succeeds or the buffer is closed
+func (sb *buffer) connect() {
+	defer sb.workers.Done()
+	defer atomic.StoreInt32(&sb.connecting, 0)
+	backoff := minRetryBackoff
+	for atomic.LoadInt32(&sb.closed) == 0 {
+		err := sb.statsd.CreateSocket()
+		if err == nil || err == ErrClosed {
+			return
+		}
+		sb.Logger.Println("Error establishing the connection to StatsD, retrying in", backoff, ":", err)
+		sb.handleError(err)
+		select {
+		case <-time.After(backoff):
+		case <-sb.done:
+			return
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
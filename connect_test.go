@@ -0,0 +1,90 @@
+package statsd
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// delayedResolver fails to dial until it's ready, like a DNS not ready yet
+type delayedResolver struct {
+	conn     *packetConn
+	ready    int32
+	attempts int32
+}
+
+func (r *delayedResolver) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	atomic.AddInt32(&r.attempts, 1)
+	if atomic.LoadInt32(&r.ready) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: address, IsTemporary: true}
+	}
+	return r.conn, nil
+}
+
+func TestConnectInBackground(t *testing.T) {
+	resolver := &delayedResolver{conn: &packetConn{}}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client := NewStatsdClient("statsd:8125", "myproject.")
+	client.SetDialer(resolver.dial)
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	var mu sync.Mutex
+	var errs []error
+	buffered.SetErrorHandler(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	buffered.ConnectInBackground()
+	defer buffered.Close()
+
+	buffered.Incr("a", 1)
+	buffered.Incr("a", 2)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	// the flushes are delayed while connecting
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return atomic.LoadInt32(&resolver.attempts) >= 3 })
+	buffered.Incr("a", 3)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	if packets := resolver.conn.sent(); len(packets) != 0 {
+		t.Fatalf("unexpected packets while connecting %q", packets)
+	}
+
+	atomic.StoreInt32(&resolver.ready, 1)
+	waitUntil(t, time.Second, func() bool { return !buffered.isConnecting() })
+	attempts := int(atomic.LoadInt32(&resolver.attempts))
+	mu.Lock()
+	if len(errs) != attempts-1 {
+		t.Errorf("expected an error per failed attempt (%d), actual %v", attempts-1, errs)
+	}
+	mu.Unlock()
+
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(resolver.conn.sent()) == 1 })
+	if expected := []string{"myproject.a:6|c"}; !reflect.DeepEqual(expected, resolver.conn.sent()) {
+		t.Errorf("expected %q, actual %q", expected, resolver.conn.sent())
+	}
+}
+
+func TestCloseWhileConnecting(t *testing.T) {
+	resolver := &delayedResolver{conn: &packetConn{}}
+	client := NewStatsdClient("statsd:8125", "myproject.")
+	client.SetDialer(resolver.dial)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.ConnectInBackground()
+	waitUntil(t, time.Second, func() bool { return atomic.LoadInt32(&resolver.attempts) >= 2 })
+	buffered.Close()
+	waitUntil(t, time.Second, func() bool { return !buffered.isConnecting() })
+	attempts := atomic.LoadInt32(&resolver.attempts)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&resolver.attempts); n != attempts {
+		t.Errorf("%d attempts after Close", n-attempts)
+	}
+}
@@ -0,0 +1,37 @@
+package statsd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholder matches the %NAME% placeholders of a prefix
+var placeholder = regexp.MustCompile(`%[A-Za-z_]+%`)
+
+// NewStatsdClientE creates a client like NewStatsdClient, but reports the
+// misconfigurations straight away: the address must be well formed (see
+// ParseAddr), and the prefix can only use placeholders which can be expanded
+// (%HOST%, if the hostname is known) and no reserved characters. If connect
+// is true the socket is created as well, see CreateSocket
+func NewStatsdClientE(addr string, prefix string, connect bool) (*StatsdClient, error) {
+	if _, err := ParseAddr(addr); err != nil {
+		return nil, err
+	}
+	if strings.Contains(prefix, "%HOST%") && Hostname == "" {
+		return nil, fmt.Errorf("statsd: can't expand %%HOST%% in prefix %q, the hostname is unknown", prefix)
+	}
+	if p := placeholder.FindString(strings.Replace(prefix, "%HOST%", "", -1)); p != "" {
+		return nil, fmt.Errorf("statsd: unknown placeholder %s in prefix %q", p, prefix)
+	}
+	if err := Validate(FieldName, prefix); err != nil {
+		return nil, fmt.Errorf("statsd: invalid prefix %q: %v", prefix, err)
+	}
+	c := NewStatsdClient(addr, prefix)
+	if connect {
+		if err := c.CreateSocket(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,58 @@
+package statsd
+
+import "testing"
+
+func TestNewStatsdClientE(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	tests := []struct {
+		addr   string
+		prefix string
+		fails  bool
+	}{
+		{addr: srv.Addr(), prefix: "myproject."},
+		{addr: srv.Addr(), prefix: "myproject.%HOST%."},
+		{addr: "[::1]:8125", prefix: ""},
+		{addr: "statsd.example.com:8125", prefix: ""},
+		{addr: "statsd_exporter:8125", prefix: ""},
+		{addr: "localhost:statsd", prefix: ""},
+		{addr: "unixstream:///var/run/dsd.sock", prefix: ""},
+		{addr: "localhost", prefix: "", fails: true},             // missing port
+		{addr: "localhost:", prefix: "", fails: true},            // missing port
+		{addr: "localhost:70000", prefix: "", fails: true},       // bad port
+		{addr: "localhost:0", prefix: "", fails: true},           // bad port
+		{addr: "http://localhost:8125", prefix: "", fails: true}, // bad scheme
+		{addr: "unixgram://", prefix: "", fails: true},           // missing path
+		{addr: srv.Addr(), prefix: "myproject.%HOSTNAME%.", fails: true},
+		{addr: srv.Addr(), prefix: "my|project.", fails: true},
+	}
+	for _, tt := range tests {
+		client, err := NewStatsdClientE(tt.addr, tt.prefix, false)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%q, %q: expected an error", tt.addr, tt.prefix)
+			}
+			continue
+		}
+		if err != nil || client == nil {
+			t.Errorf("%q, %q: %v", tt.addr, tt.prefix, err)
+		}
+	}
+
+	client, err := NewStatsdClientE(srv.Addr(), "myproject.", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Incr("a", 1); err != nil {
+		t.Errorf("eagerly connected client can't send: %v", err)
+	}
+
+	hostname := Hostname
+	Hostname = ""
+	defer func() { Hostname = hostname }()
+	if _, err := NewStatsdClientE(srv.Addr(), "%HOST%.", false); err == nil {
+		t.Error("expected an error for an unresolvable %HOST%")
+	}
+}
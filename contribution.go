@@ -0,0 +1,101 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// contribution is the value of a gauge from one of its sources, see
+// GaugeContribution. It's queued like the other events and summed by the
+// collector, instead of being merged into the pending events
+type contribution struct {
+	event.Gauge
+	source string
+	at     time.Time
+	remove bool // see RemoveContribution
+}
+
+// Copy returns a copy of the contribution, see event.Copier: the
+// one of the embedded gauge would lose the source
+func (c contribution) Copy() event.Event {
+	return &c
+}
+
+// contributor is the latest contribution of a source to a gauge
+type contributor struct {
+	value   int64
+	updated time.Time
+}
+
+// GaugeContribution sets the contribution of source to the gauge stat, for the
+// gauges several subsystems set from their own view (e.g. the active workers
+// of each pool) which would otherwise overwrite each other: every flush sends
+// the sum of the contributions as the gauge. A contribution persists across
+// the flushes until the source updates it, RemoveContribution removes it, or
+// it expires (see SetContributionTTL). Don't mix it with Gauge on the same stat
+func (sb *buffer) GaugeContribution(stat string, source string, value int64) error {
+	return sb.enqueue(&contribution{Gauge: event.Gauge{Name: stat, Value: value}, source: source, at: sb.statsd.now()})
+}
+
+// RemoveContribution removes the contribution of source to the gauge stat,
+// see GaugeContribution. Once the last one is removed, the gauge is sent as 0
+// one last time
+func (sb *buffer) RemoveContribution(stat string, source string) error {
+	return sb.enqueue(&contribution{Gauge: event.Gauge{Name: stat}, source: source, remove: true})
+}
+
+// SetContributionTTL expires the contributions to the gauges which their source
+// hasn't updated for ttl, e.g. because the subsystem died without removing
+// them, see GaugeContribution. 0, the default, keeps them until they're removed
+func (sb *buffer) SetContributionTTL(ttl time.Duration) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	atomic.StoreInt64(&sb.contributionTTL, int64(ttl))
+}
+
+// contribute records a contribution to the gauge of the given name. It's only
+// called from within the collector
+func (sb *buffer) contribute(name string, c *contribution) {
+	sources, ok := sb.contributions[name]
+	if !ok {
+		if c.remove {
+			return
+		}
+		if sb.contributions == nil {
+			sb.contributions = make(map[string]map[string]contributor)
+		}
+		sources = make(map[string]contributor)
+		sb.contributions[name] = sources
+	}
+	if c.remove {
+		delete(sources, c.source)
+		return
+	}
+	sources[c.source] = contributor{value: c.Value, updated: c.at}
+}
+
+// contributionEvents returns the sums of the contributions to the gauges,
+// after expiring the idle ones, and forgets the gauges left without any
+// contribution once their 0 is sent
+func (sb *buffer) contributionEvents(now time.Time) []event.Event {
+	ttl := time.Duration(atomic.LoadInt64(&sb.contributionTTL))
+	var events []event.Event
+	for name, sources := range sb.contributions {
+		var sum int64
+		for source, c := range sources {
+			if ttl > 0 && now.Sub(c.updated) > ttl {
+				delete(sources, source)
+				continue
+			}
+			sum += c.value
+		}
+		if len(sources) == 0 {
+			delete(sb.contributions, name)
+		}
+		events = append(events, &event.Gauge{Name: name, Value: sum})
+	}
+	return events
+}
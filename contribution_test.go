@@ -0,0 +1,88 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// newContributionClient returns a buffered client flushing every 10s on the
+// fake clock, and a function advancing the clock by an interval which returns
+// the lines of the flush
+func newContributionClient(t *testing.T) (*StatsdBuffer, func() []string) {
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Logger = discardLogger{}
+	t.Cleanup(func() { buffered.Close() })
+	reports := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+	seen := 0
+	flush := func() []string {
+		t.Helper()
+		clock.Advance(10 * time.Second)
+		select {
+		case <-reports:
+		case <-time.After(time.Second):
+			t.Fatal("no flush")
+		}
+		sent := conn.sent()
+		var lines []string
+		for _, packet := range sent[seen:] {
+			lines = append(lines, strings.Split(packet, "\n")...)
+		}
+		seen = len(sent)
+		return lines
+	}
+	return buffered, flush
+}
+
+func expectLines(t *testing.T, expected []string, actual []string) {
+	t.Helper()
+	if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestGaugeContribution(t *testing.T) {
+	buffered, flush := newContributionClient(t)
+
+	buffered.GaugeContribution("workers.active", "http", 3)
+	buffered.GaugeContribution("workers.active", "jobs", 4)
+	expectLines(t, []string{"myproject.workers.active:7|g"}, flush())
+
+	// the jobs pool updates more often, the contribution of the http one persists
+	buffered.GaugeContribution("workers.active", "jobs", 6)
+	buffered.GaugeContribution("workers.active", "jobs", 2)
+	expectLines(t, []string{"myproject.workers.active:5|g"}, flush())
+	buffered.Incr("hits", 1)
+	expectLines(t, []string{"myproject.hits:1|c", "myproject.workers.active:5|g"}, flush())
+
+	buffered.RemoveContribution("workers.active", "http")
+	expectLines(t, []string{"myproject.workers.active:2|g"}, flush())
+
+	// the gauge is reset once the last contribution is removed, then forgotten
+	buffered.RemoveContribution("workers.active", "jobs")
+	expectLines(t, []string{"myproject.workers.active:0|g"}, flush())
+	buffered.Incr("hits", 1)
+	expectLines(t, []string{"myproject.hits:1|c"}, flush())
+}
+
+func TestContributionTTL(t *testing.T) {
+	buffered, flush := newContributionClient(t)
+	buffered.SetContributionTTL(25 * time.Second)
+
+	buffered.GaugeContribution("workers.active", "http", 3)
+	buffered.GaugeContribution("workers.active", "jobs", 4)
+	expectLines(t, []string{"myproject.workers.active:7|g"}, flush())
+	buffered.GaugeContribution("workers.active", "jobs", 5)
+	expectLines(t, []string{"myproject.workers.active:8|g"}, flush())
+	buffered.GaugeContribution("workers.active", "jobs", 5)
+	// the http contribution is idle for 30s
+	expectLines(t, []string{"myproject.workers.active:5|g"}, flush())
+	expectLines(t, []string{"myproject.workers.active:5|g"}, flush())
+	expectLines(t, []string{"myproject.workers.active:0|g"}, flush())
+}
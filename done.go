@@ -0,0 +1,19 @@
+package statsd
+
+// Done returns a channel closed once the buffered client has fully terminated
+// after Close: the collector and the flush in progress have exited, the
+// background connection attempts and the loops of the client (retries, burst
+// buffer, prefix refresh) have stopped, and the socket is closed. Close
+// doesn't wait for all of them, e.g. when the final flush is abandoned
+func (sb *buffer) Done() <-chan struct{} {
+	return sb.terminated
+}
+
+// terminate closes the Done channel once all the goroutines have exited, the
+// client being closed
+func (sb *buffer) terminate() {
+	<-sb.done
+	sb.workers.Wait()
+	sb.statsd.loops.Wait()
+	close(sb.terminated)
+}
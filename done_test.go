@@ -0,0 +1,77 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDone(t *testing.T) {
+	before := runtime.NumGoroutine()
+	resolver := &delayedResolver{conn: &packetConn{}}
+	client := NewStatsdClient("statsd:8125", "myproject.")
+	client.SetDialer(resolver.dial)
+	client.EnableRetry(10, time.Minute)
+	client.SetBurstBuffer(10, time.Millisecond)
+	client.SetPrefixProvider(func() string { return "myproject." }, time.Minute)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	buffered.ConnectInBackground()
+	buffered.Incr("a", 1)
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("expected the goroutines of the client to be running")
+	}
+
+	select {
+	case <-buffered.Done():
+		t.Fatal("done before Close")
+	case <-time.After(10 * time.Millisecond):
+	}
+	buffered.Close()
+	select {
+	case <-buffered.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done after Close")
+	}
+	if buffered.isConnecting() {
+		t.Error("still connecting when done")
+	}
+	if err := client.Close(); err != ErrClosed {
+		t.Errorf("expected the client to be closed, actual %v", err)
+	}
+	// only the goroutine closing Done may still be returning
+	waitUntil(t, time.Second, func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestDoneAfterAbandonedClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	conn := &stuckConn{release: make(chan struct{})}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("a", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := buffered.CloseContext(ctx); err == nil {
+		t.Fatal("expected the final flush to be abandoned")
+	}
+	select {
+	case <-buffered.Done():
+		t.Fatal("done while the final flush is stuck")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(conn.release)
+	select {
+	case <-buffered.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done once the flush returned")
+	}
+	waitUntil(t, time.Second, func() bool { return runtime.NumGoroutine() <= before })
+}
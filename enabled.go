@@ -0,0 +1,112 @@
+package statsd
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// enabledCacheSize is the number of distinct names whose answer is cached
+const enabledCacheSize = 4096
+
+// enabledFunc is an enable switch with its cache, swapped atomically. The
+// answers of the least recently used names are cached in bounded memory
+type enabledFunc struct {
+	fn       func(stat string) bool
+	ttl      time.Duration
+	maxNames int
+	mu       sync.Mutex
+	names    map[string]*list.Element
+	lru      *list.List // of *enabledEntry, the most recently used first
+}
+
+// enabledEntry is the cached answer of the function for a name
+type enabledEntry struct {
+	name    string
+	enabled bool
+	expires time.Time
+}
+
+func newEnabledFunc(fn func(stat string) bool, ttl time.Duration, maxNames int) *enabledFunc {
+	return &enabledFunc{
+		fn:       fn,
+		ttl:      ttl,
+		maxNames: maxNames,
+		names:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// cached returns the answer cached for stat, if it hasn't expired
+func (f *enabledFunc) cached(stat string, now time.Time) (enabled bool, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	el, found := f.names[stat]
+	if !found {
+		return false, false
+	}
+	f.lru.MoveToFront(el)
+	e := el.Value.(*enabledEntry)
+	return e.enabled, now.Before(e.expires)
+}
+
+// store caches the answer for stat, evicting the least recently used name
+// beyond maxNames
+func (f *enabledFunc) store(stat string, enabled bool, expires time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if el, found := f.names[stat]; found {
+		*el.Value.(*enabledEntry) = enabledEntry{name: stat, enabled: enabled, expires: expires}
+		f.lru.MoveToFront(el)
+		return
+	}
+	f.names[stat] = f.lru.PushFront(&enabledEntry{name: stat, enabled: enabled, expires: expires})
+	if f.lru.Len() > f.maxNames {
+		oldest := f.lru.Back()
+		f.lru.Remove(oldest)
+		delete(f.names, oldest.Value.(*enabledEntry).name)
+	}
+}
+
+// SetEnabledFunc installs a switch consulted before formatting each metric,
+// e.g. to gate expensive metric families behind the feature flags of a flag
+// service: the metrics whose (unprefixed) name it returns false for are
+// dropped, and counted in Stats().Suppressed. Its answers are cached per name
+// for ttl, so it's only called again once the ttl expires (a ttl <= 0 disables
+// the cache); it can be called concurrently. The answers of the 4096 most
+// recently used names are cached. It can be changed at any time, which clears
+// the cache; nil removes it
+func (c *StatsdClient) SetEnabledFunc(fn func(stat string) bool, ttl time.Duration) {
+	if fn == nil {
+		c.enabled.Store((*enabledFunc)(nil))
+		return
+	}
+	c.enabled.Store(newEnabledFunc(fn, ttl, enabledCacheSize))
+}
+
+// isEnabled consults the enable switch, see SetEnabledFunc
+func (c *StatsdClient) isEnabled(stat string) bool {
+	f, _ := c.enabled.Load().(*enabledFunc)
+	if f == nil {
+		return true
+	}
+	if f.ttl <= 0 {
+		return f.fn(stat)
+	}
+	now := c.now()
+	if enabled, ok := f.cached(stat, now); ok {
+		return enabled
+	}
+	// called without the lock, concurrent callers may both refresh an
+	// expired entry, the last one wins
+	enabled := f.fn(stat)
+	f.store(stat, enabled, now.Add(f.ttl))
+	return enabled
+}
+
+// suppress counts a metric dropped by the enable switch
+func (c *StatsdClient) suppress(kind MetricKind) {
+	atomic.AddInt64(&c.suppressed, 1)
+	c.count(kind, outcomeDropped, 1)
+}
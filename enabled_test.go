@@ -0,0 +1,130 @@
+package statsd
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestEnabledFunc(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	var flag int32 // the expensive family is off
+	var calls int64
+	client.SetEnabledFunc(func(stat string) bool {
+		atomic.AddInt64(&calls, 1)
+		return !strings.HasPrefix(stat, "expensive.") || atomic.LoadInt32(&flag) != 0
+	}, time.Minute)
+
+	send := func() {
+		client.Incr("expensive.hits", 1)
+		client.Timing("expensive.latency", 5)
+		client.Incr("cheap", 1)
+	}
+	send()
+	send()
+	if calls != 3 {
+		t.Errorf("expected the answers to be cached, actual %d calls", calls)
+	}
+	if stats := client.Stats(); stats.Suppressed != 4 || stats.Filtered != 0 {
+		t.Errorf("expected 4 metrics suppressed, actual %+v", stats)
+	}
+
+	// the flag is turned on: the cached answers still apply until they expire
+	atomic.StoreInt32(&flag, 1)
+	clock.Advance(59 * time.Second)
+	send()
+	if packets := conn.sent(); strings.Contains(strings.Join(packets, "\n"), "expensive") {
+		t.Errorf("the metrics were sent before the ttl expired: %q", packets)
+	}
+	clock.Advance(time.Second)
+	send()
+	if calls != 6 {
+		t.Errorf("expected the answers to be refreshed, actual %d calls", calls)
+	}
+	packets := conn.sent()
+	if last := packets[len(packets)-3:]; last[0] != "myproject.expensive.hits:1|c" || last[1] != "myproject.expensive.latency:5|ms" {
+		t.Errorf("the metrics weren't sent after the ttl expired: %q", last)
+	}
+	if stats := client.StatsByKind()[KindTiming]; stats.Dropped != 3 {
+		t.Errorf("expected 3 timings dropped, actual %+v", stats)
+	}
+
+	// changing the function clears the cache
+	client.SetEnabledFunc(func(string) bool { return false }, time.Minute)
+	if client.Incr("cheap", 1); client.Stats().Suppressed != 7 {
+		t.Error("the cache of the previous function was used")
+	}
+	client.SetEnabledFunc(nil, 0)
+	if client.Incr("cheap", 1); client.Stats().Suppressed != 7 {
+		t.Error("the metric was suppressed without a function")
+	}
+}
+
+func TestEnabledFuncCache(t *testing.T) {
+	calls := 0
+	f := newEnabledFunc(func(string) bool { calls++; return true }, time.Minute, 2)
+	c := NewStatsdClient("localhost:8125", "")
+	c.enabled.Store(f)
+	for _, name := range []string{"a", "a", "b", "a", "c"} {
+		c.isEnabled(name)
+	}
+	// a was used more recently than b, which is evicted
+	if calls != 3 || f.lru.Len() != 2 || f.names["b"] != nil {
+		t.Errorf("unexpected cache: %d calls, names %v", calls, f.names)
+	}
+	c.isEnabled("a")
+	c.isEnabled("b")
+	if calls != 4 {
+		t.Errorf("expected only b to be asked again, actual %d calls", calls)
+	}
+}
+
+func TestEnabledFuncBuffered(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetEnabledFunc(func(stat string) bool { return stat != "off" }, 0)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("off", 1)
+	buffered.Incr("on", 1)
+	buffered.Close()
+	if packets := conn.sent(); len(packets) != 1 || packets[0] != "myproject.on:1|c" {
+		t.Errorf("unexpected packets %q", packets)
+	}
+}
+
+// the answers expiring while many goroutines send are refreshed without races
+func TestEnabledFuncConcurrent(t *testing.T) {
+	client, _ := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	var flag int32
+	client.SetEnabledFunc(func(string) bool { return atomic.LoadInt32(&flag) != 0 }, time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				client.Incr("hits", 1)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		atomic.StoreInt32(&flag, int32(i%2))
+		clock.Advance(time.Second)
+	}
+	wg.Wait()
+	atomic.StoreInt32(&flag, 1)
+	clock.Advance(time.Second)
+	before := client.Stats().Suppressed
+	client.Incr("hits", 1)
+	if client.Stats().Suppressed != before {
+		t.Error("the answer wasn't refreshed once the ttl expired")
+	}
+}
@@ -0,0 +1,92 @@
+package statsd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorsDropped is sent by the handler of ChannelErrorHandler once the channel
+// has room again, summarizing the errors it had to discard meanwhile
+type ErrorsDropped struct {
+	Count int
+	Since time.Time // when the first one was discarded
+}
+
+func (e *ErrorsDropped) Error() string {
+	return fmt.Sprintf("statsd: %d errors dropped since %s", e.Count, e.Since.Format(time.RFC3339))
+}
+
+// errorChannel is the state of a ChannelErrorHandler
+type errorChannel struct {
+	mu      sync.Mutex
+	ch      chan<- error
+	now     func() time.Time
+	dropped int
+	since   time.Time
+}
+
+// ChannelErrorHandler returns an error handler for SetErrorHandler which sends
+// the errors to ch without blocking: when ch is full the errors are discarded,
+// and the next time an error is reported and ch has room, an *ErrorsDropped
+// counting them is sent before it, so that the consumer knows about the gap.
+// See StatsdBuffer.SetErrorChannel to get the summary without waiting for
+// another error
+func ChannelErrorHandler(ch chan<- error) func(error) {
+	c := &errorChannel{ch: ch, now: time.Now}
+	return c.handle
+}
+
+// SetErrorChannel sends the errors to ch like a ChannelErrorHandler set with
+// SetErrorHandler, except that the *ErrorsDropped summary is also sent by the
+// next flush if ch has room by then, and dated by the clock of the client
+func (sb *buffer) SetErrorChannel(ch chan<- error) {
+	c := &errorChannel{ch: ch, now: sb.statsd.now}
+	sb.errorHandler.Store(c.handle)
+	sb.errorChannel.Store(c)
+}
+
+func (c *errorChannel) handle(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.sendDropped() {
+		c.dropped++
+		return
+	}
+	select {
+	case c.ch <- err:
+	default:
+		c.dropped, c.since = 1, c.now()
+	}
+}
+
+// report sends the summary of the errors discarded, if any and if ch has room,
+// see SetErrorChannel
+func (c *errorChannel) report() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendDropped()
+}
+
+// sendDropped sends the summary of the errors discarded, if any, and returns
+// false if ch is still full. The caller must hold c.mu
+func (c *errorChannel) sendDropped() bool {
+	if c.dropped == 0 {
+		return true
+	}
+	select {
+	case c.ch <- &ErrorsDropped{Count: c.dropped, Since: c.since}:
+		c.dropped = 0
+		return true
+	default:
+		return false
+	}
+}
+
+// reportDropped sends the summary of the errors discarded by the error
+// channel, if any, see SetErrorChannel
+func (sb *buffer) reportDropped() {
+	if c, _ := sb.errorChannel.Load().(*errorChannel); c != nil {
+		c.report()
+	}
+}
@@ -0,0 +1,82 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestChannelErrorHandler(t *testing.T) {
+	ch := make(chan error, 2)
+	handle := ChannelErrorHandler(ch)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		handle(fmt.Errorf("error %d", i))
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-ch; err.Error() != fmt.Sprintf("error %d", i) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+
+	// the summary goes first, then error 5 fills the channel and error 6 is dropped
+	handle(errors.New("error 5"))
+	handle(errors.New("error 6"))
+	dropped, ok := (<-ch).(*ErrorsDropped)
+	if !ok || dropped.Count != 3 || dropped.Since.Before(start) {
+		t.Fatalf("unexpected summary %+v", dropped)
+	}
+	if err := <-ch; err.Error() != "error 5" {
+		t.Errorf("unexpected error %v", err)
+	}
+	handle(errors.New("error 7"))
+	if err := <-ch; err.(*ErrorsDropped).Count != 1 {
+		t.Errorf("unexpected summary %v", err)
+	}
+	if err := <-ch; err.Error() != "error 7" {
+		t.Errorf("unexpected error %v", err)
+	}
+	select {
+	case err := <-ch:
+		t.Errorf("unexpected error %v", err)
+	default:
+	}
+}
+
+func TestBufferErrorChannel(t *testing.T) {
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client, _ := newPacketClient(t, "myproject.")
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	defer buffered.Close()
+	buffered.Logger = discardLogger{}
+	ch := make(chan error, 1)
+	buffered.SetErrorChannel(ch)
+	for i := 0; i < 3; i++ {
+		buffered.handleError(fmt.Errorf("error %d", i))
+	}
+	if err := <-ch; err.Error() != "error 0" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// the summary comes with the next flush, without another error
+	clock.Advance(10 * time.Second)
+	select {
+	case err := <-ch:
+		dropped, ok := err.(*ErrorsDropped)
+		if !ok || dropped.Count != 2 || !dropped.Since.Equal(time.Unix(1000, 0)) {
+			t.Errorf("unexpected summary %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no summary")
+	}
+
+	// a handler set afterwards replaces the channel
+	buffered.SetErrorHandler(func(error) {})
+	if c, _ := buffered.errorChannel.Load().(*errorChannel); c != nil {
+		t.Error("the error channel wasn't removed")
+	}
+}
@@ -4,9 +4,12 @@ import "fmt"
 
 // Absolute is a metric that is not averaged/aggregated.
 // We keep each value distinct and then we flush them all individually.
+// MaxValues bounds the number of values retained by Update, further values
+// are dropped (0 means no limit)
 type Absolute struct {
-	Name   string
-	Values []int64
+	Name      string
+	Values    []int64
+	MaxValues int
 }
 
 // Update the event with metrics coming from a new one of the same type and with the same key
@@ -14,7 +17,7 @@ func (e *Absolute) Update(e2 Event) error {
 	if e.Type() != e2.Type() {
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
-	e.Values = append(e.Values, e2.Payload().([]int64)...)
+	e.Values = appendCapped(e.Values, e.MaxValues, e2.Payload().([]int64)...)
 	return nil
 }
 
@@ -32,6 +35,20 @@ func (e Absolute) Stats() []string {
 	return ret
 }
 
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e Absolute) AppendStats(buf []byte, prefix string) []byte {
+	for _, v := range e.Values {
+		buf = appendInt(buf, prefix, e.Name, v, "|a")
+	}
+	return buf
+}
+
+// Copy returns a deep copy of the event, see Copier
+func (e Absolute) Copy() Event {
+	e.Values = append([]int64(nil), e.Values...)
+	return &e
+}
+
 // Key returns the name of this metric
 func (e Absolute) Key() string {
 	return e.Name
@@ -56,3 +73,25 @@ func (e Absolute) TypeString() string {
 func (e Absolute) String() string {
 	return fmt.Sprintf("{Type: %s, Key: %s, Values: %v}", e.TypeString(), e.Name, e.Values)
 }
+
+// appendCapped appends the values, up to max values if max > 0
+func appendCapped(values []int64, max int, more ...int64) []int64 {
+	if max > 0 && len(values)+len(more) > max {
+		if len(values) >= max {
+			return values
+		}
+		more = more[:max-len(values)]
+	}
+	return append(values, more...)
+}
+
+// appendCappedFloat appends the values, up to max values if max > 0
+func appendCappedFloat(values []float64, max int, more ...float64) []float64 {
+	if max > 0 && len(values)+len(more) > max {
+		if len(values) >= max {
+			return values
+		}
+		more = more[:max-len(values)]
+	}
+	return append(values, more...)
+}
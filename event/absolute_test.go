@@ -0,0 +1,18 @@
+package event
+
+import "testing"
+
+func TestAbsoluteCap(t *testing.T) {
+	e := &Absolute{Name: "a", Values: []int64{1}, MaxValues: 3}
+	fe := &FAbsolute{Name: "a", Values: []float64{1}, MaxValues: 3}
+	for i := 2; i <= 5; i++ {
+		e.Update(&Absolute{Name: "a", Values: []int64{int64(i)}})
+		fe.Update(&FAbsolute{Name: "a", Values: []float64{float64(i)}})
+	}
+	if len(e.Stats()) != 3 || e.Values[2] != 3 {
+		t.Errorf("expected the first 3 values, actual %v", e.Values)
+	}
+	if len(fe.Stats()) != 3 || fe.Values[2] != 3 {
+		t.Errorf("expected the first 3 values, actual %v", fe.Values)
+	}
+}
@@ -0,0 +1,98 @@
+package event
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Appender is implemented by the events which can serialize their lines
+// straight into a buffer, without allocating the strings of Stats
+type Appender interface {
+	// AppendStats appends the lines of Stats to buf, with the name prefixed
+	// by prefix, each line followed by a newline
+	AppendStats(buf []byte, prefix string) []byte
+}
+
+// PrecisionAppender is implemented by the events with floating point values,
+// which can serialize them with another maximum number of decimal digits than
+// DefaultFloatPrecision
+type PrecisionAppender interface {
+	// AppendStatsPrecision is AppendStats with at most precision decimal
+	// digits in the floating point values, DefaultFloatPrecision if 0 or less
+	AppendStatsPrecision(buf []byte, prefix string, precision int) []byte
+}
+
+// AppendStatsPrecision appends the lines of any event to buf like AppendStats,
+// with at most precision decimal digits in the floating point values, see
+// PrecisionAppender
+func AppendStatsPrecision(buf []byte, prefix string, e Event, precision int) []byte {
+	if a, ok := e.(PrecisionAppender); ok {
+		return a.AppendStatsPrecision(buf, prefix, precision)
+	}
+	return AppendStats(buf, prefix, e)
+}
+
+// AppendStats appends the lines of any event to buf, see Appender
+func AppendStats(buf []byte, prefix string, e Event) []byte {
+	if a, ok := e.(Appender); ok {
+		return a.AppendStats(buf, prefix)
+	}
+	return appendLines(buf, prefix, e.Stats())
+}
+
+// appendLines appends the lines of Stats, for the events which don't have a
+// faster serialization
+func appendLines(buf []byte, prefix string, stats []string) []byte {
+	for _, stat := range stats {
+		buf = append(append(append(buf, prefix...), stat...), '\n')
+	}
+	return buf
+}
+
+// appendName starts a line
+func appendName(buf []byte, prefix string, name string) []byte {
+	return append(append(append(buf, prefix...), name...), ':')
+}
+
+// appendInt appends a line with an integer value
+func appendInt(buf []byte, prefix string, name string, v int64, suffix string) []byte {
+	buf = strconv.AppendInt(appendName(buf, prefix, name), v, 10)
+	return append(append(buf, suffix...), '\n')
+}
+
+// appendFloat appends a line with a float value
+func appendFloat(buf []byte, prefix string, name string, v float64, precision int, suffix string) []byte {
+	buf = appendFloatValue(appendName(buf, prefix, name), v, precision)
+	return append(append(buf, suffix...), '\n')
+}
+
+// appendAggregateName starts a line with an aggregate of a timer, e.g. name.avg
+func appendAggregateName(buf []byte, prefix string, name string, aggregate string) []byte {
+	buf = append(append(append(append(buf, prefix...), name...), '.'), aggregate...)
+	return append(buf, ':')
+}
+
+// appendIntAggregate appends a line with an integer aggregate of a timer
+func appendIntAggregate(buf []byte, prefix string, name string, aggregate string, v int64) []byte {
+	buf = strconv.AppendInt(appendAggregateName(buf, prefix, name, aggregate), v, 10)
+	return append(buf, "|a\n"...)
+}
+
+// appendFloatAggregate appends a line with a float aggregate of a timer
+func appendFloatAggregate(buf []byte, prefix string, name string, aggregate string, v float64, precision int) []byte {
+	buf = appendFloatValue(appendAggregateName(buf, prefix, name, aggregate), v, precision)
+	return append(buf, "|a\n"...)
+}
+
+// appendFloatValue appends a value rendered like FormatFloatPrecision
+func appendFloatValue(buf []byte, v float64, precision int) []byte {
+	start := len(buf)
+	buf = strconv.AppendFloat(buf, v, 'f', floatDigits(precision), 64)
+	if bytes.IndexByte(buf[start:], '.') >= 0 {
+		buf = bytes.TrimRight(bytes.TrimRight(buf, "0"), ".")
+	}
+	if string(buf[start:]) == "-0" {
+		buf = append(buf[:start], '0')
+	}
+	return buf
+}
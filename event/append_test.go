@@ -0,0 +1,77 @@
+package event
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// AppendStats must render exactly the lines of Stats
+func TestAppendStats(t *testing.T) {
+	check := func(e Event) bool {
+		var expected string
+		for _, stat := range e.Stats() {
+			expected += "p." + stat + "\n"
+		}
+		if actual := string(AppendStats([]byte("x"), "p.", e)); actual != "x"+expected {
+			t.Logf("%s: expected %q, actual %q", e, expected, actual)
+			return false
+		}
+		return true
+	}
+	f := func(i int64, fl float64, small int8) bool {
+		tiny := float64(small) * 1e-7
+		return check(&Increment{Name: "a", Value: i}) &&
+			check(&Total{Name: "a", Value: i}) &&
+			check(&Gauge{Name: "a", Value: i}) &&
+			check(&GaugeDelta{Name: "a", Value: i}) &&
+			check(&FGauge{Name: "a", Value: fl}) &&
+			check(&FGauge{Name: "a", Value: tiny}) &&
+			check(&FGaugeDelta{Name: "a", Value: fl}) &&
+			check(&FGaugeDelta{Name: "a", Value: tiny}) &&
+			check(&Absolute{Name: "a", Values: []int64{i, -i}}) &&
+			check(&FAbsolute{Name: "a", Values: []float64{fl, tiny, math.NaN()}}) &&
+			check(NewTiming("a", i)) &&
+			check(NewFTiming("a", fl)) &&
+			check(NewPrecisionTiming("a", time.Duration(i)))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	if !check(&FGauge{Name: "a", Value: math.Inf(1)}) || !check(&FGaugeDelta{Name: "a", Value: -123.5}) ||
+		!check(&Set{Name: "a", Members: map[string]struct{}{"x": {}, "y": {}}}) {
+		t.Error("unexpected serialization")
+	}
+	if s := string(AppendStats(nil, "", &Gauge{Name: "g", Value: -2})); !strings.HasPrefix(s, "g:0|g\n") {
+		t.Errorf("unexpected negative gauge %q", s)
+	}
+}
+
+// AppendGroupSizes must tell the sizes of the groups of Groups
+func TestAppendGroupSizes(t *testing.T) {
+	check := func(e GroupSizer) bool {
+		var expected []int
+		for _, group := range e.Groups() {
+			expected = append(expected, len(group))
+		}
+		if actual := e.AppendGroupSizes(nil); len(actual) != len(expected) || (len(actual) > 0 && actual[0] != expected[0]) {
+			t.Logf("%s: expected %v, actual %v", e, expected, actual)
+			return false
+		}
+		return true
+	}
+	f := func(i int64, fl float64) bool {
+		return check(&Gauge{Name: "a", Value: i}) &&
+			check(&GaugeMax{Name: "a", Value: i}) &&
+			check(&GaugeMin{Name: "a", Value: i}) &&
+			check(&FGauge{Name: "a", Value: fl})
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	if !check(&FGauge{Name: "a", Value: math.NaN()}) || !check(&FGauge{Name: "a", Value: -1}) {
+		t.Error("unexpected group sizes")
+	}
+}
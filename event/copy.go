@@ -0,0 +1,15 @@
+package event
+
+// Copier is implemented by the events which can be copied, e.g. to emit an
+// event under several names: the copy doesn't share any state with the event
+type Copier interface {
+	Copy() Event
+}
+
+// Copy returns a deep copy of e, or nil if it isn't a Copier
+func Copy(e Event) Event {
+	if c, ok := e.(Copier); ok {
+		return c.Copy()
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCopy(t *testing.T) {
+	events := []Event{
+		&Increment{Name: "a", Value: 1},
+		&Gauge{Name: "a", Value: -1},
+		&Absolute{Name: "a", Values: []int64{1, 2}, MaxValues: 3},
+		&FAbsolute{Name: "a", Values: []float64{1.5}},
+		NewTiming("a", 1),
+		NewFTiming("a", 1.5),
+		NewPrecisionTiming("a", time.Millisecond),
+		NewSet("a", "x"),
+		PreQualified(&Total{Name: "a", Value: 1}),
+	}
+	for _, e := range events {
+		c := Copy(e)
+		if !reflect.DeepEqual(e, c) {
+			t.Errorf("expected %v, actual %v", e, c)
+			continue
+		}
+		// the copy doesn't share any state with the event
+		before := e.String()
+		c.SetKey("b")
+		c.Update(c)
+		if e.String() != before {
+			t.Errorf("%T: the copy modified the event: %v", e, e)
+		}
+	}
+	if c := Copy(PreQualified(struct{ Event }{&Gauge{Name: "a"}})); c != nil {
+		t.Errorf("expected no copy, actual %v", c)
+	}
+}
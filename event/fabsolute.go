@@ -4,9 +4,11 @@ import "fmt"
 
 // FAbsolute is a metric that is not averaged/aggregated.
 // We keep each value distinct and then we flush them all individually.
+// MaxValues bounds the number of values retained by Update, like Absolute
 type FAbsolute struct {
-	Name   string
-	Values []float64
+	Name      string
+	Values    []float64
+	MaxValues int
 }
 
 // Update the event with metrics coming from a new one of the same type and with the same key
@@ -14,7 +16,7 @@ func (e *FAbsolute) Update(e2 Event) error {
 	if e.Type() != e2.Type() {
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
-	e.Values = append(e.Values, e2.Payload().([]float64)...)
+	e.Values = appendCappedFloat(e.Values, e.MaxValues, e2.Payload().([]float64)...)
 	return nil
 }
 
@@ -27,11 +29,37 @@ func (e FAbsolute) Payload() interface{} {
 func (e FAbsolute) Stats() []string {
 	ret := make([]string, 0, len(e.Values))
 	for _, v := range e.Values {
-		ret = append(ret, fmt.Sprintf("%s:%g|a", e.Name, v))
+		if !IsFinite(v) {
+			// NaN and ±Inf can't be represented on the wire
+			continue
+		}
+		ret = append(ret, fmt.Sprintf("%s:%s|a", e.Name, FormatFloat(v)))
 	}
 	return ret
 }
 
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e FAbsolute) AppendStats(buf []byte, prefix string) []byte {
+	return e.AppendStatsPrecision(buf, prefix, DefaultFloatPrecision)
+}
+
+// AppendStatsPrecision appends the lines of Stats to buf with at most precision
+// decimal digits, see PrecisionAppender
+func (e FAbsolute) AppendStatsPrecision(buf []byte, prefix string, precision int) []byte {
+	for _, v := range e.Values {
+		if IsFinite(v) {
+			buf = appendFloat(buf, prefix, e.Name, v, precision, "|a")
+		}
+	}
+	return buf
+}
+
+// Copy returns a deep copy of the event, see Copier
+func (e FAbsolute) Copy() Event {
+	e.Values = append([]float64(nil), e.Values...)
+	return &e
+}
+
 // Key returns the name of this metric
 func (e FAbsolute) Key() string {
 	return e.Name
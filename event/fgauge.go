@@ -10,12 +10,17 @@ type FGauge struct {
 	Value float64
 }
 
-// Update the event with metrics coming from a new one of the same type and with the same key
+// Update the event with metrics coming from a new one with the same key:
+// a new value replaces the current one, a FGaugeDelta is added to it
 func (e *FGauge) Update(e2 Event) error {
-	if e.Type() != e2.Type() {
+	switch e2.Type() {
+	case e.Type():
+		e.Value = e2.Payload().(float64)
+	case EventFGaugeDelta:
+		e.Value += e2.Payload().(float64)
+	default:
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
-	e.Value += e2.Payload().(float64)
 	return nil
 }
 
@@ -26,16 +31,62 @@ func (e FGauge) Payload() interface{} {
 
 // Stats returns an array of StatsD events as they travel over UDP
 func (e FGauge) Stats() []string {
+	if !IsFinite(e.Value) {
+		// NaN and ±Inf can't be represented on the wire
+		return nil
+	}
 	if e.Value < 0 {
 		// because a leading '+' or '-' in the value of a gauge denotes a delta, to send
 		// a negative gauge value we first set the gauge absolutely to 0, then send the
 		// negative value as a delta from 0 (that's just how the spec works :-)
 		return []string{
 			fmt.Sprintf("%s:%d|g", e.Name, 0),
-			fmt.Sprintf("%s:%g|g", e.Name, e.Value),
+			fmt.Sprintf("%s:%s|g", e.Name, FormatFloat(e.Value)),
 		}
 	}
-	return []string{fmt.Sprintf("%s:%g|g", e.Name, e.Value)}
+	return []string{fmt.Sprintf("%s:%s|g", e.Name, FormatFloat(e.Value))}
+}
+
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e FGauge) AppendStats(buf []byte, prefix string) []byte {
+	return e.AppendStatsPrecision(buf, prefix, DefaultFloatPrecision)
+}
+
+// AppendStatsPrecision appends the lines of Stats to buf with at most precision
+// decimal digits, see PrecisionAppender
+func (e FGauge) AppendStatsPrecision(buf []byte, prefix string, precision int) []byte {
+	if !IsFinite(e.Value) {
+		return buf
+	}
+	if e.Value < 0 {
+		buf = appendInt(buf, prefix, e.Name, 0, "|g")
+	}
+	return appendFloat(buf, prefix, e.Name, e.Value, precision, "|g")
+}
+
+// Groups returns the lines of the gauge as a single group, since the reset of a
+// negative value must not be split from the delta which follows it
+func (e FGauge) Groups() [][]string {
+	if stats := e.Stats(); len(stats) > 0 {
+		return [][]string{stats}
+	}
+	return nil
+}
+
+// AppendGroupSizes appends the size of the single group of Groups, see GroupSizer
+func (e FGauge) AppendGroupSizes(sizes []int) []int {
+	switch {
+	case !IsFinite(e.Value):
+		return sizes
+	case e.Value < 0:
+		return append(sizes, 2)
+	}
+	return append(sizes, 1)
+}
+
+// Copy returns a copy of the event, see Copier
+func (e FGauge) Copy() Event {
+	return &e
 }
 
 // Key returns the name of this metric
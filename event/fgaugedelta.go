@@ -2,9 +2,7 @@ package event
 
 import "fmt"
 
-// FGaugeDelta - Gauges are a constant data type. They are not subject to averaging,
-// and they don’t change unless you change them. That is, once you set a gauge value,
-// it will be a flat line on the graph until you change it again
+// FGaugeDelta is a floating point change to the current value of a gauge
 type FGaugeDelta struct {
 	Name  string
 	Value float64
@@ -26,16 +24,38 @@ func (e FGaugeDelta) Payload() interface{} {
 
 // Stats returns an array of StatsD events as they travel over UDP
 func (e FGaugeDelta) Stats() []string {
+	if !IsFinite(e.Value) {
+		// NaN and ±Inf can't be represented on the wire
+		return nil
+	}
+	// Gauge Deltas are always sent with a leading '+' or '-'. The '-' takes care of itself but the '+' must added by hand
+	if e.Value < 0 {
+		return []string{fmt.Sprintf("%s:%s|g", e.Name, FormatFloat(e.Value))}
+	}
+	return []string{fmt.Sprintf("%s:+%s|g", e.Name, FormatFloat(e.Value))}
+}
+
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e FGaugeDelta) AppendStats(buf []byte, prefix string) []byte {
+	return e.AppendStatsPrecision(buf, prefix, DefaultFloatPrecision)
+}
+
+// AppendStatsPrecision appends the lines of Stats to buf with at most precision
+// decimal digits, see PrecisionAppender
+func (e FGaugeDelta) AppendStatsPrecision(buf []byte, prefix string, precision int) []byte {
+	if !IsFinite(e.Value) {
+		return buf
+	}
 	if e.Value < 0 {
-		// because a leading '+' or '-' in the value of a gauge denotes a delta, to send
-		// a negative gauge value we first set the gauge absolutely to 0, then send the
-		// negative value as a delta from 0 (that's just how the spec works :-)
-		return []string{
-			fmt.Sprintf("%s:%d|g", e.Name, 0),
-			fmt.Sprintf("%s:%g|g", e.Name, e.Value),
-		}
+		return appendFloat(buf, prefix, e.Name, e.Value, precision, "|g")
 	}
-	return []string{fmt.Sprintf("%s:%g|g", e.Name, e.Value)}
+	buf = appendFloatValue(append(appendName(buf, prefix, e.Name), '+'), e.Value, precision)
+	return append(buf, "|g\n"...)
+}
+
+// Copy returns a copy of the event, see Copier
+func (e FGaugeDelta) Copy() Event {
+	return &e
 }
 
 // Key returns the name of this metric
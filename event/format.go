@@ -0,0 +1,46 @@
+package event
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultFloatPrecision is the maximum number of decimal digits used when
+// serializing floating point values, unless another one is given (see
+// AppendStatsPrecision). Values smaller than that are rounded to 0
+const DefaultFloatPrecision = 6
+
+// FormatFloat renders a value in fixed-point notation, never using the exponent
+// notation some StatsD daemons reject, with at most DefaultFloatPrecision
+// decimal digits and without trailing zeros
+func FormatFloat(v float64) string {
+	return FormatFloatPrecision(v, DefaultFloatPrecision)
+}
+
+// FormatFloatPrecision is FormatFloat with at most precision decimal digits,
+// DefaultFloatPrecision if 0 or less
+func FormatFloatPrecision(v float64, precision int) string {
+	s := strconv.FormatFloat(v, 'f', floatDigits(precision), 64)
+	if strings.IndexByte(s, '.') >= 0 {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// floatDigits returns the number of decimal digits of a precision, see
+// FormatFloatPrecision
+func floatDigits(precision int) int {
+	if precision <= 0 {
+		return DefaultFloatPrecision
+	}
+	return precision
+}
+
+// IsFinite tells whether a value can be sent over the wire, i.e. it's not NaN or ±Inf
+func IsFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
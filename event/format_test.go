@@ -0,0 +1,86 @@
+package event
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatFloat(t *testing.T) {
+	tests := map[float64]string{
+		0:           "0",
+		1:           "1",
+		-1.5:        "-1.5",
+		0.0000001:   "0",
+		-0.0000001:  "0",
+		0.000001:    "0.000001",
+		0.1234567:   "0.123457",
+		1e21:        "1000000000000000000000",
+		-3.5e15:     "-3500000000000000",
+		123456.7891: "123456.7891",
+	}
+	for v, expected := range tests {
+		if actual := FormatFloat(v); actual != expected {
+			t.Errorf("FormatFloat(%v): expected %s, actual %s", v, expected, actual)
+		}
+	}
+}
+
+func TestFormatFloatPrecision(t *testing.T) {
+	tests := []struct {
+		v         float64
+		precision int
+		expected  string
+	}{
+		{0.1234567, 2, "0.12"},
+		{0.1234567, 9, "0.1234567"},
+		{0.0000001, 9, "0.0000001"},
+		{-0.004, 2, "0"},
+		{0.1234567, 0, "0.123457"},
+	}
+	for _, tt := range tests {
+		if actual := FormatFloatPrecision(tt.v, tt.precision); actual != tt.expected {
+			t.Errorf("FormatFloatPrecision(%v, %d): expected %s, actual %s", tt.v, tt.precision, tt.expected, actual)
+		}
+	}
+}
+
+func TestAppendStatsPrecision(t *testing.T) {
+	tests := []struct {
+		e        Event
+		expected string
+	}{
+		{&FGauge{Name: "g", Value: -0.123}, "g:0|g\ng:-0.12|g\n"},
+		{&FGaugeDelta{Name: "d", Value: 0.0000001}, "d:+0|g\n"},
+		{&FAbsolute{Name: "a", Values: []float64{1.005, 2}}, "a:1|a\na:2|a\n"},
+		{&FTiming{Name: "ft", Min: 1, Max: 2, Value: 3, Count: 3}, "ft.avg:1|a\nft.min:1|a\nft.max:2|a\n"},
+		{&PrecisionTiming{Name: "pt", Min: 314 * time.Microsecond, Max: time.Millisecond, Value: 1314 * time.Microsecond, Count: 2}, "pt.avg:0.66|a\npt.min:0.31|a\npt.max:1|a\n"},
+		{&Gauge{Name: "i", Value: 3}, "i:3|g\n"},
+	}
+	for _, tt := range tests {
+		if actual := string(AppendStatsPrecision(nil, "", tt.e, 2)); actual != tt.expected {
+			t.Errorf("%s: expected %q, actual %q", tt.e, tt.expected, actual)
+		}
+	}
+}
+
+func TestFloatStats(t *testing.T) {
+	tests := []struct {
+		e        Event
+		expected []string
+	}{
+		{&FGauge{Name: "tiny", Value: 0.000002}, []string{"tiny:0.000002|g"}},
+		{&FGauge{Name: "huge", Value: 1e20}, []string{"huge:100000000000000000000|g"}},
+		{&FGauge{Name: "neg", Value: -2e-6}, []string{"neg:0|g", "neg:-0.000002|g"}},
+		{&FGauge{Name: "nan", Value: math.NaN()}, nil},
+		{&FGaugeDelta{Name: "inf", Value: math.Inf(1)}, nil},
+		{&FAbsolute{Name: "abs", Values: []float64{1e-7, math.Inf(-1), 2.5e10}}, []string{"abs:0|a", "abs:25000000000|a"}},
+		{&PrecisionTiming{Name: "pt", Min: time.Millisecond, Max: 3 * time.Millisecond, Value: 4 * time.Millisecond, Count: 3}, []string{"pt.avg:1.333333|a", "pt.min:1|a", "pt.max:3|a"}},
+	}
+	for _, tt := range tests {
+		if actual := tt.e.Stats(); !reflect.DeepEqual(tt.expected, actual) && len(tt.expected)+len(actual) > 0 {
+			t.Errorf("%s: expected %v, actual %v", tt.e, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package event
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// FTiming keeps min/max/avg information about a timer given in floating point
+// milliseconds over a certain interval. Min, Max, Value (the sum) and Count are
+// exact, while percentiles are estimated from a reservoir of at most
+// ReservoirSize samples (DefaultReservoirSize if 0)
+type FTiming struct {
+	Name          string
+	Min           float64
+	Max           float64
+	Value         float64
+	Count         int64
+	Samples       []float64
+	ReservoirSize int
+}
+
+// NewFTiming is a factory for a FTiming event, setting the Count to 1 to prevent div_by_0 errors
+func NewFTiming(k string, ms float64) *FTiming {
+	return &FTiming{Name: k, Min: ms, Max: ms, Value: ms, Count: 1, Samples: []float64{ms}}
+}
+
+// Update the event with metrics coming from a new one of the same type and with the same key
+func (e *FTiming) Update(e2 Event) error {
+	if e.Type() != e2.Type() {
+		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
+	}
+	p := e2.Payload().(FTiming)
+	e.Samples = sampleFloat64(e.Samples, e.ReservoirSize, e.Count, p.Samples...)
+	e.Count += p.Count
+	e.Value += p.Value
+	e.Min = math.Min(e.Min, p.Min)
+	e.Max = math.Max(e.Max, p.Max)
+	return nil
+}
+
+// Payload returns the aggregated value for this event
+func (e FTiming) Payload() interface{} {
+	return e
+}
+
+// Percentile returns an estimate of the given percentile (0 < p <= 100) of the timings
+func (e FTiming) Percentile(p float64) float64 {
+	return percentileFloat64(e.Samples, p)
+}
+
+// Stats returns an array of StatsD events as they travel over UDP
+func (e FTiming) Stats() []string {
+	return []string{
+		fmt.Sprintf("%s.avg:%s|a", e.Name, FormatFloat(e.Value/float64(e.Count))), // make sure e.Count != 0
+		fmt.Sprintf("%s.min:%s|a", e.Name, FormatFloat(e.Min)),
+		fmt.Sprintf("%s.max:%s|a", e.Name, FormatFloat(e.Max)),
+	}
+}
+
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e FTiming) AppendStats(buf []byte, prefix string) []byte {
+	return e.AppendStatsPrecision(buf, prefix, DefaultFloatPrecision)
+}
+
+// AppendStatsPrecision appends the lines of Stats to buf with at most precision
+// decimal digits, see PrecisionAppender
+func (e FTiming) AppendStatsPrecision(buf []byte, prefix string, precision int) []byte {
+	buf = appendFloatAggregate(buf, prefix, e.Name, "avg", e.Value/float64(e.Count), precision)
+	buf = appendFloatAggregate(buf, prefix, e.Name, "min", e.Min, precision)
+	return appendFloatAggregate(buf, prefix, e.Name, "max", e.Max, precision)
+}
+
+// Resize sets the ReservoirSize, dropping random samples beyond it, see Resizer
+func (e *FTiming) Resize(size int) {
+	e.ReservoirSize = size
+	if n := reservoirSize(size); len(e.Samples) > n {
+		rand.Shuffle(len(e.Samples), func(i, j int) { e.Samples[i], e.Samples[j] = e.Samples[j], e.Samples[i] })
+		e.Samples = e.Samples[:n]
+	}
+}
+
+// Copy returns a deep copy of the event, see Copier
+func (e FTiming) Copy() Event {
+	e.Samples = append([]float64(nil), e.Samples...)
+	return &e
+}
+
+// Key returns the name of this metric
+func (e FTiming) Key() string {
+	return e.Name
+}
+
+// SetKey sets the name of this metric
+func (e *FTiming) SetKey(key string) {
+	e.Name = key
+}
+
+// Type returns an integer identifier for this type of metric
+func (e FTiming) Type() int {
+	return EventFTiming
+}
+
+// TypeString returns a name for this type of metric
+func (e FTiming) TypeString() string {
+	return "FTiming"
+}
+
+// String returns a debug-friendly representation of this metric
+func (e FTiming) String() string {
+	return fmt.Sprintf("{Type: %s, Key: %s, Value: {Min: %v, Max: %v, Value: %v, Count: %d}}", e.TypeString(), e.Name, e.Min, e.Max, e.Value, e.Count)
+}
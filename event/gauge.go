@@ -10,12 +10,17 @@ type Gauge struct {
 	Value int64
 }
 
-// Update the event with metrics coming from a new one of the same type and with the same key
+// Update the event with metrics coming from a new one with the same key:
+// a new value replaces the current one, a GaugeDelta is added to it
 func (e *Gauge) Update(e2 Event) error {
-	if e.Type() != e2.Type() {
+	switch e2.Type() {
+	case e.Type():
+		e.Value = e2.Payload().(int64)
+	case EventGaugeDelta:
+		e.Value += e2.Payload().(int64)
+	default:
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
-	e.Value += e2.Payload().(int64)
 	return nil
 }
 
@@ -38,6 +43,36 @@ func (e Gauge) Stats() []string {
 	return []string{fmt.Sprintf("%s:%d|g", e.Name, e.Value)}
 }
 
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e Gauge) AppendStats(buf []byte, prefix string) []byte {
+	if e.Value < 0 {
+		buf = appendInt(buf, prefix, e.Name, 0, "|g")
+	}
+	return appendInt(buf, prefix, e.Name, e.Value, "|g")
+}
+
+// Groups returns the lines of the gauge as a single group, since the reset of a
+// negative value must not be split from the delta which follows it
+func (e Gauge) Groups() [][]string {
+	if stats := e.Stats(); len(stats) > 0 {
+		return [][]string{stats}
+	}
+	return nil
+}
+
+// AppendGroupSizes appends the size of the single group of Groups, see GroupSizer
+func (e Gauge) AppendGroupSizes(sizes []int) []int {
+	if e.Value < 0 {
+		return append(sizes, 2)
+	}
+	return append(sizes, 1)
+}
+
+// Copy returns a copy of the event, see Copier
+func (e Gauge) Copy() Event {
+	return &e
+}
+
 // Key returns the name of this metric
 func (e Gauge) Key() string {
 	return e.Name
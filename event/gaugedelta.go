@@ -1,10 +1,11 @@
 package event
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
-// GaugeDelta - Gauges are a constant data type. They are not subject to averaging,
-// and they don’t change unless you change them. That is, once you set a gauge value,
-// it will be a flat line on the graph until you change it again
+// GaugeDelta is a change to the current value of a gauge
 type GaugeDelta struct {
 	Name  string
 	Value int64
@@ -26,16 +27,25 @@ func (e GaugeDelta) Payload() interface{} {
 
 // Stats returns an array of StatsD events as they travel over UDP
 func (e GaugeDelta) Stats() []string {
+	// Gauge Deltas are always sent with a leading '+' or '-'. The '-' takes care of itself but the '+' must added by hand
 	if e.Value < 0 {
-		// because a leading '+' or '-' in the value of a gauge denotes a delta, to send
-		// a negative gauge value we first set the gauge absolutely to 0, then send the
-		// negative value as a delta from 0 (that's just how the spec works :-)
-		return []string{
-			fmt.Sprintf("%s:%d|g", e.Name, 0),
-			fmt.Sprintf("%s:%d|g", e.Name, e.Value),
-		}
+		return []string{fmt.Sprintf("%s:%d|g", e.Name, e.Value)}
 	}
-	return []string{fmt.Sprintf("%s:%d|g", e.Name, e.Value)}
+	return []string{fmt.Sprintf("%s:+%d|g", e.Name, e.Value)}
+}
+
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e GaugeDelta) AppendStats(buf []byte, prefix string) []byte {
+	if e.Value < 0 {
+		return appendInt(buf, prefix, e.Name, e.Value, "|g")
+	}
+	buf = strconv.AppendInt(append(appendName(buf, prefix, e.Name), '+'), e.Value, 10)
+	return append(buf, "|g\n"...)
+}
+
+// Copy returns a copy of the event, see Copier
+func (e GaugeDelta) Copy() Event {
+	return &e
 }
 
 // Key returns the name of this metric
@@ -0,0 +1,77 @@
+package event
+
+import "fmt"
+
+// GaugeMax is the highest value of a gauge within an interval, e.g. the peak
+// depth of a queue: it's sent as a plain gauge
+type GaugeMax struct {
+	Name  string
+	Value int64
+}
+
+// Update the event with metrics coming from a new one of the same type and
+// with the same key, keeping the highest value
+func (e *GaugeMax) Update(e2 Event) error {
+	if e.Type() != e2.Type() {
+		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
+	}
+	if v := e2.Payload().(int64); v > e.Value {
+		e.Value = v
+	}
+	return nil
+}
+
+// Payload returns the aggregated value for this event
+func (e GaugeMax) Payload() interface{} {
+	return e.Value
+}
+
+// Stats returns an array of StatsD events as they travel over UDP
+func (e GaugeMax) Stats() []string {
+	return Gauge{Name: e.Name, Value: e.Value}.Stats()
+}
+
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e GaugeMax) AppendStats(buf []byte, prefix string) []byte {
+	return Gauge{Name: e.Name, Value: e.Value}.AppendStats(buf, prefix)
+}
+
+// Groups returns the lines of the gauge as a single group, see Gauge.Groups
+func (e GaugeMax) Groups() [][]string {
+	return Gauge{Name: e.Name, Value: e.Value}.Groups()
+}
+
+// AppendGroupSizes appends the size of the single group of Groups, see GroupSizer
+func (e GaugeMax) AppendGroupSizes(sizes []int) []int {
+	return Gauge{Name: e.Name, Value: e.Value}.AppendGroupSizes(sizes)
+}
+
+// Copy returns a copy of the event, see Copier
+func (e GaugeMax) Copy() Event {
+	return &e
+}
+
+// Key returns the name of this metric
+func (e GaugeMax) Key() string {
+	return e.Name
+}
+
+// SetKey sets the name of this metric
+func (e *GaugeMax) SetKey(key string) {
+	e.Name = key
+}
+
+// Type returns an integer identifier for this type of metric
+func (e GaugeMax) Type() int {
+	return EventGaugeMax
+}
+
+// TypeString returns a name for this type of metric
+func (e GaugeMax) TypeString() string {
+	return "GaugeMax"
+}
+
+// String returns a debug-friendly representation of this metric
+func (e GaugeMax) String() string {
+	return fmt.Sprintf("{Type: %s, Key: %s, Value: %d}", e.TypeString(), e.Name, e.Value)
+}
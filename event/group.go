@@ -0,0 +1,32 @@
+package event
+
+// Grouper is implemented by the events whose lines must reach the server
+// together: the client never splits a group across packets, so that losing a
+// packet can't leave e.g. a negative gauge reset to 0
+type Grouper interface {
+	Groups() [][]string
+}
+
+// GroupSizer is implemented by the Groupers which can tell the number of lines
+// of their groups, so that the lines serialized by AppendStats are grouped
+// without building the strings of Groups
+type GroupSizer interface {
+	Grouper
+	// AppendGroupSizes appends the number of lines of each group, in the
+	// order of the lines, to sizes
+	AppendGroupSizes(sizes []int) []int
+}
+
+// Groups returns the lines of an event grouped as they must be packed: the
+// groups of a Grouper, or else one group per line
+func Groups(e Event) [][]string {
+	if g, ok := e.(Grouper); ok {
+		return g.Groups()
+	}
+	stats := e.Stats()
+	groups := make([][]string, len(stats))
+	for i := range stats {
+		groups[i] = stats[i : i+1 : i+1]
+	}
+	return groups
+}
@@ -8,13 +8,15 @@ type Increment struct {
 	Value int64
 }
 
-// Update the event with metrics coming from a new one of the same type and with the same key
+// Update the event with metrics coming from a new one of the same type and with the same key.
+// A sum out of the int64 range saturates, and ErrCounterOverflow is returned
 func (e *Increment) Update(e2 Event) error {
 	if e.Type() != e2.Type() {
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
-	e.Value += e2.Payload().(int64)
-	return nil
+	var err error
+	e.Value, err = addSaturated(e.Value, e2.Payload().(int64))
+	return err
 }
 
 // Payload returns the aggregated value for this event
@@ -27,6 +29,16 @@ func (e Increment) Stats() []string {
 	return []string{fmt.Sprintf("%s:%d|c", e.Name, e.Value)}
 }
 
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e Increment) AppendStats(buf []byte, prefix string) []byte {
+	return appendInt(buf, prefix, e.Name, e.Value, "|c")
+}
+
+// Copy returns a copy of the event, see Copier
+func (e Increment) Copy() Event {
+	return &e
+}
+
 // Key returns the name of this metric
 func (e Increment) Key() string {
 	return e.Name
@@ -12,6 +12,10 @@ const (
 	EventFGaugeDelta
 	EventFAbsolute
 	EventPrecisionTiming
+	EventFTiming
+	EventSet
+	EventGaugeMax
+	EventGaugeMin
 )
 
 // Event is an interface to a generic StatsD event, used by the buffered client collator
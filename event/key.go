@@ -0,0 +1,78 @@
+package event
+
+import "strings"
+
+// Tag is a dimension of a metric, as a key and a value
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// SortTags sorts the tags in place by key, then by value, the canonical order
+// of KeyFor. There are only a few tags per metric, an insertion sort doesn't
+// allocate
+func SortTags(tags []Tag) {
+	for i := 1; i < len(tags); i++ {
+		for j := i; j > 0 && tagLess(tags[j], tags[j-1]); j-- {
+			tags[j], tags[j-1] = tags[j-1], tags[j]
+		}
+	}
+}
+
+func tagLess(a, b Tag) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.Value < b.Value
+}
+
+// the separators of KeyFor, escaped with a backslash in the stat and the tags
+const keySeparators = `\,=`
+
+// KeyFor returns the aggregation key of a stat with tags, the same whatever
+// the order of the tags: the events with the same key are merged. The key is
+// stat,k1=v1,k2=v2 with the tags sorted (see SortTags), and the separators in
+// the stat and the tags escaped with a backslash, so that different stats or
+// tags never share a key. The tags given aren't modified
+func KeyFor(stat string, tags []Tag) string {
+	if len(tags) == 0 && !strings.ContainsAny(stat, keySeparators) {
+		return stat
+	}
+	sorted := tags
+	for i := 1; i < len(tags); i++ {
+		if tagLess(tags[i], tags[i-1]) {
+			sorted = append([]Tag(nil), tags...)
+			SortTags(sorted)
+			break
+		}
+	}
+	n := len(stat)
+	for _, t := range sorted {
+		n += 2 + len(t.Key) + len(t.Value)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	writeKeyPart(&b, stat)
+	for _, t := range sorted {
+		b.WriteByte(',')
+		writeKeyPart(&b, t.Key)
+		b.WriteByte('=')
+		writeKeyPart(&b, t.Value)
+	}
+	return b.String()
+}
+
+// writeKeyPart writes s escaping the separators of KeyFor
+func writeKeyPart(b *strings.Builder, s string) {
+	for {
+		i := strings.IndexAny(s, keySeparators)
+		if i < 0 {
+			b.WriteString(s)
+			return
+		}
+		b.WriteString(s[:i])
+		b.WriteByte('\\')
+		b.WriteByte(s[i])
+		s = s[i+1:]
+	}
+}
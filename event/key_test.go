@@ -0,0 +1,106 @@
+package event
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestKeyFor(t *testing.T) {
+	tests := []struct {
+		stat     string
+		tags     []Tag
+		expected string
+	}{
+		{stat: "requests", expected: "requests"},
+		{stat: "requests", tags: []Tag{{"status", "200"}, {"method", "GET"}}, expected: "requests,method=GET,status=200"},
+		{stat: "requests", tags: []Tag{{"db", "b"}, {"db", "a"}}, expected: "requests,db=a,db=b"},
+		{stat: `a,b=c\d`, expected: `a\,b\=c\\d`},
+		{stat: "x", tags: []Tag{{"k,1", "v=2"}}, expected: `x,k\,1=v\=2`},
+	}
+	for _, tt := range tests {
+		tags := append([]Tag(nil), tt.tags...)
+		if actual := KeyFor(tt.stat, tt.tags); actual != tt.expected {
+			t.Errorf("expected %q, actual %q", tt.expected, actual)
+		}
+		if !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("the tags were modified: %v", tt.tags)
+		}
+	}
+}
+
+// randomTags generates tags made of few characters, mostly the separators, so
+// that the keys and values often clash
+func randomTags(r *rand.Rand) []Tag {
+	const chars = `ab,=\`
+	part := func() string {
+		b := make([]byte, r.Intn(4))
+		for i := range b {
+			b[i] = chars[r.Intn(len(chars))]
+		}
+		return string(b)
+	}
+	tags := make([]Tag, r.Intn(4))
+	for i := range tags {
+		tags[i] = Tag{Key: part(), Value: part()}
+	}
+	return tags
+}
+
+func TestKeyForPermutations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	f := func() bool {
+		tags := randomTags(r)
+		shuffled := append([]Tag(nil), tags...)
+		r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		if k1, k2 := KeyFor("stat", tags), KeyFor("stat", shuffled); k1 != k2 {
+			t.Logf("%v: %q, %v: %q", tags, k1, shuffled, k2)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{Rand: r, MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeyForCollisions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	stats := []string{"s", "s,", "s=", `s\`, "s,a=b"}
+	keys := make(map[string][]Tag)
+	owners := make(map[string]string)
+	for i := 0; i < 20000; i++ {
+		stat := stats[r.Intn(len(stats))]
+		tags := randomTags(r)
+		SortTags(tags)
+		key := KeyFor(stat, tags)
+		if previous, ok := keys[key]; ok {
+			if owners[key] != stat || !sameTags(previous, tags) {
+				t.Fatalf("%q shared by %q %v and %q %v", key, owners[key], previous, stat, tags)
+			}
+			continue
+		}
+		keys[key], owners[key] = tags, stat
+	}
+}
+
+func sameTags(a, b []Tag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func BenchmarkKeyFor(b *testing.B) {
+	tags := []Tag{{"status", "200"}, {"method", "GET"}, {"route", "/users"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		KeyFor("requests", tags)
+	}
+}
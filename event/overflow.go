@@ -0,0 +1,22 @@
+package event
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrCounterOverflow is returned by Update when the sum of a counter exceeds
+// the int64 range: the value saturates at math.MaxInt64 (or math.MinInt64)
+// instead of wrapping around
+var ErrCounterOverflow = errors.New("statsd: counter overflow, saturated at the int64 range")
+
+// addSaturated adds b to a, saturating at the bounds of the int64 range
+func addSaturated(a, b int64) (int64, error) {
+	switch {
+	case b > 0 && a > math.MaxInt64-b:
+		return math.MaxInt64, ErrCounterOverflow
+	case b < 0 && a < math.MinInt64-b:
+		return math.MinInt64, ErrCounterOverflow
+	}
+	return a + b, nil
+}
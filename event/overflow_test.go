@@ -0,0 +1,36 @@
+package event
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIncrementOverflow(t *testing.T) {
+	tests := []struct {
+		values   []int64
+		expected int64
+		overflow bool
+	}{
+		{values: []int64{math.MaxInt64 - 1, 1}, expected: math.MaxInt64},
+		{values: []int64{math.MaxInt64 / 2, math.MaxInt64 / 2, 1}, expected: math.MaxInt64},
+		{values: []int64{math.MaxInt64 / 2, math.MaxInt64 / 2, 2}, expected: math.MaxInt64, overflow: true},
+		{values: []int64{math.MaxInt64, math.MaxInt64, -5}, expected: math.MaxInt64 - 5, overflow: true},
+		{values: []int64{math.MinInt64 + 1, -1}, expected: math.MinInt64},
+		{values: []int64{math.MinInt64, -1}, expected: math.MinInt64, overflow: true},
+		{values: []int64{math.MaxInt64, math.MinInt64}, expected: -1},
+	}
+	for _, tt := range tests {
+		e := &Increment{Name: "a", Value: tt.values[0]}
+		overflow := false
+		for _, v := range tt.values[1:] {
+			if err := e.Update(&Increment{Name: "a", Value: v}); err == ErrCounterOverflow {
+				overflow = true
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if e.Value != tt.expected || overflow != tt.overflow {
+			t.Errorf("%v: expected %d (overflow %v), actual %d (overflow %v)", tt.values, tt.expected, tt.overflow, e.Value, overflow)
+		}
+	}
+}
@@ -2,21 +2,26 @@ package event
 
 import (
 	"fmt"
+	"math/rand"
 	"time"
 )
 
-// PrecisionTiming keeps min/max/avg information about a timer over a certain interval
+// PrecisionTiming keeps min/max/avg information about a timer over a certain interval.
+// Min, Max, Value (the sum) and Count are exact, while percentiles are estimated
+// from a reservoir of at most ReservoirSize samples (DefaultReservoirSize if 0)
 type PrecisionTiming struct {
-	Name  string
-	Min   time.Duration
-	Max   time.Duration
-	Value time.Duration
-	Count int64
+	Name          string
+	Min           time.Duration
+	Max           time.Duration
+	Value         time.Duration
+	Count         int64
+	Samples       []time.Duration
+	ReservoirSize int
 }
 
 // NewPrecisionTiming is a factory for a Timing event, setting the Count to 1 to prevent div_by_0 errors
 func NewPrecisionTiming(k string, delta time.Duration) *PrecisionTiming {
-	return &PrecisionTiming{Name: k, Min: delta, Max: delta, Value: delta, Count: 1}
+	return &PrecisionTiming{Name: k, Min: delta, Max: delta, Value: delta, Count: 1, Samples: []time.Duration{delta}}
 }
 
 // Update the event with metrics coming from a new one of the same type and with the same key
@@ -25,10 +30,11 @@ func (e *PrecisionTiming) Update(e2 Event) error {
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
 	p := e2.Payload().(PrecisionTiming)
+	e.Samples = sampleDuration(e.Samples, e.ReservoirSize, e.Count, p.Samples...)
 	e.Count += p.Count
 	e.Value += p.Value
 	e.Min = time.Duration(minInt64(int64(e.Min), int64(p.Min)))
-	e.Max = time.Duration(maxInt64(int64(e.Max), int64(p.Min)))
+	e.Max = time.Duration(maxInt64(int64(e.Max), int64(p.Max)))
 	return nil
 }
 
@@ -37,15 +43,55 @@ func (e PrecisionTiming) Payload() interface{} {
 	return e
 }
 
-// Stats returns an array of StatsD events as they travel over UDP
+// Percentile returns an estimate of the given percentile (0 < p <= 100) of the timings
+func (e PrecisionTiming) Percentile(p float64) time.Duration {
+	return percentileDuration(e.Samples, p)
+}
+
+// Stats returns an array of StatsD events as they travel over UDP.
+// Durations are sent as fractional milliseconds, computed from the full-precision values
 func (e PrecisionTiming) Stats() []string {
 	return []string{
-		fmt.Sprintf("%s.avg:%.6f|a", e.Name, float64(int64(e.Value)/e.Count)), // make sure e.Count != 0
-		fmt.Sprintf("%s.min:%.6f|a", e.Name, e.Min),
-		fmt.Sprintf("%s.max:%.6f|a", e.Name, e.Max),
+		fmt.Sprintf("%s.avg:%s|a", e.Name, FormatFloat(float64(e.Value)/float64(e.Count)/float64(time.Millisecond))), // make sure e.Count != 0
+		fmt.Sprintf("%s.min:%s|a", e.Name, Milliseconds(e.Min)),
+		fmt.Sprintf("%s.max:%s|a", e.Name, Milliseconds(e.Max)),
 	}
 }
 
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e PrecisionTiming) AppendStats(buf []byte, prefix string) []byte {
+	return e.AppendStatsPrecision(buf, prefix, DefaultFloatPrecision)
+}
+
+// AppendStatsPrecision appends the lines of Stats to buf with at most precision
+// decimal digits, see PrecisionAppender
+func (e PrecisionTiming) AppendStatsPrecision(buf []byte, prefix string, precision int) []byte {
+	ms := float64(time.Millisecond)
+	buf = appendFloatAggregate(buf, prefix, e.Name, "avg", float64(e.Value)/float64(e.Count)/ms, precision)
+	buf = appendFloatAggregate(buf, prefix, e.Name, "min", float64(e.Min)/ms, precision)
+	return appendFloatAggregate(buf, prefix, e.Name, "max", float64(e.Max)/ms, precision)
+}
+
+// Milliseconds renders a duration as fractional milliseconds, e.g. 314µs as 0.314
+func Milliseconds(d time.Duration) string {
+	return FormatFloat(float64(d) / float64(time.Millisecond))
+}
+
+// Resize sets the ReservoirSize, dropping random samples beyond it, see Resizer
+func (e *PrecisionTiming) Resize(size int) {
+	e.ReservoirSize = size
+	if n := reservoirSize(size); len(e.Samples) > n {
+		rand.Shuffle(len(e.Samples), func(i, j int) { e.Samples[i], e.Samples[j] = e.Samples[j], e.Samples[i] })
+		e.Samples = e.Samples[:n]
+	}
+}
+
+// Copy returns a deep copy of the event, see Copier
+func (e PrecisionTiming) Copy() Event {
+	e.Samples = append([]time.Duration(nil), e.Samples...)
+	return &e
+}
+
 // Key returns the name of this metric
 func (e PrecisionTiming) Key() string {
 	return e.Name
@@ -68,5 +114,5 @@ func (e PrecisionTiming) TypeString() string {
 
 // String returns a debug-friendly representation of this metric
 func (e PrecisionTiming) String() string {
-	return fmt.Sprintf("{Type: %s, Key: %s, Value: %+v}", e.TypeString(), e.Name, e.Payload())
+	return fmt.Sprintf("{Type: %s, Key: %s, Value: {Min: %s, Max: %s, Value: %s, Count: %d}}", e.TypeString(), e.Name, e.Min, e.Max, e.Value, e.Count)
 }
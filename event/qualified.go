@@ -0,0 +1,24 @@
+package event
+
+// Qualified wraps an event whose key is already a complete metric name, e.g.
+// received from another system, so that the clients send it as is instead of
+// prepending their prefix, see PreQualified. The key of the wrapped event
+// isn't modified to mark it
+type Qualified struct {
+	Event
+}
+
+// PreQualified marks the key of e as a complete metric name
+func PreQualified(e Event) *Qualified {
+	return &Qualified{Event: e}
+}
+
+// Copy returns a deep copy of the event, still marked as qualified, or nil if
+// the wrapped event isn't a Copier
+func (q *Qualified) Copy() Event {
+	c := Copy(q.Event)
+	if c == nil {
+		return nil
+	}
+	return PreQualified(c)
+}
@@ -0,0 +1,111 @@
+package event
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// DefaultReservoirSize is the number of samples retained by timing events
+// when their ReservoirSize is not set
+const DefaultReservoirSize = 1000
+
+// Resizer is implemented by the timing events, whose reservoir can be resized
+// once they're created
+type Resizer interface {
+	// Resize sets the ReservoirSize, dropping random samples beyond it
+	Resize(size int)
+}
+
+func reservoirSize(size int) int {
+	if size <= 0 {
+		return DefaultReservoirSize
+	}
+	return size
+}
+
+// sampleInt64 adds the samples to the reservoir (Algorithm R), seen being
+// the number of samples observed so far, including the ones in the reservoir
+func sampleInt64(reservoir []int64, size int, seen int64, samples ...int64) []int64 {
+	size = reservoirSize(size)
+	for _, v := range samples {
+		if len(reservoir) < size {
+			reservoir = append(reservoir, v)
+		} else if j := rand.Int63n(seen + 1); j < int64(size) {
+			reservoir[j] = v
+		}
+		seen++
+	}
+	return reservoir
+}
+
+// sampleDuration adds the samples to the reservoir (Algorithm R), seen being
+// the number of samples observed so far, including the ones in the reservoir
+func sampleDuration(reservoir []time.Duration, size int, seen int64, samples ...time.Duration) []time.Duration {
+	size = reservoirSize(size)
+	for _, v := range samples {
+		if len(reservoir) < size {
+			reservoir = append(reservoir, v)
+		} else if j := rand.Int63n(seen + 1); j < int64(size) {
+			reservoir[j] = v
+		}
+		seen++
+	}
+	return reservoir
+}
+
+// sampleFloat64 adds the samples to the reservoir (Algorithm R), seen being
+// the number of samples observed so far, including the ones in the reservoir
+func sampleFloat64(reservoir []float64, size int, seen int64, samples ...float64) []float64 {
+	size = reservoirSize(size)
+	for _, v := range samples {
+		if len(reservoir) < size {
+			reservoir = append(reservoir, v)
+		} else if j := rand.Int63n(seen + 1); j < int64(size) {
+			reservoir[j] = v
+		}
+		seen++
+	}
+	return reservoir
+}
+
+// percentileInt64 returns the nearest-rank percentile (0 < p <= 100) of the samples
+func percentileInt64(samples []int64, p float64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[rank(len(sorted), p)]
+}
+
+// percentileDuration returns the nearest-rank percentile (0 < p <= 100) of the samples
+func percentileDuration(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[rank(len(sorted), p)]
+}
+
+// percentileFloat64 returns the nearest-rank percentile (0 < p <= 100) of the samples
+func percentileFloat64(samples []float64, p float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	return sorted[rank(len(sorted), p)]
+}
+
+func rank(n int, p float64) int {
+	r := int(p/100*float64(n)+0.5) - 1
+	if r < 0 {
+		return 0
+	}
+	if r >= n {
+		return n - 1
+	}
+	return r
+}
@@ -0,0 +1,96 @@
+package event
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Set counts the unique values seen for a key: every distinct member is sent
+// once per flush, and the StatsD server counts them
+type Set struct {
+	Name    string
+	Members map[string]struct{}
+}
+
+// NewSet creates a set with a single member
+func NewSet(name string, member string) *Set {
+	return &Set{Name: name, Members: map[string]struct{}{member: {}}}
+}
+
+// Update the event with metrics coming from a new one of the same type and with the same key
+func (e *Set) Update(e2 Event) error {
+	if e.Type() != e2.Type() {
+		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
+	}
+	for m := range e2.Payload().(map[string]struct{}) {
+		e.Members[m] = struct{}{}
+	}
+	return nil
+}
+
+// Payload returns the aggregated value for this event
+func (e Set) Payload() interface{} {
+	return e.Members
+}
+
+// members returns the members in a stable order
+func (e Set) members() []string {
+	ret := make([]string, 0, len(e.Members))
+	for m := range e.Members {
+		ret = append(ret, m)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Stats returns an array of StatsD events as they travel over UDP
+func (e Set) Stats() []string {
+	ret := make([]string, 0, len(e.Members))
+	for _, m := range e.members() {
+		ret = append(ret, fmt.Sprintf("%s:%s|s", e.Name, m))
+	}
+	return ret
+}
+
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e Set) AppendStats(buf []byte, prefix string) []byte {
+	for _, m := range e.members() {
+		buf = append(append(appendName(buf, prefix, e.Name), m...), "|s\n"...)
+	}
+	return buf
+}
+
+// Copy returns a deep copy of the event, see Copier
+func (e Set) Copy() Event {
+	members := make(map[string]struct{}, len(e.Members))
+	for m := range e.Members {
+		members[m] = struct{}{}
+	}
+	e.Members = members
+	return &e
+}
+
+// Key returns the name of this metric
+func (e Set) Key() string {
+	return e.Name
+}
+
+// SetKey sets the name of this metric
+func (e *Set) SetKey(key string) {
+	e.Name = key
+}
+
+// Type returns an integer identifier for this type of metric
+func (e Set) Type() int {
+	return EventSet
+}
+
+// TypeString returns a name for this type of metric
+func (e Set) TypeString() string {
+	return "Set"
+}
+
+// String returns a debug-friendly representation of this metric
+func (e Set) String() string {
+	return fmt.Sprintf("{Type: %s, Key: %s, Members: %v}", e.TypeString(), e.Name, e.members())
+}
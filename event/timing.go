@@ -1,19 +1,26 @@
 package event
 
-import "fmt"
+import (
+	"fmt"
+	"math/rand"
+)
 
-// Timing keeps min/max/avg information about a timer over a certain interval
+// Timing keeps min/max/avg information about a timer over a certain interval.
+// Min, Max, Value (the sum) and Count are exact, while percentiles are estimated
+// from a reservoir of at most ReservoirSize samples (DefaultReservoirSize if 0)
 type Timing struct {
-	Name  string
-	Min   int64
-	Max   int64
-	Value int64
-	Count int64
+	Name          string
+	Min           int64
+	Max           int64
+	Value         int64
+	Count         int64
+	Samples       []int64
+	ReservoirSize int
 }
 
 // NewTiming is a factory for a Timing event, setting the Count to 1 to prevent div_by_0 errors
 func NewTiming(k string, delta int64) *Timing {
-	return &Timing{Name: k, Min: delta, Max: delta, Value: delta, Count: 1}
+	return &Timing{Name: k, Min: delta, Max: delta, Value: delta, Count: 1, Samples: []int64{delta}}
 }
 
 // Update the event with metrics coming from a new one of the same type and with the same key
@@ -22,6 +29,9 @@ func (e *Timing) Update(e2 Event) error {
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
 	p := e2.Payload().(map[string]int64)
+	if t, ok := e2.(*Timing); ok {
+		e.Samples = sampleInt64(e.Samples, e.ReservoirSize, e.Count, t.Samples...)
+	}
 	e.Count += p["cnt"]
 	e.Value += p["val"]
 	e.Min = minInt64(e.Min, p["min"])
@@ -39,6 +49,11 @@ func (e Timing) Payload() interface{} {
 	}
 }
 
+// Percentile returns an estimate of the given percentile (0 < p <= 100) of the timings
+func (e Timing) Percentile(p float64) int64 {
+	return percentileInt64(e.Samples, p)
+}
+
 // Stats returns an array of StatsD events as they travel over UDP
 func (e Timing) Stats() []string {
 	return []string{
@@ -48,6 +63,29 @@ func (e Timing) Stats() []string {
 	}
 }
 
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e Timing) AppendStats(buf []byte, prefix string) []byte {
+	buf = appendIntAggregate(buf, prefix, e.Name, "avg", e.Value/e.Count)
+	buf = appendIntAggregate(buf, prefix, e.Name, "min", e.Min)
+	return appendIntAggregate(buf, prefix, e.Name, "max", e.Max)
+}
+
+// Resize sets the ReservoirSize, dropping random samples beyond it, see Resizer
+func (e *Timing) Resize(size int) {
+	e.ReservoirSize = size
+	if n := reservoirSize(size); len(e.Samples) > n {
+		// a random subset of the reservoir is a random subset of the samples
+		rand.Shuffle(len(e.Samples), func(i, j int) { e.Samples[i], e.Samples[j] = e.Samples[j], e.Samples[i] })
+		e.Samples = e.Samples[:n]
+	}
+}
+
+// Copy returns a deep copy of the event, see Copier
+func (e Timing) Copy() Event {
+	e.Samples = append([]int64(nil), e.Samples...)
+	return &e
+}
+
 // Key returns the name of this metric
 func (e Timing) Key() string {
 	return e.Name
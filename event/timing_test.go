@@ -0,0 +1,96 @@
+package event
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTimingPercentileEstimate(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	e := NewTiming("t", r.Int63n(10000))
+	pe := NewPrecisionTiming("pt", time.Duration(r.Int63n(10000)))
+	for i := 0; i < 100000; i++ {
+		e.Update(NewTiming("t", r.Int63n(10000)))
+		pe.Update(NewPrecisionTiming("pt", time.Duration(r.Int63n(10000))))
+	}
+	if len(e.Samples) != DefaultReservoirSize || len(pe.Samples) != DefaultReservoirSize {
+		t.Fatalf("expected %d samples, actual %d and %d", DefaultReservoirSize, len(e.Samples), len(pe.Samples))
+	}
+	// uniform distribution over [0, 10000): p95 is 9500
+	if p95 := e.Percentile(95); p95 < 9200 || p95 > 9800 {
+		t.Errorf("Timing p95 estimate out of tolerance: %d", p95)
+	}
+	if p95 := pe.Percentile(95); p95 < 9200 || p95 > 9800 {
+		t.Errorf("PrecisionTiming p95 estimate out of tolerance: %d", p95)
+	}
+	if e.Count != 100001 || pe.Count != 100001 {
+		t.Errorf("counts must be exact: %d %d", e.Count, pe.Count)
+	}
+}
+
+func TestTimingReservoirBounded(t *testing.T) {
+	n := 10000000
+	if testing.Short() {
+		n = 100000
+	}
+	e := NewPrecisionTiming("pt", time.Millisecond)
+	e.ReservoirSize = 100
+	for i := 1; i < n; i++ {
+		e.Update(NewPrecisionTiming("pt", time.Duration(i)))
+	}
+	if len(e.Samples) != 100 || cap(e.Samples) > 200 {
+		t.Errorf("reservoir grew to %d samples (cap %d)", len(e.Samples), cap(e.Samples))
+	}
+	if e.Count != int64(n) || e.Min != 1 || e.Max != time.Duration(n-1) {
+		t.Errorf("aggregates must be exact: %s", e)
+	}
+}
+
+func TestTimingResize(t *testing.T) {
+	e := NewTiming("t", 0)
+	for i := int64(1); i < 100; i++ {
+		e.Update(NewTiming("t", i))
+	}
+	var resizer Resizer = e
+	resizer.Resize(10)
+	if e.ReservoirSize != 10 || len(e.Samples) != 10 {
+		t.Fatalf("expected 10 samples, actual %d (size %d)", len(e.Samples), e.ReservoirSize)
+	}
+	e.Update(NewTiming("t", 100))
+	if len(e.Samples) != 10 || e.Count != 101 {
+		t.Errorf("reservoir grew to %d samples after the resize (count %d)", len(e.Samples), e.Count)
+	}
+	// growing keeps the samples
+	resizer.Resize(0)
+	if len(e.Samples) != 10 {
+		t.Errorf("expected the samples to be kept, actual %d", len(e.Samples))
+	}
+	for _, r := range []Resizer{NewPrecisionTiming("pt", 1), NewFTiming("ft", 1)} {
+		r.Resize(1)
+	}
+}
+
+func TestFTiming(t *testing.T) {
+	e := NewFTiming("ft", 3.275)
+	for _, ms := range []float64{0.001, 12.5, 0.25} {
+		if err := e.Update(NewFTiming("ft", ms)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Update(NewTiming("ft", 1)); err == nil {
+		t.Error("expected a type conflict with Timing")
+	}
+	expected := []string{"ft.avg:4.0065|a", "ft.min:0.001|a", "ft.max:12.5|a"}
+	for i, s := range e.Stats() {
+		if s != expected[i] {
+			t.Errorf("expected %q, actual %q", expected[i], s)
+		}
+	}
+	if p := e.Percentile(50); p != 0.25 {
+		t.Errorf("expected a median of 0.25, actual %v", p)
+	}
+	if e.Count != 4 {
+		t.Errorf("expected a count of 4, actual %d", e.Count)
+	}
+}
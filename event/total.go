@@ -8,12 +8,13 @@ type Total struct {
 	Value int64
 }
 
-// Update the event with metrics coming from a new one of the same type and with the same key
+// Update the event with metrics coming from a new one of the same type and with the same key.
+// A total is absolute, so the latest value replaces the previous one
 func (e *Total) Update(e2 Event) error {
 	if e.Type() != e2.Type() {
 		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
 	}
-	e.Value += e2.Payload().(int64)
+	e.Value = e2.Payload().(int64)
 	return nil
 }
 
@@ -27,6 +28,16 @@ func (e Total) Stats() []string {
 	return []string{fmt.Sprintf("%s:%d|t", e.Name, e.Value)}
 }
 
+// AppendStats appends the lines of Stats to buf, see Appender
+func (e Total) AppendStats(buf []byte, prefix string) []byte {
+	return appendInt(buf, prefix, e.Name, e.Value, "|t")
+}
+
+// Copy returns a copy of the event, see Copier
+func (e Total) Copy() Event {
+	return &e
+}
+
 // Key returns the name of this metric
 func (e Total) Key() string {
 	return e.Name
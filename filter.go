@@ -0,0 +1,136 @@
+package statsd
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// MetricKind identifies a family of metric types
+type MetricKind int
+
+// metric kinds, as seen by filters
+const (
+	KindCounter MetricKind = iota
+	KindTiming
+	KindGauge
+	KindAbsolute
+	KindTotal
+	KindSet
+	numKinds
+)
+
+// FilterFunc tells whether a metric of the given kind and (unprefixed) name must be sent
+type FilterFunc func(kind MetricKind, stat string) bool
+
+// metricFilter is an immutable set of filtering rules, swapped atomically
+type metricFilter struct {
+	fn       FilterFunc
+	disabled [numKinds]bool
+	denied   []string
+}
+
+func (f *metricFilter) allows(kind MetricKind, stat string) bool {
+	if kind >= 0 && kind < numKinds && f.disabled[kind] {
+		return false
+	}
+	for _, prefix := range f.denied {
+		if strings.HasPrefix(stat, prefix) {
+			return false
+		}
+	}
+	return f.fn == nil || f.fn(kind, stat)
+}
+
+// kindOf maps an event type to its metric kind
+func kindOf(e event.Event) MetricKind {
+	switch e.Type() {
+	case event.EventIncr:
+		return KindCounter
+	case event.EventTiming, event.EventPrecisionTiming, event.EventFTiming:
+		return KindTiming
+	case event.EventGauge, event.EventGaugeDelta, event.EventFGauge, event.EventFGaugeDelta,
+		event.EventGaugeMax, event.EventGaugeMin:
+		return KindGauge
+	case event.EventAbsolute, event.EventFAbsolute:
+		return KindAbsolute
+	case event.EventTotal:
+		return KindTotal
+	case event.EventSet:
+		return KindSet
+	}
+	return -1
+}
+
+// allowed consults the filter and the enable switch (see SetEnabledFunc)
+// before a metric is formatted (or buffered), counting the metrics which are
+// deliberately dropped
+func (c *StatsdClient) allowed(kind MetricKind, stat string) bool {
+	f, _ := c.filter.Load().(*metricFilter)
+	if f != nil && !f.allows(kind, stat) {
+		atomic.AddInt64(&c.filtered, 1)
+		c.count(kind, outcomeDropped, 1)
+		return false
+	}
+	if !c.isEnabled(stat) {
+		c.suppress(kind)
+		return false
+	}
+	return true
+}
+
+// updateFilter atomically replaces the filter with a modified copy
+func (c *StatsdClient) updateFilter(update func(f *metricFilter)) {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	f := &metricFilter{}
+	if old, _ := c.filter.Load().(*metricFilter); old != nil {
+		*f = *old
+		f.denied = append([]string(nil), old.denied...)
+	}
+	update(f)
+	c.filter.Store(f)
+}
+
+// SetFilter installs a function deciding, before formatting, whether each metric
+// is sent: metrics for which it returns false are dropped and counted in Stats().
+// It can be changed at any time, also while other goroutines are sending; nil removes it
+func (c *StatsdClient) SetFilter(fn FilterFunc) {
+	c.updateFilter(func(f *metricFilter) { f.fn = fn })
+}
+
+// DisableKind drops all the metrics of the given kind, e.g. to silence chatty
+// timings. An unknown kind is ignored
+func (c *StatsdClient) DisableKind(kind MetricKind) {
+	if kind < 0 || kind >= numKinds {
+		return
+	}
+	c.updateFilter(func(f *metricFilter) { f.disabled[kind] = true })
+}
+
+// EnableKind enables again the metrics of a kind disabled with DisableKind
+func (c *StatsdClient) EnableKind(kind MetricKind) {
+	if kind < 0 || kind >= numKinds {
+		return
+	}
+	c.updateFilter(func(f *metricFilter) { f.disabled[kind] = false })
+}
+
+// DenyPrefix drops all the metrics whose name (without the client prefix) starts with prefix
+func (c *StatsdClient) DenyPrefix(prefix string) {
+	c.updateFilter(func(f *metricFilter) { f.denied = append(f.denied, prefix) })
+}
+
+// AllowPrefix removes a prefix previously denied with DenyPrefix
+func (c *StatsdClient) AllowPrefix(prefix string) {
+	c.updateFilter(func(f *metricFilter) {
+		denied := f.denied[:0]
+		for _, p := range f.denied {
+			if p != prefix {
+				denied = append(denied, p)
+			}
+		}
+		f.denied = denied
+	})
+}
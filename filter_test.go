@@ -0,0 +1,85 @@
+package statsd
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	buffered := NewStatsdBuffer(time.Hour, client)
+
+	client.DisableKind(KindTiming)
+	// the unknown kinds are ignored
+	client.DisableKind(-1)
+	client.EnableKind(numKinds)
+	client.DenyPrefix("debug.")
+	client.SetFilter(func(kind MetricKind, stat string) bool { return !strings.HasSuffix(stat, ".secret") })
+
+	client.Timing("timing", 1)
+	client.PrecisionTiming("timing", time.Millisecond)
+	buffered.Timing("buffered.timing", 1)
+	client.Incr("debug.counter", 1)
+	client.Gauge("a.secret", 1)
+	client.Incr("counter", 1)
+	buffered.Incr("buffered.counter", 1)
+	buffered.Close()
+
+	if _, err := srv.WaitFor("myproject.buffered.counter", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range srv.Metrics() {
+		if m.Name != "myproject.counter" && m.Name != "myproject.buffered.counter" {
+			t.Errorf("unexpected metric %s", m.Raw)
+		}
+	}
+	if filtered := client.Stats().Filtered; filtered != 5 {
+		t.Errorf("expected 5 filtered metrics, actual %d", filtered)
+	}
+}
+
+func TestFilterToggledMidStream(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				client.Timing("timing", 1)
+				client.Incr("counter", 1)
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		client.DisableKind(KindTiming)
+		client.DenyPrefix("x.")
+		client.EnableKind(KindTiming)
+		client.AllowPrefix("x.")
+	}
+	wg.Wait()
+
+	client.DisableKind(KindTiming)
+	before := client.Stats().Filtered
+	client.Timing("timing", 1)
+	if client.Stats().Filtered != before+1 {
+		t.Error("timing not filtered after DisableKind")
+	}
+}
@@ -0,0 +1,174 @@
+package statsd
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// DefaultMaxRetainedIntervals is the default number of intervals in a row the
+// events of failed flushes are retained for, see SetMaxRetainedIntervals
+const DefaultMaxRetainedIntervals = 5
+
+// FlushError is passed to the error handler of the buffered client when a
+// flush fails, see SetErrorHandler
+type FlushError struct {
+	Events int // aggregated events which couldn't be sent
+	// Retained is true if the events are kept, merged into the next interval,
+	// false if they were dropped
+	Retained bool
+	// of the events retained, the ones dropped anyway since their lines are
+	// unknown: the stats derived from the aggregated events (rates,
+	// histograms, estimates) in Graphite mode or through NewBufferedStatter
+	Unretained int
+	Err        error // the first error, a *MapError when the write of some events failed
+}
+
+func (e *FlushError) Error() string {
+	action := "dropped"
+	if e.Retained {
+		action = "retained"
+	}
+	return fmt.Sprintf("statsd: flush failed, %d events %s: %v", e.Events, action, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *FlushError) Unwrap() error {
+	return e.Err
+}
+
+// SetErrorHandler sets a function called with a *FlushError whenever a flush of
+// the buffered client fails, with an *OverflowError when a counter saturates,
+// with the error of every failed attempt of ConnectInBackground, and the first
+// time a call is dropped because the output mode doesn't support it (see
+// Supports), besides logging the error. It may be called from the collector
+// goroutine, so it must not block nor call Close
+func (sb *buffer) SetErrorHandler(handler func(error)) {
+	sb.errorHandler.Store(handler)
+	sb.errorChannel.Store((*errorChannel)(nil))
+}
+
+// handleError passes an error to the error handler, if any
+func (sb *buffer) handleError(err error) {
+	if handler, _ := sb.errorHandler.Load().(func(error)); handler != nil {
+		handler(err)
+	}
+}
+
+// SetMaxRetainedIntervals sets for how many intervals in a row the events of a
+// failed flush are retained and merged into the next interval (counters sum,
+// timings merge, gauges take the newer value) instead of being dropped, to ride
+// out short outages in bounded memory: DefaultMaxRetainedIntervals by default,
+// 0 drops them right away. The events of which only some lines were delivered
+// (e.g. an absolute split across packets), and the stats derived from the
+// aggregated ones (rates, histograms, estimates), can't be merged without
+// sending the lines delivered again: their lines lost are sent as is, first, by
+// the next flush. The intervals carried are reported in Stats()
+func (sb *buffer) SetMaxRetainedIntervals(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&sb.maxRetained, int32(n))
+}
+
+// failedKey is a key of which some lines couldn't be sent by a flush
+type failedKey struct {
+	lost    [][]byte // the groups of lines lost, nil if unknown
+	partial bool     // whether some of its lines were delivered
+}
+
+// failedKeys returns the keys which failed according to err: the keys of a
+// MapError, with their lines lost if known, or else all the keys of events
+func failedKeys(err error, events []event.Event, known bool) map[string]failedKey {
+	failed := make(map[string]failedKey)
+	if err == nil {
+		return failed
+	}
+	mapErr, ok := err.(*MapError)
+	if !ok {
+		for _, e := range events {
+			failed[e.Key()] = failedKey{}
+		}
+		return failed
+	}
+	for k := range mapErr.Errors {
+		if known {
+			failed[k] = failedKey{lost: mapErr.lost[k], partial: mapErr.partial[k]}
+		} else {
+			failed[k] = failedKey{}
+		}
+	}
+	return failed
+}
+
+// retainedLines are the groups of lines of a key lost by a flush, sent as is
+// by the next one, see retain
+type retainedLines struct {
+	key    string
+	groups [][]byte
+}
+
+// retain merges the aggregated events of the job which failed whole back into
+// the pending ones, and keeps the lines lost of the others for the next flush:
+// the events partly delivered, and the ones derived from the aggregated events,
+// unless they're derived again from an event retained (the rates of a counter)
+// or sent every flush anyway (the contributions). It returns the number of
+// events dropped since their lines are unknown. It's only called from within
+// the collector
+func (sb *buffer) retain(job *flushJob) (unretained int) {
+	sent := make(map[string]event.Event, len(job.failed))
+	for _, e := range job.events {
+		if _, ok := job.failed[e.Key()]; ok {
+			sent[e.Key()] = e
+		}
+	}
+	// the keys in detached of the events retained whole
+	whole := make(map[string]bool, len(job.failed))
+	for key, f := range job.failed {
+		e := sent[key]
+		if k, ok := job.renamed[e]; ok {
+			key = k
+		}
+		if e != nil && !f.partial && (job.detached[key] == e || job.standIns[e]) {
+			whole[key] = true
+		}
+	}
+	for key, f := range job.failed {
+		e := sent[key]
+		k := key
+		if renamed, ok := job.renamed[e]; ok {
+			k = renamed
+		}
+		if source, ok := job.derived[key]; whole[k] || (ok && whole[source]) || job.persistent[e] {
+			continue
+		}
+		if f.lost == nil {
+			unretained++
+			continue
+		}
+		sb.retainedLines = append(sb.retainedLines, retainedLines{key: key, groups: f.lost})
+	}
+	for key, f := range job.failedRetained {
+		sb.retainedLines = append(sb.retainedLines, retainedLines{key: key, groups: f.lost})
+	}
+	if unretained > 0 {
+		sb.Logger.Println("Dropping", unretained, "derived stats of the failed flush, their lines can't be retained")
+	}
+
+	for key := range whole {
+		e := job.detached[key]
+		if k, ok := job.renamed[e]; ok {
+			e.SetKey(k)
+		}
+		// the events received meanwhile are newer
+		if e2, ok := sb.events[key]; ok {
+			if overridesGauge(e, e2) {
+				continue
+			}
+			sb.merge(key, e, e2)
+		}
+		sb.events[key] = e
+	}
+	return unretained
+}
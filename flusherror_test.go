@@ -0,0 +1,231 @@
+package statsd
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestFlushErrorHandler(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	var connected int32
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		if atomic.LoadInt32(&connected) == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	defer buffered.Close()
+	buffered.Logger = discardLogger{}
+	buffered.SetMaxRetainedIntervals(1)
+	errs := make(chan error, 10)
+	buffered.SetErrorHandler(func(err error) { errs <- err })
+
+	next := func() *FlushError {
+		t.Helper()
+		select {
+		case err := <-errs:
+			flushErr, ok := err.(*FlushError)
+			if !ok {
+				t.Fatalf("expected a *FlushError, actual %T %v", err, err)
+			}
+			return flushErr
+		case <-time.After(time.Second):
+			t.Fatal("no error reported")
+		}
+		return nil
+	}
+
+	// not connected: the events are kept for the next flush
+	buffered.Incr("a", 1)
+	buffered.Gauge("b", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 2 })
+	clock.Advance(time.Second)
+	if err := next(); err.Events != 2 || !err.Retained || !errors.Is(err, errNotConnected) {
+		t.Errorf("unexpected error %+v", err)
+	}
+	if pending := buffered.Stats().Pending; pending != 2 {
+		t.Errorf("expected 2 retained events, actual %d", pending)
+	}
+
+	// the writes fail again: the events are dropped
+	atomic.StoreInt32(&connected, 1)
+	buffered.Incr("a", 1)
+	clock.Advance(time.Second)
+	err := next()
+	var mapErr *MapError
+	if err.Events != 2 || err.Retained || !errors.As(err, &mapErr) || mapErr.Errors["myproject.a"] == nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+	if pending := buffered.Stats().Pending; pending != 0 {
+		t.Errorf("expected the events to be dropped, %d pending", pending)
+	}
+
+	// the flush succeeds, nothing is reported
+	conn.mu.Lock()
+	conn.until = time.Now()
+	conn.mu.Unlock()
+	buffered.Incr("c", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.lines()) == 1 })
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected error %v", err)
+	default:
+	}
+}
+
+func TestRetainFailedFlushes(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	defer buffered.Close()
+	buffered.Logger = discardLogger{}
+	flushes := make(chan error, 10)
+	buffered.SetErrorHandler(func(err error) { flushes <- err })
+
+	for i := int64(1); i <= 2; i++ {
+		buffered.Incr("a", i)
+		buffered.Gauge("g", i)
+		waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 2 })
+		clock.Advance(time.Second)
+		if err := <-flushes; !err.(*FlushError).Retained {
+			t.Fatalf("interval %d: events dropped: %v", i, err)
+		}
+		if carried := buffered.Stats().CarriedIntervals; carried != i {
+			t.Errorf("expected %d intervals carried, actual %d", i, carried)
+		}
+	}
+
+	conn.mu.Lock()
+	conn.until = time.Now()
+	conn.mu.Unlock()
+	buffered.Incr("a", 3)
+	buffered.Gauge("g", 3)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 2 })
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.lines()) == 2 })
+	if expected, lines := []string{"myproject.a:6|c", "myproject.g:3|g"}, conn.lines(); !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+	if carried := buffered.Stats().CarriedIntervals; carried != 0 {
+		t.Errorf("expected no intervals carried, actual %d", carried)
+	}
+}
+
+func TestRetainedIntervalsBound(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	defer buffered.Close()
+	buffered.Logger = discardLogger{}
+	buffered.SetMaxRetainedIntervals(2)
+	flushes := make(chan error, 10)
+	buffered.SetErrorHandler(func(err error) { flushes <- err })
+
+	for i, expected := range []bool{true, true, false, true} {
+		buffered.Incr("a", 1)
+		waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+		clock.Advance(time.Second)
+		if err := (<-flushes).(*FlushError); err.Retained != expected {
+			t.Errorf("flush %d: expected retained %v, actual %v", i, expected, err)
+		}
+	}
+}
+
+// newLossyBuffer returns a buffered client on the fake clock writing to a
+// connection which fails once the packet with the line lost, and a function
+// advancing the clock by an interval which returns the lines delivered so far
+func newLossyBuffer(t *testing.T, lost string) (*StatsdBuffer, func() []string) {
+	conn := &lossyConn{mtuConn: mtuConn{mtu: 1 << 16}, lost: lost}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	client.SetMaxPacketSize(40)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	t.Cleanup(func() { buffered.Close() })
+	reports := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+	flush := func() []string {
+		t.Helper()
+		clock.Advance(time.Second)
+		select {
+		case <-reports:
+		case <-time.After(time.Second):
+			t.Fatal("no flush")
+		}
+		var lines []string
+		for _, packet := range conn.sent() {
+			lines = append(lines, strings.Split(packet, "\n")...)
+		}
+		sort.Strings(lines)
+		return lines
+	}
+	return buffered, flush
+}
+
+// only the lines lost of an event split across packets are sent again
+func TestRetainPartialEvent(t *testing.T) {
+	buffered, flush := newLossyBuffer(t, "myproject.a:4|a")
+	for i := 1; i <= 6; i++ {
+		buffered.Absolute("a", int64(i))
+	}
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	delivered := len(flush())
+	if delivered == 0 || delivered == 6 {
+		t.Fatalf("expected some of the lines delivered, actual %d", delivered)
+	}
+	expected := []string{"myproject.a:1|a", "myproject.a:2|a", "myproject.a:3|a", "myproject.a:4|a", "myproject.a:5|a", "myproject.a:6|a"}
+	if lines := flush(); !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+}
+
+// the stats derived from the aggregated events are retained as well
+func TestRetainDerivedEvents(t *testing.T) {
+	buffered, flush := newLossyBuffer(t, "myproject.latency.le_10ms:1|c")
+	buffered.SetHistogramBuckets("latency", []float64{10})
+	buffered.Timing("latency", 5)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	first := flush()
+	for _, line := range first {
+		if strings.Contains(line, ".le_10ms") {
+			t.Fatalf("unexpected bucket delivered: %q", first)
+		}
+	}
+	lines := flush()
+	if len(lines) != len(first)+1 {
+		t.Errorf("expected the bucket to be sent again alone, actual %q after %q", lines, first)
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == lines[i-1] {
+			t.Errorf("%q sent twice", lines[i])
+		}
+	}
+}
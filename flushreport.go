@@ -0,0 +1,53 @@
+package statsd
+
+import (
+	"bytes"
+	"time"
+)
+
+// FlushReport details a flush of the buffered client, see SetFlushObserver
+type FlushReport struct {
+	Time time.Time // of the flush, on the clock of the client
+	Keys int       // aggregated events flushed, derived ones included
+	// lines, packets and bytes written, the failed writes included
+	Lines   int
+	Packets int
+	Bytes   int
+	// time spent serializing the events and packing the lines, writing the
+	// packets to the socket, and waiting between them (see SetPacing)
+	Serialization time.Duration
+	Sending       time.Duration
+	Pacing        time.Duration
+	Duration      time.Duration // of the whole flush, run outside of the collector
+	Err           error         // the first error, nil if the flush succeeded
+	// how late the tick of the flush interval starting the flush came, see
+	// BufferStats.LastFlushSkew
+	Skew time.Duration
+}
+
+// written accounts for a packet written in d
+func (r *FlushReport) written(packet []byte, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.Packets++
+	r.Bytes += len(packet)
+	r.Lines += bytes.Count(bytes.TrimSuffix(packet, []byte("\n")), []byte("\n")) + 1
+	r.Sending += d
+}
+
+// SetFlushObserver sets a function called with a FlushReport after every flush
+// of the buffered client which had events to send, whether it succeeded or
+// not, e.g. for capacity planning. It's called from the collector goroutine
+// once the flush is complete: while it runs the events queue up and the next
+// flush waits, so it must be fast, and must not block nor call Close
+func (sb *buffer) SetFlushObserver(observer func(FlushReport)) {
+	sb.flushObserver.Store(observer)
+}
+
+// observeFlush passes the report of a flush to the observer, if any
+func (sb *buffer) observeFlush(report FlushReport) {
+	if observer, _ := sb.flushObserver.Load().(func(FlushReport)); observer != nil {
+		observer(report)
+	}
+}
@@ -0,0 +1,51 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestFlushObserver(t *testing.T) {
+	conn := &flakyConn{}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	client.SetMaxPacketSize(40)
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	defer buffered.Close()
+	buffered.Logger = discardLogger{}
+	buffered.SetMaxRetainedIntervals(0)
+	reports := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+
+	// 15 bytes per line: a and b share a packet, c doesn't fit in it
+	for _, stat := range []string{"a", "b", "c"} {
+		buffered.Incr(stat, 1)
+	}
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 3 })
+	clock.Advance(time.Second)
+	r := <-reports
+	if r.Keys != 3 || r.Lines != 3 || r.Packets != 2 || r.Bytes != 46 || r.Err != nil {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if !r.Time.Equal(clock.Now()) || r.Sending <= 0 || r.Serialization < 0 {
+		t.Errorf("unexpected times in %+v", r)
+	}
+
+	conn.mu.Lock()
+	conn.until = time.Now().Add(time.Hour)
+	conn.mu.Unlock()
+	buffered.Gauge("g", -1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	clock.Advance(time.Second)
+	r = <-reports
+	if r.Keys != 1 || r.Lines != 2 || r.Packets != 1 || r.Bytes != 32 || r.Err == nil {
+		t.Errorf("unexpected report of the failed flush %+v", r)
+	}
+}
@@ -0,0 +1,46 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// flushSkewName is the timing of the flush skew, see SetTelemetry
+const flushSkewName = "statsd.client.flush_skew_ms"
+
+// SetTelemetry makes the buffered client report on itself along with the
+// stats: at every tick of the flush interval, the time by which the tick came
+// late (see BufferStats.LastFlushSkew) is aggregated as the timing
+// statsd.client.flush_skew_ms, after the prefix. With TrackCardinality, the
+// number of distinct names is sent as the gauge statsd.client.unique_names
+func (sb *buffer) SetTelemetry(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&sb.telemetry, v)
+}
+
+// measureSkew records how late a tick of the flush ticker came, compared to
+// the flush interval: e.g. under CPU starvation, which stretches the intervals
+// (the rates use the time actually elapsed, see SetEmitRates). It's only
+// called from within the collector
+func (sb *buffer) measureSkew() {
+	now := sb.statsd.now()
+	skew := now.Sub(sb.lastTick) - sb.flushInterval
+	sb.lastTick = now
+	if skew < 0 {
+		// the tick following a late one comes early
+		skew = 0
+	}
+	sb.skew = skew
+	atomic.StoreInt64(&sb.lastSkew, int64(skew))
+	if int64(skew) > atomic.LoadInt64(&sb.maxSkew) {
+		atomic.StoreInt64(&sb.maxSkew, int64(skew))
+	}
+	if atomic.LoadInt32(&sb.telemetry) != 0 {
+		sb.add(event.NewFTiming(flushSkewName, float64(skew)/float64(time.Millisecond)))
+	}
+}
@@ -0,0 +1,66 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestFlushSkew(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+	buffered.SetEmitRates(true)
+	buffered.SetTelemetry(true)
+	reports := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+
+	expect := func(skew time.Duration, n int, ms, rate string) {
+		t.Helper()
+		r := <-reports
+		if r.Skew != skew {
+			t.Errorf("expected a skew of %s in the report, actual %s", skew, r.Skew)
+		}
+		metrics, err := srv.WaitFor("myproject."+flushSkewName+".max", n, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := metrics[n-1]; m.Type != "a" || m.Value != ms {
+			t.Errorf("unexpected skew metric %q", m.Raw)
+		}
+		metrics, err = srv.WaitFor("myproject.hits.rate", n, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metrics[n-1].Value != rate {
+			t.Errorf("expected a rate of %s, actual %s", rate, metrics[n-1].Value)
+		}
+	}
+
+	buffered.Incr("hits", 30)
+	clock.Advance(10 * time.Second)
+	expect(0, 1, "0", "3")
+
+	// the tick is delayed by 5s: the rate is computed over the 15s elapsed
+	buffered.Incr("hits", 30)
+	clock.Advance(15 * time.Second)
+	expect(5*time.Second, 2, "5000", "2")
+	if stats := buffered.Stats(); stats.LastFlushSkew != 5*time.Second || stats.MaxFlushSkew != 5*time.Second {
+		t.Errorf("unexpected skews %s and %s", stats.LastFlushSkew, stats.MaxFlushSkew)
+	}
+
+	// the next tick is on schedule, 5s later
+	buffered.Incr("hits", 10)
+	clock.Advance(5 * time.Second)
+	expect(0, 3, "0", "2")
+	if stats := buffered.Stats(); stats.LastFlushSkew != 0 || stats.MaxFlushSkew != 5*time.Second {
+		t.Errorf("unexpected skews %s and %s", stats.LastFlushSkew, stats.MaxFlushSkew)
+	}
+}
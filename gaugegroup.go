@@ -0,0 +1,85 @@
+package statsd
+
+import (
+	"sync"
+	"time"
+)
+
+// GaugeGroup keeps the current value of many gauges, updated from anywhere,
+// and sends them all together in as few packets as possible. Values persist
+// between flushes, until deleted. It is safe for concurrent use
+type GaugeGroup struct {
+	client   *StatsdClient
+	mu       sync.Mutex
+	values   map[string]int64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewGaugeGroup creates an empty group of gauges sent through the client
+func (c *StatsdClient) NewGaugeGroup() *GaugeGroup {
+	return &GaugeGroup{
+		client: c,
+		values: make(map[string]int64),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Set sets the value of a gauge
+func (g *GaugeGroup) Set(name string, value int64) {
+	g.mu.Lock()
+	g.values[name] = value
+	g.mu.Unlock()
+}
+
+// Add changes the value of a gauge by delta (a missing gauge starts from 0)
+func (g *GaugeGroup) Add(name string, delta int64) {
+	g.mu.Lock()
+	g.values[name] += delta
+	g.mu.Unlock()
+}
+
+// Delete removes a gauge from the group, it's not sent anymore
+func (g *GaugeGroup) Delete(name string) {
+	g.mu.Lock()
+	delete(g.values, name)
+	g.mu.Unlock()
+}
+
+// Flush sends the current value of all the gauges, packed like GaugeMap
+func (g *GaugeGroup) Flush() error {
+	g.mu.Lock()
+	values := make(map[string]int64, len(g.values))
+	for name, v := range g.values {
+		values[name] = v
+	}
+	g.mu.Unlock()
+	if len(values) == 0 {
+		return nil
+	}
+	return g.client.GaugeMap(values)
+}
+
+// FlushEvery starts flushing the group every interval, on the clock of the
+// client, until Stop is called. Errors are logged. It must be called at most once
+func (g *GaugeGroup) FlushEvery(interval time.Duration) {
+	tick, stop := g.client.newTicker(interval)
+	go func() {
+		defer stop()
+		for {
+			select {
+			case <-tick:
+				if err := g.Flush(); err != nil {
+					g.client.Logger.Println(err)
+				}
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic flushes started by FlushEvery
+func (g *GaugeGroup) Stop() {
+	g.stopOnce.Do(func() { close(g.stop) })
+}
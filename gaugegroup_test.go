@@ -0,0 +1,90 @@
+package statsd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestGaugeGroup(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	g := client.NewGaugeGroup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g.Set(fmt.Sprintf("pool.p%d", i), int64(i))
+			for j := 0; j < 100; j++ {
+				g.Add("cache.entries", 1)
+			}
+			g.Add("shard.lag", -1)
+		}(i)
+	}
+	wg.Wait()
+	g.Delete("pool.p0")
+	if err := g.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.packets) != 1 {
+		t.Fatalf("expected a single packed payload, actual %q", conn.packets)
+	}
+	lines := strings.Split(conn.packets[0], "\n")
+	// in the order of the names
+	pools := make([]int, 0, 19)
+	for i := 1; i < 20; i++ {
+		pools = append(pools, i)
+	}
+	sort.Slice(pools, func(i, j int) bool { return strconv.Itoa(pools[i]) < strconv.Itoa(pools[j]) })
+	expected := []string{"myproject.cache.entries:2000|g"}
+	for _, i := range pools {
+		expected = append(expected, fmt.Sprintf("myproject.pool.p%d:%d|g", i, i))
+	}
+	expected = append(expected, "myproject.shard.lag:0|g", "myproject.shard.lag:-20|g")
+	if !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+
+	// the values persist between flushes
+	conn.packets = nil
+	g.Flush()
+	if len(conn.packets) != 1 || strings.Count(conn.packets[0], "\n") != len(expected)-1 {
+		t.Errorf("values not persisted: %q", conn.packets)
+	}
+}
+
+func TestGaugeGroupFlushEvery(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	clock := statsdtest.NewFakeClock(time.Now())
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetClock(clock)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	g := client.NewGaugeGroup()
+	g.Set("pool.db", 42)
+	g.FlushEvery(time.Second)
+	defer g.Stop()
+
+	for i := 1; i <= 2; i++ {
+		clock.Advance(time.Second)
+		metrics, err := srv.WaitFor("myproject.pool.db", i, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metrics[i-1].Value != "42" {
+			t.Errorf("expected 42, actual %s", metrics[i-1].Value)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package statsd
+
+import "github.com/CrowdSurge/statsd/event"
+
+// suffixes of the names the extremes of a gauge are sent under
+const (
+	maxSuffix = ".max"
+	minSuffix = ".min"
+)
+
+// GaugeMax sends the value as the gauge stat.max. The direct client has no
+// interval to track the peak of, see StatsdBuffer.GaugeMax
+func (c *StatsdClient) GaugeMax(stat string, value int64) error {
+	return c.Gauge(stat+maxSuffix, value)
+}
+
+// GaugeMin sends the value as the gauge stat.min, see GaugeMax
+func (c *StatsdClient) GaugeMin(stat string, value int64) error {
+	return c.Gauge(stat+minSuffix, value)
+}
+
+// peakGauger is implemented by the clients which track the extremes of gauges
+type peakGauger interface {
+	GaugeMax(stat string, value int64) error
+	GaugeMin(stat string, value int64) error
+}
+
+// gaugeMax is the GaugeMax of s if it has one, or else a gauge stat.max like
+// the direct client
+func gaugeMax(s Statsd, stat string, value int64) error {
+	if g, ok := s.(peakGauger); ok {
+		return g.GaugeMax(stat, value)
+	}
+	return s.Gauge(stat+maxSuffix, value)
+}
+
+// gaugeMin is the GaugeMin of s if it has one, see gaugeMax
+func gaugeMin(s Statsd, stat string, value int64) error {
+	if g, ok := s.(peakGauger); ok {
+		return g.GaugeMin(stat, value)
+	}
+	return s.Gauge(stat+minSuffix, value)
+}
+
+// GaugeMax tracks the highest value of a fast-moving gauge (queue depth,
+// concurrent requests) within each interval, which sampling the last value
+// misses: it's flushed as the gauge stat.max, and reset after every flush
+func (sb *buffer) GaugeMax(stat string, value int64) error {
+	return sb.enqueue(newGaugeMax(stat, value))
+}
+
+// GaugeMin tracks the lowest value of a gauge within each interval, flushed as
+// the gauge stat.min, see GaugeMax
+func (sb *buffer) GaugeMin(stat string, value int64) error {
+	return sb.enqueue(newGaugeMin(stat, value))
+}
+
+// newGaugeMax and newGaugeMin create the events of GaugeMax and GaugeMin
+func newGaugeMax(stat string, value int64) *event.GaugeMax {
+	return &event.GaugeMax{Name: stat + maxSuffix, Value: value}
+}
+
+func newGaugeMin(stat string, value int64) *event.GaugeMin {
+	return &event.GaugeMin{Name: stat + minSuffix, Value: value}
+}
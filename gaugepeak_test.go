@@ -0,0 +1,49 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestGaugePeak(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+
+	// a sawtooth, ending far from its peaks
+	for _, v := range []int64{3, 9, 1, 12, 4, -2, 7, 0} {
+		buffered.Gauge("queue", v)
+		buffered.GaugeMax("queue", v)
+		buffered.GaugeMin("queue", v)
+	}
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == 1 })
+	// reset after the flush
+	buffered.GaugeMax("queue", 5)
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == 2 })
+
+	expected := []string{
+		"myproject.queue:0|g\nmyproject.queue.max:12|g\nmyproject.queue.min:0|g\nmyproject.queue.min:-2|g",
+		"myproject.queue.max:5|g",
+	}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+
+	// sent right away by the direct client
+	client.GaugeMax("queue", 3)
+	client.WithSource("db").GaugeMin("conns", 1)
+	// and as plain gauges by a Statsd without GaugeMax
+	NewRouter(methodsOnly{client}).GaugeMax("queue", 4)
+	expected = append(expected, "myproject.queue.max:3|g", "myproject.db.conns.min:1|g", "myproject.queue.max:4|g")
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
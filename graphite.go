@@ -0,0 +1,149 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// ErrGraphiteDirect is returned by the direct sends of a client in Graphite mode:
+// the plaintext protocol has no server-side aggregation, so the stats must be
+// aggregated by a buffered client first
+var ErrGraphiteDirect = errors.New("statsd: in Graphite mode stats can only be sent through a StatsdBuffer")
+
+// SetGraphite switches the client to the Graphite plaintext protocol, for
+// environments with a Carbon server but no StatsD daemon: CreateSocket opens a
+// TCP connection, and the stats aggregated by a buffered client wrapping it are
+// written as "name value timestamp" lines at flush time, with the timestamp
+// taken from the clock of the client. Gauge deltas are applied to the last
+// value the client wrote, timers get their count, sum and percentiles besides
+// their average, min and max, and sets are dropped unless they're estimated
+// (see Supports).
+// Direct sends return ErrGraphiteDirect. It must be called before CreateSocket
+func (c *StatsdClient) SetGraphite(graphite bool) {
+	var v int32
+	if graphite {
+		v = 1
+	}
+	atomic.StoreInt32(&c.graphite, v)
+}
+
+// isGraphite tells whether the client is in Graphite mode
+func (c *StatsdClient) isGraphite() bool {
+	return atomic.LoadInt32(&c.graphite) != 0
+}
+
+// sendGraphite writes the stats of an aggregated event in the Graphite
+// plaintext format. The event key must already be a prefixed metric name.
+// The packet written is accounted for in the report, if not nil
+func (c *StatsdClient) sendGraphite(e event.Event, now time.Time, report *FlushReport) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.conn == nil {
+		return errNotConnected
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	c.buf = c.buf[:0]
+	if name, delta, ok := gaugeDelta(e); ok {
+		// Carbon keeps no state: the delta is applied to the running value
+		value := c.graphiteGauges[name] + delta
+		c.setGraphiteGauge(name, value)
+		c.buf = appendGraphite(c.buf, name, c.formatFloat(value), ts)
+	} else {
+		c.scratch = event.AppendStatsPrecision(c.scratch[:0], "", e, c.precision())
+		var stats [][]byte
+		if len(c.scratch) > 0 {
+			stats = bytes.Split(bytes.TrimSuffix(c.scratch, []byte{'\n'}), []byte{'\n'})
+		}
+		gauge := kindOf(e) == KindGauge
+		if gauge && len(stats) > 1 {
+			// skip the reset to 0 which precedes the negative gauges
+			stats = stats[len(stats)-1:]
+		}
+		for _, stat := range stats {
+			m, err := wire.ParseLine(stat)
+			if err != nil {
+				return err
+			}
+			value := strings.TrimPrefix(m.Value, "+")
+			if v, err := strconv.ParseFloat(value, 64); gauge && err == nil {
+				c.setGraphiteGauge(m.Name, v)
+			}
+			c.buf = appendGraphite(c.buf, m.Name, value, ts)
+		}
+		c.buf = c.appendTimerAggregates(c.buf, e, ts)
+	}
+	start := time.Now()
+	err := c.writeLine(c.buf)
+	report.written(c.buf, time.Since(start))
+	return err
+}
+
+// graphitePercentiles are the percentiles of the timers written in Graphite
+// mode, as name.p50 and so on, next to the aggregates of the other modes
+var graphitePercentiles = []float64{50, 90, 95, 99}
+
+// appendGraphite appends a plaintext line
+func appendGraphite(buf []byte, name string, value string, ts string) []byte {
+	buf = append(append(buf, name...), ' ')
+	buf = append(append(buf, value...), ' ')
+	return append(append(buf, ts...), '\n')
+}
+
+// appendTimerAggregates appends the count, the sum and the percentiles of a
+// timer, which a StatsD server would compute but Carbon doesn't (see
+// graphitePercentiles). Other events are left out
+func (c *StatsdClient) appendTimerAggregates(buf []byte, e event.Event, ts string) []byte {
+	var count int64
+	var sum float64
+	var percentile func(p float64) float64
+	switch t := e.(type) {
+	case *event.Timing:
+		count, sum = t.Count, float64(t.Value)
+		percentile = func(p float64) float64 { return float64(t.Percentile(p)) }
+	case *event.PrecisionTiming:
+		ms := float64(time.Millisecond)
+		count, sum = t.Count, float64(t.Value)/ms
+		percentile = func(p float64) float64 { return float64(t.Percentile(p)) / ms }
+	case *event.FTiming:
+		count, sum, percentile = t.Count, t.Value, t.Percentile
+	default:
+		return buf
+	}
+	name := e.Key()
+	buf = appendGraphite(buf, name+".count", strconv.FormatInt(count, 10), ts)
+	buf = appendGraphite(buf, name+".sum", c.formatFloat(sum), ts)
+	for _, p := range graphitePercentiles {
+		buf = appendGraphite(buf, name+".p"+strconv.FormatFloat(p, 'f', -1, 64), c.formatFloat(percentile(p)), ts)
+	}
+	return buf
+}
+
+// gaugeDelta returns the name and the value of a gauge delta
+func gaugeDelta(e event.Event) (string, float64, bool) {
+	switch d := e.(type) {
+	case *event.GaugeDelta:
+		return d.Name, float64(d.Value), true
+	case *event.FGaugeDelta:
+		return d.Name, d.Value, true
+	}
+	return "", 0, false
+}
+
+// setGraphiteGauge records the running value of a gauge in Graphite mode, the
+// base of its next deltas. The caller must hold c.mu
+func (c *StatsdClient) setGraphiteGauge(name string, value float64) {
+	if c.graphiteGauges == nil {
+		c.graphiteGauges = make(map[string]float64)
+	}
+	c.graphiteGauges[name] = value
+}
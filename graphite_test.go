@@ -0,0 +1,169 @@
+package statsd
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// carbonServer collects the plaintext lines received over TCP
+type carbonServer struct {
+	ln    net.Listener
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	lines []string
+}
+
+func newCarbonServer(t *testing.T) *carbonServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &carbonServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					s.mu.Lock()
+					s.lines = append(s.lines, scanner.Text())
+					s.mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func TestGraphite(t *testing.T) {
+	srv := newCarbonServer(t)
+	defer srv.ln.Close()
+
+	clock := statsdtest.NewFakeClock(time.Unix(1700000000, 0))
+	client := NewStatsdClient(srv.ln.Addr().String(), "myproject.")
+	client.SetGraphite(true)
+	client.SetClock(clock)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Incr("direct", 1); err != ErrGraphiteDirect {
+		t.Errorf("Incr: expected ErrGraphiteDirect, actual %v", err)
+	}
+	if err := client.IncrMap(map[string]int64{"direct": 1}); err != ErrGraphiteDirect {
+		t.Errorf("IncrMap: expected ErrGraphiteDirect, actual %v", err)
+	}
+
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Incr("a", 3)
+	buffered.Incr("a", 4)
+	buffered.Gauge("g", -5)
+	buffered.GaugeDelta("d", 2)
+	buffered.PrecisionTiming("t", 1500*time.Microsecond)
+	clock.Advance(10 * time.Second)
+	buffered.Close()
+	srv.ln.Close()
+	srv.wg.Wait()
+
+	expected := []string{
+		"myproject.a 7 1700000010",
+		"myproject.d 2 1700000010",
+		"myproject.g -5 1700000010",
+		"myproject.t.avg 1.5 1700000010",
+		"myproject.t.min 1.5 1700000010",
+		"myproject.t.max 1.5 1700000010",
+		"myproject.t.count 1 1700000010",
+		"myproject.t.sum 1.5 1700000010",
+		"myproject.t.p50 1.5 1700000010",
+		"myproject.t.p90 1.5 1700000010",
+		"myproject.t.p95 1.5 1700000010",
+		"myproject.t.p99 1.5 1700000010",
+	}
+	if len(srv.lines) != len(expected) {
+		t.Fatalf("expected %q, actual %q", expected, srv.lines)
+	}
+	for i := range expected {
+		if srv.lines[i] != expected[i] {
+			t.Errorf("expected %q, actual %q", expected[i], srv.lines[i])
+		}
+	}
+}
+
+func TestGraphiteAggregates(t *testing.T) {
+	srv := newCarbonServer(t)
+	defer srv.ln.Close()
+
+	clock := statsdtest.NewFakeClock(time.Unix(1700000000, 0))
+	client := NewStatsdClient(srv.ln.Addr().String(), "")
+	client.SetGraphite(true)
+	client.SetClock(clock)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Logger = discardLogger{}
+	reports := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+	flush := func() {
+		t.Helper()
+		clock.Advance(10 * time.Second)
+		select {
+		case <-reports:
+		case <-time.After(time.Second):
+			t.Fatal("no flush")
+		}
+	}
+
+	// the deltas apply to the running value of the gauges
+	buffered.Gauge("g", -5)
+	buffered.GaugeDelta("d", 2)
+	flush()
+	buffered.GaugeDelta("g", 1)
+	buffered.GaugeDelta("d", 3)
+	buffered.FGaugeDelta("f", -0.5)
+	flush()
+	for i := int64(1); i <= 10; i++ {
+		buffered.Timing("t", i)
+	}
+	flush()
+	buffered.Close()
+	srv.ln.Close()
+	srv.wg.Wait()
+
+	expected := []string{
+		"d 2 1700000010",
+		"g -5 1700000010",
+		"d 5 1700000020",
+		"f -0.5 1700000020",
+		"g -4 1700000020",
+		"t.avg 5 1700000030",
+		"t.min 1 1700000030",
+		"t.max 10 1700000030",
+		"t.count 10 1700000030",
+		"t.sum 55 1700000030",
+		"t.p50 5 1700000030",
+		"t.p90 9 1700000030",
+		"t.p95 10 1700000030",
+		"t.p99 10 1700000030",
+	}
+	// each flush may reconnect, the lines are ordered per flush
+	lines := append([]string(nil), srv.lines...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i][strings.LastIndexByte(lines[i], ' '):] < lines[j][strings.LastIndexByte(lines[j], ' '):]
+	})
+	if strings.Join(expected, "\n") != strings.Join(lines, "\n") {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+}
@@ -0,0 +1,146 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// histogram counts the timing samples of a key into cumulative buckets
+type histogram struct {
+	bounds []float64 // upper bounds in milliseconds, sorted
+	names  []string  // suffix of each bucket, the last one is .le_inf
+	counts []int64   // one more than the bounds, for the samples above all of them
+}
+
+// observe counts a sample in milliseconds in the first bucket holding it
+func (h *histogram) observe(ms float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, ms)]++
+}
+
+// SetHistogramBuckets makes the buffered client count the timing samples of stat
+// into cumulative buckets with the given upper bounds, in milliseconds, for
+// backends without histograms. At flush time every bucket is sent as a counter
+// named after its bound, e.g. stat.le_10ms, stat.le_2_5s and stat.le_inf for
+// all the samples, alongside the usual timer aggregates. Nil bounds remove the
+// buckets of the stat
+func (sb *buffer) SetHistogramBuckets(stat string, bounds []float64) {
+	sb.bucketsMu.Lock()
+	defer sb.bucketsMu.Unlock()
+	// copy on write, the map is read by the collector without locking
+	old, _ := sb.buckets.Load().(map[string][]float64)
+	buckets := make(map[string][]float64, len(old)+1)
+	for k, v := range old {
+		buckets[k] = v
+	}
+	if bounds == nil {
+		delete(buckets, stat)
+	} else {
+		sorted := make([]float64, 0, len(bounds))
+		for _, b := range bounds {
+			if event.IsFinite(b) {
+				sorted = append(sorted, b)
+			}
+		}
+		sort.Float64s(sorted)
+		buckets[stat] = sorted
+	}
+	sb.buckets.Store(buckets)
+}
+
+// histogramSamples returns the samples of a timing event in milliseconds
+func histogramSamples(e event.Event) []float64 {
+	var samples []float64
+	switch t := e.(type) {
+	case *event.Timing:
+		for _, v := range t.Samples {
+			samples = append(samples, float64(v))
+		}
+	case *event.FTiming:
+		samples = t.Samples
+	case *event.PrecisionTiming:
+		for _, v := range t.Samples {
+			samples = append(samples, float64(v)/float64(time.Millisecond))
+		}
+	}
+	return samples
+}
+
+// observeHistogram counts the samples of a new event, before it's merged into
+// the pending ones. It's only called from within the collector, stat is the key
+// given by the application and name the metric name
+func (sb *buffer) observeHistogram(stat string, name string, e event.Event) {
+	buckets, _ := sb.buckets.Load().(map[string][]float64)
+	bounds, ok := buckets[stat]
+	if !ok {
+		return
+	}
+	samples := histogramSamples(e)
+	if len(samples) == 0 {
+		return
+	}
+	h := sb.histograms[name]
+	if h == nil || !equalBounds(h.bounds, bounds) {
+		h = newHistogram(bounds)
+		if sb.histograms == nil {
+			sb.histograms = make(map[string]*histogram)
+		}
+		sb.histograms[name] = h
+	}
+	for _, ms := range samples {
+		h.observe(ms)
+	}
+}
+
+func newHistogram(bounds []float64) *histogram {
+	h := &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+	for _, b := range bounds {
+		h.names = append(h.names, ".le_"+bucketName(b))
+	}
+	h.names = append(h.names, ".le_inf")
+	return h
+}
+
+// histogramEvents returns the cumulative bucket counters of the histograms
+// observed since the previous flush, and resets them
+func (sb *buffer) histogramEvents() []event.Event {
+	var events []event.Event
+	for name, h := range sb.histograms {
+		var total int64
+		for i, n := range h.counts {
+			total += n
+			events = append(events, &event.Increment{Name: name + h.names[i], Value: total})
+		}
+		delete(sb.histograms, name)
+	}
+	return events
+}
+
+// bucketName renders a bound in milliseconds as a stable, sanitized suffix:
+// 10ms, 2_5s or 500us
+func bucketName(ms float64) string {
+	value, unit := ms, "ms"
+	switch {
+	case math.Abs(ms) >= 1000:
+		value, unit = ms/1000, "s"
+	case ms != 0 && math.Abs(ms) < 1:
+		value, unit = ms*1000, "us"
+	}
+	s := strings.Replace(event.FormatFloat(value), ".", "_", -1)
+	return strings.Replace(s, "-", "minus_", -1) + unit
+}
+
+func equalBounds(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package statsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.SetHistogramBuckets("latency", []float64{50, 10, 2500})
+
+	// 3 samples up to 10ms, 2 up to 50ms, 1 up to 2.5s and 2 above
+	for _, ms := range []int64{1, 5, 10, 11, 50} {
+		buffered.Timing("latency", ms)
+	}
+	buffered.PrecisionTiming("latency", 2*time.Second)
+	buffered.FTiming("latency", 2500.5)
+	buffered.TimingMicroseconds("latency", 9e6)
+	buffered.Timing("other", 1)
+	buffered.Close()
+
+	counters := make(map[string]string)
+	for _, p := range conn.packets {
+		for _, line := range strings.Split(p, "\n") {
+			if strings.Contains(line, ".le_") {
+				parts := strings.SplitN(line, ":", 2)
+				counters[parts[0]] = parts[1]
+			}
+		}
+	}
+	expected := map[string]string{
+		"myproject.latency.le_10ms": "3|c",
+		"myproject.latency.le_50ms": "5|c",
+		"myproject.latency.le_2_5s": "6|c",
+		"myproject.latency.le_inf":  "8|c",
+	}
+	if !reflect.DeepEqual(expected, counters) {
+		t.Errorf("expected %v, actual %v", expected, counters)
+	}
+}
+
+func TestBucketName(t *testing.T) {
+	tests := map[float64]string{
+		10:   "10ms",
+		0:    "0ms",
+		2500: "2_5s",
+		1000: "1s",
+		0.5:  "500us",
+		12.5: "12_5ms",
+	}
+	for ms, expected := range tests {
+		if actual := bucketName(ms); actual != expected {
+			t.Errorf("%v: expected %s, actual %s", ms, expected, actual)
+		}
+	}
+}
@@ -10,6 +10,7 @@ type Statsd interface {
 	Decr(stat string, count int64) error
 	Timing(stat string, delta int64) error
 	PrecisionTiming(stat string, delta time.Duration) error
+	TimingMicroseconds(stat string, us float64) error
 	Gauge(stat string, value int64) error
 	GaugeDelta(stat string, value int64) error
 	Absolute(stat string, value int64) error
@@ -18,4 +19,8 @@ type Statsd interface {
 	FGauge(stat string, value float64) error
 	FGaugeDelta(stat string, value float64) error
 	FAbsolute(stat string, value float64) error
+
+	IncrMap(counts map[string]int64) error
+	GaugeMap(values map[string]int64) error
+	TimingSlices(timings map[string][]time.Duration) error
 }
@@ -0,0 +1,5 @@
+// Package benchmarks holds the micro-benchmarks of every send method of the
+// statsd clients, run against a statsdtest.DiscardSender:
+//
+//	go test -run xxx -bench . -benchmem ./internal/benchmarks
+package benchmarks
@@ -0,0 +1,100 @@
+package benchmarks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd"
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+var (
+	counts  = map[string]int64{"a": 1, "b": 2, "c": 3}
+	timings = map[string][]time.Duration{"a": {time.Millisecond, 2 * time.Millisecond}}
+)
+
+// sincer and fTimer are the optional methods of the clients
+type sincer interface {
+	Since(stat string, start time.Time) error
+}
+
+type fTimer interface {
+	FTiming(stat string, ms float64) error
+}
+
+// sends are all the send methods shared by the clients
+var sends = []struct {
+	name string
+	send func(c statsd.Statsd) error
+}{
+	{"Incr", func(c statsd.Statsd) error { return c.Incr("bench.incr", 1) }},
+	{"Decr", func(c statsd.Statsd) error { return c.Decr("bench.decr", 1) }},
+	{"Timing", func(c statsd.Statsd) error { return c.Timing("bench.timing", 12) }},
+	{"PrecisionTiming", func(c statsd.Statsd) error { return c.PrecisionTiming("bench.ptiming", 1500*time.Microsecond) }},
+	{"TimingMicroseconds", func(c statsd.Statsd) error { return c.TimingMicroseconds("bench.us", 314) }},
+	{"FTiming", func(c statsd.Statsd) error { return c.(fTimer).FTiming("bench.ftiming", 3.275) }},
+	{"Since", func(c statsd.Statsd) error { return c.(sincer).Since("bench.since", time.Time{}) }},
+	{"Gauge", func(c statsd.Statsd) error { return c.Gauge("bench.gauge", 42) }},
+	{"NegativeGauge", func(c statsd.Statsd) error { return c.Gauge("bench.ngauge", -42) }},
+	{"GaugeDelta", func(c statsd.Statsd) error { return c.GaugeDelta("bench.gdelta", 1) }},
+	{"FGauge", func(c statsd.Statsd) error { return c.FGauge("bench.fgauge", 4.2) }},
+	{"FGaugeDelta", func(c statsd.Statsd) error { return c.FGaugeDelta("bench.fgdelta", 0.1) }},
+	{"Absolute", func(c statsd.Statsd) error { return c.Absolute("bench.abs", 7) }},
+	{"FAbsolute", func(c statsd.Statsd) error { return c.FAbsolute("bench.fabs", 0.7) }},
+	{"Total", func(c statsd.Statsd) error { return c.Total("bench.total", 100) }},
+	{"IncrMap", func(c statsd.Statsd) error { return c.IncrMap(counts) }},
+	{"GaugeMap", func(c statsd.Statsd) error { return c.GaugeMap(counts) }},
+	{"TimingSlices", func(c statsd.Statsd) error { return c.TimingSlices(timings) }},
+}
+
+func newClient(b *testing.B) *statsd.StatsdClient {
+	client := statsd.NewStatsdClient("discard:8125", "bench.")
+	client.SetDialer((&statsdtest.DiscardSender{}).Dial)
+	if err := client.CreateSocket(); err != nil {
+		b.Fatal(err)
+	}
+	return client
+}
+
+func run(b *testing.B, c statsd.Statsd) {
+	for _, s := range sends {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := s.send(c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkClient(b *testing.B) {
+	client := newClient(b)
+	defer client.Close()
+	run(b, client)
+	b.Run("SendEvent", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			client.SendEvent(&event.Increment{Name: "bench.event", Value: 1})
+		}
+	})
+	b.Run("SendEvents", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			client.SendEvents(&event.Increment{Name: "bench.event", Value: 1}, &event.Gauge{Name: "bench.event", Value: -1})
+		}
+	})
+}
+
+func BenchmarkBufferedClient(b *testing.B) {
+	buffered := statsd.NewStatsdBuffer(time.Second, newClient(b))
+	buffered.Logger = noopLogger{}
+	defer buffered.Close()
+	run(b, buffered)
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Println(v ...interface{}) {}
@@ -0,0 +1,103 @@
+package statsd
+
+import (
+	"sync/atomic"
+)
+
+// KindStats counts the outcome of the metrics of a kind, see StatsByKind
+type KindStats struct {
+	Sent    int64 // written to the socket, or queued for retrying
+	Dropped int64 // filtered, sampled out, unsupported by the output mode, sent after Close or of an empty name
+	Errors  int64 // failed to be named or written
+}
+
+// outcome of a metric, indexing the columns of the matrix of StatsByKind
+type outcome int
+
+const (
+	outcomeSent outcome = iota
+	outcomeDropped
+	outcomeError
+	numOutcomes
+)
+
+func (k MetricKind) String() string {
+	switch k {
+	case KindCounter:
+		return "counter"
+	case KindTiming:
+		return "timing"
+	case KindGauge:
+		return "gauge"
+	case KindAbsolute:
+		return "absolute"
+	case KindTotal:
+		return "total"
+	case KindSet:
+		return "set"
+	}
+	return "unknown kind"
+}
+
+// outcomeOf classifies the error of a send
+func outcomeOf(err error) outcome {
+	if err == nil {
+		return outcomeSent
+	}
+	if err == ErrClosed || err == ErrEmptyName {
+		return outcomeDropped
+	}
+	for _, unsupported := range unsupportedErrors {
+		if err == unsupported {
+			return outcomeDropped
+		}
+	}
+	return outcomeError
+}
+
+// count adds n metrics of kind to the outcome
+func (c *StatsdClient) count(kind MetricKind, o outcome, n int64) {
+	if kind >= 0 && kind < numKinds && n > 0 {
+		atomic.AddInt64(&c.outcomes[kind][o], n)
+	}
+}
+
+// countRejected counts a metric of kind rejected before being queued by the
+// buffered client if its name is empty, and returns err
+func (c *StatsdClient) countRejected(kind MetricKind, err error) error {
+	if err == ErrEmptyName {
+		c.count(kind, outcomeDropped, 1)
+	}
+	return err
+}
+
+// countResult counts a metric of kind with the outcome of err, and returns err
+func (c *StatsdClient) countResult(kind MetricKind, err error) error {
+	c.count(kind, outcomeOf(err), 1)
+	return err
+}
+
+// StatsByKind returns, for each kind of metric sent through the client, how
+// many metrics were sent, dropped and failed. The metrics aggregated by a
+// buffered client are counted once flushed, or when filtered before being
+// queued. The kinds never used are omitted
+func (c *StatsdClient) StatsByKind() map[MetricKind]KindStats {
+	stats := make(map[MetricKind]KindStats)
+	for kind := range c.outcomes {
+		s := KindStats{
+			Sent:    atomic.LoadInt64(&c.outcomes[kind][outcomeSent]),
+			Dropped: atomic.LoadInt64(&c.outcomes[kind][outcomeDropped]),
+			Errors:  atomic.LoadInt64(&c.outcomes[kind][outcomeError]),
+		}
+		if s != (KindStats{}) {
+			stats[MetricKind(kind)] = s
+		}
+	}
+	return stats
+}
+
+// StatsByKind returns the outcome of the metrics per kind, see
+// StatsdClient.StatsByKind
+func (sb *buffer) StatsByKind() map[MetricKind]KindStats {
+	return sb.statsd.StatsByKind()
+}
@@ -0,0 +1,56 @@
+package statsd
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+func TestStatsByKind(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+
+	// failing writes
+	client.Incr("a", 1)
+	client.Incr("b", 1)
+	client.Gauge("g", 1)
+
+	conn.mu.Lock()
+	conn.until = time.Time{}
+	conn.mu.Unlock()
+	client.Incr("a", 1)
+	client.Incr("b", 1)
+	client.IncrMap(map[string]int64{"c": 1, "d": 2})
+	client.Gauge("g", -5)
+	client.Timing("t", 10)
+	client.Unique("u", "joe")
+	client.SendEvents(&event.Absolute{Name: "abs", Values: []int64{1, 2}}, &event.Total{Name: "tot", Value: 3})
+
+	client.DisableKind(KindTiming)
+	client.Timing("t", 10)
+	client.Timing("t", 20)
+
+	client.Close()
+	client.Incr("a", 1)
+
+	expected := map[MetricKind]KindStats{
+		KindCounter:  {Sent: 4, Dropped: 1, Errors: 2},
+		KindGauge:    {Sent: 1, Errors: 1},
+		KindTiming:   {Sent: 1, Dropped: 2},
+		KindSet:      {Sent: 1},
+		KindAbsolute: {Sent: 1},
+		KindTotal:    {Sent: 1},
+	}
+	if actual := client.StatsByKind(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+}
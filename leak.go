@@ -0,0 +1,49 @@
+package statsd
+
+import "sync/atomic"
+
+// activeClients counts the buffered clients whose collector is running, see ActiveClients
+var activeClients int64
+
+// leakHandler holds the func(Config) set by SetLeakHandler
+var leakHandler atomic.Value
+
+// ActiveClients returns the number of buffered clients created and not closed
+// yet (nor garbage collected), to track down the clients never closed
+func ActiveClients() int {
+	return int(atomic.LoadInt64(&activeClients))
+}
+
+// SetLeakHandler sets a function called with the configuration of the
+// underlying client whenever a buffered client becomes unreachable without
+// being closed. Such a client is closed by the garbage collector instead: its
+// goroutines stop, its socket is closed and its pending stats are dropped,
+// logged by the Logger of the underlying client. The handler runs on a
+// goroutine of its own. A buffered client referred to by its own handlers
+// (e.g. the ones of SetErrorHandler) stays reachable, and is never collected
+func SetLeakHandler(handler func(Config)) {
+	leakHandler.Store(handler)
+}
+
+// collected stops the collector of a buffered client garbage collected without
+// Close, it's the finalizer of the StatsdBuffer. The clients are closed, and
+// the handler called, on a goroutine of their own: the finalizers run one at a
+// time on a single goroutine of the runtime, which a close stuck on the socket
+// would hold up
+func (sb *buffer) collected() {
+	if atomic.LoadInt32(&sb.closed) != 0 {
+		return
+	}
+	close(sb.leaked)
+	go func() {
+		cfg := sb.statsd.Config()
+		sb.statsd.Logger.Println("Buffered StatsD client garbage collected without being closed, its pending stats are dropped:", cfg)
+		sb.statsd.Close()
+		if sb.next != nil {
+			sb.next.Close()
+		}
+		if handler, _ := leakHandler.Load().(func(Config)); handler != nil {
+			handler(cfg)
+		}
+	}()
+}
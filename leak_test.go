@@ -0,0 +1,128 @@
+package statsd
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newLeakedBuffer creates a buffered client over client and drops it without Close
+func newLeakedBuffer(client *StatsdClient) {
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("a", 1)
+}
+
+func TestLeakedBuffer(t *testing.T) {
+	leaks := leakedPrefixes("leaky.")
+	defer SetLeakHandler((func(Config))(nil))
+	active, goroutines := ActiveClients(), runtime.NumGoroutine()
+
+	client, _ := newPacketClient(t, "leaky.")
+	client.Logger = discardLogger{}
+	newLeakedBuffer(client)
+	if n := ActiveClients(); n != active+1 {
+		t.Errorf("expected %d active clients, actual %d", active+1, n)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for leaked := false; !leaked; {
+		runtime.GC()
+		select {
+		case <-leaks:
+			leaked = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the leak handler wasn't called")
+		}
+	}
+	if err := client.Close(); err != ErrClosed {
+		t.Errorf("expected the client to be closed, actual %v", err)
+	}
+	// the clients left behind by the other tests may be collected meanwhile
+	waitUntil(t, time.Second, func() bool { return ActiveClients() <= active })
+	waitUntil(t, time.Second, func() bool { return runtime.NumGoroutine() <= goroutines })
+}
+
+func TestClosedBufferNotLeaked(t *testing.T) {
+	leaks := leakedPrefixes("closed.")
+	defer SetLeakHandler((func(Config))(nil))
+	active := ActiveClients()
+
+	client, _ := newPacketClient(t, "closed.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Close()
+	<-buffered.Done()
+	if n := ActiveClients(); n > active {
+		t.Errorf("expected at most %d active clients, actual %d", active, n)
+	}
+	buffered = nil
+	runtime.GC()
+	runtime.GC()
+	select {
+	case <-leaks:
+		t.Error("the closed client was reported as leaked")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// leakedPrefixes sets a leak handler reporting the leaked clients with the given prefix
+func leakedPrefixes(prefix string) <-chan Config {
+	leaks := make(chan Config, 1)
+	SetLeakHandler(func(cfg Config) {
+		if cfg.Prefix == prefix {
+			leaks <- cfg
+		}
+	})
+	return leaks
+}
+
+// blockingCloseConn blocks in Close until released
+type blockingCloseConn struct {
+	packetConn
+	release chan struct{}
+}
+
+func (c *blockingCloseConn) Close() error {
+	<-c.release
+	return nil
+}
+
+// a leaked client stuck closing doesn't hold up the cleanup of the others
+func TestLeakedBufferStuckClose(t *testing.T) {
+	leaks := leakedPrefixes("leaky.")
+	defer SetLeakHandler((func(Config))(nil))
+
+	stuck := &blockingCloseConn{release: make(chan struct{})}
+	defer close(stuck.release)
+	client := NewStatsdClient("localhost:8125", "stuck.")
+	client.Logger = discardLogger{}
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return stuck, nil
+	}
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	newLeakedBuffer(client)
+	// collected first
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+
+	client, _ = newPacketClient(t, "leaky.")
+	client.Logger = discardLogger{}
+	newLeakedBuffer(client)
+	deadline := time.After(5 * time.Second)
+	for leaked := false; !leaked; {
+		runtime.GC()
+		select {
+		case <-leaks:
+			leaked = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the leak handler wasn't called")
+		}
+	}
+}
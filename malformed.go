@@ -0,0 +1,92 @@
+package statsd
+
+import (
+	"sort"
+	"sync"
+)
+
+// BadName counts how many times a malformed stat name was rewritten by the
+// sanitization, or rejected in strict mode
+type BadName struct {
+	Name       string // as given by the application
+	Rewrites   int64
+	Rejections int64
+}
+
+// malformed counts the malformed names, in bounded memory
+type malformed struct {
+	mu         sync.Mutex
+	names      map[string]*BadName
+	maxNames   int
+	log        bool
+	rewrites   int64
+	rejections int64
+}
+
+// TrackMalformedNames makes the client count, per original name, the stat
+// names it had to rewrite (reserved characters escaped, or normalized, see
+// SetNormalizeNames) and the ones rejected in strict mode, to find the calling
+// code to fix. At most maxNames names are remembered, the others are only
+// counted in the totals of Stats(). If log is true, the first occurrence of
+// each name remembered is logged. It must be called before the client is used
+func (c *StatsdClient) TrackMalformedNames(maxNames int, log bool) {
+	c.malformed = &malformed{
+		names:    make(map[string]*BadName),
+		maxNames: maxNames,
+		log:      log,
+	}
+}
+
+// MalformedReport returns the malformed names seen, the most frequent first,
+// or nil if the tracking is not enabled
+func (c *StatsdClient) MalformedReport() []BadName {
+	t := c.malformed
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	report := make([]BadName, 0, len(t.names))
+	for _, n := range t.names {
+		report = append(report, *n)
+	}
+	t.mu.Unlock()
+	sort.Slice(report, func(i, j int) bool {
+		ni, nj := report[i].Rewrites+report[i].Rejections, report[j].Rewrites+report[j].Rejections
+		if ni != nj {
+			return ni > nj
+		}
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
+
+// observe records a malformed name, rewritten or rejected
+func (t *malformed) observe(logger Logger, name string, rewritten bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rewritten {
+		t.rewrites++
+	} else {
+		t.rejections++
+	}
+	n, ok := t.names[name]
+	if !ok {
+		if len(t.names) >= t.maxNames {
+			return
+		}
+		n = &BadName{Name: name}
+		t.names[name] = n
+		if t.log {
+			action := "rejected"
+			if rewritten {
+				action = "rewritten"
+			}
+			logger.Println("Malformed stat name", action+":", name)
+		}
+	}
+	if rewritten {
+		n.Rewrites++
+	} else {
+		n.Rejections++
+	}
+}
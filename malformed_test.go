@@ -0,0 +1,71 @@
+package statsd
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingLogger keeps the lines logged
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Println(v ...interface{}) {
+	l.mu.Lock()
+	l.lines = append(l.lines, fmt.Sprintln(v...))
+	l.mu.Unlock()
+}
+
+func TestMalformedNames(t *testing.T) {
+	client, _ := newPacketClient(t, "myproject.")
+	logger := &recordingLogger{}
+	client.Logger = logger
+	client.TrackMalformedNames(2, true)
+
+	client.Incr("good", 1)
+	client.Incr("bad|name", 1)
+	client.Incr("bad|name", 1)
+	client.Gauge("bad\nline", 1)
+	client.Incr("third|bad", 1) // over the limit, only counted in the totals
+	client.SetStrictNames(true)
+	client.Incr("bad|name", 1)
+
+	expected := []BadName{
+		{Name: "bad|name", Rewrites: 2, Rejections: 1},
+		{Name: "bad\nline", Rewrites: 1},
+	}
+	if report := client.MalformedReport(); !reflect.DeepEqual(expected, report) {
+		t.Errorf("expected %+v, actual %+v", expected, report)
+	}
+	if stats := client.Stats(); stats.NameRewrites != 4 || stats.NameRejections != 1 {
+		t.Errorf("expected 4 rewrites and 1 rejection, actual %+v", stats)
+	}
+	expectedLog := []string{
+		"Malformed stat name rewritten: bad|name\n",
+		"Malformed stat name rewritten: bad\nline\n",
+	}
+	if !reflect.DeepEqual(expectedLog, logger.lines) {
+		t.Errorf("expected %q, actual %q", expectedLog, logger.lines)
+	}
+}
+
+func TestMalformedNamesBuffered(t *testing.T) {
+	client, _ := newPacketClient(t, "myproject.")
+	client.TrackMalformedNames(10, false)
+	client.SetNormalizeNames(true)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("HTTP..Requests", 1)
+	buffered.Incr("http.requests", 1)
+	buffered.Close()
+
+	// names are resolved twice by the buffered client, but counted once
+	expected := []BadName{{Name: "HTTP..Requests", Rewrites: 1}}
+	if report := client.MalformedReport(); !reflect.DeepEqual(expected, report) {
+		t.Errorf("expected %+v, actual %+v", expected, report)
+	}
+}
@@ -0,0 +1,69 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNameMapper(t *testing.T) {
+	mapper := NameMapper(
+		map[string]string{"legacy.requests.count": "http.server.requests"},
+		map[string]string{"legacy.": "old.", "legacy.db.": "db."},
+	)
+	tests := map[string]string{
+		"legacy.requests.count": "http.server.requests",
+		"legacy.cache.hits":     "old.cache.hits",
+		"legacy.db.queries":     "db.queries",
+		"other.metric":          "other.metric",
+	}
+	for in, expected := range tests {
+		if actual := mapper(in); actual != expected {
+			t.Errorf("%s: expected %s, actual %s", in, expected, actual)
+		}
+	}
+}
+
+func TestNameMapperClients(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetNameMapper(NameMapper(
+		map[string]string{
+			"myproject.legacy.requests.count": "myproject.http.server.requests",
+			"myproject.old.hits":              "myproject.http.server.requests",
+			"myproject.shared.jobs":           "platform.jobs",
+			"business.orders":                 "business.orders.created",
+		},
+		map[string]string{"myproject.legacy.": "myproject.legacy.v2."},
+	))
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("legacy.requests.count", 1)
+	client.Incr("unmapped", 1)
+
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Incr("legacy.requests.count", 2)
+	buffered.Incr("old.hits", 3)
+	buffered.Incr("legacy.errors", 4)
+	// mapped out of the prefix
+	buffered.Incr("shared.jobs", 5)
+	// the raw names are mapped without the prefix
+	buffered.Incr(RawName("business.orders"), 6)
+	buffered.Close()
+
+	metrics, err := srv.WaitFor("myproject.http.server.requests", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[0].Value != "1" || metrics[1].Value != "5" {
+		t.Errorf("expected direct 1 and merged 5, actual %+v", metrics)
+	}
+	// prefix rewrites are applied once, also through the buffer
+	for _, name := range []string{"myproject.legacy.v2.errors", "myproject.unmapped", "platform.jobs", "business.orders.created"} {
+		if _, err := srv.WaitFor(name, 1, time.Second); err != nil {
+			t.Error(err)
+		}
+	}
+}
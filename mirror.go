@@ -0,0 +1,146 @@
+package statsd
+
+import (
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Sender receives whole packets, e.g. a net.Conn
+type Sender interface {
+	Write(packet []byte) (int, error)
+}
+
+// the packets waiting to be copied to the mirror, further ones are dropped
+const mirrorQueueSize = 256
+
+// how long Close waits for the packets queued to be copied to the mirror
+const mirrorDrainTimeout = time.Second
+
+// mirror copies a sample of the packets to a secondary sink from its own
+// goroutine, so that a slow sink never holds up the sends. The sampling is
+// guarded by c.mu, the counters are updated atomically
+type mirror struct {
+	sink    Sender
+	rate    float64
+	random  *rand.Rand
+	queue   chan []byte
+	done    chan struct{} // closed once the queue is drained
+	sent    int64
+	errors  int64
+	dropped int64
+	conn    net.Conn // dialed by DialMirror, closed with the mirror
+}
+
+// SetMirror copies a random sample (0 < sampleRate <= 1) of the packets written
+// to the socket to a secondary sink, e.g. a debug UDP port, to check the names
+// in production without duplicating the whole traffic. The packets are copied
+// whole, as written after batching, with a Write each, from a goroutine of the
+// mirror: the failures of the sink are only counted in Stats().MirrorErrors,
+// and the packets sampled while it lags too far behind in Stats().MirrorDropped,
+// they never affect the send. A nil sink or a sampleRate <= 0 removes the
+// mirror. See SetMirrorSeed to make the sample reproducible
+func (c *StatsdClient) SetMirror(sink Sender, sampleRate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setMirror(sink, sampleRate)
+}
+
+// setMirror replaces the mirror, stopping the previous one: it closes its
+// connection once its queue is drained. The caller must hold c.mu
+func (c *StatsdClient) setMirror(sink Sender, sampleRate float64) {
+	if c.mirror != nil {
+		close(c.mirror.queue)
+		c.mirror = nil
+	}
+	if sink == nil || sampleRate <= 0 {
+		return
+	}
+	seed := time.Now().UnixNano()
+	if c.mirrorSeed != nil {
+		seed = *c.mirrorSeed
+	}
+	m := &mirror{
+		sink:   sink,
+		rate:   sampleRate,
+		random: rand.New(rand.NewSource(seed)),
+		queue:  make(chan []byte, mirrorQueueSize),
+		done:   make(chan struct{}),
+	}
+	go m.run()
+	c.mirror = m
+}
+
+// closeMirror stops the mirror, waiting for the packets queued to be copied
+// for at most mirrorDrainTimeout. The caller must hold c.mu
+func (c *StatsdClient) closeMirror() {
+	m := c.mirror
+	if m == nil {
+		return
+	}
+	c.setMirror(nil, 0)
+	select {
+	case <-m.done:
+	case <-time.After(mirrorDrainTimeout):
+	}
+}
+
+// DialMirror mirrors a sample of the packets to an address, in any format
+// accepted by ParseAddr (e.g. file:///tmp/mirror.log, one packet per line),
+// see SetMirror. The connection is closed with the client
+func (c *StatsdClient) DialMirror(addr string, sampleRate float64) error {
+	conn, err := c.dialTarget(addr, c.connectTimeout())
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setMirror(conn, sampleRate)
+	if c.mirror == nil {
+		conn.Close()
+	} else {
+		c.mirror.conn = conn
+	}
+	return nil
+}
+
+// SetMirrorSeed seeds the sampling of the mirror, see SetMirror. The seed
+// applies to the mirrors set afterwards as well
+func (c *StatsdClient) SetMirrorSeed(seed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mirrorSeed = &seed
+	if c.mirror != nil {
+		c.mirror.random = rand.New(rand.NewSource(seed))
+	}
+}
+
+// mirrorPacket queues a copy of a packet for the mirror if it's sampled, or
+// drops it if the queue is full. The caller must hold c.mu
+func (c *StatsdClient) mirrorPacket(packet []byte) {
+	m := c.mirror
+	if m == nil || m.random.Float64() >= m.rate {
+		return
+	}
+	// copied, the buffers of the packets are reused
+	select {
+	case m.queue <- append([]byte(nil), packet...):
+	default:
+		atomic.AddInt64(&m.dropped, 1)
+	}
+}
+
+// run copies the packets queued to the sink until the mirror is stopped
+func (m *mirror) run() {
+	defer close(m.done)
+	for packet := range m.queue {
+		if _, err := m.sink.Write(packet); err != nil {
+			atomic.AddInt64(&m.errors, 1)
+		}
+		atomic.AddInt64(&m.sent, 1)
+	}
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
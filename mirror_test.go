@@ -0,0 +1,149 @@
+package statsd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingSink fails every write
+type failingSink struct{}
+
+func (failingSink) Write(b []byte) (int, error) {
+	return 0, errors.New("mirror down")
+}
+
+// waitMirrored waits until the packets queued are copied to the sink
+func waitMirrored(t *testing.T, client *StatsdClient, sink *packetConn) {
+	t.Helper()
+	client.mu.Lock()
+	m := client.mirror
+	client.mu.Unlock()
+	waitUntil(t, time.Second, func() bool {
+		return len(m.queue) == 0 && atomic.LoadInt64(&m.sent) == int64(len(sink.sent()))
+	})
+}
+
+func TestMirror(t *testing.T) {
+	mirrored := func(seed int64, seedFirst bool) []string {
+		client, conn := newPacketClient(t, "myproject.")
+		sink := &packetConn{}
+		if seedFirst {
+			client.SetMirrorSeed(seed)
+			client.SetMirror(sink, 0.1)
+		} else {
+			client.SetMirror(sink, 0.1)
+			client.SetMirrorSeed(seed)
+		}
+		for i := 0; i < 10000; i++ {
+			client.Incr("a", int64(i))
+			if i%100 == 0 {
+				// the queue of the mirror never fills up
+				waitMirrored(t, client, sink)
+			}
+		}
+		waitMirrored(t, client, sink)
+		sent := make(map[string]bool)
+		for _, p := range conn.packets {
+			sent[p] = true
+		}
+		for _, p := range sink.sent() {
+			if !sent[p] {
+				t.Errorf("mirrored packet %q wasn't sent", p)
+			}
+		}
+		if stats := client.Stats(); stats.Mirrored != int64(len(sink.sent())) || stats.MirrorErrors != 0 || stats.MirrorDropped != 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+		return sink.sent()
+	}
+	packets := mirrored(1, false)
+	if n := len(packets); n < 800 || n > 1200 {
+		t.Errorf("expected about 1000 packets mirrored, actual %d", n)
+	}
+	if !reflect.DeepEqual(packets, mirrored(1, false)) {
+		t.Error("different packets mirrored with the same seed")
+	}
+	// the seed applies to the mirrors set afterwards
+	if !reflect.DeepEqual(packets, mirrored(1, true)) {
+		t.Error("different packets mirrored with the seed set first")
+	}
+}
+
+// blockingSink blocks every write until released
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s blockingSink) Write(b []byte) (int, error) {
+	<-s.release
+	return len(b), nil
+}
+
+// a sink lagging behind never holds up the sends
+func TestMirrorSlowSink(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	sink := blockingSink{release: make(chan struct{})}
+	client.SetMirror(sink, 1)
+	n := 2 * mirrorQueueSize
+	for i := 0; i < n; i++ {
+		client.Incr("a", 1)
+	}
+	if len(conn.sent()) != n {
+		t.Errorf("expected %d packets sent, actual %d", n, len(conn.sent()))
+	}
+	if dropped := client.Stats().MirrorDropped; dropped < int64(n-mirrorQueueSize-1) {
+		t.Errorf("expected at least %d packets dropped, actual %d", n-mirrorQueueSize-1, dropped)
+	}
+	close(sink.release)
+	waitUntil(t, time.Second, func() bool {
+		stats := client.Stats()
+		return stats.Mirrored+stats.MirrorDropped == int64(n)
+	})
+}
+
+func TestMirrorErrors(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetMirror(failingSink{}, 1)
+	for i := 0; i < 10; i++ {
+		if err := client.Incr("a", 1); err != nil {
+			t.Errorf("the mirror failure affected the send: %v", err)
+		}
+	}
+	if len(conn.packets) != 10 {
+		t.Errorf("expected 10 packets sent, actual %d", len(conn.packets))
+	}
+	waitUntil(t, time.Second, func() bool { return client.Stats().Mirrored == 10 })
+	if stats := client.Stats(); stats.MirrorErrors != 10 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	client.SetMirror(nil, 1)
+	client.Incr("a", 1)
+	if stats := client.Stats(); stats.Mirrored != 0 {
+		t.Errorf("mirror not removed: %+v", stats)
+	}
+}
+
+func TestDialMirror(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.log")
+	client, _ := newPacketClient(t, "myproject.")
+	if err := client.DialMirror("file://"+path, 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		client.Incr("a"+strconv.Itoa(i), 1)
+	}
+	client.Close()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "myproject.a0:1|c\nmyproject.a1:1|c\nmyproject.a2:1|c\n"; string(b) != expected {
+		t.Errorf("expected %q, actual %q", expected, b)
+	}
+}
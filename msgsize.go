@@ -0,0 +1,113 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+)
+
+// MinPacketSize is the size below which the packets are never downshifted:
+// any IPv4 network can deliver UDP payloads of this size
+const MinPacketSize = 512
+
+// isMessageTooLong tells whether a write failed because the packet exceeds
+// the size the network can deliver (EMSGSIZE)
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
+
+// partialWriteError is returned by downshift when only some of the packets a
+// payload was split into could be written: failed are the spans of the
+// payload, made of whole lines, which weren't
+type partialWriteError struct {
+	err    error
+	failed []span
+}
+
+// span is the range [start, end) of a payload
+type span struct {
+	start, end int
+}
+
+func (e *partialWriteError) Error() string { return e.err.Error() }
+
+func (e *partialWriteError) Unwrap() error { return e.err }
+
+// undelivered returns the spans of the payload whose write failed with err:
+// all of it, unless only some of its packets failed
+func undelivered(payload []byte, err error) []span {
+	if partial, ok := err.(*partialWriteError); ok {
+		return partial.failed
+	}
+	return []span{{0, len(payload)}}
+}
+
+// downshift halves the effective packet size (down to MinPacketSize) after a
+// payload was rejected as too long, and writes it again split at the line
+// boundaries into packets of the new size, downshifting further if needed.
+// It returns the original error if the payload can't be split any further,
+// and a partialWriteError if only some of the packets failed, so that the
+// lines delivered aren't sent again. The caller must hold c.mu
+func (c *StatsdClient) downshift(payload []byte, err error) error {
+	size := c.packetSize / 2
+	if size < MinPacketSize {
+		size = MinPacketSize
+	}
+	if size >= c.packetSize || bytes.IndexByte(payload, '\n') < 0 {
+		return err
+	}
+	c.packetSize = size
+	c.downshifts++
+	c.Logger.Println("Packet rejected as too long, lowering the packet size to", size, "bytes:", err)
+
+	var first error
+	var failed []span
+	delivered := false
+	for offset := 0; offset < len(payload); {
+		// a nested downshift may have lowered the size further
+		chunk := splitPacket(payload[offset:], c.packetSize)
+		_, err := c.conn.Write(chunk)
+		if err == nil {
+			c.markSent()
+			c.mirrorPacket(chunk)
+		}
+		if err != nil && isMessageTooLong(err) {
+			err = c.downshift(chunk, err)
+		}
+		if err == nil {
+			delivered = true
+		} else {
+			for _, s := range undelivered(chunk, err) {
+				failed = append(failed, span{offset + s.start, offset + s.end})
+			}
+			if partial, ok := err.(*partialWriteError); ok {
+				delivered = true
+				err = partial.err
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		// past the newline
+		offset += len(chunk) + 1
+	}
+	if first == nil || !delivered {
+		return first
+	}
+	return &partialWriteError{err: first, failed: failed}
+}
+
+// splitPacket returns the longest prefix of whole lines of the payload which
+// fits the size, or the first line if it's longer than that
+func splitPacket(payload []byte, size int) []byte {
+	if len(payload) <= size {
+		return payload
+	}
+	if i := bytes.LastIndexByte(payload[:size+1], '\n'); i > 0 {
+		return payload[:i]
+	}
+	if i := bytes.IndexByte(payload, '\n'); i > 0 {
+		return payload[:i]
+	}
+	return payload
+}
@@ -0,0 +1,134 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// mtuConn rejects the packets longer than mtu bytes, like a non-fragmenting network
+type mtuConn struct {
+	packetConn
+	mtu int
+}
+
+func (c *mtuConn) Write(b []byte) (int, error) {
+	if len(b) > c.mtu {
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: os.NewSyscallError("write", syscall.EMSGSIZE)}
+	}
+	return c.packetConn.Write(b)
+}
+
+func TestPacketSizeDownshift(t *testing.T) {
+	conn := &mtuConn{mtu: 1000}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.Logger = discardLogger{}
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetMaxPacketSize(8000)
+
+	counts := make(map[string]int64)
+	for i := 0; i < 500; i++ {
+		counts[fmt.Sprintf("key%d", i)] = 1
+	}
+	if err := client.IncrMap(counts); err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for _, p := range conn.packets {
+		if len(p) > conn.mtu {
+			t.Errorf("packet of %d bytes delivered", len(p))
+		}
+		lines += strings.Count(p, "\n") + 1
+	}
+	if lines != len(counts) {
+		t.Errorf("expected %d lines delivered, actual %d", len(counts), lines)
+	}
+	stats := client.Stats()
+	if stats.PacketSize != 1000 || stats.Downshifts != 3 {
+		t.Errorf("expected 3 downshifts to 1000 bytes, actual %+v", stats)
+	}
+
+	// the next batches are built with the lowered size straight away
+	conn.packets = nil
+	if err := client.IncrMap(counts); err != nil {
+		t.Fatal(err)
+	}
+	if stats := client.Stats(); stats.Downshifts != 3 {
+		t.Errorf("unexpected further downshifts: %+v", stats)
+	}
+
+	// a single line can't be split
+	client.SetMaxPacketSize(8000)
+	if err := client.Incr(strings.Repeat("x", 2000), 1); !isMessageTooLong(err) {
+		t.Errorf("expected EMSGSIZE, actual %v", err)
+	}
+}
+
+// lossyConn is an mtuConn failing once the first packet with a line of lost
+type lossyConn struct {
+	mtuConn
+	lost string
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if c.lost != "" && len(b) <= c.mtu && strings.Contains("\n"+string(b)+"\n", "\n"+c.lost+"\n") {
+		c.lost = ""
+		return 0, errors.New("sendto: no route to host")
+	}
+	return c.mtuConn.Write(b)
+}
+
+// after a downshift only the packets which failed are retried
+func TestPacketSizeDownshiftRetry(t *testing.T) {
+	conn := &lossyConn{mtuConn: mtuConn{mtu: 1000}, lost: "myproject.key7:1|c"}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.Logger = discardLogger{}
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.EnableRetry(10, time.Minute)
+	client.SetMaxPacketSize(8000)
+
+	events := make([]event.Event, 500)
+	for i := range events {
+		events[i] = &event.Increment{Name: fmt.Sprintf("key%d", i), Value: 1}
+	}
+	if err := client.SendEvents(events...); err != nil {
+		t.Fatal(err)
+	}
+	count := func() map[string]int {
+		received := make(map[string]int)
+		for _, p := range conn.sent() {
+			for _, line := range strings.Split(p, "\n") {
+				received[line]++
+			}
+		}
+		return received
+	}
+	waitUntil(t, time.Second, func() bool { return count()["myproject.key7:1|c"] == 1 })
+	received := count()
+	if len(received) != len(events) {
+		t.Errorf("expected %d lines, actual %d", len(events), len(received))
+	}
+	for line, n := range received {
+		if n != 1 {
+			t.Errorf("%s delivered %d times", line, n)
+		}
+	}
+}
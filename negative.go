@@ -0,0 +1,137 @@
+package statsd
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// NegativePolicy selects what the buffered client does with a counter whose
+// sum over an interval is negative (it was decremented more than incremented),
+// which some StatsD servers reject, dropping the whole key
+type NegativePolicy int
+
+const (
+	// AllowNegative sends the negative counter as is
+	AllowNegative NegativePolicy = iota
+	// ClampNegative sends 0, and carries the negative remainder over to the
+	// next intervals of the key until it's compensated
+	ClampNegative
+	// RejectNegative drops the counter, passing a *NegativeCounterError to the
+	// error handler
+	RejectNegative
+)
+
+func (p NegativePolicy) String() string {
+	switch p {
+	case AllowNegative:
+		return "allow"
+	case ClampNegative:
+		return "clamp"
+	case RejectNegative:
+		return "reject"
+	}
+	return "unknown policy"
+}
+
+// DefaultRemainderIntervals is the number of intervals a negative remainder
+// is carried over without its key being sent again, see SetRemainderIntervals
+const DefaultRemainderIntervals = 10
+
+// NegativeCounterError is passed to the error handler of the buffered client
+// when a negative counter is dropped, see RejectNegative, or when the negative
+// remainder of a clamped counter expires before it's compensated, see
+// SetRemainderIntervals
+type NegativeCounterError struct {
+	Key     string
+	Value   int64
+	Expired bool // the remainder of a clamped counter
+}
+
+func (e *NegativeCounterError) Error() string {
+	if e.Expired {
+		return fmt.Sprintf("statsd: negative remainder of counter %s expired (%d)", e.Key, e.Value)
+	}
+	return fmt.Sprintf("statsd: negative counter %s dropped (%d)", e.Key, e.Value)
+}
+
+// negativeRemainder is the negative sum carried over by a clamped counter
+type negativeRemainder struct {
+	value int64
+	idle  int // the intervals since it was carried over, see expireRemainders
+}
+
+// SetNegativeCounters selects what happens to the counters whose sum over an
+// interval is negative, AllowNegative by default
+func (sb *buffer) SetNegativeCounters(policy NegativePolicy) {
+	atomic.StoreInt32(&sb.negativePolicy, int32(policy))
+}
+
+// SetRemainderIntervals sets the number of intervals the negative remainder of
+// a clamped counter (see ClampNegative) is carried over while its key isn't
+// sent: it's dropped afterwards, passing a *NegativeCounterError to the error
+// handler. 0 or less restores DefaultRemainderIntervals
+func (sb *buffer) SetRemainderIntervals(n int) {
+	if n <= 0 {
+		n = DefaultRemainderIntervals
+	}
+	atomic.StoreInt32(&sb.remainderIntervals, int32(n))
+}
+
+// remainderIntervalsValue returns the effective number of intervals of the
+// negative remainders, see SetRemainderIntervals
+func (sb *buffer) remainderIntervalsValue() int {
+	if n := int(atomic.LoadInt32(&sb.remainderIntervals)); n > 0 {
+		return n
+	}
+	return DefaultRemainderIntervals
+}
+
+// checkNegative applies the policy to a counter about to be flushed, carrying
+// over the remainder of the previous intervals first. It returns false if the
+// counter must be dropped. It's only called from within the collector
+func (sb *buffer) checkNegative(key string, e *event.Increment) bool {
+	if remainder, ok := sb.remainders[key]; ok {
+		delete(sb.remainders, key)
+		// saturated like the other updates of the counter
+		sb.merge(key, e, &event.Increment{Name: key, Value: remainder.value})
+	}
+	if e.Value >= 0 {
+		return true
+	}
+	switch NegativePolicy(atomic.LoadInt32(&sb.negativePolicy)) {
+	case ClampNegative:
+		if sb.remainders == nil {
+			sb.remainders = make(map[string]*negativeRemainder)
+		}
+		sb.remainders[key] = &negativeRemainder{value: e.Value}
+		e.Value = 0
+	case RejectNegative:
+		err := &NegativeCounterError{Key: key, Value: e.Value}
+		sb.Logger.Println(err)
+		sb.handleError(err)
+		return false
+	}
+	return true
+}
+
+// expireRemainders drops the negative remainders carried over for
+// remainderIntervals without their key being sent, reporting them, before the
+// counters about to be flushed reclaim theirs. It's only called from within
+// the collector
+func (sb *buffer) expireRemainders(flushed map[string]event.Event) {
+	n := sb.remainderIntervalsValue()
+	for key, r := range sb.remainders {
+		if _, ok := flushed[key]; ok {
+			continue
+		}
+		if r.idle++; r.idle < n {
+			continue
+		}
+		delete(sb.remainders, key)
+		err := &NegativeCounterError{Key: key, Value: r.value, Expired: true}
+		sb.Logger.Println(err)
+		sb.handleError(err)
+	}
+}
@@ -0,0 +1,122 @@
+package statsd
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestNegativeCounters(t *testing.T) {
+	intervals := [][]int64{{1, -3}, {1}, {5}, {-1}}
+	tests := []struct {
+		policy   NegativePolicy
+		expected []string // the line of x per interval, "" if none
+		errors   int
+	}{
+		{policy: AllowNegative, expected: []string{"x:-2|c", "x:1|c", "x:5|c", "x:-1|c"}},
+		{policy: ClampNegative, expected: []string{"x:0|c", "x:0|c", "x:4|c", "x:0|c"}},
+		{policy: RejectNegative, expected: []string{"", "x:1|c", "x:5|c", ""}, errors: 2},
+	}
+	for _, tt := range tests {
+		client, conn := newPacketClient(t, "")
+		clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+		client.SetClock(clock)
+		buffered := NewStatsdBuffer(time.Second, client)
+		buffered.Logger = discardLogger{}
+		buffered.SetNegativeCounters(tt.policy)
+		var mu sync.Mutex
+		var errs []error
+		buffered.SetErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		})
+
+		for i, counts := range intervals {
+			for _, count := range counts {
+				buffered.Incr("x", count)
+			}
+			buffered.Gauge("interval", int64(i))
+			clock.Advance(time.Second)
+			waitUntil(t, time.Second, func() bool { return len(conn.sent()) == i+1 })
+			line := ""
+			for _, l := range strings.Split(conn.sent()[i], "\n") {
+				if strings.HasPrefix(l, "x:") {
+					line = l
+				}
+			}
+			if line != tt.expected[i] {
+				t.Errorf("%s, interval %d: expected %q, actual %q", tt.policy, i, tt.expected[i], line)
+			}
+		}
+		buffered.Close()
+		mu.Lock()
+		if len(errs) != tt.errors {
+			t.Errorf("%s: expected %d errors, actual %v", tt.policy, tt.errors, errs)
+		}
+		for _, err := range errs {
+			if err, ok := err.(*NegativeCounterError); !ok || err.Key != "x" || err.Value >= 0 {
+				t.Errorf("%s: unexpected error %v", tt.policy, err)
+			}
+		}
+		mu.Unlock()
+	}
+}
+
+func TestNegativeRemainders(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	defer buffered.Close()
+	buffered.Logger = discardLogger{}
+	buffered.SetNegativeCounters(ClampNegative)
+	buffered.SetRemainderIntervals(2)
+	errs := make(chan error, 10)
+	buffered.SetErrorHandler(func(err error) { errs <- err })
+	interval := func(i int, counts ...int64) string {
+		t.Helper()
+		for _, count := range counts {
+			buffered.Incr("x", count)
+		}
+		buffered.Gauge("interval", int64(i))
+		clock.Advance(time.Second)
+		waitUntil(t, time.Second, func() bool { return len(conn.sent()) == i+1 })
+		for _, l := range strings.Split(conn.sent()[i], "\n") {
+			if strings.HasPrefix(l, "x:") {
+				return l
+			}
+		}
+		return ""
+	}
+
+	// the remainder saturates instead of wrapping around
+	interval(0, math.MinInt64+1)
+	interval(1, -2)
+	if err := <-errs; !errors.Is(err, ErrCounterOverflow) {
+		t.Errorf("expected an overflow, actual %v", err)
+	}
+	// the remainder expires after 2 intervals without the key, even if there's
+	// nothing to flush
+	interval(2)
+	clock.Advance(time.Second)
+	select {
+	case err := <-errs:
+		if err, ok := err.(*NegativeCounterError); !ok || !err.Expired || err.Key != "x" || err.Value != math.MinInt64 {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the expiry wasn't reported")
+	}
+	if line := interval(3, 5); line != "x:5|c" {
+		t.Errorf("expected the remainder expired, actual %q", line)
+	}
+	if n := buffered.Config().RemainderIntervals; n != 2 {
+		t.Errorf("expected 2 intervals, actual %d", n)
+	}
+}
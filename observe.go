@@ -0,0 +1,116 @@
+package statsd
+
+import (
+	"time"
+)
+
+// default suffixes of the metrics of Observe, see SetObserveNames
+const (
+	DefaultSuccessSuffix = ".success"
+	DefaultErrorSuffix   = ".error"
+	DefaultLatencySuffix = ".latency"
+)
+
+// observeNames is an immutable set of suffixes, swapped atomically
+type observeNames struct {
+	success string
+	failure string
+	latency string
+	split   bool // the latency is suffixed with the outcome as well
+}
+
+var defaultObserveNames = &observeNames{success: DefaultSuccessSuffix, failure: DefaultErrorSuffix, latency: DefaultLatencySuffix}
+
+// SetObserveNames sets the suffixes of the metrics sent by Observe, by default
+// DefaultSuccessSuffix, DefaultErrorSuffix and DefaultLatencySuffix. If
+// splitLatency is true the latency is tracked per outcome as well, e.g.
+// stat.latency.error
+func (c *StatsdClient) SetObserveNames(success string, failure string, latency string, splitLatency bool) {
+	c.observe.Store(&observeNames{
+		success: Escape(FieldName, success),
+		failure: Escape(FieldName, failure),
+		latency: Escape(FieldName, latency),
+		split:   splitLatency,
+	})
+}
+
+func (c *StatsdClient) observeNames() *observeNames {
+	if names, _ := c.observe.Load().(*observeNames); names != nil {
+		return names
+	}
+	return defaultObserveNames
+}
+
+// observe sends the metrics of Observe through s, returning the first error
+func observe(s Statsd, names *observeNames, stat string, elapsed time.Duration, err error) error {
+	outcome, latency := names.success, stat+names.latency
+	if err != nil {
+		outcome = names.failure
+	}
+	if names.split {
+		latency += outcome
+	}
+	err = s.Incr(stat+outcome, 1)
+	if err2 := s.PrecisionTiming(latency, elapsed); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// observer is implemented by the clients which track the outcome of the calls
+// with their own clock and suffixes
+type observer interface {
+	Observe(stat string, start time.Time, err error) error
+	ObserveFunc(stat string, fn func() error) error
+}
+
+// observeOn is the Observe of s if it has one, or else observe with the
+// default suffixes and the real clock
+func observeOn(s Statsd, stat string, start time.Time, err error) error {
+	if o, ok := s.(observer); ok {
+		return o.Observe(stat, start, err)
+	}
+	return observe(s, defaultObserveNames, stat, time.Since(start), err)
+}
+
+// observeFuncOn is the ObserveFunc of s if it has one, see observeOn
+func observeFuncOn(s Statsd, stat string, fn func() error) error {
+	if o, ok := s.(observer); ok {
+		return o.ObserveFunc(stat, fn)
+	}
+	start := time.Now()
+	err := fn()
+	observeOn(s, stat, start, err)
+	return err
+}
+
+// Observe tracks the outcome of a fallible call started at start: stat.success
+// or stat.error (if err isn't nil) is incremented, and the time elapsed is
+// tracked as stat.latency, see SetObserveNames
+func (c *StatsdClient) Observe(stat string, start time.Time, err error) error {
+	return observe(c, c.observeNames(), stat, c.now().Sub(start), err)
+}
+
+// ObserveFunc calls fn and tracks its outcome like Observe, returning the
+// error of fn
+func (c *StatsdClient) ObserveFunc(stat string, fn func() error) error {
+	start := c.now()
+	err := fn()
+	c.Observe(stat, start, err)
+	return err
+}
+
+// Observe tracks the outcome of a fallible call started at start, see
+// StatsdClient.Observe. The counters and the latencies are aggregated
+func (sb *buffer) Observe(stat string, start time.Time, err error) error {
+	return observe(sb, sb.statsd.observeNames(), stat, sb.statsd.now().Sub(start), err)
+}
+
+// ObserveFunc calls fn and tracks its outcome like Observe, returning the
+// error of fn
+func (sb *buffer) ObserveFunc(stat string, fn func() error) error {
+	start := sb.statsd.now()
+	err := fn()
+	sb.Observe(stat, start, err)
+	return err
+}
@@ -0,0 +1,68 @@
+package statsd
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestObserve(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+
+	start := clock.Now()
+	clock.Advance(25 * time.Millisecond)
+	client.Observe("db.query", start, nil)
+	client.Observe("db.query", start, errors.New("timeout"))
+	failure := errors.New("refused")
+	if err := client.ObserveFunc("rpc", func() error {
+		clock.Advance(time.Millisecond)
+		return failure
+	}); err != failure {
+		t.Errorf("expected the error of fn, actual %v", err)
+	}
+
+	client.SetObserveNames("_ok", "_ko", "_ms", true)
+	client.ObserveFunc("rpc", func() error { return nil })
+
+	expected := []string{
+		"myproject.db.query.success:1|c",
+		"myproject.db.query.latency:25|ms",
+		"myproject.db.query.error:1|c",
+		"myproject.db.query.latency:25|ms",
+		"myproject.rpc.error:1|c",
+		"myproject.rpc.latency:1|ms",
+		"myproject.rpc_ok:1|c",
+		"myproject.rpc_ms_ok:0|ms",
+	}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestObserveFallback(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetObserveNames("_ok", "_ko", "_ms", false)
+	router := NewRouter(client)
+	router.Observe("db.query", time.Now(), nil)
+	// a Statsd without Observe gets the default suffixes
+	fallback := NewRouter(methodsOnly{client})
+	if err := fallback.ObserveFunc("rpc", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	sent := conn.sent()
+	expected := []string{"myproject.db.query_ok:1|c", "myproject.db.query_ms:", "myproject.rpc.success:1|c", "myproject.rpc.latency:"}
+	if len(sent) != len(expected) {
+		t.Fatalf("expected %q, actual %q", expected, sent)
+	}
+	for i, line := range sent {
+		if !strings.HasPrefix(line, expected[i]) {
+			t.Errorf("expected %q, actual %q", expected[i], line)
+		}
+	}
+}
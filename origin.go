@@ -0,0 +1,114 @@
+package statsd
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// origin holds the DogStatsD origin detection fields, see SetContainerID
+type origin struct {
+	containerID  string
+	externalData string
+	suffix       string // appended to every line, e.g. "|c:abc|e:it-false"
+}
+
+// SetContainerID makes the client append the container ID field (|c:id) to
+// every line, for the DogStatsD agents which tag the metrics by origin, e.g.
+// with the ID found by DetectContainerID. It applies to the stats aggregated
+// by a buffered client as well, but not to the lines of WriteRaw. An empty id
+// removes the field
+func (c *StatsdClient) SetContainerID(id string) {
+	c.updateOrigin(func(o *origin) { o.containerID = id })
+}
+
+// SetExternalData makes the client append the external data field (|e:data) to
+// every line, as given to the application by the Datadog admission controller
+// (DD_EXTERNAL_ENV), see SetContainerID. Empty data removes the field
+func (c *StatsdClient) SetExternalData(data string) {
+	c.updateOrigin(func(o *origin) { o.externalData = data })
+}
+
+// updateOrigin atomically replaces the origin fields with a modified copy
+func (c *StatsdClient) updateOrigin(update func(o *origin)) {
+	c.originMu.Lock()
+	defer c.originMu.Unlock()
+	o := &origin{}
+	if old, _ := c.origin.Load().(*origin); old != nil {
+		*o = *old
+	}
+	update(o)
+	o.suffix = ""
+	if o.containerID != "" {
+		o.suffix += "|c:" + Escape(FieldTagValue, o.containerID)
+	}
+	if o.externalData != "" {
+		// the external data is a list of comma-separated fields
+		o.suffix += "|e:" + Escape(FieldName, o.externalData)
+	}
+	c.origin.Store(o)
+}
+
+// originSuffix returns the fields appended to every line
+func (c *StatsdClient) originSuffix() string {
+	if o, _ := c.origin.Load().(*origin); o != nil {
+		return o.suffix
+	}
+	return ""
+}
+
+// container IDs as found in the cgroup paths: the 64 hex digits of docker and
+// containerd, the 32 hex digits followed by a number of ECS tasks, and the
+// UUIDs of Cloud Foundry and CRI-O
+var containerIDPattern = regexp.MustCompile(`([0-9a-f]{64})|([0-9a-f]{32}-[0-9]+)|([0-9a-f]{8}(-[0-9a-f]{4}){4})`)
+
+// mountinfo paths holding the container ID, e.g. /var/lib/docker/containers/<id>/hostname
+var mountinfoPattern = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+
+// DetectContainerID returns the ID of the container the process runs in,
+// found in /proc/self/cgroup (cgroup v1) or /proc/self/mountinfo (cgroup v2),
+// or an empty string outside of a container. It's meant for SetContainerID
+func DetectContainerID() string {
+	return detectContainerID("/proc/self/cgroup", "/proc/self/mountinfo")
+}
+
+func detectContainerID(cgroupPath string, mountinfoPath string) string {
+	if id := scanFile(cgroupPath, func(line string) string {
+		// hierarchy-ID:controllers:path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 3 {
+			return ""
+		}
+		path := fields[2]
+		return containerIDPattern.FindString(path[strings.LastIndex(path, "/")+1:])
+	}); id != "" {
+		return id
+	}
+	return scanFile(mountinfoPath, func(line string) string {
+		if strings.Contains(line, "/sandboxes/") {
+			// the ID of the pause container
+			return ""
+		}
+		if m := mountinfoPattern.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+		return ""
+	})
+}
+
+// scanFile returns the first non-empty result of match over the lines of a file
+func scanFile(path string, match func(line string) string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := match(scanner.Text()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
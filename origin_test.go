@@ -0,0 +1,102 @@
+package statsd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+func TestOriginFields(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetContainerID("abc|def")
+	client.SetExternalData("it-false,cn-app")
+	client.SetSampleRate(0.5)
+	client.random = func() float64 { return 0 }
+
+	client.Incr("a", 1)
+	client.Gauge("g", -1)
+	client.IncrMap(map[string]int64{"b": 2})
+	client.SetContainerID("")
+	client.Total("t", 3)
+	client.SetExternalData("")
+	client.Total("t", 4)
+
+	expected := []string{
+		"myproject.a:1|c|@0.5|c:abc_def|e:it-false,cn-app",
+		"myproject.g:0|g|c:abc_def|e:it-false,cn-app",
+		"myproject.g:-1|g|c:abc_def|e:it-false,cn-app",
+		"myproject.b:2|c|@0.5|c:abc_def|e:it-false,cn-app",
+		"myproject.t:3|t|e:it-false,cn-app",
+		"myproject.t:4|t",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+	m, err := wire.ParseLine([]byte(expected[0]))
+	if err != nil || m.SampleRate != 0.5 || m.ContainerID != "abc_def" || m.ExternalData != "it-false,cn-app" {
+		t.Errorf("unexpected metric %+v (%v)", m, err)
+	}
+}
+
+func TestBufferOriginFields(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetContainerID("abc")
+	// room for the gauge group and one more line
+	client.SetMaxPacketSize(80)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Gauge("g", -1)
+	buffered.Incr("a", 1)
+	buffered.Incr("b", 2)
+	buffered.Close()
+
+	expected := []string{
+		"myproject.a:1|c|c:abc\nmyproject.b:2|c|c:abc",
+		"myproject.g:0|g|c:abc\nmyproject.g:-1|g|c:abc",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestDetectContainerID(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, lines ...string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	id := strings.Repeat("3726184226f5", 5) + "abcd"
+	cgroupV1 := write("cgroup1",
+		"12:memory:/docker/"+id,
+		"11:cpu,cpuacct:/docker/"+id)
+	kubepods := write("cgroup2",
+		"0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-"+id+".scope")
+	cgroupV2 := write("cgroup3", "0::/")
+	mountinfo := write("mountinfo",
+		"1 2 0:1 / / rw - overlay overlay rw",
+		"3 1 8:1 /var/lib/docker/containers/"+id+"/hostname /etc/hostname rw - ext4 /dev/sda1 rw")
+	empty := write("empty", "")
+
+	tests := []struct {
+		cgroup, mountinfo, expected string
+	}{
+		{cgroupV1, empty, id},
+		{kubepods, empty, id},
+		{cgroupV2, mountinfo, id},
+		{cgroupV2, empty, ""},
+		{filepath.Join(dir, "missing"), mountinfo, id},
+	}
+	for _, tt := range tests {
+		if actual := detectContainerID(tt.cgroup, tt.mountinfo); actual != tt.expected {
+			t.Errorf("%s, %s: expected %q, actual %q", tt.cgroup, tt.mountinfo, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package statsd
+
+import (
+	"fmt"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// ErrCounterOverflow is the error unwrapped from an *OverflowError
+var ErrCounterOverflow = event.ErrCounterOverflow
+
+// OverflowError is passed to the error handler of the buffered client the
+// first time in an interval the aggregated value of a counter exceeds the
+// int64 range: the counter saturates instead of wrapping around
+type OverflowError struct {
+	Key string
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("statsd: counter %s saturated at the int64 range", e.Key)
+}
+
+// Unwrap returns ErrCounterOverflow
+func (e *OverflowError) Unwrap() error {
+	return ErrCounterOverflow
+}
+
+// merge updates the pending event of the key with e, reporting the first
+// overflow of the key in the interval. It's only called from within the collector
+func (sb *buffer) merge(key string, pending event.Event, e event.Event) {
+	if pending.Update(e) != event.ErrCounterOverflow || sb.overflows[key] {
+		return
+	}
+	if sb.overflows == nil {
+		sb.overflows = make(map[string]bool)
+	}
+	sb.overflows[key] = true
+	err := &OverflowError{Key: key}
+	sb.Logger.Println(err)
+	sb.handleError(err)
+}
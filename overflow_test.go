@@ -0,0 +1,58 @@
+package statsd
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestBufferCounterOverflow(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	var mu sync.Mutex
+	var errs []error
+	buffered.SetErrorHandler(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	defer buffered.Close()
+
+	// exactly at the boundary
+	buffered.Incr("exact", math.MaxInt64-1)
+	buffered.Incr("exact", 1)
+	// reported once per key per interval
+	for i := 0; i < 3; i++ {
+		buffered.Incr("requests", math.MaxInt64/2)
+	}
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == 1 })
+	expected := []string{"myproject.exact:9223372036854775807|c\nmyproject.requests:9223372036854775807|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	mu.Lock()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrCounterOverflow) || errs[0].(*OverflowError).Key != "myproject.requests" {
+		t.Errorf("expected an overflow of myproject.requests, actual %v", errs)
+	}
+	mu.Unlock()
+
+	// and again in the next interval
+	buffered.Incr("requests", math.MaxInt64)
+	buffered.Incr("requests", 1)
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == 2 })
+	mu.Lock()
+	if len(errs) != 2 {
+		t.Errorf("expected another overflow, actual %v", errs)
+	}
+	mu.Unlock()
+}
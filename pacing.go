@@ -0,0 +1,92 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// SetPacing spreads the packets of every flush evenly over window, instead of
+// sending them back-to-back, so that a large interval doesn't end with a burst
+// of packets: with 200 packets and a window of 2s, one packet every 10ms. The
+// packets are built and ordered as usual. The final flush of Close isn't paced,
+// and Close cuts short the pacing of the flush in progress. Keep the window well
+// under the flush interval, a flush still in progress skips the next tick.
+// Graphite mode, which writes the events one by one, isn't paced. 0 disables
+// it, and so do the windows under minPacingWindow, too short to pace anything
+func (sb *buffer) SetPacing(window time.Duration) {
+	if window < minPacingWindow {
+		window = 0
+	}
+	atomic.StoreInt64(&sb.pacing, int64(window))
+}
+
+const (
+	// the shortest pacing window, see SetPacing
+	minPacingWindow = time.Millisecond
+	// the shortest interval between two paced packets: with more packets than
+	// fit in the window, the pacing takes longer than the window
+	minPacingInterval = 10 * time.Microsecond
+)
+
+// pace is the pacing of a flush
+type pace struct {
+	window time.Duration
+	hurry  chan struct{} // closed to send the packets left right away
+}
+
+// sendEventsPaced is sendEvents for the buffered client (the event keys are
+// prefixed metric names), spreading the packets over the window of the pace.
+// The packets are built under the lock, then c.mu is released between the
+// writes, so that the other sends go on meanwhile
+func (c *StatsdClient) sendEventsPaced(events []event.Event, report *FlushReport, pace *pace) error {
+	c.mu.Lock()
+	if c.closed || c.conn == nil {
+		c.mu.Unlock()
+		return c.sendEvents(events, true, report)
+	}
+	p := c.newPacker()
+	p.report = report
+	p.holding = true
+	for _, e := range events {
+		p.addEvent(e.Key(), e)
+		p.count(e.Key(), kindOf(e))
+	}
+	p.writeRest()
+	c.mu.Unlock()
+
+	var tick <-chan time.Time
+	if len(p.held) > 1 {
+		interval := pace.window / time.Duration(len(p.held))
+		if interval < minPacingInterval {
+			interval = minPacingInterval
+		}
+		t, stop := c.newTicker(interval)
+		defer stop()
+		tick = t
+	}
+	for i, packet := range p.held {
+		if i > 0 {
+			start := time.Now()
+			select {
+			case <-tick:
+			case <-pace.hurry:
+			}
+			if report != nil {
+				report.Pacing += time.Since(start)
+			}
+		}
+		c.mu.Lock()
+		switch {
+		case c.closed:
+			p.settle(packet.data, packet.groups, ErrClosed)
+		case c.conn == nil:
+			p.settle(packet.data, packet.groups, errNotConnected)
+		default:
+			p.send(packet.data, packet.groups)
+		}
+		c.mu.Unlock()
+	}
+	return p.result()
+}
@@ -0,0 +1,114 @@
+package statsd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// newPacedBuffer returns a buffered client flushing 5 packets every second,
+// paced over 500ms
+func newPacedBuffer(t *testing.T) (*StatsdBuffer, *packetConn, *statsdtest.FakeClock) {
+	client, conn := newPacketClient(t, "")
+	client.SetMaxPacketSize(13)
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	buffered.SetPacing(500 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		buffered.Incr(fmt.Sprintf("c%d", i), 1)
+	}
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 10 })
+	return buffered, conn, clock
+}
+
+// the lines of all the packets, in order
+func sentLines(conn *packetConn) []string {
+	var lines []string
+	for _, packet := range conn.sent() {
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	return lines
+}
+
+func TestPacing(t *testing.T) {
+	buffered, conn, clock := newPacedBuffer(t)
+	defer buffered.Close()
+	reports := make(chan FlushReport, 1)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+
+	clock.Advance(time.Second)
+	for n := 1; n <= 5; n++ {
+		waitUntil(t, time.Second, func() bool { return len(conn.sent()) == n })
+		if n == 5 {
+			break
+		}
+		// one packet every 100ms
+		clock.Advance(99 * time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		if sent := len(conn.sent()); sent != n {
+			t.Fatalf("expected %d packets after %dms, actual %d", n, (n-1)*100+99, sent)
+		}
+		clock.Advance(time.Millisecond)
+	}
+	expected := []string{"c0:1|c", "c1:1|c", "c2:1|c", "c3:1|c", "c4:1|c", "c5:1|c", "c6:1|c", "c7:1|c", "c8:1|c", "c9:1|c"}
+	if actual := sentLines(conn); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	if r := <-reports; r.Packets != 5 || r.Pacing <= 0 || r.Err != nil {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if cfg := buffered.Config(); cfg.Pacing != 500*time.Millisecond || cfg.Validate() != nil {
+		t.Errorf("unexpected configuration %s: %v", cfg, cfg.Validate())
+	}
+	buffered.SetPacing(time.Second)
+	if err := buffered.Config().Validate(); err == nil || !strings.Contains(err.Error(), "pacing") {
+		t.Errorf("expected a problem about the pacing, actual %v", err)
+	}
+}
+
+func TestPacingClose(t *testing.T) {
+	buffered, conn, clock := newPacedBuffer(t)
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == 1 })
+	buffered.Incr("late", 1)
+
+	// the packets left and the final flush go out right away
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"c0:1|c", "c1:1|c", "c2:1|c", "c3:1|c", "c4:1|c", "c5:1|c", "c6:1|c", "c7:1|c", "c8:1|c", "c9:1|c", "late:1|c"}
+	if actual := sentLines(conn); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+// a window shorter than the packets doesn't make a zero interval
+func TestPacingShortWindow(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.SetMaxPacketSize(13)
+	events := make([]event.Event, 10)
+	for i := range events {
+		events[i] = &event.Increment{Name: fmt.Sprintf("c%d", i), Value: 1}
+	}
+	if err := client.sendEventsPaced(events, nil, &pace{window: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sentLines(conn)); n != 10 {
+		t.Errorf("expected 10 lines, actual %d", n)
+	}
+
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+	buffered.SetPacing(time.Nanosecond)
+	if pacing := buffered.Config().Pacing; pacing != 0 {
+		t.Errorf("expected the pacing to be disabled, actual %s", pacing)
+	}
+}
@@ -0,0 +1,282 @@
+package statsd
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// packer packs groups of lines into packets with the wire.Packer of the
+// client, writing a packet out before a group would make it exceed the maximum
+// packet size, so the packets are built in a single pass. The lines of a group
+// (e.g. the reset and the value of a negative gauge) are never split across
+// packets. The caller must hold c.mu for the whole lifetime of the packer,
+// since the buffers are shared by all the sends
+type packer struct {
+	c      *StatsdClient
+	groups []packedGroup // the groups waiting in the buffer
+	failed map[string]error
+	// the groups of lines of the keys failed, and the keys partly delivered,
+	// see settle
+	lost          map[string][][]byte
+	partial       map[string]bool
+	lastDelivered string
+	report        *FlushReport // accounts for the packets written, if not nil
+	// the metrics added per kind, and per key and kind to move the ones of the keys
+	// failed to their outcome, counted in StatsByKind once their packets are
+	// written
+	counted [numKinds]int64
+	keys    map[packedKey]int64
+	// if holding, the packets are kept in held instead of being written, see
+	// sendEventsPaced
+	holding bool
+	held    []heldPacket
+}
+
+// heldPacket is a packet built but not written yet, with its groups
+type heldPacket struct {
+	data   []byte
+	groups []packedGroup
+}
+
+// packedGroup is a group of lines of key in a packet, ending at offset end
+type packedGroup struct {
+	key string
+	end int
+}
+
+// packedKey is a key with a kind of metric added with its groups
+type packedKey struct {
+	key  string
+	kind MetricKind
+}
+
+func (c *StatsdClient) newPacker() *packer {
+	c.packer.Reset()
+	// appended to every line, see SetContainerID
+	c.packer.Suffix = c.originSuffix()
+	return &packer{c: c}
+}
+
+// add appends a group of lines of key, without its trailing newline, to the
+// packet, writing out the groups before it if they can't share the packet
+func (p *packer) add(key string, group []byte) {
+	if len(group) == 0 {
+		return
+	}
+	// the downshifts lower the packet size while packing
+	p.c.packer.MaxSize = p.c.payloadSize()
+	if packet := p.c.packer.Add(group); packet != nil {
+		p.write(packet)
+	}
+	p.groups = append(p.groups, packedGroup{key: key, end: p.c.packer.Len()})
+}
+
+// addEvent serializes the lines of an event and adds them, one group per line
+// unless the event is an event.Grouper
+func (p *packer) addEvent(key string, e event.Event) {
+	c := p.c
+	c.scratch = event.AppendStatsPrecision(c.scratch[:0], "", e, c.precision())
+	if g, ok := e.(event.GroupSizer); ok {
+		c.groupSizes = g.AppendGroupSizes(c.groupSizes[:0])
+		lines := c.scratch
+		for _, size := range c.groupSizes {
+			n := 0
+			for ; size > 0 && n < len(lines); size-- {
+				n += bytes.IndexByte(lines[n:], '\n') + 1
+			}
+			p.add(key, bytes.TrimSuffix(lines[:n], []byte{'\n'}))
+			lines = lines[n:]
+		}
+		return
+	}
+	if g, ok := e.(event.Grouper); ok {
+		// the groups of a Grouper are made of consecutive lines
+		lines := c.scratch
+		for _, group := range g.Groups() {
+			n := len(group) // the newlines
+			for _, stat := range group {
+				n += len(stat)
+			}
+			if n > len(lines) {
+				n = len(lines)
+			}
+			p.add(key, bytes.TrimSuffix(lines[:n], []byte{'\n'}))
+			lines = lines[n:]
+		}
+		return
+	}
+	for lines := c.scratch; len(lines) > 0; {
+		i := bytes.IndexByte(lines, '\n')
+		p.add(key, lines[:i])
+		lines = lines[i+1:]
+	}
+}
+
+// fail records the error of a key
+func (p *packer) fail(key string, err error) {
+	if p.failed == nil {
+		p.failed = make(map[string]error)
+	}
+	p.failed[key] = err
+}
+
+// write sends a packet, or holds it if holding
+func (p *packer) write(packet []byte) {
+	if p.holding {
+		p.held = append(p.held, heldPacket{data: append([]byte(nil), packet...), groups: append([]packedGroup(nil), p.groups...)})
+	} else {
+		p.send(packet, p.groups)
+	}
+	p.groups = p.groups[:0]
+}
+
+// send writes a packet, see settle
+func (p *packer) send(packet []byte, groups []packedGroup) {
+	start := time.Now()
+	err := p.c.writeLine(packet)
+	p.report.written(packet, time.Since(start))
+	p.settle(packet, groups, err)
+}
+
+// settle records the outcome of the write of a packet: the error is reported
+// for the keys of the groups which weren't delivered, all of them unless a
+// downshift delivered some (see partialWriteError), and the lines of these
+// groups are kept, so that a key split across packets can be retried without
+// its lines already delivered. The packets must be settled in order: the
+// groups of a key are consecutive, so it was partly delivered if one of its
+// groups meets a group of the other outcome
+func (p *packer) settle(packet []byte, groups []packedGroup, err error) {
+	if len(groups) == 0 {
+		return
+	}
+	if err == nil {
+		if key := groups[0].key; p.failed[key] != nil {
+			p.markPartial(key)
+		}
+		p.lastDelivered = groups[len(groups)-1].key
+		return
+	}
+	failed := undelivered(packet, err)
+	if partial, ok := err.(*partialWriteError); ok {
+		err = partial.err
+	}
+	start := 0
+	for _, g := range groups {
+		lost := false
+		for _, s := range failed {
+			if s.start < g.end && start < s.end {
+				lost = true
+				break
+			}
+		}
+		switch {
+		case lost:
+			if p.lastDelivered == g.key {
+				p.markPartial(g.key)
+			}
+			p.fail(g.key, err)
+			if p.lost == nil {
+				p.lost = make(map[string][][]byte)
+			}
+			p.lost[g.key] = append(p.lost[g.key], append([]byte(nil), packet[start:g.end]...))
+		case p.failed[g.key] != nil:
+			p.markPartial(g.key)
+			p.lastDelivered = g.key
+		default:
+			p.lastDelivered = g.key
+		}
+		start = g.end + 1
+	}
+}
+
+// markPartial records a key of which some lines were delivered, see settle
+func (p *packer) markPartial(key string) {
+	if p.partial == nil {
+		p.partial = make(map[string]bool)
+	}
+	p.partial[key] = true
+}
+
+// count records a metric added with the groups of key, see StatsByKind
+func (p *packer) count(key string, kind MetricKind) {
+	if kind < 0 || kind >= numKinds {
+		return
+	}
+	if p.keys == nil {
+		p.keys = make(map[packedKey]int64)
+	}
+	p.keys[packedKey{key, kind}]++
+	p.counted[kind]++
+}
+
+// flush writes out the groups left in the buffer, and returns a MapError
+// reporting the keys which failed, if any
+func (p *packer) flush() error {
+	p.writeRest()
+	return p.result()
+}
+
+// writeRest writes out the groups left in the buffer
+func (p *packer) writeRest() {
+	if packet := p.c.packer.Flush(); packet != nil {
+		p.write(packet)
+	}
+}
+
+// result counts the metrics added, and returns a MapError reporting the keys
+// which failed, if any
+func (p *packer) result() error {
+	var outcomes [numKinds][numOutcomes]int64
+	for kind, n := range p.counted {
+		outcomes[kind][outcomeSent] = n
+	}
+	for key, err := range p.failed {
+		for kind := range outcomes {
+			n := p.keys[packedKey{key, MetricKind(kind)}]
+			outcomes[kind][outcomeSent] -= n
+			outcomes[kind][outcomeOf(err)] += n
+		}
+	}
+	for kind := range outcomes {
+		for o, n := range outcomes[kind] {
+			p.c.count(MetricKind(kind), outcome(o), n)
+		}
+	}
+	if len(p.failed) > 0 {
+		return &MapError{Errors: p.failed, lost: p.lost, partial: p.partial}
+	}
+	return nil
+}
+
+// sendLines writes the lines retained by a failed flush of the buffered
+// client, packed like the events, see StatsdBuffer.retain. They're
+// serialized already, with their origin fields
+func (c *StatsdClient) sendLines(retained []retainedLines, report *FlushReport) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := ErrClosed
+	switch {
+	case c.closed:
+	case !c.writable():
+		err = errNotConnected
+	default:
+		p := c.newPacker()
+		p.report = report
+		c.packer.Suffix = ""
+		for _, r := range retained {
+			for _, group := range r.groups {
+				p.add(r.key, group)
+			}
+		}
+		return p.flush()
+	}
+	errs := make(map[string]error, len(retained))
+	lost := make(map[string][][]byte, len(retained))
+	for _, r := range retained {
+		errs[r.key] = err
+		lost[r.key] = append(lost[r.key], r.groups...)
+	}
+	return &MapError{Errors: errs, lost: lost}
+}
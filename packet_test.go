@@ -0,0 +1,92 @@
+package statsd
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// randomEvents returns events of all the types, with their expected lines
+func randomEvents(r *rand.Rand, n int) ([]event.Event, []string) {
+	var events []event.Event
+	var lines []string
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("metric%d.%s", i, strings.Repeat("x", r.Intn(40)))
+		v := r.Int63n(2000) - 1000
+		var e event.Event
+		switch r.Intn(8) {
+		case 0:
+			e = &event.Increment{Name: name, Value: v}
+		case 1:
+			e = &event.Gauge{Name: name, Value: v}
+		case 2:
+			e = &event.GaugeDelta{Name: name, Value: v}
+		case 3:
+			e = &event.FGauge{Name: name, Value: float64(v) / 7}
+		case 4:
+			e = &event.Absolute{Name: name, Values: []int64{v, v + 1, v + 2}}
+		case 5:
+			e = &event.Total{Name: name, Value: v}
+		case 6:
+			t := event.NewTiming(name, v)
+			t.Update(event.NewTiming(name, v*2))
+			e = t
+		default:
+			e = &event.FAbsolute{Name: name, Values: []float64{float64(v) / 3}}
+		}
+		for _, stat := range e.Stats() {
+			lines = append(lines, "myproject."+stat)
+		}
+		events = append(events, e)
+	}
+	return events, lines
+}
+
+func TestPackerLimits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, size := range []int{128, 256, 600, DefaultMaxPacketSize} {
+		client, conn := newPacketClient(t, "myproject.")
+		client.SetMaxPacketSize(size)
+		events, expected := randomEvents(r, 300)
+		if err := client.SendEvents(events...); err != nil {
+			t.Fatal(err)
+		}
+		var received []string
+		for _, p := range conn.packets {
+			lines := strings.Split(p, "\n")
+			// only a group larger than the limit, alone, can exceed it
+			if len(p) > size && !(len(lines) == 2 && strings.HasSuffix(lines[0], ":0|g")) {
+				t.Errorf("size %d: packet of %d bytes", size, len(p))
+			}
+			for _, line := range lines {
+				if _, err := wire.ParseLine([]byte(line)); err != nil {
+					t.Errorf("size %d: line split in %q: %v", size, line, err)
+				}
+			}
+			received = append(received, lines...)
+		}
+		sort.Strings(expected)
+		sort.Strings(received)
+		if !reflect.DeepEqual(expected, received) {
+			t.Errorf("size %d: %d lines expected, %d received", size, len(expected), len(received))
+		}
+	}
+}
+
+func TestPackerOversizedGroup(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.SetMaxPacketSize(20)
+	name := strings.Repeat("n", 30)
+	client.SendEvents(&event.Increment{Name: "a", Value: 1}, &event.Gauge{Name: name, Value: -1}, &event.Increment{Name: "b", Value: 1})
+	// a group larger than the packets is sent whole, in a packet of its own
+	expected := []string{"a:1|c", name + ":0|g\n" + name + ":-1|g", "b:1|c"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
@@ -0,0 +1,177 @@
+package statsd
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// ErrOddPairs is returned by IncrWith, TimingWith and GaugeWith when the
+// key/value strings don't come in pairs
+var ErrOddPairs = errors.New("statsd: odd number of key/value strings")
+
+// pairSegments escapes the separators in a key or a value, so that each one
+// is a single segment of the name
+var pairSegments = strings.NewReplacer(".", "_", "|", "_", "\n", "_", "\r", "_", ":", "_")
+
+// withPairs appends the alternating keys and values to the stat name as
+// key.value segments, sorted by key (see event.SortTags) so that the same pairs
+// in any order name the same stat, e.g. "requests.method.GET.status.200".
+// Plain StatsD has no tags, so this is how a stat is broken down by dimension
+func withPairs(stat string, kv []string) (string, error) {
+	if len(kv)%2 != 0 {
+		return "", ErrOddPairs
+	}
+	if len(kv) == 0 {
+		return stat, nil
+	}
+	var buf [8]event.Tag
+	tags := buf[:0]
+	for i := 0; i < len(kv); i += 2 {
+		tags = append(tags, event.Tag{Key: kv[i], Value: kv[i+1]})
+	}
+	event.SortTags(tags)
+	return pairsName(stat, tags), nil
+}
+
+// pairsName appends the keys and values of the tags to the stat name as
+// key.value segments, in the order given
+func pairsName(stat string, tags []event.Tag) string {
+	n := len(stat)
+	for _, t := range tags {
+		n += 2 + len(t.Key) + len(t.Value)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	b.WriteString(stat)
+	for _, t := range tags {
+		writePair(&b, t.Key)
+		writePair(&b, t.Value)
+	}
+	return b.String()
+}
+
+// writePair writes a key or a value of pairsName as a segment of the name
+func writePair(b *strings.Builder, s string) {
+	b.WriteByte('.')
+	if s == "" {
+		// keeps the pairs aligned
+		s = "_"
+	}
+	pairSegments.WriteString(b, s)
+}
+
+// IncrWith increments the counter stat broken down by the alternating keys
+// and values in kv, e.g. IncrWith("requests", 1, "status", "200") increments
+// requests.status.200. Dots in the keys and values are replaced
+func (c *StatsdClient) IncrWith(stat string, count int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return c.Incr(stat, count)
+}
+
+// TimingWith tracks a duration broken down by the keys and values in kv, see IncrWith
+func (c *StatsdClient) TimingWith(stat string, delta time.Duration, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return c.PrecisionTiming(stat, delta)
+}
+
+// GaugeWith sets a gauge broken down by the keys and values in kv, see IncrWith
+func (c *StatsdClient) GaugeWith(stat string, value int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return c.Gauge(stat, value)
+}
+
+// IncrWith increments the counter stat broken down by the keys and values in
+// kv, see StatsdClient.IncrWith
+func (sb *buffer) IncrWith(stat string, count int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return sb.Incr(stat, count)
+}
+
+// TimingWith tracks a duration broken down by the keys and values in kv, see IncrWith
+func (sb *buffer) TimingWith(stat string, delta time.Duration, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return sb.PrecisionTiming(stat, delta)
+}
+
+// GaugeWith sets a gauge broken down by the keys and values in kv, see IncrWith
+func (sb *buffer) GaugeWith(stat string, value int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return sb.Gauge(stat, value)
+}
+
+// IncrWith increments the counter stat broken down by the keys and values in
+// kv, see StatsdClient.IncrWith
+func (r *Router) IncrWith(stat string, count int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return r.Incr(stat, count)
+}
+
+// TimingWith tracks a duration broken down by the keys and values in kv, see IncrWith
+func (r *Router) TimingWith(stat string, delta time.Duration, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return r.PrecisionTiming(stat, delta)
+}
+
+// GaugeWith sets a gauge broken down by the keys and values in kv, see IncrWith
+func (r *Router) GaugeWith(stat string, value int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return r.Gauge(stat, value)
+}
+
+// IncrWith increments the counter stat broken down by the keys and values in
+// kv, see StatsdClient.IncrWith
+func (s *Source) IncrWith(stat string, count int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return s.Incr(stat, count)
+}
+
+// TimingWith tracks a duration broken down by the keys and values in kv, see IncrWith
+func (s *Source) TimingWith(stat string, delta time.Duration, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return s.PrecisionTiming(stat, delta)
+}
+
+// GaugeWith sets a gauge broken down by the keys and values in kv, see IncrWith
+func (s *Source) GaugeWith(stat string, value int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return s.Gauge(stat, value)
+}
@@ -0,0 +1,51 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithPairs(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	if err := client.IncrWith("requests", 1, "status"); err != ErrOddPairs {
+		t.Errorf("expected ErrOddPairs, actual %v", err)
+	}
+	client.IncrWith("requests", 1)
+	client.IncrWith("requests", 2, "status", "200", "route", "/v1.2/users", "method", "")
+	client.TimingWith("latency", 1500*time.Microsecond, "method", "GET")
+	client.WithSource("plugin").GaugeWith("queue", -3, "shard", "a:b")
+	expected := []string{
+		"myproject.requests:1|c",
+		"myproject.requests.method._.route./v1_2/users.status.200:2|c",
+		"myproject.latency.method.GET:1.5|ms",
+		"myproject.plugin.queue.shard.a_b:0|g",
+		"myproject.plugin.queue.shard.a_b:-3|g",
+	}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+// the same pairs in any order are aggregated together
+func TestWithPairsOrder(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.IncrWith("requests", 1, "status", "200", "method", "GET")
+	buffered.IncrWith("requests", 2, "method", "GET", "status", "200")
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"myproject.requests.method.GET.status.200:3|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+func BenchmarkIncrWith(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		withPairs("requests", []string{"status", "200", "method", "GET"})
+	}
+}
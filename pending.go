@@ -0,0 +1,93 @@
+package statsd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// pendingChunk is the number of events copied by the collector per request of
+// ForEachPending, between which the intake goes on
+const pendingChunk = 256
+
+// pendingRequest asks the collector for the keys of the pending events, if
+// keys is nil, or for copies of the events of keys
+type pendingRequest struct {
+	keys  []string
+	reply chan []event.Event
+	list  chan []string
+}
+
+// ForEachPending calls fn with a copy of each aggregated event waiting for the
+// next flush, in key order, until fn returns false, without flushing them: e.g.
+// to export the current aggregates to another system. The keys are the ones
+// pending when it's called, including the events queued before the call, and
+// the events are copied by the collector in small chunks, so that a large
+// buffer doesn't hold up the intake: an event updated meanwhile may include
+// values sent after the call, and an event flushed meanwhile is skipped. The
+// events of custom types are skipped. fn is called from the calling goroutine
+func (sb *buffer) ForEachPending(fn func(key string, e event.Event) bool) error {
+	list := make(chan []string, 1)
+	if err := sb.requestPending(pendingRequest{list: list}); err != nil {
+		return err
+	}
+	keys := <-list
+	prefix, _ := sb.statsd.currentPrefix()
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > pendingChunk {
+			n = pendingChunk
+		}
+		reply := make(chan []event.Event, 1)
+		if err := sb.requestPending(pendingRequest{keys: keys[:n], reply: reply}); err != nil {
+			return err
+		}
+		for _, e := range <-reply {
+			key := e.Key()
+			if strings.HasPrefix(key, deferredPrefix) {
+				key = prefix + key[len(deferredPrefix):]
+				e.SetKey(key)
+			}
+			if !fn(key, e) {
+				return nil
+			}
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (sb *buffer) requestPending(req pendingRequest) error {
+	select {
+	case sb.peekChannel <- req:
+		return nil
+	case <-sb.done:
+		return ErrClosed
+	}
+}
+
+// peek answers a request of ForEachPending.
+// It's only called from within the collector
+func (sb *buffer) peek(req pendingRequest) {
+	if req.list != nil {
+		// include the events queued before the call
+		sb.drain()
+		keys := make([]string, 0, len(sb.events))
+		for k := range sb.events {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		req.list <- keys
+		return
+	}
+	events := make([]event.Event, 0, len(req.keys))
+	for _, k := range req.keys {
+		if e, ok := sb.events[k]; ok {
+			if c := event.Copy(e); c != nil {
+				events = append(events, c)
+			}
+		}
+	}
+	req.reply <- events
+}
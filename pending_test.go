@@ -0,0 +1,76 @@
+package statsd
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+func TestForEachPending(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	for i := 0; i < 1000; i++ {
+		buffered.Incr(fmt.Sprintf("c%04d", i), 1)
+	}
+	buffered.Gauge("g", 5)
+	buffered.Incr("c0000", 1)
+
+	var keys []string
+	buffered.ForEachPending(func(key string, e event.Event) bool {
+		keys = append(keys, key)
+		if key == "myproject.c0000" && e.Payload().(int64) != 2 {
+			t.Errorf("unexpected aggregate %s", e)
+		}
+		// the copies don't affect the pending events
+		e.Update(&event.Increment{Name: key, Value: 10})
+		return true
+	})
+	if len(keys) != 1001 || keys[0] != "myproject.c0000" || keys[1000] != "myproject.g" {
+		t.Errorf("unexpected keys, %d from %v", len(keys), keys[:1])
+	}
+
+	n := 0
+	buffered.ForEachPending(func(key string, e event.Event) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("expected the iteration to stop after 3 events, actual %d", n)
+	}
+	if len(conn.sent()) != 0 {
+		t.Error("the pending events were flushed")
+	}
+	buffered.Close()
+	if sent := conn.sent(); len(sent) == 0 || sent[0][:24] != "myproject.c0000:2|c\nmypr" {
+		t.Errorf("unexpected flush %q", sent)
+	}
+}
+
+func TestForEachPendingDuringIntake(t *testing.T) {
+	client, _ := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Millisecond, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				buffered.Incr(fmt.Sprintf("w%d.c%d", w, i%500), 1)
+				buffered.Timing(fmt.Sprintf("w%d.t", w), int64(i))
+			}
+		}(w)
+	}
+	for i := 0; i < 20; i++ {
+		buffered.ForEachPending(func(key string, e event.Event) bool {
+			e.Stats()
+			return true
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+package statsd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// deferredPrefix stands for the prefix in the keys aggregated by a buffered
+// client while a prefix provider is set: it's replaced by the current prefix
+// at flush time, see SetPrefixProvider
+const deferredPrefix = "\x00"
+
+// dynamicPrefix is the prefix returned by the provider, swapped atomically
+type dynamicPrefix struct {
+	prefix string
+}
+
+// SetPrefixProvider makes the client take its prefix from provider instead of
+// the one given to NewStatsdClient: provider is called right away, then every
+// refresh on the clock of the client, and its result (normalized, with %HOST%
+// expanded) applies to the following sends. A buffered client applies the
+// prefix at flush time, so the stats of an interval are all sent with the
+// prefix current at its end. A nil provider restores the static prefix
+func (c *StatsdClient) SetPrefixProvider(provider func() string, refresh time.Duration) {
+	var initial *dynamicPrefix
+	if provider != nil {
+		// not under the lock, the provider may be slow or use the client
+		initial = providedPrefix(provider)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prefixStop != nil {
+		close(c.prefixStop)
+		c.prefixStop = nil
+	}
+	if provider == nil || c.closed {
+		c.dynamic.Store((*dynamicPrefix)(nil))
+		return
+	}
+	c.dynamic.Store(initial)
+	stop := make(chan struct{})
+	c.prefixStop = stop
+	tick, stopTicker := c.newTicker(refresh)
+	c.loops.Add(1)
+	go func() {
+		defer c.loops.Done()
+		defer stopTicker()
+		for {
+			select {
+			case <-tick:
+				c.refreshPrefix(provider, stop)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// providedPrefix returns the prefix returned by the provider, normalized
+func providedPrefix(provider func() string) *dynamicPrefix {
+	prefix := strings.Replace(provider(), "%HOST%", Hostname, 1)
+	return &dynamicPrefix{prefix: Escape(FieldName, normalizePrefix(prefix))}
+}
+
+// refreshPrefix swaps in the prefix returned by the provider, unless the
+// provider was replaced (stop closed) while it was called
+func (c *StatsdClient) refreshPrefix(provider func() string, stop chan struct{}) {
+	d := providedPrefix(provider)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prefixStop == stop {
+		c.dynamic.Store(d)
+	}
+}
+
+// currentPrefix returns the prefix of the metric names, and whether it comes
+// from a prefix provider
+func (c *StatsdClient) currentPrefix() (string, bool) {
+	if d, _ := c.dynamic.Load().(*dynamicPrefix); d != nil {
+		return d.prefix, true
+	}
+	return c.prefix, false
+}
+
+// applyPrefix replaces the deferred prefix of the keys of the events to flush
+// with the current one, recording the keys in sb.events of the renamed events
+// in derived. It returns the events renamed, with their original key, so that
+// the ones retained after a failure can be restored
+func (sb *buffer) applyPrefix(events []event.Event, derived map[string]string) map[event.Event]string {
+	prefix, _ := sb.statsd.currentPrefix()
+	renamed := make(map[event.Event]string)
+	for _, e := range events {
+		key := e.Key()
+		if !strings.HasPrefix(key, deferredPrefix) {
+			continue
+		}
+		name := prefix + key[len(deferredPrefix):]
+		if k, ok := derived[key]; ok {
+			derived[name] = k
+		} else {
+			derived[name] = key
+		}
+		e.SetKey(name)
+		renamed[e] = key
+	}
+	return renamed
+}
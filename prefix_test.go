@@ -0,0 +1,107 @@
+package statsd
+
+import (
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestPrefixProvider(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	var color atomic.Value
+	color.Store("blue")
+	client.SetPrefixProvider(func() string { return "app." + color.Load().(string) }, time.Second)
+	flip := func(c string) {
+		t.Helper()
+		color.Store(c)
+		clock.Advance(time.Second)
+		waitUntil(t, time.Second, func() bool { return client.Config().Prefix == "app."+c+"." })
+	}
+
+	client.Incr("a", 1)
+	flip("green")
+	client.Incr("a", 2)
+	expected := []string{"app.blue.a:1|c", "app.green.a:2|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+
+	// the buffered client applies the prefix current at flush time
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("b", 1)
+	buffered.Gauge(RawName("raw"), 1)
+	flip("blue")
+	clock.Advance(9 * time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == 3 })
+	expected = []string{"app.blue.b:1|c\nraw:1|g"}
+	if actual := conn.sent()[2:]; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+
+	client.SetPrefixProvider(nil, 0)
+	buffered.Incr("b", 1)
+	buffered.Close()
+	if actual := conn.sent()[3]; actual != "myproject.b:1|c" {
+		t.Errorf("expected the static prefix, actual %q", actual)
+	}
+}
+
+// the events retained after a failed flush are sent with the prefix current
+// at the next flush
+func TestPrefixProviderRetained(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	var color atomic.Value
+	color.Store("blue")
+	client.SetPrefixProvider(func() string { return color.Load().(string) }, 5*time.Second)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Logger = discardLogger{}
+
+	buffered.Incr("a", 1)
+	clock.Advance(10 * time.Second)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().CarriedIntervals == 1 })
+	conn.mu.Lock()
+	conn.until = time.Time{}
+	conn.mu.Unlock()
+	color.Store("green")
+	buffered.Incr("a", 2)
+	clock.Advance(5 * time.Second)
+	waitUntil(t, time.Second, func() bool { return client.Config().Prefix == "green." })
+	clock.Advance(5 * time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.lines()) == 1 })
+	buffered.Close()
+	if lines := conn.lines(); !reflect.DeepEqual([]string{"green.a:3|c"}, lines) {
+		t.Errorf("expected the retained counter with the new prefix, actual %q", lines)
+	}
+}
+
+// the provider is called without the lock of the client, it may use it
+func TestPrefixProviderUsesClient(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.SetPrefixProvider(func() string { return client.Config().Prefix + "blue" }, time.Hour)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SetPrefixProvider deadlocked")
+	}
+	client.Incr("a", 1)
+	if actual := conn.sent(); !reflect.DeepEqual([]string{"myproject.blue.a:1|c"}, actual) {
+		t.Errorf("expected the provided prefix, actual %q", actual)
+	}
+}
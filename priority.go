@@ -0,0 +1,328 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// Priority ranks the metrics of a buffered client when its queue is full:
+// the drop policies (see SetQueuePolicy) shed the lower priorities first
+type Priority int
+
+const (
+	// PriorityLow is for the metrics which can be lost first, e.g. debug timings
+	PriorityLow Priority = iota
+	// PriorityNormal is the priority of the metrics sent through the buffered client itself
+	PriorityNormal
+	// PriorityHigh is for the metrics to keep for as long as possible, e.g. billing counters
+	PriorityHigh
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return "unknown priority"
+}
+
+// priorityQueue is the queue drained by the collector, with a channel per
+// priority sharing a single capacity: each channel can hold all of it, so that
+// a send which reserved its place never blocks
+type priorityQueue struct {
+	channels [numPriorities]chan event.Event
+	size     int64 // events queued or being sent, updated atomically
+	capacity int64
+}
+
+func newPriorityQueue(capacity int) *priorityQueue {
+	q := &priorityQueue{capacity: int64(capacity)}
+	for i := range q.channels {
+		q.channels[i] = make(chan event.Event, capacity)
+	}
+	return q
+}
+
+// tryPush queues the event unless the queue is full
+func (q *priorityQueue) tryPush(e event.Event, priority Priority) bool {
+	for {
+		size := atomic.LoadInt64(&q.size)
+		if size >= q.capacity {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&q.size, size, size+1) {
+			q.channels[priority] <- e
+			return true
+		}
+	}
+}
+
+// push queues the event, waiting for room in its channel or for done
+func (q *priorityQueue) push(e event.Event, priority Priority, done <-chan struct{}) error {
+	atomic.AddInt64(&q.size, 1)
+	select {
+	case q.channels[priority] <- e:
+		return nil
+	case <-done:
+		atomic.AddInt64(&q.size, -1)
+		return ErrClosed
+	}
+}
+
+// evict drops the oldest event of the lowest priority below the one given,
+// returning its priority, or false if no such event is queued
+func (q *priorityQueue) evict(below Priority) (Priority, bool) {
+	for priority := PriorityLow; priority < below; priority++ {
+		select {
+		case <-q.channels[priority]:
+			q.received()
+			return priority, true
+		default:
+		}
+	}
+	return 0, false
+}
+
+// pop returns an event of the highest priority queued, without waiting
+func (q *priorityQueue) pop() (event.Event, bool) {
+	for priority := numPriorities - 1; priority >= PriorityLow; priority-- {
+		select {
+		case e := <-q.channels[priority]:
+			q.received()
+			return e, true
+		default:
+		}
+	}
+	return nil, false
+}
+
+// received releases the place of an event received from one of the channels
+func (q *priorityQueue) received() {
+	atomic.AddInt64(&q.size, -1)
+}
+
+// Prioritized is a view of a buffered client queueing all the metrics with a
+// priority, see WithPriority. Like a Source, it's cheap and doesn't own the
+// client. The batch calls queue their metrics one by one
+type Prioritized struct {
+	sb       *StatsdBuffer
+	priority Priority
+}
+
+// WithPriority returns a view of the buffered client queueing the metrics with
+// priority: when the queue is full, the drop policies shed the lower priorities
+// first (with Block nothing is dropped and the priorities don't matter). The
+// drops are counted per priority in Stats().PriorityDrops. The metrics are
+// aggregated with the ones of the same keys whatever their priority
+func (sb *StatsdBuffer) WithPriority(priority Priority) *Prioritized {
+	if priority < PriorityLow || priority >= numPriorities {
+		priority = PriorityNormal
+	}
+	return &Prioritized{sb: sb, priority: priority}
+}
+
+// enqueue is StatsdBuffer.enqueue at the priority of the view
+func (p *Prioritized) enqueue(e event.Event) error {
+	return p.sb.enqueueAt(e, p.priority)
+}
+
+// rawMarker returns the marker of the raw names of the client, see rawMarked
+func (p *Prioritized) rawMarker() string {
+	return p.sb.rawMarker()
+}
+
+// CreateSocket does nothing: the view doesn't own the connection of the client
+func (p *Prioritized) CreateSocket() error {
+	return nil
+}
+
+// Close does nothing: the view doesn't own the client
+func (p *Prioritized) Close() error {
+	return nil
+}
+
+// Incr - Increment a counter metric. Often used to note a particular event
+func (p *Prioritized) Incr(stat string, count int64) error {
+	return p.sb.incrAt(stat, count, p.priority)
+}
+
+// Decr - Decrement a counter metric. Often used to note a particular event
+func (p *Prioritized) Decr(stat string, count int64) error {
+	return p.sb.incrAt(stat, -count, p.priority)
+}
+
+// Timing - Track a duration event
+func (p *Prioritized) Timing(stat string, delta int64) error {
+	return p.enqueue(p.sb.newTiming(stat, delta))
+}
+
+// PrecisionTiming - Track a duration event
+func (p *Prioritized) PrecisionTiming(stat string, delta time.Duration) error {
+	return p.enqueue(p.sb.newPrecisionTiming(stat, delta))
+}
+
+// TimingMicroseconds - Track a duration event given in microseconds
+func (p *Prioritized) TimingMicroseconds(stat string, us float64) error {
+	return p.sb.timingMicrosecondsAt(stat, us, p.priority)
+}
+
+// FTiming - Track a duration event given in floating point milliseconds
+func (p *Prioritized) FTiming(stat string, ms float64) error {
+	return p.sb.enqueueFinite(ms, p.sb.newFTiming(stat, ms), p.priority)
+}
+
+// Since - Track the time elapsed since start
+func (p *Prioritized) Since(stat string, start time.Time) error {
+	return p.PrecisionTiming(stat, p.sb.statsd.now().Sub(start))
+}
+
+// Observe - Track the outcome and the latency of a fallible call, see StatsdClient.Observe
+func (p *Prioritized) Observe(stat string, start time.Time, err error) error {
+	return observe(p, p.sb.statsd.observeNames(), stat, p.sb.statsd.now().Sub(start), err)
+}
+
+// ObserveFunc - Call fn and track its outcome like Observe, returning the error of fn
+func (p *Prioritized) ObserveFunc(stat string, fn func() error) error {
+	start := p.sb.statsd.now()
+	err := fn()
+	p.Observe(stat, start, err)
+	return err
+}
+
+// Gauge - Gauges are a constant data type
+func (p *Prioritized) Gauge(stat string, value int64) error {
+	return p.enqueue(&event.Gauge{Name: stat, Value: value})
+}
+
+// GaugeDelta records a delta from the previous value (as int64)
+func (p *Prioritized) GaugeDelta(stat string, value int64) error {
+	return p.enqueue(&event.GaugeDelta{Name: stat, Value: value})
+}
+
+// GaugeMax records the highest value of the interval, see StatsdBuffer.GaugeMax
+func (p *Prioritized) GaugeMax(stat string, value int64) error {
+	return p.enqueue(newGaugeMax(stat, value))
+}
+
+// GaugeMin records the lowest value of the interval, see StatsdBuffer.GaugeMin
+func (p *Prioritized) GaugeMin(stat string, value int64) error {
+	return p.enqueue(newGaugeMin(stat, value))
+}
+
+// FGauge is a Gauge working with float64 values
+func (p *Prioritized) FGauge(stat string, value float64) error {
+	return p.sb.enqueueFinite(value, &event.FGauge{Name: stat, Value: value}, p.priority)
+}
+
+// FGaugeDelta records a delta from the previous value (as float64)
+func (p *Prioritized) FGaugeDelta(stat string, value float64) error {
+	return p.sb.enqueueFinite(value, &event.FGaugeDelta{Name: stat, Value: value}, p.priority)
+}
+
+// Absolute - Send absolute-valued metric (not averaged/aggregated)
+func (p *Prioritized) Absolute(stat string, value int64) error {
+	return p.enqueue(p.sb.newAbsolute(stat, value))
+}
+
+// FAbsolute - Send absolute-valued metric (not averaged/aggregated)
+func (p *Prioritized) FAbsolute(stat string, value float64) error {
+	return p.sb.enqueueFinite(value, p.sb.newFAbsolute(stat, value), p.priority)
+}
+
+// sendTyped buffers a metric sendMetric can't send with the methods of the
+// view at its priority, see typedSender
+func (p *Prioritized) sendTyped(m wire.Metric, value float64) error {
+	return p.sb.sendTypedAt(m, value, p.priority)
+}
+
+// Total - Send a metric that is continously increasing, e.g. read operations since boot
+func (p *Prioritized) Total(stat string, value int64) error {
+	return p.enqueue(&event.Total{Name: stat, Value: value})
+}
+
+// Unique - Send a unique value, see StatsdBuffer.Unique
+func (p *Prioritized) Unique(stat string, value string) error {
+	return p.sb.uniqueAt(stat, value, p.priority)
+}
+
+// IncrMap increments all the counters in the map
+func (p *Prioritized) IncrMap(counts map[string]int64) error {
+	var first error
+	for stat, count := range counts {
+		if err := p.Incr(stat, count); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// GaugeMap sets all the gauges in the map
+func (p *Prioritized) GaugeMap(values map[string]int64) error {
+	var first error
+	for stat, value := range values {
+		if err := p.Gauge(stat, value); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// TimingSlices tracks all the durations in the map
+func (p *Prioritized) TimingSlices(timings map[string][]time.Duration) error {
+	var first error
+	for stat, durations := range timings {
+		for _, d := range durations {
+			if err := p.PrecisionTiming(stat, d); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// IncrWith increments the counter stat broken down by the keys and values in
+// kv, see StatsdClient.IncrWith
+func (p *Prioritized) IncrWith(stat string, count int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return p.Incr(stat, count)
+}
+
+// TimingWith tracks a duration broken down by the keys and values in kv, see IncrWith
+func (p *Prioritized) TimingWith(stat string, delta time.Duration, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return p.PrecisionTiming(stat, delta)
+}
+
+// GaugeWith sets a gauge broken down by the keys and values in kv, see IncrWith
+func (p *Prioritized) GaugeWith(stat string, value int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return p.Gauge(stat, value)
+}
+
+// SendEvents queues the events one by one, see StatsdBuffer.SendEvents
+func (p *Prioritized) SendEvents(events ...event.Event) error {
+	var first error
+	for _, e := range events {
+		if err := p.enqueue(e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,89 @@
+package statsd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+var _ Statsd = (*Prioritized)(nil)
+
+func TestPriorityDrops(t *testing.T) {
+	for _, policy := range []QueuePolicy{DropNewest, DropOldest} {
+		client, conn := newPacketClient(t, "myproject.")
+		buffered := NewStatsdBuffer(time.Hour, client)
+		buffered.Logger = discardLogger{}
+		buffered.SetQueuePolicy(policy)
+
+		// the collector blocks merging an event, and the queue (of 100 events) fills up
+		stall := &stallingEvent{Increment: &event.Increment{Name: "warmup", Value: 1},
+			entered: make(chan struct{}), release: make(chan struct{})}
+		buffered.enqueue(stall)
+		buffered.Incr("warmup", 1)
+		<-stall.entered
+		low, high := buffered.WithPriority(PriorityLow), buffered.WithPriority(PriorityHigh)
+		for i := 0; i < 100; i++ {
+			low.Gauge(fmt.Sprintf("low%03d", i), 1)
+		}
+		for i := 0; i < 50; i++ {
+			buffered.Gauge(fmt.Sprintf("normal%03d", i), 1)
+		}
+		for i := 0; i < 100; i++ {
+			high.Gauge(fmt.Sprintf("high%03d", i), 1)
+		}
+		close(stall.release)
+
+		stats := buffered.Stats()
+		expected := map[Priority]int64{PriorityLow: 100, PriorityNormal: 50}
+		if !reflect.DeepEqual(expected, stats.PriorityDrops) {
+			t.Errorf("%s: expected drops %v, actual %v", policy, expected, stats.PriorityDrops)
+		}
+		if dropped := stats.QueueDrops[policy]; dropped != 150 {
+			t.Errorf("%s: expected 150 drops, actual %d", policy, dropped)
+		}
+		buffered.Close()
+
+		delivered := map[string]int{}
+		for _, packet := range conn.sent() {
+			for _, line := range strings.Split(packet, "\n") {
+				for _, tier := range []string{"low", "normal", "high"} {
+					if strings.HasPrefix(line, "myproject."+tier) {
+						delivered[tier]++
+					}
+				}
+			}
+		}
+		if !reflect.DeepEqual(map[string]int{"high": 100}, delivered) {
+			t.Errorf("%s: expected all the high priority gauges only, actual %v", policy, delivered)
+		}
+	}
+}
+
+func TestPriorityBlock(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	high := buffered.WithPriority(PriorityHigh)
+	high.Incr("hits", 2)
+	high.IncrMap(map[string]int64{"hits": 3})
+	buffered.Incr("hits", 1)
+	buffered.Close()
+	if packets := conn.sent(); len(packets) != 1 || !strings.Contains(packets[0], "myproject.hits:6|c") {
+		t.Errorf("the priorities must be aggregated together, actual %q", packets)
+	}
+	if drops := buffered.Stats().PriorityDrops; len(drops) != 0 {
+		t.Errorf("unexpected drops %v", drops)
+	}
+}
+
+func TestPriorityString(t *testing.T) {
+	for p, s := range map[Priority]string{PriorityLow: "low", PriorityNormal: "normal", PriorityHigh: "high", 7: "unknown priority"} {
+		if p.String() != s {
+			t.Errorf("expected %q, actual %q", s, p.String())
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package statsd
+
+import "github.com/CrowdSurge/statsd/event"
+
+// unqualified returns the event wrapped by event.PreQualified if e is one, and
+// whether it is. The wrapper is kept until the event is named, so that the
+// mark doesn't depend on its key
+func unqualified(e event.Event) (event.Event, bool) {
+	if q, ok := e.(*event.Qualified); ok {
+		return q.Event, true
+	}
+	return e, false
+}
+
+// eventName is metricName for the key of an event: the key of an event marked
+// by event.PreQualified is the metric name as is, without the prefix nor
+// the raw marker
+func (c *StatsdClient) eventName(e event.Event) (string, error) {
+	e, qualified := unqualified(e)
+	return c.resolveNameAs(e.Key(), true, false, qualified)
+}
+
+// SendEvent aggregates an arbitrary event until the next flush. Events marked
+// by event.PreQualified are aggregated and sent under their key as is, apart
+// from the ones sent under the same key with the prefix. The buffered client
+// takes over the event: it's renamed, and the events of the same key are
+// merged into it
+func (sb *buffer) SendEvent(e event.Event) error {
+	return sb.enqueue(e)
+}
+
+// SendEvents aggregates several events, handing them over to the collector at
+// once, see SendEvent
+func (sb *buffer) SendEvents(events ...event.Event) error {
+	return sb.enqueueBatch(append([]event.Event(nil), events...))
+}
@@ -0,0 +1,64 @@
+package statsd
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestPreQualified(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SendEvent(event.PreQualified(&event.Increment{Name: "upstream.orders", Value: 1}))
+	client.SendEvents(&event.Gauge{Name: "queue", Value: 3}, event.PreQualified(&event.Gauge{Name: "upstream.queue", Value: 4}))
+	expected := []string{"upstream.orders:1|c", "myproject.queue:3|g\nupstream.queue:4|g"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestPreQualifiedKeys(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetRawMarker("")
+
+	orders := &event.Increment{Name: "upstream.orders", Value: 1}
+	queue := &event.Gauge{Name: "queue", Value: 3}
+	client.SendEvent(event.PreQualified(orders))
+	client.SendEvents(queue, event.PreQualified(orders))
+	expected := []string{"upstream.orders:1|c", "myproject.queue:3|g\nupstream.orders:1|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	// the events sent keep their keys
+	if orders.Key() != "upstream.orders" || queue.Key() != "queue" {
+		t.Errorf("the keys were modified: %q, %q", orders.Key(), queue.Key())
+	}
+}
+
+func TestBufferPreQualified(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+
+	buffered.SendEvent(&event.Increment{Name: "orders", Value: 1})
+	buffered.SendEvent(event.PreQualified(&event.Increment{Name: "orders", Value: 2}))
+	buffered.Incr("orders", 4)
+	buffered.SendEvents(event.PreQualified(&event.Increment{Name: "orders", Value: 8}), &event.Gauge{Name: "queue", Value: 5})
+
+	clock.Advance(time.Second)
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) > 0 })
+	lines := strings.Split(strings.Join(conn.sent(), "\n"), "\n")
+	sort.Strings(lines)
+	// the qualified events are aggregated apart, and sent without the prefix
+	expected := []string{"myproject.orders:5|c", "myproject.queue:5|g", "orders:10|c"}
+	if !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+}
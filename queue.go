@@ -0,0 +1,111 @@
+package statsd
+
+import (
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// QueuePolicy selects what the buffered client does with a metric when the
+// queue drained by its collector is full, e.g. while a flush is blocked
+type QueuePolicy int
+
+const (
+	// Block waits for the collector to make room in the queue
+	Block QueuePolicy = iota
+	// DropNewest drops the metric being sent, never blocking the caller
+	DropNewest
+	// DropOldest drops the oldest metric in the queue to make room for the
+	// new one: the latest gauge values are kept
+	DropOldest
+	numQueuePolicies
+)
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop newest"
+	case DropOldest:
+		return "drop oldest"
+	}
+	return "unknown policy"
+}
+
+// SetQueuePolicy selects what happens to the metrics sent while the queue of
+// the collector is full, Block by default. The metrics dropped are counted per
+// policy in Stats().QueueDrops. The drop policies shed the metrics of lower
+// priorities first, see WithPriority. The batch calls (IncrMap, GaugeMap,
+// TimingSlices) are queued whole, and dropped whole
+func (sb *buffer) SetQueuePolicy(policy QueuePolicy) {
+	atomic.StoreInt32(&sb.queuePolicy, int32(policy))
+}
+
+// pushAt hands an event over to the collector, according to the queue policy:
+// when the queue is full, the events of lower priorities are dropped first
+func (sb *buffer) pushAt(e event.Event, priority Priority) error {
+	policy := QueuePolicy(atomic.LoadInt32(&sb.queuePolicy))
+	for {
+		if sb.eventQueue.tryPush(e, priority) {
+			return nil
+		}
+		select {
+		case <-sb.done:
+			return ErrClosed
+		default:
+		}
+		switch policy {
+		case DropNewest, DropOldest:
+			// DropNewest only makes room at the expense of lower priorities
+			below := priority
+			if policy == DropOldest {
+				below++
+			}
+			evicted, ok := sb.eventQueue.evict(below)
+			if !ok {
+				// everything queued has a higher priority
+				evicted = priority
+			}
+			atomic.AddInt64(&sb.queueDrops[policy], 1)
+			atomic.AddInt64(&sb.priorityDrops[evicted], 1)
+			if !ok {
+				return nil
+			}
+		default:
+			return sb.eventQueue.push(e, priority, sb.done)
+		}
+	}
+}
+
+// pushBatch hands a batch of events over to the collector, see push
+func (sb *buffer) pushBatch(events []event.Event) error {
+	policy := QueuePolicy(atomic.LoadInt32(&sb.queuePolicy))
+	for {
+		select {
+		case sb.batchChannel <- events:
+			return nil
+		case <-sb.done:
+			return ErrClosed
+		default:
+		}
+		switch policy {
+		case DropNewest:
+			atomic.AddInt64(&sb.queueDrops[DropNewest], int64(len(events)))
+			return nil
+		case DropOldest:
+			select {
+			case oldest := <-sb.batchChannel:
+				atomic.AddInt64(&sb.queueDrops[DropOldest], int64(len(oldest)))
+			default:
+			}
+		default:
+			select {
+			case sb.batchChannel <- events:
+				return nil
+			case <-sb.done:
+				return ErrClosed
+			}
+		}
+	}
+}
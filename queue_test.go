@@ -0,0 +1,95 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// stallingEvent is a counter whose merges block until it's released,
+// stalling the collector while it aggregates
+type stallingEvent struct {
+	*event.Increment
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (e *stallingEvent) Update(e2 event.Event) error {
+	e.once.Do(func() {
+		close(e.entered)
+		<-e.release
+	})
+	return e.Increment.Update(e2)
+}
+
+func TestQueuePolicy(t *testing.T) {
+	tests := []struct {
+		policy   QueuePolicy
+		first    int // of the gauges which survive
+		last     int
+		dropped  int64
+		blocking bool
+	}{
+		{policy: Block, first: 0, last: 149, blocking: true},
+		{policy: DropNewest, first: 0, last: 99, dropped: 50},
+		{policy: DropOldest, first: 50, last: 149, dropped: 50},
+	}
+	for _, tt := range tests {
+		client, conn := newPacketClient(t, "myproject.")
+		buffered := NewStatsdBuffer(time.Hour, client)
+		buffered.Logger = discardLogger{}
+		buffered.SetQueuePolicy(tt.policy)
+
+		// the collector blocks merging an event, and the queue (of 100 events) fills up
+		stall := &stallingEvent{Increment: &event.Increment{Name: "warmup", Value: 1},
+			entered: make(chan struct{}), release: make(chan struct{})}
+		buffered.enqueue(stall)
+		buffered.Incr("warmup", 1)
+		<-stall.entered
+		sent := make(chan struct{})
+		go func() {
+			for i := 0; i < 150; i++ {
+				buffered.Gauge(fmt.Sprintf("g%03d", i), int64(i))
+			}
+			close(sent)
+		}()
+		select {
+		case <-sent:
+			if tt.blocking {
+				t.Errorf("%s: the sends didn't block", tt.policy)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if !tt.blocking {
+				t.Errorf("%s: the sends blocked", tt.policy)
+			}
+		}
+		close(stall.release)
+		<-sent
+		if dropped := buffered.Stats().QueueDrops[tt.policy]; dropped != tt.dropped {
+			t.Errorf("%s: expected %d drops, actual %d", tt.policy, tt.dropped, dropped)
+		}
+		buffered.Close()
+
+		var gauges []string
+		for _, packet := range conn.sent() {
+			for _, line := range strings.Split(packet, "\n") {
+				if strings.HasPrefix(line, "myproject.g") {
+					gauges = append(gauges, line)
+				}
+			}
+		}
+		if n := tt.last - tt.first + 1; len(gauges) != n {
+			t.Errorf("%s: expected %d gauges, actual %d", tt.policy, n, len(gauges))
+			continue
+		}
+		first, last := fmt.Sprintf("myproject.g%03d:%d|g", tt.first, tt.first), fmt.Sprintf("myproject.g%03d:%d|g", tt.last, tt.last)
+		if gauges[0] != first || gauges[len(gauges)-1] != last {
+			t.Errorf("%s: expected %s to %s, actual %s to %s", tt.policy, first, last, gauges[0], gauges[len(gauges)-1])
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package statsd
+
+// the race detector makes allocations unpredictable
+const raceEnabled = true
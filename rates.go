@@ -0,0 +1,55 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// default suffixes of the metrics derived from the counters, see SetEmitRates
+const (
+	DefaultCountSuffix = ".count"
+	DefaultRateSuffix  = ".rate"
+)
+
+// rateNames is an immutable pair of suffixes, swapped atomically
+type rateNames struct {
+	count string
+	rate  string
+}
+
+// SetEmitRates makes the buffered client send every counter at flush time as
+// two metrics: stat.count with the raw sum and stat.rate, a gauge with the sum
+// divided by the seconds actually elapsed since the previous flush (which is
+// longer than the flush interval when a flush is late or delayed)
+func (sb *buffer) SetEmitRates(emit bool) {
+	var v int32
+	if emit {
+		v = 1
+	}
+	atomic.StoreInt32(&sb.emitRates, v)
+}
+
+// SetRateNames sets the suffixes of the metrics derived from the counters,
+// DefaultCountSuffix and DefaultRateSuffix by default
+func (sb *buffer) SetRateNames(countSuffix string, rateSuffix string) {
+	sb.rateNames.Store(&rateNames{count: Escape(FieldName, countSuffix), rate: Escape(FieldName, rateSuffix)})
+}
+
+// rateEvents returns the events to send in place of a counter when rates are
+// enabled, or nil. It's only called from within the collector
+func (sb *buffer) rateEvents(e event.Event, elapsed time.Duration) []event.Event {
+	incr, ok := e.(*event.Increment)
+	if !ok || atomic.LoadInt32(&sb.emitRates) == 0 || elapsed <= 0 {
+		return nil
+	}
+	names := &rateNames{count: DefaultCountSuffix, rate: DefaultRateSuffix}
+	if n, _ := sb.rateNames.Load().(*rateNames); n != nil {
+		names = n
+	}
+	return []event.Event{
+		&event.Increment{Name: incr.Name + names.count, Value: incr.Value},
+		&event.FGauge{Name: incr.Name + names.rate, Value: float64(incr.Value) / elapsed.Seconds()},
+	}
+}
@@ -0,0 +1,53 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestEmitRates(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	defer buffered.Close()
+	buffered.SetEmitRates(true)
+
+	expect := func(name string, n int, value string) {
+		t.Helper()
+		metrics, err := srv.WaitFor(name, n, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metrics[n-1].Value != value {
+			t.Errorf("%s: expected %s, actual %s", name, value, metrics[n-1].Value)
+		}
+	}
+
+	buffered.Incr("a", 30)
+	buffered.Gauge("g", 1)
+	clock.Advance(10 * time.Second)
+	expect("myproject.a.count", 1, "30")
+	expect("myproject.a.rate", 1, "3")
+	expect("myproject.g", 1, "1")
+
+	// the flush is late: the rate uses the actual elapsed time
+	buffered.Incr("a", 30)
+	clock.Advance(15 * time.Second)
+	expect("myproject.a.count", 2, "30")
+	expect("myproject.a.rate", 2, "2")
+
+	buffered.SetRateNames("_sum", "_per_sec")
+	buffered.Incr("a", 5)
+	clock.Advance(10 * time.Second)
+	expect("myproject.a_sum", 1, "5")
+	expect("myproject.a_per_sec", 1, "0.5")
+	if _, err := srv.WaitFor("myproject.a", 1, 10*time.Millisecond); err == nil {
+		t.Error("raw counter sent along with the rates")
+	}
+}
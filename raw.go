@@ -0,0 +1,162 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// ErrInvalidLine is returned by WriteRaw for a payload which is empty, has
+// several lines (or empty ones) or exceeds the maximum packet size
+var ErrInvalidLine = errors.New("statsd: invalid raw line")
+
+// rawKey is the key the raw lines are packed and retained under. It can't be
+// the key of a metric, the names being sanitized
+const rawKey = "(raw)"
+
+// WriteRaw writes a line already in the StatsD format, e.g. produced by a
+// legacy component, with the connection management, error handling and retries
+// of the other sends. The line is written as is: the prefix, filters, sampling
+// and name sanitization don't apply. A trailing newline is ignored, the line
+// must not exceed the maximum packet size (see SetMaxPacketSize).
+// The lines written are counted in Stats().RawLines
+func (c *StatsdClient) WriteRaw(line []byte) error {
+	return c.writeRaw(line, false)
+}
+
+// WriteRawLines is WriteRaw for several newline-separated lines, never split
+// across packets
+func (c *StatsdClient) WriteRawLines(lines []byte) error {
+	return c.writeRaw(lines, true)
+}
+
+// rawPayload returns the payload without its trailing newline and its number
+// of lines, or ErrInvalidLine
+func rawPayload(payload []byte, multiLine bool) ([]byte, int, error) {
+	payload = bytes.TrimSuffix(payload, []byte("\n"))
+	n := bytes.Count(payload, []byte("\n")) + 1
+	if len(payload) == 0 || (n > 1 && !multiLine) || bytes.Contains(payload, []byte("\n\n")) {
+		return nil, 0, ErrInvalidLine
+	}
+	return payload, n, nil
+}
+
+// checkRaw returns the error of a raw write which can't be sent by the client:
+// ErrGraphiteDirect in Graphite mode, not counted yet (see unsupported). The
+// caller must hold c.mu
+func (c *StatsdClient) checkRaw(payload []byte) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if c.isGraphite() {
+		return ErrGraphiteDirect
+	}
+	if len(payload) > c.packetSize {
+		return ErrInvalidLine
+	}
+	return nil
+}
+
+func (c *StatsdClient) writeRaw(payload []byte, multiLine bool) error {
+	payload, n, err := rawPayload(payload, multiLine)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkRaw(payload); err == ErrGraphiteDirect {
+		return c.unsupported(FeatureDirectSend, 1, nil)
+	} else if err != nil {
+		return err
+	}
+	if !c.writable() {
+		return errNotConnected
+	}
+	p := c.newPacker()
+	// written as is, see sendLines
+	c.packer.Suffix = ""
+	p.add(rawKey, payload)
+	if err := p.flush(); err != nil {
+		if mapErr, ok := err.(*MapError); ok {
+			return mapErr.Errors[rawKey]
+		}
+		return err
+	}
+	atomic.AddInt64(&c.rawLines, int64(n))
+	return nil
+}
+
+// rawEvent is a payload of raw lines queued by the buffered client, see
+// StatsdBuffer.WriteRaw. It isn't aggregated, the collector keeps it for the
+// next flush with the lines retained by the previous one
+type rawEvent struct {
+	payload []byte
+}
+
+func (e *rawEvent) Update(e2 event.Event) error { return nil }
+func (e *rawEvent) Payload() interface{}        { return e.payload }
+func (e *rawEvent) Stats() []string             { return []string{string(e.payload)} }
+func (e *rawEvent) Key() string                 { return rawKey }
+func (e *rawEvent) SetKey(key string)           {}
+func (e *rawEvent) Type() int                   { return -1 }
+func (e *rawEvent) TypeString() string          { return "Raw" }
+func (e *rawEvent) String() string              { return string(e.payload) }
+
+// WriteRaw queues a line already in the StatsD format, see
+// StatsdClient.WriteRaw: it isn't aggregated, but packed with the other lines
+// of the next flush, and retained like them if the flush fails
+func (sb *buffer) WriteRaw(line []byte) error {
+	return sb.writeRaw(line, false)
+}
+
+// WriteRawLines queues several lines already in the StatsD format, never split
+// across packets, see StatsdBuffer.WriteRaw
+func (sb *buffer) WriteRawLines(lines []byte) error {
+	return sb.writeRaw(lines, true)
+}
+
+func (sb *buffer) writeRaw(payload []byte, multiLine bool) error {
+	if atomic.LoadInt32(&sb.closed) != 0 {
+		return ErrClosed
+	}
+	payload, _, err := rawPayload(payload, multiLine)
+	if err != nil {
+		return err
+	}
+	c := sb.statsd
+	c.mu.Lock()
+	err = c.checkRaw(payload)
+	c.mu.Unlock()
+	if err == ErrGraphiteDirect {
+		return c.unsupported(FeatureDirectSend, 1, sb.handleError)
+	} else if err != nil {
+		return err
+	}
+	// copied, the caller may reuse its buffer
+	return sb.pushAt(&rawEvent{payload: append([]byte(nil), payload...)}, PriorityNormal)
+}
+
+// addRaw keeps the raw lines for the next flush. It's only called from within
+// the collector
+func (sb *buffer) addRaw(e *rawEvent) {
+	sb.retainedLines = append(sb.retainedLines, retainedLines{key: rawKey, groups: [][]byte{e.payload}})
+}
+
+// countRaw counts the raw lines delivered by a flush in Stats().RawLines: the
+// ones of the groups kept under rawKey, short of the groups failed
+func (c *StatsdClient) countRaw(retained []retainedLines, failed map[string]failedKey) {
+	var n int
+	for _, r := range retained {
+		if r.key == rawKey {
+			for _, group := range r.groups {
+				n += bytes.Count(group, []byte("\n")) + 1
+			}
+		}
+	}
+	for _, group := range failed[rawKey].lost {
+		n -= bytes.Count(group, []byte("\n")) + 1
+	}
+	atomic.AddInt64(&c.rawLines, int64(n))
+}
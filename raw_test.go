@@ -0,0 +1,101 @@
+package statsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRaw(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetMaxPacketSize(64)
+	client.Incr("a", 1)
+	if err := client.WriteRaw([]byte("legacy.requests:1|c\n")); err != nil {
+		t.Error(err)
+	}
+	client.Gauge("g", 2)
+	if err := client.WriteRawLines([]byte("legacy.a:1|c\nlegacy.b:2|ms")); err != nil {
+		t.Error(err)
+	}
+	for _, invalid := range []string{"", "\n", "legacy.a:1|c\nlegacy.b:2|c", strings.Repeat("x", 65)} {
+		if err := client.WriteRaw([]byte(invalid)); err != ErrInvalidLine {
+			t.Errorf("%q: expected ErrInvalidLine, actual %v", invalid, err)
+		}
+	}
+	if err := client.WriteRawLines([]byte("legacy.a:1|c\n\nlegacy.b:2|c")); err != ErrInvalidLine {
+		t.Errorf("empty line: expected ErrInvalidLine, actual %v", err)
+	}
+
+	expected := []string{
+		"myproject.a:1|c",
+		"legacy.requests:1|c",
+		"myproject.g:2|g",
+		"legacy.a:1|c\nlegacy.b:2|ms",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+	if n := client.Stats().RawLines; n != 3 {
+		t.Errorf("expected 3 raw lines, actual %d", n)
+	}
+	client.Close()
+	if err := client.WriteRaw([]byte("legacy.a:1|c")); err != ErrClosed {
+		t.Errorf("expected ErrClosed, actual %v", err)
+	}
+}
+
+func TestBufferWriteRaw(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.Incr("a", 1)
+	if err := buffered.WriteRaw([]byte("legacy.requests:1|c")); err != nil {
+		t.Error(err)
+	}
+	buffered.Incr("a", 2)
+	if err := buffered.WriteRawLines([]byte("legacy.a:1|c\nlegacy.b:2|ms\n")); err != nil {
+		t.Error(err)
+	}
+	if err := buffered.WriteRaw([]byte("legacy.a:1|c\nlegacy.b:2|ms")); err != ErrInvalidLine {
+		t.Errorf("expected ErrInvalidLine, actual %v", err)
+	}
+	buffered.Close()
+	if err := buffered.WriteRaw([]byte("legacy.requests:1|c")); err != ErrClosed {
+		t.Errorf("expected ErrClosed, actual %v", err)
+	}
+
+	// the raw lines aren't aggregated, they're packed together by the flush
+	expected := []string{"legacy.requests:1|c\nlegacy.a:1|c\nlegacy.b:2|ms", "myproject.a:3|c"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+	if n := client.Stats().RawLines; n != 3 {
+		t.Errorf("expected 3 raw lines, actual %d", n)
+	}
+}
+
+// the raw lines are retained like the lines of the events
+func TestBufferWriteRawRetained(t *testing.T) {
+	buffered, flush := newLossyBuffer(t, "legacy.requests:1|c")
+	buffered.WriteRaw([]byte("legacy.requests:1|c"))
+	// queued behind the raw line
+	buffered.Incr("a", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	if lines := flush(); !reflect.DeepEqual([]string{"myproject.a:1|c"}, lines) {
+		t.Fatalf("expected only the counter delivered, actual %q", lines)
+	}
+	if n := buffered.statsd.Stats().RawLines; n != 0 {
+		t.Errorf("expected no raw line delivered, actual %d", n)
+	}
+	buffered.WriteRaw([]byte("legacy.requests:2|c"))
+	buffered.Incr("a", 1)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	expected := []string{"legacy.requests:1|c", "legacy.requests:2|c", "myproject.a:1|c", "myproject.a:1|c"}
+	if lines := flush(); !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+	if n := buffered.statsd.Stats().RawLines; n != 2 {
+		t.Errorf("expected 2 raw lines, actual %d", n)
+	}
+}
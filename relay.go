@@ -0,0 +1,215 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// relayQueueSize is the number of datagrams received and not relayed yet
+// beyond which the new ones are dropped, see Relay
+const relayQueueSize = 1024
+
+// Relay receives StatsD datagrams, e.g. from legacy applications on the same
+// host, parses them and sends the metrics again through a client, which adds
+// its prefix and may aggregate them (a StatsdBuffer). A rewrite function can
+// rename or drop the metrics on the way. When the client can't keep up, the
+// datagrams are dropped rather than queued without bounds; the drops and the
+// malformed lines are counted in Stats
+type Relay struct {
+	client  Statsd
+	rewrite func(m *wire.Metric) bool
+	conn    net.PacketConn
+	queue   chan []byte
+	wg      sync.WaitGroup
+	// updated atomically, see Stats
+	packets   int64
+	metrics   int64
+	malformed int64
+	dropped   int64
+	errors    int64
+}
+
+// RelayStats are the counters of a Relay
+type RelayStats struct {
+	Packets   int64 // datagrams received
+	Metrics   int64 // metrics sent through the client
+	Malformed int64 // lines which failed to parse
+	Dropped   int64 // datagrams dropped because the client was lagging behind
+	Errors    int64 // metrics the client failed to send
+}
+
+// NewRelay creates a Relay sending the metrics through client
+func NewRelay(client Statsd) *Relay {
+	return &Relay{client: client, queue: make(chan []byte, relayQueueSize)}
+}
+
+// SetRewrite sets a function called with every metric before it's sent, which
+// can change its name (e.g. to move its tags into the name, which the client
+// doesn't send) or return false to drop it. It must be called before Listen
+func (r *Relay) SetRewrite(rewrite func(m *wire.Metric) bool) {
+	r.rewrite = rewrite
+}
+
+// Listen starts receiving datagrams on a UDP (host:port or udp://host:port)
+// or unixgram:// address, until Close. Port 0 picks an ephemeral port, see Addr
+func (r *Relay) Listen(addr string) error {
+	network, address := "udp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, address = addr[:i], addr[i+3:]
+	}
+	if network != "udp" && network != "unixgram" {
+		return fmt.Errorf("statsd: the relay can't listen on %q, only on datagram sockets", addr)
+	}
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.wg.Add(2)
+	go r.receive()
+	go r.relay()
+	return nil
+}
+
+// Addr returns the address the relay listens on, nil before Listen
+func (r *Relay) Addr() net.Addr {
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.LocalAddr()
+}
+
+// Close stops listening, once the datagrams already received are relayed.
+// The client isn't closed
+func (r *Relay) Close() error {
+	if r.conn == nil {
+		// never listened
+		return nil
+	}
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
+
+// Stats returns the counters of the relay
+func (r *Relay) Stats() RelayStats {
+	return RelayStats{
+		Packets:   atomic.LoadInt64(&r.packets),
+		Metrics:   atomic.LoadInt64(&r.metrics),
+		Malformed: atomic.LoadInt64(&r.malformed),
+		Dropped:   atomic.LoadInt64(&r.dropped),
+		Errors:    atomic.LoadInt64(&r.errors),
+	}
+}
+
+// receive reads the datagrams until the socket is closed
+func (r *Relay) receive() {
+	defer r.wg.Done()
+	defer close(r.queue)
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, _, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		r.enqueue(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// enqueue hands a datagram over to relay, or drops it if the queue is full
+func (r *Relay) enqueue(packet []byte) {
+	atomic.AddInt64(&r.packets, 1)
+	select {
+	case r.queue <- packet:
+	default:
+		atomic.AddInt64(&r.dropped, 1)
+	}
+}
+
+// relay parses the datagrams queued and sends their metrics
+func (r *Relay) relay() {
+	defer r.wg.Done()
+	for packet := range r.queue {
+		r.relayPacket(packet)
+	}
+}
+
+// relayPacket sends the metrics of a datagram, which may hold several lines
+func (r *Relay) relayPacket(packet []byte) {
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		m, err := wire.ParseLine(line)
+		if err != nil {
+			atomic.AddInt64(&r.malformed, 1)
+			continue
+		}
+		if r.rewrite != nil && !r.rewrite(&m) {
+			continue
+		}
+		if err := r.send(m); err != nil {
+			atomic.AddInt64(&r.errors, 1)
+			continue
+		}
+		atomic.AddInt64(&r.metrics, 1)
+	}
+}
+
+// send sends a metric through the client, see sendMetric
+func (r *Relay) send(m wire.Metric) error {
+	return sendMetric(r.client, m)
+}
+
+// sendMetric sends a parsed metric through client, with the method of its
+// type. The sampled counters are scaled back up by their rate, the sampled
+// timings and the absolutes of the other types are sent with their rate and
+// their type, see sendTyped
+func sendMetric(client Statsd, m wire.Metric) error {
+	if m.Type == wire.TypeSet {
+		return unique(client, m.Name, m.Value)
+	}
+	v, err := m.Float()
+	if err != nil {
+		return err
+	}
+	if needsTyped(m) {
+		return sendTyped(client, m, v)
+	}
+	i, err := strconv.ParseInt(m.Value, 10, 64)
+	isInt := err == nil
+	if !isInt {
+		i = int64(v)
+	}
+	switch m.Type {
+	case wire.TypeCounter:
+		return client.Incr(m.Name, int64(math.Round(v/m.SampleRate)))
+	case wire.TypeGauge:
+		switch {
+		case m.IsDelta() && isInt:
+			return client.GaugeDelta(m.Name, i)
+		case m.IsDelta():
+			return client.FGaugeDelta(m.Name, v)
+		case isInt:
+			return client.Gauge(m.Name, i)
+		}
+		return client.FGauge(m.Name, v)
+	case wire.TypeAbsolute:
+		if isInt {
+			return client.Absolute(m.Name, i)
+		}
+		return client.FAbsolute(m.Name, v)
+	case wire.TypeTotal:
+		return client.Total(m.Name, i)
+	}
+	// timings, histograms and distributions
+	return fTiming(client, m.Name, v)
+}
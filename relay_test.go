@@ -0,0 +1,127 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+func TestRelay(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	client := NewStatsdClient(srv.Addr(), "relayed.")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	relay := NewRelay(client)
+	relay.SetRewrite(func(m *wire.Metric) bool {
+		m.Name = strings.TrimPrefix(m.Name, "legacy.")
+		for _, tag := range m.Tags {
+			m.Name += "." + strings.Replace(tag, ":", "_", -1)
+		}
+		return m.Name != "debug"
+	})
+	if err := relay.Listen("udp://127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := NewStatsdClient(relay.Addr().String(), "legacy.")
+	if err := legacy.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Incr("debug", 1)
+	legacy.Incr("hits", 3)
+	legacy.GaugeDelta("queue", -2)
+	legacy.FTiming("latency", 1.5)
+	legacy.Unique("users", "joe")
+	legacy.Close()
+
+	raw, err := net.Dial("udp", relay.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// several lines, sampled, tagged and malformed ones, and the types the
+	// client has no method for
+	raw.Write([]byte("sampled:1|c|@0.5\ntagged:4|g|#env:prod\nbroken\nslow:8|ms|@0.25\nmean:3|am\nsum:4|asum\n"))
+	raw.Close()
+
+	expected := map[string]string{
+		"relayed.hits":            "3|c",
+		"relayed.queue":           "-2|g",
+		"relayed.latency":         "1.5|ms",
+		"relayed.users":           "joe|s",
+		"relayed.sampled":         "2|c",
+		"relayed.tagged.env_prod": "4|g",
+		"relayed.slow":            "8|ms|@0.25",
+		"relayed.mean":            "3|am",
+		"relayed.sum":             "4|asum",
+	}
+	for name, line := range expected {
+		metrics, err := srv.WaitFor(name, 1, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := metrics[0].Raw; actual != name+":"+line {
+			t.Errorf("expected %s:%s, actual %s", name, line, actual)
+		}
+	}
+	if err := relay.Close(); err != nil {
+		t.Error(err)
+	}
+	for _, m := range srv.Metrics() {
+		if strings.Contains(m.Raw, "debug") {
+			t.Errorf("the metric dropped by the rewrite was relayed: %s", m.Raw)
+		}
+	}
+	stats := relay.Stats()
+	if stats.Packets < 2 || stats.Metrics != 9 || stats.Malformed != 1 || stats.Dropped != 0 || stats.Errors != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRelayDrops(t *testing.T) {
+	relay := NewRelay(NewStatsdClient("", ""))
+	// nothing relays the queue without Listen
+	for i := 0; i < relayQueueSize+3; i++ {
+		relay.enqueue([]byte("a:1|c"))
+	}
+	if stats := relay.Stats(); stats.Packets != relayQueueSize+3 || stats.Dropped != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// the buffered client scales the sampled timings back up, and keeps the types
+// of the absolutes
+func TestRelayBuffered(t *testing.T) {
+	buffered, flush := newContributionClient(t)
+	for _, line := range []string{"slow:8|ms|@0.25", "slow:2|ms", "mean:3|am", "mean:5|am", "sum:4|asum"} {
+		m, err := wire.ParseLine([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sendMetric(buffered, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLines(t, []string{
+		"myproject.mean:3|am", "myproject.mean:5|am",
+		// 4 timings of 8 and 1 of 2
+		"myproject.slow.avg:6.8|a", "myproject.slow.min:2|a", "myproject.slow.max:8|a",
+		"myproject.sum:4|asum",
+	}, flush())
+}
+
+func TestRelayListen(t *testing.T) {
+	relay := NewRelay(NewStatsdClient("", ""))
+	if err := relay.Close(); err != nil {
+		t.Errorf("closing a relay which never listened: %v", err)
+	}
+	if err := relay.Listen("tcp://127.0.0.1:0"); err == nil {
+		t.Error("expected an error for a stream socket")
+	}
+}
@@ -0,0 +1,355 @@
+package statsd
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// ErrNotPermitted is returned by a RestrictedStatter for the metrics of the
+// kinds it doesn't allow
+var ErrNotPermitted = errors.New("statsd: metric kind not permitted by the restricted client")
+
+// RestrictedStatter is a view of a client only allowing some kinds of metrics,
+// e.g. to hand the client to third-party plugins which must not set gauges:
+// the other calls return ErrNotPermitted, and are counted in Denied. Like a
+// Source, it's cheap and doesn't own the client, and the raw writes (see
+// WriteRaw) aren't part of it. The views derived from it (see WithSource) are
+// restricted as well
+type RestrictedStatter struct {
+	client  Statsd
+	allowed [numKinds]bool
+	denied  int64 // updated atomically, see Denied
+}
+
+// NewRestrictedStatter returns a view of client only allowing the metrics of
+// the given kinds. Restricting a restricted view allows the kinds both allow
+func NewRestrictedStatter(client Statsd, kinds ...MetricKind) *RestrictedStatter {
+	r := &RestrictedStatter{client: client}
+	for _, kind := range kinds {
+		if kind >= 0 && kind < numKinds {
+			r.allowed[kind] = true
+		}
+	}
+	return r
+}
+
+// CountersOnly returns a view of the client only allowing the counters, see
+// RestrictedStatter
+func (c *StatsdClient) CountersOnly() *RestrictedStatter {
+	return NewRestrictedStatter(c, KindCounter)
+}
+
+// CountersOnly returns a view of the buffered client only allowing the
+// counters, see RestrictedStatter
+func (sb *StatsdBuffer) CountersOnly() *RestrictedStatter {
+	return NewRestrictedStatter(sb, KindCounter)
+}
+
+// CountersOnly returns a view of the router only allowing the counters, see
+// RestrictedStatter
+func (r *Router) CountersOnly() *RestrictedStatter {
+	return NewRestrictedStatter(r, KindCounter)
+}
+
+// CountersOnly returns a view of the source only allowing the counters, see
+// RestrictedStatter
+func (s *Source) CountersOnly() *RestrictedStatter {
+	return NewRestrictedStatter(s, KindCounter)
+}
+
+// rawMarker returns the marker of the raw names of the client, see rawMarked
+func (r *RestrictedStatter) rawMarker() string {
+	return rawMarkerOf(r.client)
+}
+
+// WithSource returns a view sending the metrics under source, with the same
+// restrictions, see Source
+func (r *RestrictedStatter) WithSource(source string) *Source {
+	return newSource(r, source)
+}
+
+// Allows tells whether the view allows the metrics of the given kind
+func (r *RestrictedStatter) Allows(kind MetricKind) bool {
+	return kind >= 0 && kind < numKinds && r.allowed[kind]
+}
+
+// Denied returns the number of calls which returned ErrNotPermitted, including
+// the ones of the derived views
+func (r *RestrictedStatter) Denied() int64 {
+	return atomic.LoadInt64(&r.denied)
+}
+
+// check returns ErrNotPermitted, counting the attempt, unless all the kinds
+// are allowed
+func (r *RestrictedStatter) check(kinds ...MetricKind) error {
+	for _, kind := range kinds {
+		if !r.Allows(kind) {
+			atomic.AddInt64(&r.denied, 1)
+			return ErrNotPermitted
+		}
+	}
+	return nil
+}
+
+// CreateSocket does nothing: the view doesn't own the connection of the client
+func (r *RestrictedStatter) CreateSocket() error {
+	return nil
+}
+
+// Close does nothing: the view doesn't own the client, so a plugin can't close it
+func (r *RestrictedStatter) Close() error {
+	return nil
+}
+
+// Incr - Increment a counter metric. Often used to note a particular event
+func (r *RestrictedStatter) Incr(stat string, count int64) error {
+	if err := r.check(KindCounter); err != nil {
+		return err
+	}
+	return r.client.Incr(stat, count)
+}
+
+// Decr - Decrement a counter metric. Often used to note a particular event
+func (r *RestrictedStatter) Decr(stat string, count int64) error {
+	if err := r.check(KindCounter); err != nil {
+		return err
+	}
+	return r.client.Decr(stat, count)
+}
+
+// Timing - Track a duration event
+func (r *RestrictedStatter) Timing(stat string, delta int64) error {
+	if err := r.check(KindTiming); err != nil {
+		return err
+	}
+	return r.client.Timing(stat, delta)
+}
+
+// PrecisionTiming - Track a duration event
+func (r *RestrictedStatter) PrecisionTiming(stat string, delta time.Duration) error {
+	if err := r.check(KindTiming); err != nil {
+		return err
+	}
+	return r.client.PrecisionTiming(stat, delta)
+}
+
+// TimingMicroseconds - Track a duration event given in microseconds
+func (r *RestrictedStatter) TimingMicroseconds(stat string, us float64) error {
+	if err := r.check(KindTiming); err != nil {
+		return err
+	}
+	return r.client.TimingMicroseconds(stat, us)
+}
+
+// FTiming - Track a duration event given in floating point milliseconds
+func (r *RestrictedStatter) FTiming(stat string, ms float64) error {
+	if err := r.check(KindTiming); err != nil {
+		return err
+	}
+	return fTiming(r.client, stat, ms)
+}
+
+// Since - Track the time elapsed since start
+func (r *RestrictedStatter) Since(stat string, start time.Time) error {
+	if err := r.check(KindTiming); err != nil {
+		return err
+	}
+	return since(r.client, stat, start)
+}
+
+// Observe - Track the outcome and the latency of a fallible call, see
+// StatsdClient.Observe. It needs both the counters and the timings
+func (r *RestrictedStatter) Observe(stat string, start time.Time, err error) error {
+	if err := r.check(KindCounter, KindTiming); err != nil {
+		return err
+	}
+	return observeOn(r.client, stat, start, err)
+}
+
+// ObserveFunc - Call fn and track its outcome like Observe, returning the
+// error of fn. Without the counters or the timings fn isn't called
+func (r *RestrictedStatter) ObserveFunc(stat string, fn func() error) error {
+	if err := r.check(KindCounter, KindTiming); err != nil {
+		return err
+	}
+	return observeFuncOn(r.client, stat, fn)
+}
+
+// Gauge - Gauges are a constant data type
+func (r *RestrictedStatter) Gauge(stat string, value int64) error {
+	if err := r.check(KindGauge); err != nil {
+		return err
+	}
+	return r.client.Gauge(stat, value)
+}
+
+// GaugeDelta -- Send a change for a gauge
+func (r *RestrictedStatter) GaugeDelta(stat string, value int64) error {
+	if err := r.check(KindGauge); err != nil {
+		return err
+	}
+	return r.client.GaugeDelta(stat, value)
+}
+
+// GaugeMax - Track the highest value of a gauge, see StatsdBuffer.GaugeMax
+func (r *RestrictedStatter) GaugeMax(stat string, value int64) error {
+	if err := r.check(KindGauge); err != nil {
+		return err
+	}
+	return gaugeMax(r.client, stat, value)
+}
+
+// GaugeMin - Track the lowest value of a gauge, see StatsdBuffer.GaugeMin
+func (r *RestrictedStatter) GaugeMin(stat string, value int64) error {
+	if err := r.check(KindGauge); err != nil {
+		return err
+	}
+	return gaugeMin(r.client, stat, value)
+}
+
+// Absolute - Send absolute-valued metric (not averaged/aggregated)
+func (r *RestrictedStatter) Absolute(stat string, value int64) error {
+	if err := r.check(KindAbsolute); err != nil {
+		return err
+	}
+	return r.client.Absolute(stat, value)
+}
+
+// Total - Send a metric that is continously increasing, e.g. read operations since boot
+func (r *RestrictedStatter) Total(stat string, value int64) error {
+	if err := r.check(KindTotal); err != nil {
+		return err
+	}
+	return r.client.Total(stat, value)
+}
+
+// Unique - Count the unique values of a set
+func (r *RestrictedStatter) Unique(stat string, value string) error {
+	if err := r.check(KindSet); err != nil {
+		return err
+	}
+	return unique(r.client, stat, value)
+}
+
+// sendTyped checks the kind of a metric sendMetric can't send with the
+// methods of the view, see typedSender
+func (r *RestrictedStatter) sendTyped(m wire.Metric, value float64) error {
+	kind := KindTiming
+	if m.Type == wire.TypeAbsMean || m.Type == wire.TypeAbsSum {
+		kind = KindAbsolute
+	}
+	if err := r.check(kind); err != nil {
+		return err
+	}
+	return sendTyped(r.client, m, value)
+}
+
+// FGauge -- Send a floating point value for a gauge
+func (r *RestrictedStatter) FGauge(stat string, value float64) error {
+	if err := r.check(KindGauge); err != nil {
+		return err
+	}
+	return r.client.FGauge(stat, value)
+}
+
+// FGaugeDelta -- Send a floating point change for a gauge
+func (r *RestrictedStatter) FGaugeDelta(stat string, value float64) error {
+	if err := r.check(KindGauge); err != nil {
+		return err
+	}
+	return r.client.FGaugeDelta(stat, value)
+}
+
+// FAbsolute - Send absolute-valued floating point metric (not averaged/aggregated)
+func (r *RestrictedStatter) FAbsolute(stat string, value float64) error {
+	if err := r.check(KindAbsolute); err != nil {
+		return err
+	}
+	return r.client.FAbsolute(stat, value)
+}
+
+// IncrMap increments all the counters in the map
+func (r *RestrictedStatter) IncrMap(counts map[string]int64) error {
+	if err := r.check(KindCounter); err != nil {
+		return err
+	}
+	return r.client.IncrMap(counts)
+}
+
+// GaugeMap sets all the gauges in the map
+func (r *RestrictedStatter) GaugeMap(values map[string]int64) error {
+	if err := r.check(KindGauge); err != nil {
+		return err
+	}
+	return r.client.GaugeMap(values)
+}
+
+// TimingSlices tracks all the durations in the map
+func (r *RestrictedStatter) TimingSlices(timings map[string][]time.Duration) error {
+	if err := r.check(KindTiming); err != nil {
+		return err
+	}
+	return r.client.TimingSlices(timings)
+}
+
+// IncrWith increments the counter stat broken down by the keys and values in
+// kv, see StatsdClient.IncrWith
+func (r *RestrictedStatter) IncrWith(stat string, count int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return r.Incr(stat, count)
+}
+
+// TimingWith tracks a duration broken down by the keys and values in kv, see
+// StatsdClient.IncrWith
+func (r *RestrictedStatter) TimingWith(stat string, delta time.Duration, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return r.PrecisionTiming(stat, delta)
+}
+
+// GaugeWith sets a gauge broken down by the keys and values in kv, see
+// StatsdClient.IncrWith
+func (r *RestrictedStatter) GaugeWith(stat string, value int64, kv ...string) error {
+	stat, err := withPairs(stat, kv)
+	if err != nil {
+		return err
+	}
+	return r.Gauge(stat, value)
+}
+
+// SendEvents sends the events of the kinds allowed; the other ones are
+// reported with ErrNotPermitted in a MapError, under their keys
+func (r *RestrictedStatter) SendEvents(events ...event.Event) error {
+	allowed := make([]event.Event, 0, len(events))
+	errs := make(map[string]error)
+	for _, e := range events {
+		if err := r.check(kindOf(e)); err != nil {
+			errs[e.Key()] = err
+			continue
+		}
+		allowed = append(allowed, e)
+	}
+	if len(allowed) > 0 {
+		if err := sendEvents(r.client, allowed); err != nil {
+			if mapErr, ok := err.(*MapError); ok {
+				for k, v := range mapErr.Errors {
+					errs[k] = v
+				}
+			} else {
+				for _, e := range allowed {
+					errs[e.Key()] = err
+				}
+			}
+		}
+	}
+	return mapError(errs)
+}
@@ -0,0 +1,132 @@
+package statsd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+func TestRestrictedStatter(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	r := client.CountersOnly()
+	var _ Statsd = r
+	calls := []struct {
+		name    string
+		call    func() error
+		allowed bool
+	}{
+		{"Incr", func() error { return r.Incr("a", 1) }, true},
+		{"Decr", func() error { return r.Decr("a", 1) }, true},
+		{"IncrMap", func() error { return r.IncrMap(map[string]int64{"b": 2}) }, true},
+		{"IncrWith", func() error { return r.IncrWith("c", 1, "k", "v") }, true},
+		{"Timing", func() error { return r.Timing("t", 1) }, false},
+		{"PrecisionTiming", func() error { return r.PrecisionTiming("t", time.Millisecond) }, false},
+		{"TimingMicroseconds", func() error { return r.TimingMicroseconds("t", 1) }, false},
+		{"FTiming", func() error { return r.FTiming("t", 1) }, false},
+		{"Since", func() error { return r.Since("t", time.Now()) }, false},
+		{"TimingSlices", func() error { return r.TimingSlices(map[string][]time.Duration{"t": {1}}) }, false},
+		{"TimingWith", func() error { return r.TimingWith("t", 1, "k", "v") }, false},
+		{"Observe", func() error { return r.Observe("o", time.Now(), nil) }, false},
+		{"ObserveFunc", func() error { return r.ObserveFunc("o", func() error { return nil }) }, false},
+		{"Gauge", func() error { return r.Gauge("g", 1) }, false},
+		{"GaugeDelta", func() error { return r.GaugeDelta("g", 1) }, false},
+		{"GaugeMax", func() error { return r.GaugeMax("g", 1) }, false},
+		{"GaugeMin", func() error { return r.GaugeMin("g", 1) }, false},
+		{"GaugeMap", func() error { return r.GaugeMap(map[string]int64{"g": 1}) }, false},
+		{"GaugeWith", func() error { return r.GaugeWith("g", 1, "k", "v") }, false},
+		{"FGauge", func() error { return r.FGauge("g", 1) }, false},
+		{"FGaugeDelta", func() error { return r.FGaugeDelta("g", 1) }, false},
+		{"Absolute", func() error { return r.Absolute("abs", 1) }, false},
+		{"FAbsolute", func() error { return r.FAbsolute("abs", 1) }, false},
+		{"Total", func() error { return r.Total("total", 1) }, false},
+		{"Unique", func() error { return r.Unique("u", "x") }, false},
+	}
+	denied := 0
+	for _, c := range calls {
+		err := c.call()
+		switch {
+		case c.allowed && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case !c.allowed && err != ErrNotPermitted:
+			t.Errorf("%s: expected ErrNotPermitted, actual %v", c.name, err)
+		case !c.allowed:
+			denied++
+		}
+	}
+	if n := client.CountersOnly().Denied(); n != 0 {
+		t.Errorf("the views don't share their counts, actual %d", n)
+	}
+	if n := r.Denied(); n != int64(denied) {
+		t.Errorf("expected %d denied calls, actual %d", denied, n)
+	}
+	expected := []string{"myproject.a:1|c", "myproject.a:-1|c", "myproject.b:2|c", "myproject.c.k.v:1|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := client.Incr("after", 1); err != nil {
+		t.Errorf("the view closed the client: %v", err)
+	}
+}
+
+func TestRestrictedSendEvents(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	r := NewRestrictedStatter(client, KindCounter, KindTiming)
+	err := r.SendEvents(
+		&event.Increment{Name: "a", Value: 1},
+		&event.Gauge{Name: "g", Value: 1},
+		event.PreQualified(&event.Gauge{Name: "other.g", Value: 1}),
+		event.NewTiming("t", 5),
+	)
+	var mapErr *MapError
+	if !errors.As(err, &mapErr) || len(mapErr.Errors) != 2 || mapErr.Errors["g"] != ErrNotPermitted || mapErr.Errors["other.g"] != ErrNotPermitted {
+		t.Errorf("unexpected error %v", err)
+	}
+	expected := []string{"myproject.a:1|c\nmyproject.t.avg:5|a\nmyproject.t.min:5|a\nmyproject.t.max:5|a"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	if r.Observe("o", time.Now(), nil) != nil {
+		t.Error("Observe needs counters and timings, which are both allowed")
+	}
+}
+
+// the restrictions survive the derivations
+func TestRestrictedDerivation(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	r := NewRestrictedStatter(client, KindCounter, KindTiming)
+	plugin := r.WithSource("plugin").WithSource("cache")
+	if err := plugin.Gauge("size", 1); err != ErrNotPermitted {
+		t.Errorf("expected ErrNotPermitted, actual %v", err)
+	}
+	if err := plugin.Incr("hits", 1); err != nil {
+		t.Error(err)
+	}
+	if r.Denied() != 1 {
+		t.Errorf("the denied calls of the derived views aren't counted, actual %d", r.Denied())
+	}
+
+	// restricting a restricted view allows the kinds both allow
+	narrower := NewRestrictedStatter(plugin, KindGauge, KindTiming)
+	if err := narrower.Incr("hits", 1); err != ErrNotPermitted {
+		t.Errorf("expected ErrNotPermitted, actual %v", err)
+	}
+	if err := narrower.Gauge("size", 1); err != ErrNotPermitted {
+		t.Errorf("expected ErrNotPermitted, actual %v", err)
+	}
+	if err := narrower.Timing("latency", 3); err != nil {
+		t.Error(err)
+	}
+	if err := client.WithSource("lib").CountersOnly().Timing("latency", 3); err != ErrNotPermitted {
+		t.Errorf("expected ErrNotPermitted, actual %v", err)
+	}
+	expected := []string{"myproject.plugin.cache.hits:1|c", "myproject.plugin.cache.latency:3|ms"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
@@ -0,0 +1,171 @@
+package statsd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bounds of the delay between two retry attempts
+const (
+	minRetryBackoff = 10 * time.Millisecond
+	maxRetryBackoff = time.Second
+)
+
+type retryEntry struct {
+	line    string
+	created time.Time
+}
+
+// retryQueue is a bounded FIFO of payloads whose write failed,
+// retried with backoff by a background goroutine
+type retryQueue struct {
+	mu         sync.Mutex
+	entries    []retryEntry
+	maxEntries int
+	maxAge     time.Duration
+	now        func() time.Time
+	dropped    int64 // updated atomically
+	wake       chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+// EnableRetry makes the client retry in memory the payloads whose write failed
+// (e.g. during a brief EMFILE or an agent restart), with backoff, instead of
+// dropping them. At most maxEntries payloads are retained, for at most maxAge:
+// older payloads, and the oldest ones when the queue is full, are dropped and
+// counted in Stats(). Retried payloads are sent after the ones written in the
+// meantime, so a gauge may be overwritten by an older value.
+// Calling it again hands the pending payloads over to the new bounds, and a
+// maxEntries of 0 or less disables the retries, dropping them
+func (c *StatsdClient) EnableRetry(maxEntries int, maxAge time.Duration) {
+	var q *retryQueue
+	if maxEntries > 0 {
+		q = &retryQueue{
+			maxEntries: maxEntries,
+			maxAge:     maxAge,
+			now:        c.now,
+			wake:       make(chan struct{}, 1),
+			done:       make(chan struct{}),
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if old := c.retry; old != nil {
+		old.stop()
+		old.mu.Lock()
+		entries := old.entries
+		old.entries = nil
+		old.mu.Unlock()
+		atomic.AddInt64(&c.retryDropped, atomic.LoadInt64(&old.dropped))
+		for _, e := range entries {
+			if q == nil {
+				atomic.AddInt64(&c.retryDropped, 1)
+				continue
+			}
+			q.pushEntry(e)
+		}
+	}
+	c.retry = q
+	if q != nil {
+		c.loops.Add(1)
+		go q.loop(c)
+	}
+}
+
+// push queues a payload, dropping the oldest one if the queue is full
+func (q *retryQueue) push(line string) {
+	q.pushEntry(retryEntry{line: line, created: q.now()})
+}
+
+// pushEntry queues an entry, see push
+func (q *retryQueue) pushEntry(e retryEntry) {
+	q.mu.Lock()
+	if len(q.entries) >= q.maxEntries {
+		q.entries = q.entries[1:]
+		atomic.AddInt64(&q.dropped, 1)
+	}
+	q.entries = append(q.entries, e)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop terminates the retry loop, the pending payloads are dropped
+func (q *retryQueue) stop() {
+	q.stopOnce.Do(func() { close(q.done) })
+}
+
+// head returns the oldest payload which is not expired yet
+func (q *retryQueue) head() (retryEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.entries) > 0 {
+		e := q.entries[0]
+		if q.now().Sub(e.created) <= q.maxAge {
+			return e, true
+		}
+		q.entries = q.entries[1:]
+		atomic.AddInt64(&q.dropped, 1)
+	}
+	return retryEntry{}, false
+}
+
+// pop removes the payload from the head of the queue, if it's still there
+func (q *retryQueue) pop(e retryEntry) {
+	q.mu.Lock()
+	if len(q.entries) > 0 && q.entries[0] == e {
+		q.entries = q.entries[1:]
+	}
+	q.mu.Unlock()
+}
+
+func (q *retryQueue) loop(c *StatsdClient) {
+	defer c.loops.Done()
+	backoff := minRetryBackoff
+	for {
+		e, ok := q.head()
+		if !ok {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+		c.mu.Lock()
+		if c.retry != q {
+			// replaced, its entries were handed over, see EnableRetry
+			c.mu.Unlock()
+			return
+		}
+		var err error = ErrClosed
+		if c.conn != nil && !c.closed {
+			_, err = c.conn.Write([]byte(e.line))
+			if err == nil {
+				c.mirrorPacket([]byte(e.line))
+			}
+		}
+		c.mu.Unlock()
+		if err == nil {
+			c.markSent()
+			q.pop(e)
+			backoff = minRetryBackoff
+			continue
+		}
+		select {
+		case <-time.After(backoff):
+		case <-q.done:
+			return
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
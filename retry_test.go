@@ -0,0 +1,193 @@
+package statsd
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// flakyConn is a net.Conn failing all the writes until a deadline
+type flakyConn struct {
+	net.Conn
+	mu      sync.Mutex
+	until   time.Time
+	written []string
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.until) {
+		return 0, errors.New("sendto: too many open files")
+	}
+	c.written = append(c.written, string(b))
+	return len(b), nil
+}
+
+func (c *flakyConn) Close() error { return nil }
+
+func (c *flakyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// lines returns the lines of all the packets written so far
+func (c *flakyConn) lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var lines []string
+	for _, packet := range c.written {
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	return lines
+}
+
+func TestRetryEventualDelivery(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(500 * time.Millisecond)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	client.EnableRetry(10, 10*time.Second)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		if err := client.Incr("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := client.Stats(); stats.RetryPending != 3 {
+		t.Errorf("expected 3 pending retries, actual %+v", stats)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for len(conn.lines()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expected := []string{"myproject.a:1|c", "myproject.a:2|c", "myproject.a:3|c"}
+	if lines := conn.lines(); len(lines) != 3 || lines[0] != expected[0] || lines[2] != expected[2] {
+		t.Errorf("expected %v, actual %v", expected, lines)
+	}
+	if stats := client.Stats(); stats.RetryPending != 0 || stats.RetryDropped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRetryBounds(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	client.EnableRetry(2, 50*time.Millisecond)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := int64(1); i <= 5; i++ {
+		client.Incr("a", i)
+	}
+	if stats := client.Stats(); stats.RetryPending != 2 || stats.RetryDropped != 3 {
+		t.Errorf("expected the queue to be capped, actual %+v", stats)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if stats := client.Stats(); stats.RetryPending != 0 || stats.RetryDropped != 5 {
+		t.Errorf("expected expired entries to be dropped, actual %+v", stats)
+	}
+}
+
+// the drops of a degraded pipeline are all accounted for by the client stats
+func TestRetryWithFaultySender(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	sender := statsdtest.NewFaultySender(nil)
+	client := NewStatsdClient(srv.Addr(), "")
+	client.SetDialer(sender.Dial)
+	client.EnableRetry(5, time.Minute)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the sender goes down: the queue retains the 5 newest payloads
+	sender.SetDown(true)
+	for i := 0; i < 20; i++ {
+		client.Incr("down", 1)
+	}
+	stats := client.Stats()
+	if stats.RetryPending+int(stats.RetryDropped) != 20 || stats.RetryDropped < 15 {
+		t.Errorf("expected 20 payloads queued or dropped, actual %+v", stats)
+	}
+
+	// it comes back: the queued payloads are delivered
+	sender.SetDown(false)
+	if _, err := srv.WaitFor("down", stats.RetryPending, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, time.Second, func() bool { return client.QueueDepth() == 0 })
+	if delivered := len(srv.Metrics()); int64(delivered)+client.Stats().RetryDropped != 20 {
+		t.Errorf("%d payloads delivered and %d dropped, expected 20", delivered, client.Stats().RetryDropped)
+	}
+}
+
+// a queue of 0 entries disables the retries instead of panicking on the first
+// failed write
+func TestRetryDisabled(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	client.EnableRetry(0, time.Minute)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Incr("a", 1); err == nil {
+		t.Error("expected the write error without retries")
+	}
+
+	// disabling them drops the pending payloads
+	client.EnableRetry(5, time.Minute)
+	client.Incr("a", 2)
+	client.EnableRetry(-1, time.Minute)
+	if stats := client.Stats(); stats.RetryPending != 0 || stats.RetryDropped != 1 || client.Config().RetryEntries != 0 {
+		t.Errorf("expected the pending payload to be dropped, actual %+v", stats)
+	}
+}
+
+// enabling the retries again hands the pending payloads over to the new queue,
+// whose loop is the only one retrying them
+func TestRetryReplaced(t *testing.T) {
+	conn := &flakyConn{until: time.Now().Add(time.Hour)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	client.EnableRetry(10, time.Minute)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := int64(1); i <= 3; i++ {
+		client.Incr("a", i)
+	}
+	client.EnableRetry(2, time.Minute)
+	if stats := client.Stats(); stats.RetryPending != 2 || stats.RetryDropped != 1 {
+		t.Errorf("expected the payloads handed over within the new bounds, actual %+v", stats)
+	}
+	conn.mu.Lock()
+	conn.until = time.Time{}
+	conn.mu.Unlock()
+	waitUntil(t, 3*time.Second, func() bool { return client.Stats().RetryPending == 0 })
+	time.Sleep(50 * time.Millisecond)
+	expected := []string{"myproject.a:2|c", "myproject.a:3|c"}
+	if lines := conn.lines(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+}
@@ -0,0 +1,349 @@
+package statsd
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// route is a compiled routing rule
+type route struct {
+	prefix  string
+	rewrite string
+	re      *regexp.Regexp
+	client  Statsd
+}
+
+// match tells whether the rule applies to the stat, returning the stat name to send
+func (r *route) match(stat string) (string, bool) {
+	if r.re != nil {
+		return stat, r.re.MatchString(stat)
+	}
+	if strings.HasPrefix(stat, r.prefix) {
+		return r.rewrite + stat[len(r.prefix):], true
+	}
+	return stat, false
+}
+
+// Router is a Statsd client dispatching every metric to a backend, according
+// to rules evaluated in order: the metrics matched by no rule are sent to the
+// fallback client. The rules must be added before the Router is used
+type Router struct {
+	routes   []*route
+	fallback Statsd
+}
+
+// NewRouter creates a Router sending the metrics matched by no rule to fallback
+func NewRouter(fallback Statsd) *Router {
+	return &Router{fallback: fallback}
+}
+
+// RoutePrefix sends the metrics whose name starts with prefix to client,
+// replacing the prefix with rewrite (pass the prefix itself to keep it)
+func (r *Router) RoutePrefix(prefix string, rewrite string, client Statsd) *Router {
+	r.routes = append(r.routes, &route{prefix: prefix, rewrite: rewrite, client: client})
+	return r
+}
+
+// RouteRegexp sends the metrics whose name matches re to client
+func (r *Router) RouteRegexp(re *regexp.Regexp, client Statsd) *Router {
+	r.routes = append(r.routes, &route{re: re, client: client})
+	return r
+}
+
+// route returns the backend of a stat, and the stat name to send to it
+func (r *Router) route(stat string) (Statsd, string) {
+	for _, rt := range r.routes {
+		if name, ok := rt.match(stat); ok {
+			return rt.client, name
+		}
+	}
+	return r.fallback, stat
+}
+
+// clients returns all the backends, once each
+func (r *Router) clients() []Statsd {
+	clients := []Statsd{r.fallback}
+	for _, rt := range r.routes {
+		found := false
+		for _, c := range clients {
+			found = found || c == rt.client
+		}
+		if !found {
+			clients = append(clients, rt.client)
+		}
+	}
+	return clients
+}
+
+// CreateSocket connects all the backends, returning the first error
+func (r *Router) CreateSocket() error {
+	var err error
+	for _, c := range r.clients() {
+		if err2 := c.CreateSocket(); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Close closes all the backends, returning the first error
+func (r *Router) Close() error {
+	var err error
+	for _, c := range r.clients() {
+		if err2 := c.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Incr - Increment a counter metric. Often used to note a particular event
+func (r *Router) Incr(stat string, count int64) error {
+	c, stat := r.route(stat)
+	return c.Incr(stat, count)
+}
+
+// Decr - Decrement a counter metric. Often used to note a particular event
+func (r *Router) Decr(stat string, count int64) error {
+	c, stat := r.route(stat)
+	return c.Decr(stat, count)
+}
+
+// Timing - Track a duration event
+func (r *Router) Timing(stat string, delta int64) error {
+	c, stat := r.route(stat)
+	return c.Timing(stat, delta)
+}
+
+// PrecisionTiming - Track a duration event
+func (r *Router) PrecisionTiming(stat string, delta time.Duration) error {
+	c, stat := r.route(stat)
+	return c.PrecisionTiming(stat, delta)
+}
+
+// TimingMicroseconds - Track a duration event given in microseconds
+func (r *Router) TimingMicroseconds(stat string, us float64) error {
+	c, stat := r.route(stat)
+	return c.TimingMicroseconds(stat, us)
+}
+
+// FTiming - Track a duration event given in floating point milliseconds
+func (r *Router) FTiming(stat string, ms float64) error {
+	c, stat := r.route(stat)
+	return fTiming(c, stat, ms)
+}
+
+// Since - Track the time elapsed since start
+func (r *Router) Since(stat string, start time.Time) error {
+	c, stat := r.route(stat)
+	return since(c, stat, start)
+}
+
+// Observe - Track the outcome and the latency of a fallible call, see StatsdClient.Observe
+func (r *Router) Observe(stat string, start time.Time, err error) error {
+	c, stat := r.route(stat)
+	return observeOn(c, stat, start, err)
+}
+
+// ObserveFunc - Call fn and track its outcome like Observe, returning the error of fn
+func (r *Router) ObserveFunc(stat string, fn func() error) error {
+	c, stat := r.route(stat)
+	return observeFuncOn(c, stat, fn)
+}
+
+// Gauge - Gauges are a constant data type
+func (r *Router) Gauge(stat string, value int64) error {
+	c, stat := r.route(stat)
+	return c.Gauge(stat, value)
+}
+
+// GaugeDelta -- Send a change for a gauge
+func (r *Router) GaugeDelta(stat string, value int64) error {
+	c, stat := r.route(stat)
+	return c.GaugeDelta(stat, value)
+}
+
+// GaugeMax - Track the highest value of a gauge, see StatsdBuffer.GaugeMax
+func (r *Router) GaugeMax(stat string, value int64) error {
+	c, stat := r.route(stat)
+	return gaugeMax(c, stat, value)
+}
+
+// GaugeMin - Track the lowest value of a gauge, see StatsdBuffer.GaugeMin
+func (r *Router) GaugeMin(stat string, value int64) error {
+	c, stat := r.route(stat)
+	return gaugeMin(c, stat, value)
+}
+
+// Absolute - Send absolute-valued metric (not averaged/aggregated)
+func (r *Router) Absolute(stat string, value int64) error {
+	c, stat := r.route(stat)
+	return c.Absolute(stat, value)
+}
+
+// Total - Send a metric that is continously increasing, e.g. read operations since boot
+func (r *Router) Total(stat string, value int64) error {
+	c, stat := r.route(stat)
+	return c.Total(stat, value)
+}
+
+// Unique - Count the unique values of a set
+func (r *Router) Unique(stat string, value string) error {
+	c, stat := r.route(stat)
+	return unique(c, stat, value)
+}
+
+// sendTyped routes a metric sendMetric can't send with the methods of the
+// router, see typedSender
+func (r *Router) sendTyped(m wire.Metric, value float64) error {
+	c, stat := r.route(m.Name)
+	m.Name = stat
+	return sendTyped(c, m, value)
+}
+
+// FGauge -- Send a floating point value for a gauge
+func (r *Router) FGauge(stat string, value float64) error {
+	c, stat := r.route(stat)
+	return c.FGauge(stat, value)
+}
+
+// FGaugeDelta -- Send a floating point change for a gauge
+func (r *Router) FGaugeDelta(stat string, value float64) error {
+	c, stat := r.route(stat)
+	return c.FGaugeDelta(stat, value)
+}
+
+// FAbsolute - Send absolute-valued floating point metric (not averaged/aggregated)
+func (r *Router) FAbsolute(stat string, value float64) error {
+	c, stat := r.route(stat)
+	return c.FAbsolute(stat, value)
+}
+
+// routedKeys maps the names sent to a backend back to the original stat
+// names, several stats being possibly routed to the same name
+type routedKeys map[string][]string
+
+// add records that stat is sent as name
+func (k routedKeys) add(name string, stat string) {
+	k[name] = append(k[name], stat)
+}
+
+// IncrMap increments all the counters in the map, with one batch call per backend
+func (r *Router) IncrMap(counts map[string]int64) error {
+	batches := make(map[Statsd]map[string]int64)
+	keys := make(map[Statsd]routedKeys)
+	for stat, count := range counts {
+		c, name := r.route(stat)
+		if batches[c] == nil {
+			batches[c], keys[c] = make(map[string]int64), make(routedKeys)
+		}
+		// the counters routed to the same name add up
+		batches[c][name] += count
+		keys[c].add(name, stat)
+	}
+	errs := make(map[string]error)
+	for c, batch := range batches {
+		keys[c].collect(c.IncrMap(batch), errs)
+	}
+	return mapError(errs)
+}
+
+// GaugeMap sets all the gauges in the map, with one batch call per backend
+func (r *Router) GaugeMap(values map[string]int64) error {
+	batches := make(map[Statsd]map[string]int64)
+	keys := make(map[Statsd]routedKeys)
+	for stat, value := range values {
+		c, name := r.route(stat)
+		if batches[c] == nil {
+			batches[c], keys[c] = make(map[string]int64), make(routedKeys)
+		}
+		batches[c][name] = value
+		keys[c].add(name, stat)
+	}
+	errs := make(map[string]error)
+	for c, batch := range batches {
+		keys[c].collect(c.GaugeMap(batch), errs)
+	}
+	return mapError(errs)
+}
+
+// TimingSlices tracks all the durations in the map, with one batch call per backend
+func (r *Router) TimingSlices(timings map[string][]time.Duration) error {
+	batches := make(map[Statsd]map[string][]time.Duration)
+	keys := make(map[Statsd]routedKeys)
+	for stat, deltas := range timings {
+		c, name := r.route(stat)
+		if batches[c] == nil {
+			batches[c], keys[c] = make(map[string][]time.Duration), make(routedKeys)
+		}
+		batches[c][name] = append(batches[c][name], deltas...)
+		keys[c].add(name, stat)
+	}
+	errs := make(map[string]error)
+	for c, batch := range batches {
+		keys[c].collect(c.TimingSlices(batch), errs)
+	}
+	return mapError(errs)
+}
+
+// SendEvents sends the events with one batch call per backend, copies of the
+// events being renamed like the stats
+func (r *Router) SendEvents(events ...event.Event) error {
+	batches := make(map[Statsd][]event.Event)
+	keys := make(map[Statsd]routedKeys)
+	errs := make(map[string]error)
+	for _, e := range events {
+		stat := e.Key()
+		c, name := r.route(stat)
+		if name != stat {
+			renamed, err := withKey(e, name)
+			if err != nil {
+				errs[stat] = err
+				continue
+			}
+			e = renamed
+		}
+		if keys[c] == nil {
+			keys[c] = make(routedKeys)
+		}
+		keys[c].add(name, stat)
+		batches[c] = append(batches[c], e)
+	}
+	for c, batch := range batches {
+		keys[c].collect(sendEvents(c, batch), errs)
+	}
+	return mapError(errs)
+}
+
+// collect records the error of a backend batch call under the original stat
+// names: a MapError for the keys it reports, any other error for all the keys
+func (k routedKeys) collect(err error, errs map[string]error) {
+	if err == nil {
+		return
+	}
+	if mapErr, ok := err.(*MapError); ok {
+		for name, err := range mapErr.Errors {
+			for _, stat := range k[name] {
+				errs[stat] = err
+			}
+		}
+		return
+	}
+	for _, stats := range k {
+		for _, stat := range stats {
+			errs[stat] = err
+		}
+	}
+}
+
+// mapError returns a MapError for the given errors, or nil if there are none
+func mapError(errs map[string]error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &MapError{Errors: errs}
+}
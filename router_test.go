@@ -0,0 +1,75 @@
+package statsd
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var _ Statsd = (*Router)(nil)
+
+func TestRouter(t *testing.T) {
+	infra, infraConn := newPacketClient(t, "infra.")
+	product, productConn := newPacketClient(t, "")
+	audit, auditConn := newPacketClient(t, "audit.")
+	router := NewRouter(infra).
+		RoutePrefix("product.", "analytics.", product).
+		RouteRegexp(regexp.MustCompile(`\.login$`), audit).
+		RoutePrefix("product.login", "never.", audit) // shadowed by the first rule
+
+	router.Incr("requests", 1)
+	router.Incr("product.orders", 2)
+	router.Incr("product.login", 3)
+	router.Gauge("user.login", 4)
+	if err := router.IncrMap(map[string]int64{"product.clicks": 5, "cpu": 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"infra.requests:1|c", "infra.cpu:6|c"}; !reflect.DeepEqual(expected, infraConn.packets) {
+		t.Errorf("infra: expected %q, actual %q", expected, infraConn.packets)
+	}
+	if expected := []string{"analytics.orders:2|c", "analytics.login:3|c", "analytics.clicks:5|c"}; !reflect.DeepEqual(expected, productConn.packets) {
+		t.Errorf("product: expected %q, actual %q", expected, productConn.packets)
+	}
+	if expected := []string{"audit.user.login:4|g"}; !reflect.DeepEqual(expected, auditConn.packets) {
+		t.Errorf("audit: expected %q, actual %q", expected, auditConn.packets)
+	}
+
+	// errors are reported under the original names
+	product.SetStrictNames(true)
+	err := router.IncrMap(map[string]int64{"product.bad|name": 1, "good": 1})
+	if mapErr, ok := err.(*MapError); !ok || len(mapErr.Errors) != 1 || mapErr.Errors["product.bad|name"] != ErrInvalidName {
+		t.Errorf("expected a MapError for product.bad|name, actual %v", err)
+	}
+
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]*StatsdClient{"infra": infra, "product": product, "audit": audit} {
+		if err := c.Incr("a", 1); err != ErrClosed {
+			t.Errorf("%s not closed: %v", name, err)
+		}
+	}
+}
+
+func TestRouterSharedNames(t *testing.T) {
+	backend, conn := newPacketClient(t, "")
+	router := NewRouter(backend).
+		RoutePrefix("eu.", "", backend).
+		RoutePrefix("us.", "", backend)
+
+	// the stats routed to the same name are merged, not overwritten
+	if err := router.IncrMap(map[string]int64{"eu.orders": 1, "us.orders": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"orders:3|c"}; !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+
+	// and their errors are reported under all the original names
+	backend.SetStrictNames(true)
+	err := router.IncrMap(map[string]int64{"eu.bad|name": 1, "us.bad|name": 1})
+	if mapErr, ok := err.(*MapError); !ok || len(mapErr.Errors) != 2 || mapErr.Errors["eu.bad|name"] == nil || mapErr.Errors["us.bad|name"] == nil {
+		t.Errorf("expected a MapError for both names, actual %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package statsd
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// sampling is an immutable sampling configuration, swapped atomically
+type sampling struct {
+	rate   float64
+	suffix string // appended to the sampled lines, e.g. "|@0.1"
+}
+
+// SetSampleRate makes the client send only a random fraction (0 < rate < 1) of
+// the counters and timings, tagged with the rate so that the server scales them
+// back. Gauges, absolutes and totals are never sampled, and neither are the
+// stats of the buffered client, which are already aggregated. The metrics
+// skipped are counted by kind in Stats(). A rate >= 1 disables sampling, a
+// rate <= 0 (which would drop everything, and which the servers reject) returns
+// an error and leaves the sampling as it was
+func (c *StatsdClient) SetSampleRate(rate float64) error {
+	if !(rate > 0) {
+		return fmt.Errorf("statsd: invalid sample rate %v, it must be positive", rate)
+	}
+	if rate >= 1 {
+		c.sampling.Store((*sampling)(nil))
+		return nil
+	}
+	c.sampling.Store(&sampling{rate: rate, suffix: string(wire.AppendSampleRate(nil, rate))})
+	return nil
+}
+
+// sample decides, before formatting, whether a metric of stat is sent, and
+// returns the suffix to append to its line. The metrics skipped are counted by kind
+func (c *StatsdClient) sample(kind MetricKind, stat string) (string, bool) {
+	if kind != KindCounter && kind != KindTiming {
+		return "", true
+	}
+	if a, _ := c.adaptive.Load().(*adaptive); a != nil {
+		return c.sampleAdaptive(a, kind, stat)
+	}
+	s, _ := c.sampling.Load().(*sampling)
+	if s == nil {
+		return "", true
+	}
+	if c.randomFloat() >= s.rate {
+		atomic.AddInt64(&c.sampledOut[kind], 1)
+		c.count(kind, outcomeDropped, 1)
+		return "", false
+	}
+	return s.suffix, true
+}
+
+// randomFloat returns a random number in [0, 1)
+func (c *StatsdClient) randomFloat() float64 {
+	if c.random == nil {
+		return rand.Float64()
+	}
+	return c.random()
+}
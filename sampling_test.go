@@ -0,0 +1,63 @@
+package statsd
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampledOut(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.random = rand.New(rand.NewSource(1)).Float64
+	client.SetSampleRate(0.1)
+
+	const calls = 10000
+	for i := 0; i < calls; i++ {
+		client.Incr("c", 1)
+		client.PrecisionTiming("t", time.Millisecond)
+		client.Gauge("g", 1)
+	}
+	sent := map[string]int64{}
+	for _, p := range conn.packets {
+		name := p[:strings.IndexByte(p, ':')]
+		sent[name]++
+		if name != "g" && !strings.HasSuffix(p, "|@0.1") {
+			t.Errorf("sample rate missing from %q", p)
+		}
+	}
+	stats := client.Stats()
+	if sent["c"]+stats.SampledOut[KindCounter] != calls {
+		t.Errorf("counters: %d sent + %d sampled out != %d calls", sent["c"], stats.SampledOut[KindCounter], calls)
+	}
+	if sent["t"]+stats.SampledOut[KindTiming] != calls {
+		t.Errorf("timings: %d sent + %d sampled out != %d calls", sent["t"], stats.SampledOut[KindTiming], calls)
+	}
+	if sent["g"] != calls || stats.SampledOut[KindGauge] != 0 {
+		t.Errorf("gauges must not be sampled: %d sent, %d sampled out", sent["g"], stats.SampledOut[KindGauge])
+	}
+	if sent["c"] < calls/20 || sent["c"] > calls/5 {
+		t.Errorf("%d counters sent at a 10%% sample rate", sent["c"])
+	}
+
+	client.SetSampleRate(1)
+	conn.packets = nil
+	client.Incr("c", 1)
+	if len(conn.packets) != 1 || conn.packets[0] != "c:1|c" {
+		t.Errorf("sampling not disabled: %q", conn.packets)
+	}
+}
+
+func TestSampleRateInvalid(t *testing.T) {
+	client := NewStatsdClient("localhost:8125", "")
+	client.SetSampleRate(0.5)
+	for _, rate := range []float64{0, -0.1, math.NaN()} {
+		if err := client.SetSampleRate(rate); err == nil {
+			t.Errorf("expected an error for a rate of %v", rate)
+		}
+	}
+	if rate := client.Config().SampleRate; rate != 0.5 {
+		t.Errorf("expected the previous rate to be kept, actual %v", rate)
+	}
+}
@@ -0,0 +1,38 @@
+package statsd
+
+import "github.com/CrowdSurge/statsd/wire"
+
+// ErrInvalidName is returned in strict mode when a string-valued field
+// contains characters reserved by the wire format
+var ErrInvalidName = wire.ErrInvalidName
+
+// Field identifies a string-valued field of a metric line:
+// each one has its own set of reserved characters, see wire.Field
+type Field = wire.Field
+
+// string-valued fields known to the serializer
+const (
+	FieldName      = wire.FieldName
+	FieldSetMember = wire.FieldSetMember
+	FieldTagValue  = wire.FieldTagValue
+	FieldEventText = wire.FieldEventText
+)
+
+// Escape replaces the characters reserved for the given field, see wire.Escape
+func Escape(field Field, s string) string {
+	return wire.Escape(field, s)
+}
+
+// Validate returns ErrInvalidName if s contains characters reserved for the
+// given field, see wire.Validate
+func Validate(field Field, s string) error {
+	return wire.Validate(field, s)
+}
+
+// NormalizeName lowercases a stat name, replaces the common unicode lookalikes
+// and whitespace, collapses the runs of separators ('.', '-' and '_') and
+// trims the leading and trailing ones, so that e.g. "HTTP..Requests." becomes
+// "http.requests", see wire.NormalizeName
+func NormalizeName(stat string) string {
+	return wire.NormalizeName(stat)
+}
@@ -0,0 +1,162 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		field    Field
+		in       string
+		expected string
+	}{
+		{FieldName, "a.b:c", "a.b:c"},
+		{FieldName, "a|b\nc:1|c", "a_b_c:1_c"},
+		{FieldSetMember, "user:1|s", "user_1_s"},
+		{FieldTagValue, "a,b#c", "a_b_c"},
+		{FieldEventText, "line1\nline2|x\r", `line1\nline2_x`},
+	}
+	for _, tt := range tests {
+		if actual := Escape(tt.field, tt.in); actual != tt.expected {
+			t.Errorf("Escape(%d, %q): expected %q, actual %q", tt.field, tt.in, tt.expected, actual)
+		}
+		if err := Validate(tt.field, tt.in); (err == nil) != (tt.in == tt.expected) {
+			t.Errorf("Validate(%d, %q): unexpected %v", tt.field, tt.in, err)
+		}
+	}
+}
+
+func TestStrictNames(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetStrictNames(true)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Incr("a:1|c\nevil", 1); err != ErrInvalidName {
+		t.Errorf("expected ErrInvalidName, actual %v", err)
+	}
+	buffered := NewStatsdBuffer(time.Hour, client)
+	if err := buffered.Incr("a|b", 1); err != ErrInvalidName {
+		t.Errorf("expected ErrInvalidName, actual %v", err)
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		stat     string
+		expected string
+	}{
+		{"HTTP.Requests", "http.requests"},
+		{"http.requests", "http.requests"},
+		{"api..v2...latency", "api.v2.latency"},
+		{".leading.and.trailing.", "leading.and.trailing"},
+		{"...", ""},
+		{"Queue Depth.Orders", "queue_depth.orders"},
+		{"cache\u00a0hits", "cache_hits"},
+		{"db\u200b.queries", "db.queries"},
+		{"checkout\u2013flow.errors", "checkout-flow.errors"},
+		{"web\uff0erequests", "web.requests"},
+		{"\u0441ache.hits", "cache.hits"}, // Cyrillic es
+		{"Payments.\u0420rocessed", "payments.processed"},
+		{"ÜBER.Grüße", "über.grüße"},
+		{"jobs.Failed_Total", "jobs.failed_total"},
+		{"Queue  Depth__Orders", "queue_depth_orders"},
+		{"checkout--flow.errors", "checkout-flow.errors"},
+		{"checkout - flow", "checkout-flow"},
+		{"_private_.Hits-", "private.hits"},
+		{"api_.v2-.latency", "api.v2.latency"},
+		{" -_. ", ""},
+	}
+	for _, tt := range tests {
+		if actual := NormalizeName(tt.stat); actual != tt.expected {
+			t.Errorf("%q: expected %q, actual %q", tt.stat, tt.expected, actual)
+		}
+	}
+}
+
+func TestNormalizeNames(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "MyProject.")
+	client.SetNormalizeNames(true)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Incr("HTTP.Requests", 1)
+	buffered.Incr("http..requests", 2)
+	buffered.Incr("Http.Requests.", 3)
+	buffered.Close()
+
+	metrics, err := srv.WaitFor("MyProject.http.requests", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[0].Value != "6" {
+		t.Errorf("expected the variants to be merged, actual %+v", srv.Metrics())
+	}
+}
+
+func TestEmptyName(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetNormalizeNames(true)
+	for _, name := range []string{"", "...", "-_.", "._-"} {
+		if err := client.Incr(name, 1); err != ErrEmptyName {
+			t.Errorf("%q: expected ErrEmptyName, actual %v", name, err)
+		}
+	}
+	if len(conn.packets) != 0 {
+		t.Errorf("unexpected packets %q", conn.packets)
+	}
+	if stats := client.StatsByKind()[KindCounter]; stats.Dropped != 4 || stats.Errors != 0 {
+		t.Errorf("expected 4 counters dropped, actual %+v", stats)
+	}
+
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	if err := buffered.Gauge("..", 1); err != ErrEmptyName {
+		t.Errorf("expected ErrEmptyName, actual %v", err)
+	}
+	buffered.Gauge("g", 1)
+	buffered.Close()
+	if len(conn.packets) != 1 || conn.packets[0] != "myproject.g:1|g" {
+		t.Errorf("unexpected packets %q", conn.packets)
+	}
+	if stats := client.StatsByKind()[KindGauge]; stats.Dropped != 1 || stats.Sent != 1 {
+		t.Errorf("expected 1 gauge dropped, actual %+v", stats)
+	}
+}
+
+// whatever the stat name, a single send must never produce more than one metric
+func FuzzStatName(f *testing.F) {
+	srv, err := statsdtest.NewServer()
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer srv.Close()
+	client := NewStatsdClient(srv.Addr(), "fuzz.")
+	if err := client.CreateSocket(); err != nil {
+		f.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, seed := range []string{"a", "a:1|c\nb:2|c", "x|@0.1|#tag:evil", "\r\n\n", ":|:|", "a\nb\nc\nd"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, name []byte) {
+		srv.Reset()
+		client.Incr(string(name), 1)
+		client.Incr("sentinel", 1)
+		if _, err := srv.WaitFor("fuzz.sentinel", 1, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(srv.Metrics()); n > 2 {
+			t.Errorf("name %q produced %d metrics: %+v", name, n-1, srv.Metrics())
+		}
+	})
+}
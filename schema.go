@@ -0,0 +1,113 @@
+package statsd
+
+import (
+	"container/list"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSchemaViolation is returned in strict mode for the names which don't
+// match the schema, see SetNameSchema
+var ErrSchemaViolation = errors.New("statsd: metric name violates the schema")
+
+// schemaCacheSize is the number of distinct names whose check is cached
+const schemaCacheSize = 4096
+
+// nameSchema checks the full metric names, caching the verdicts of the least
+// recently used names in bounded memory
+type nameSchema struct {
+	re       *regexp.Regexp
+	prefixes []string
+	maxNames int
+	mu       sync.Mutex
+	names    map[string]*list.Element
+	lru      *list.List // of *schemaVerdict, the most recently used first
+	checks   int64      // names actually checked, for the tests
+}
+
+type schemaVerdict struct {
+	name string
+	ok   bool
+}
+
+// SetNameSchema enforces a naming schema on the full metric names, prefix
+// included: they must match re, if not nil, and start with one of the
+// allowedPrefixes, if any, e.g. `^[a-z0-9_]+(\.[a-z0-9_]+)*$` and "payments.".
+// In strict mode (see SetStrictNames) the metrics violating it are rejected
+// with ErrSchemaViolation, otherwise they're sent, counted in
+// Stats().SchemaViolations, and each distinct name is logged once while it's
+// cached. The verdicts of the 4096 most recently used names are cached, so
+// that re doesn't run on every call. nil and no prefixes removes the schema
+func (c *StatsdClient) SetNameSchema(re *regexp.Regexp, allowedPrefixes []string) {
+	if re == nil && len(allowedPrefixes) == 0 {
+		c.schema.Store((*nameSchema)(nil))
+		return
+	}
+	c.schema.Store(newNameSchema(re, allowedPrefixes, schemaCacheSize))
+}
+
+func newNameSchema(re *regexp.Regexp, allowedPrefixes []string, maxNames int) *nameSchema {
+	return &nameSchema{
+		re:       re,
+		prefixes: append([]string(nil), allowedPrefixes...),
+		maxNames: maxNames,
+		names:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// check tells whether name conforms to the schema, and whether the verdict
+// was just computed rather than cached
+func (s *nameSchema) check(name string) (ok bool, fresh bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, found := s.names[name]; found {
+		s.lru.MoveToFront(el)
+		return el.Value.(*schemaVerdict).ok, false
+	}
+	s.checks++
+	ok = s.re == nil || s.re.MatchString(name)
+	if ok && len(s.prefixes) > 0 {
+		ok = false
+		for _, p := range s.prefixes {
+			if strings.HasPrefix(name, p) {
+				ok = true
+				break
+			}
+		}
+	}
+	s.names[name] = s.lru.PushFront(&schemaVerdict{name: name, ok: ok})
+	if s.lru.Len() > s.maxNames {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.names, oldest.Value.(*schemaVerdict).name)
+	}
+	return ok, true
+}
+
+// checkSchema checks the full name of a metric against the schema, if any,
+// counting and logging the violations only if track is true (see resolveName)
+func (c *StatsdClient) checkSchema(prefix string, stat string, track bool) error {
+	s, _ := c.schema.Load().(*nameSchema)
+	if s == nil {
+		return nil
+	}
+	name := prefix + stat
+	ok, fresh := s.check(name)
+	if ok {
+		return nil
+	}
+	if track {
+		atomic.AddInt64(&c.schemaViolations, 1)
+	}
+	if atomic.LoadInt32(&c.strict) != 0 {
+		return ErrSchemaViolation
+	}
+	if fresh && track {
+		c.Logger.Println("Metric name violating the schema:", name)
+	}
+	return nil
+}
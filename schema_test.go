@@ -0,0 +1,80 @@
+package statsd
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var testSchema = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+
+func TestNameSchemaCache(t *testing.T) {
+	s := newNameSchema(testSchema, nil, 2)
+	for _, name := range []string{"a", "a", "B", "a", "c"} {
+		s.check(name)
+	}
+	// a was used more recently than B, which is evicted
+	if s.checks != 3 || s.lru.Len() != 2 || s.names["B"] != nil {
+		t.Errorf("unexpected cache: %d checks, names %v", s.checks, s.names)
+	}
+	if ok, fresh := s.check("a"); !ok || fresh {
+		t.Errorf("expected a cached valid name, actual ok %v, fresh %v", ok, fresh)
+	}
+	if ok, fresh := s.check("B"); ok || !fresh {
+		t.Errorf("expected a new invalid name, actual ok %v, fresh %v", ok, fresh)
+	}
+}
+
+func TestNameSchema(t *testing.T) {
+	client, conn := newPacketClient(t, "payments.")
+	logger := &recordingLogger{}
+	client.Logger = logger
+	client.SetNameSchema(testSchema, []string{"payments.", "billing."})
+
+	// lenient: sent, counted, and logged once
+	client.Incr("charges", 1)
+	client.Incr("Bad-Name", 1)
+	client.Incr("Bad-Name", 1)
+	if n := client.Stats().SchemaViolations; n != 2 {
+		t.Errorf("expected 2 violations, actual %d", n)
+	}
+	expected := []string{"payments.charges:1|c", "payments.Bad-Name:1|c", "payments.Bad-Name:1|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	if len(logger.lines) != 1 || logger.lines[0] != "Metric name violating the schema: payments.Bad-Name\n" {
+		t.Errorf("unexpected log %q", logger.lines)
+	}
+
+	// strict: rejected, by the buffered client as well
+	client.SetStrictNames(true)
+	unprefixed, _ := newPacketClient(t, "other.")
+	unprefixed.SetStrictNames(true)
+	unprefixed.SetNameSchema(nil, []string{"payments."})
+	if err := unprefixed.Incr("charges", 1); err != ErrSchemaViolation {
+		t.Errorf("expected ErrSchemaViolation, actual %v", err)
+	}
+	if err := client.Incr("Bad-Name", 1); err != ErrSchemaViolation {
+		t.Errorf("expected ErrSchemaViolation, actual %v", err)
+	}
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	if err := buffered.Gauge("Bad-Name", 1); err != ErrSchemaViolation {
+		t.Errorf("expected ErrSchemaViolation, actual %v", err)
+	}
+	buffered.Gauge("refunds", 1)
+	buffered.Close()
+	expected = append(expected, "payments.refunds:1|g")
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	if n := client.Stats().SchemaViolations; n != 4 {
+		t.Errorf("expected 4 violations, actual %d", n)
+	}
+
+	unprefixed.SetNameSchema(nil, nil)
+	if err := unprefixed.Incr("charges", 1); err != nil {
+		t.Errorf("schema removed: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// sequenceName is the name of the gauge numbering the packets, see SetSequenceTrailer
+const sequenceName = "statsd.client.seq"
+
+// maxSequenceDigits is the length of the largest sequence number
+const maxSequenceDigits = 19
+
+// SetSequenceTrailer makes the client number its packets, to measure the ones
+// lost on the way: every packet ends with a gauge line carrying the sequence
+// number of the packet, after the prefix,
+//
+//	myproject.statsd.client.seq:42|g
+//
+// so that the gaps seen by the receiving end (e.g. a Relay or a statsdtest.Server)
+// are the packets lost, and the rate of the gauge is the packet rate. The
+// numbers keep increasing across reconnects. The packets are built with room
+// for the trailer; the few payloads too large for it (e.g. a single huge line)
+// are sent without a number, as are the packets in Graphite mode
+func (c *StatsdClient) SetSequenceTrailer(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&c.numbered, v)
+}
+
+// sequenceReserve returns the room to leave in the packets for the trailer
+func (c *StatsdClient) sequenceReserve() int {
+	if atomic.LoadInt32(&c.numbered) == 0 || c.isGraphite() {
+		return 0
+	}
+	prefix, _ := c.currentPrefix()
+	return len("\n") + len(prefix) + len(sequenceName) + len(":") + maxSequenceDigits + len("|g")
+}
+
+// payloadSize returns the maximum size of a packet before the trailer.
+// The caller must hold c.mu
+func (c *StatsdClient) payloadSize() int {
+	return c.packetSize - c.sequenceReserve()
+}
+
+// withSequence returns the payload with its sequence trailer, if enabled and if it
+// fits. The caller must hold c.mu
+func (c *StatsdClient) withSequence(payload []byte) []byte {
+	reserve := c.sequenceReserve()
+	if reserve == 0 || len(payload)+reserve > c.packetSize {
+		return payload
+	}
+	prefix, _ := c.currentPrefix()
+	seq := atomic.AddInt64(&c.sequence, 1)
+	c.seqBuf = append(append(c.seqBuf[:0], payload...), '\n')
+	c.seqBuf = append(append(c.seqBuf, Escape(FieldName, prefix)...), sequenceName+":"...)
+	c.seqBuf = append(strconv.AppendInt(c.seqBuf, seq, 10), "|g"...)
+	return c.seqBuf
+}
@@ -0,0 +1,110 @@
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sequenceOf returns the sequence number ending a packet, or -1
+func sequenceOf(prefix, packet string) int64 {
+	lines := strings.Split(packet, "\n")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, prefix+sequenceName+":") || !strings.HasSuffix(last, "|g") {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(last, prefix+sequenceName+":"), "|g"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func TestSequenceTrailer(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetSequenceTrailer(true)
+	client.SetMaxPacketSize(100)
+	client.Incr("hits", 1)
+	counts := make(map[string]int64)
+	for i := 0; i < 50; i++ {
+		counts[fmt.Sprintf("key%d", i)] = int64(i + 1)
+	}
+	if err := client.IncrMap(counts); err != nil {
+		t.Fatal(err)
+	}
+	client.Gauge("queue", -3) // two lines, in a single packet
+
+	packets := conn.sent()
+	if len(packets) < 4 {
+		t.Fatalf("expected several packets, actual %q", packets)
+	}
+	received := 0
+	for i, packet := range packets {
+		if len(packet) > 100 {
+			t.Errorf("packet of %d bytes: %q", len(packet), packet)
+		}
+		if seq := sequenceOf("myproject.", packet); seq != int64(i+1) {
+			t.Errorf("expected the sequence number %d, actual %d in %q", i+1, seq, packet)
+		}
+		received += strings.Count(packet, "|c")
+	}
+	if received != 51 {
+		t.Errorf("expected 51 counters, actual %d", received)
+	}
+	if seq := client.Stats().Sequence; seq != int64(len(packets)) {
+		t.Errorf("expected the last sequence number %d, actual %d", len(packets), seq)
+	}
+}
+
+func TestSequenceReconnect(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetSequenceTrailer(true)
+	for i := 0; i < 3; i++ {
+		if err := client.CreateSocket(); err != nil {
+			t.Fatal(err)
+		}
+		client.Incr("hits", 1)
+		client.Timing("latency", 5)
+	}
+	defer client.Close()
+	metrics, err := srv.WaitFor("myproject."+sequenceName, 6, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last int64
+	for _, m := range metrics {
+		seq, _ := strconv.ParseInt(m.Value, 10, 64)
+		if seq <= last {
+			t.Errorf("the sequence number %d follows %d", seq, last)
+		}
+		last = seq
+	}
+	if last != 6 {
+		t.Errorf("expected 6 packets, actual %d", last)
+	}
+}
+
+func TestSequenceTooLarge(t *testing.T) {
+	client, conn := newPacketClient(t, "")
+	client.SetSequenceTrailer(true)
+	client.SetMaxPacketSize(70)
+	line := strings.Repeat("x", 40) + ":1|c"
+	if err := client.WriteRaw([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("a", 1)
+	if packets := conn.sent(); len(packets) != 2 || packets[0] != line || packets[1] != "a:1|c\nstatsd.client.seq:1|g" {
+		t.Errorf("unexpected packets %q", packets)
+	}
+
+	// off by default
+	client, conn = newPacketClient(t, "")
+	client.Incr("a", 1)
+	if packets := conn.sent(); len(packets) != 1 || packets[0] != "a:1|c" {
+		t.Errorf("unexpected packets %q", packets)
+	}
+}
@@ -0,0 +1,74 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Closer is implemented by both the direct and the buffered client
+type Closer interface {
+	Close() error
+}
+
+// DefaultShutdownTimeout is the maximum time FlushOnShutdown waits for each of
+// the clients without a close timeout of their own to close, so that a
+// black-holed network can't hang the shutdown
+const DefaultShutdownTimeout = 5 * time.Second
+
+// closeTimeouter is implemented by the clients bounding their own Close, see
+// StatsdBuffer.SetCloseTimeout
+type closeTimeouter interface {
+	closeTimeoutValue() time.Duration
+}
+
+// shutdownTimeout returns the time FlushOnShutdown waits for the clients:
+// the sum of their close timeouts, DefaultShutdownTimeout for the clients
+// without one
+func shutdownTimeout(clients []Closer) time.Duration {
+	var timeout time.Duration
+	for _, c := range clients {
+		if t, ok := c.(closeTimeouter); ok {
+			timeout += t.closeTimeoutValue()
+		} else {
+			timeout += DefaultShutdownTimeout
+		}
+	}
+	return timeout
+}
+
+// FlushOnShutdown waits for ctx to be cancelled, then closes the given clients in
+// order: buffered clients drain their queue and flush the pending stats, and all
+// sockets are closed. Combine it with signal.NotifyContext to flush on SIGTERM.
+// The returned channel receives the first Close error (or nil) once all the
+// clients are closed, or a timeout error once the clients had their close
+// timeout each (see StatsdBuffer.SetCloseTimeout, DefaultShutdownTimeout for
+// the other clients). It is safe for the application to also call Close on
+// the same clients.
+func FlushOnShutdown(ctx context.Context, clients ...Closer) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		closed := make(chan error, 1)
+		go func() {
+			var err error
+			for _, c := range clients {
+				// the application may have closed some of the clients already
+				if err2 := c.Close(); err2 != nil && err2 != ErrClosed && err == nil {
+					err = err2
+				}
+			}
+			closed <- err
+		}()
+		timeout := shutdownTimeout(clients)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case err := <-closed:
+			done <- err
+		case <-timer.C:
+			done <- fmt.Errorf("statsd shutdown timed out after %s", timeout)
+		}
+	}()
+	return done
+}
@@ -0,0 +1,55 @@
+package statsd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFlushOnShutdown(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+
+	buffered.Incr("a", 1)
+	buffered.Incr("a", 2)
+	buffered.Gauge("b", 7)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := FlushOnShutdown(ctx, buffered, client)
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if client.conn != nil {
+		t.Error("socket not closed on shutdown")
+	}
+	// the application closing the clients again must be harmless
+	if err := buffered.Close(); err != ErrClosed {
+		t.Errorf("expected ErrClosed, actual %v", err)
+	}
+
+	expected := map[string]string{"myproject.a": "3", "myproject.b": "7"}
+	for name, value := range expected {
+		metrics, err := srv.WaitFor(name, 1, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metrics[0].Value != value {
+			t.Errorf("%s: expected %s, actual %s", name, value, metrics[0].Value)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	defer buffered.Close()
+	buffered.SetCloseTimeout(time.Second)
+	if timeout := shutdownTimeout([]Closer{buffered, client}); timeout != time.Second+DefaultShutdownTimeout {
+		t.Errorf("expected %s, actual %s", time.Second+DefaultShutdownTimeout, timeout)
+	}
+}
@@ -0,0 +1,253 @@
+package statsd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// Source is a view of a client sending all the metrics under a source segment,
+// inserted between the prefix of the client and the stat name, e.g. to give
+// every plugin sharing a client its own namespace. Views are cheap (they share
+// the connection of the client) and safe to create per request. Raw names
+// (see RawName, with the marker of the client) are sent unchanged
+type Source struct {
+	client Statsd
+	source string // with the trailing separator
+}
+
+// newSource creates a view of client under source
+func newSource(client Statsd, source string) *Source {
+	source = strings.Trim(Escape(FieldName, source), ".")
+	if source != "" {
+		source += "."
+	}
+	return &Source{client: client, source: source}
+}
+
+// WithSource returns a view of the client sending the metrics under source, see Source
+func (c *StatsdClient) WithSource(source string) *Source {
+	return newSource(c, source)
+}
+
+// WithSource returns a view of the buffered client sending the metrics under source, see Source
+func (sb *StatsdBuffer) WithSource(source string) *Source {
+	return newSource(sb, source)
+}
+
+// WithSource returns a view of the router sending the metrics under source,
+// see Source. The routing rules apply to the names with the source
+func (r *Router) WithSource(source string) *Source {
+	return newSource(r, source)
+}
+
+// rawMarker returns the marker of the raw names of the fallback client, see
+// rawMarked
+func (r *Router) rawMarker() string {
+	return rawMarkerOf(r.fallback)
+}
+
+// WithSource returns a nested view, sending the metrics under source within
+// the source of the view
+func (s *Source) WithSource(source string) *Source {
+	nested := newSource(s.client, source)
+	nested.source = s.source + nested.source
+	return nested
+}
+
+// name returns the stat name with the source
+func (s *Source) name(stat string) string {
+	if marker := s.rawMarker(); marker != "" && strings.HasPrefix(stat, marker) {
+		return stat
+	}
+	return s.source + stat
+}
+
+// rawMarker returns the marker of the raw names of the client, see rawMarked
+func (s *Source) rawMarker() string {
+	return rawMarkerOf(s.client)
+}
+
+// CreateSocket does nothing: the view doesn't own the connection of the client
+func (s *Source) CreateSocket() error {
+	return nil
+}
+
+// Close does nothing: the view doesn't own the client, so a plugin can't close it
+func (s *Source) Close() error {
+	return nil
+}
+
+// Incr - Increment a counter metric. Often used to note a particular event
+func (s *Source) Incr(stat string, count int64) error {
+	return s.client.Incr(s.name(stat), count)
+}
+
+// Decr - Decrement a counter metric. Often used to note a particular event
+func (s *Source) Decr(stat string, count int64) error {
+	return s.client.Decr(s.name(stat), count)
+}
+
+// Timing - Track a duration event
+func (s *Source) Timing(stat string, delta int64) error {
+	return s.client.Timing(s.name(stat), delta)
+}
+
+// PrecisionTiming - Track a duration event
+func (s *Source) PrecisionTiming(stat string, delta time.Duration) error {
+	return s.client.PrecisionTiming(s.name(stat), delta)
+}
+
+// TimingMicroseconds - Track a duration event given in microseconds
+func (s *Source) TimingMicroseconds(stat string, us float64) error {
+	return s.client.TimingMicroseconds(s.name(stat), us)
+}
+
+// FTiming - Track a duration event given in floating point milliseconds
+func (s *Source) FTiming(stat string, ms float64) error {
+	return fTiming(s.client, s.name(stat), ms)
+}
+
+// Since - Track the time elapsed since start
+func (s *Source) Since(stat string, start time.Time) error {
+	return since(s.client, s.name(stat), start)
+}
+
+// Observe - Track the outcome and the latency of a fallible call, see StatsdClient.Observe
+func (s *Source) Observe(stat string, start time.Time, err error) error {
+	return observeOn(s.client, s.name(stat), start, err)
+}
+
+// ObserveFunc - Call fn and track its outcome like Observe, returning the error of fn
+func (s *Source) ObserveFunc(stat string, fn func() error) error {
+	return observeFuncOn(s.client, s.name(stat), fn)
+}
+
+// Gauge - Gauges are a constant data type
+func (s *Source) Gauge(stat string, value int64) error {
+	return s.client.Gauge(s.name(stat), value)
+}
+
+// GaugeDelta -- Send a change for a gauge
+func (s *Source) GaugeDelta(stat string, value int64) error {
+	return s.client.GaugeDelta(s.name(stat), value)
+}
+
+// GaugeMax - Track the highest value of a gauge, see StatsdBuffer.GaugeMax
+func (s *Source) GaugeMax(stat string, value int64) error {
+	return gaugeMax(s.client, s.name(stat), value)
+}
+
+// GaugeMin - Track the lowest value of a gauge, see StatsdBuffer.GaugeMin
+func (s *Source) GaugeMin(stat string, value int64) error {
+	return gaugeMin(s.client, s.name(stat), value)
+}
+
+// Absolute - Send absolute-valued metric (not averaged/aggregated)
+func (s *Source) Absolute(stat string, value int64) error {
+	return s.client.Absolute(s.name(stat), value)
+}
+
+// Total - Send a metric that is continously increasing, e.g. read operations since boot
+func (s *Source) Total(stat string, value int64) error {
+	return s.client.Total(s.name(stat), value)
+}
+
+// Unique - Count the unique values of a set
+func (s *Source) Unique(stat string, value string) error {
+	return unique(s.client, s.name(stat), value)
+}
+
+// sendTyped sends a metric sendMetric can't send with the methods of the
+// source under the source, see typedSender
+func (s *Source) sendTyped(m wire.Metric, value float64) error {
+	m.Name = s.name(m.Name)
+	return sendTyped(s.client, m, value)
+}
+
+// FGauge -- Send a floating point value for a gauge
+func (s *Source) FGauge(stat string, value float64) error {
+	return s.client.FGauge(s.name(stat), value)
+}
+
+// FGaugeDelta -- Send a floating point change for a gauge
+func (s *Source) FGaugeDelta(stat string, value float64) error {
+	return s.client.FGaugeDelta(s.name(stat), value)
+}
+
+// FAbsolute - Send absolute-valued floating point metric (not averaged/aggregated)
+func (s *Source) FAbsolute(stat string, value float64) error {
+	return s.client.FAbsolute(s.name(stat), value)
+}
+
+// IncrMap increments all the counters in the map; errors are reported under
+// the stat names without the source
+func (s *Source) IncrMap(counts map[string]int64) error {
+	batch, keys := make(map[string]int64, len(counts)), make(routedKeys, len(counts))
+	for stat, count := range counts {
+		name := s.name(stat)
+		batch[name] += count
+		keys.add(name, stat)
+	}
+	errs := make(map[string]error)
+	keys.collect(s.client.IncrMap(batch), errs)
+	return mapError(errs)
+}
+
+// GaugeMap sets all the gauges in the map; errors are reported under the stat
+// names without the source
+func (s *Source) GaugeMap(values map[string]int64) error {
+	batch, keys := make(map[string]int64, len(values)), make(routedKeys, len(values))
+	for stat, value := range values {
+		name := s.name(stat)
+		batch[name] = value
+		keys.add(name, stat)
+	}
+	errs := make(map[string]error)
+	keys.collect(s.client.GaugeMap(batch), errs)
+	return mapError(errs)
+}
+
+// TimingSlices tracks all the durations in the map; errors are reported under
+// the stat names without the source
+func (s *Source) TimingSlices(timings map[string][]time.Duration) error {
+	batch, keys := make(map[string][]time.Duration, len(timings)), make(routedKeys, len(timings))
+	for stat, deltas := range timings {
+		name := s.name(stat)
+		batch[name] = append(batch[name], deltas...)
+		keys.add(name, stat)
+	}
+	errs := make(map[string]error)
+	keys.collect(s.client.TimingSlices(batch), errs)
+	return mapError(errs)
+}
+
+// SendEvents sends copies of the events under the source, apart from the ones
+// marked by event.PreQualified; errors are reported under the keys without
+// the source
+func (s *Source) SendEvents(events ...event.Event) error {
+	batch, keys := make([]event.Event, 0, len(events)), make(routedKeys, len(events))
+	errs := make(map[string]error)
+	for _, e := range events {
+		stat, name := e.Key(), e.Key()
+		if _, qualified := e.(*event.Qualified); !qualified {
+			name = s.name(stat)
+		}
+		if name != stat {
+			renamed, err := withKey(e, name)
+			if err != nil {
+				errs[stat] = err
+				continue
+			}
+			e = renamed
+		}
+		batch = append(batch, e)
+		keys.add(name, stat)
+	}
+	if len(batch) > 0 {
+		keys.collect(sendEvents(s.client, batch), errs)
+	}
+	return mapError(errs)
+}
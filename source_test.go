@@ -0,0 +1,64 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var _ Statsd = (*Source)(nil)
+
+func TestSource(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	kafka := client.WithSource("plugin.kafka")
+	kafka.Incr("messages", 1)
+	kafka.WithSource("consumer").Gauge("lag", 3)
+	kafka.WithSource("").Incr("empty", 1)
+	client.WithSource("bad|source.").Incr("a", 1)
+	kafka.Incr(RawName("global.total"), 1)
+	if err := kafka.Close(); err != nil {
+		t.Error(err)
+	}
+	client.Incr("after.close", 1)
+
+	expected := []string{
+		"myproject.plugin.kafka.messages:1|c",
+		"myproject.plugin.kafka.consumer.lag:3|g",
+		"myproject.plugin.kafka.empty:1|c",
+		"myproject.bad_source.a:1|c",
+		"global.total:1|c",
+		"myproject.after.close:1|c",
+	}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestSourceBatch(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetStrictNames(true)
+	err := client.WithSource("plugin").IncrMap(map[string]int64{"ok": 1, "bad|name": 2})
+	mapErr, ok := err.(*MapError)
+	if !ok || len(mapErr.Errors) != 1 || mapErr.Errors["bad|name"] != ErrInvalidName {
+		t.Errorf("expected the error under the original name, actual %v", err)
+	}
+	if expected := []string{"myproject.plugin.ok:1|c"}; !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestSourceBuffered(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	a, b := buffered.WithSource("a"), buffered.WithSource("b")
+	a.Incr("requests", 1)
+	b.Incr("requests", 2)
+	a.Incr("requests", 3)
+	buffered.Close()
+
+	expected := []string{"myproject.a.requests:4|c\nmyproject.b.requests:2|c"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
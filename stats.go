@@ -0,0 +1,132 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ClientStats is a snapshot of the internal counters of a StatsdClient
+type ClientStats struct {
+	RetryPending int   // payloads waiting to be retried
+	RetryDropped int64 // payloads dropped because they got too old or the retry queue was full
+	Filtered     int64 // metrics deliberately dropped by the filter
+	Suppressed   int64 // metrics switched off by the enable switch (see SetEnabledFunc)
+	RawLines     int64 // pre-formatted lines written by WriteRaw and WriteRawLines
+	// metrics skipped by sampling, per kind (see SetSampleRate)
+	SampledOut map[MetricKind]int64
+	// distinct metric names seen, and the metrics whose name could not be
+	// tracked because the limit was reached (see TrackCardinality)
+	UniqueNames         int
+	CardinalityOverflow int64
+	// effective maximum packet size, and the times it was lowered because
+	// the network rejected the packets as too long (see SetMaxPacketSize)
+	PacketSize int
+	Downshifts int64
+	// stat names rewritten by the sanitization and rejected in strict mode
+	// (see TrackMalformedNames)
+	NameRewrites   int64
+	NameRejections int64
+	// names violating the schema, rejected or not (see SetNameSchema)
+	SchemaViolations int64
+	// packets copied to the mirror, the copies which failed, and the ones
+	// dropped since the mirror lagged behind (see SetMirror)
+	Mirrored      int64
+	MirrorErrors  int64
+	MirrorDropped int64
+	// packets parked because the socket buffer was full, the ones written
+	// since, and the ones dropped (see SetBurstBuffer)
+	BurstPending   int
+	BurstRecovered int64
+	BurstDropped   int64
+	// payloads held until the first successful write, the ones written since,
+	// and the ones dropped (see SetWarmupBuffer)
+	WarmupPending  int
+	WarmupReplayed int64
+	WarmupDropped  int64
+	// sequence number of the last packet numbered (see SetSequenceTrailer)
+	Sequence int64
+	// time of the last successful write, zero if nothing was ever sent (see
+	// LastSendTime)
+	LastSend time.Time
+	// metrics dropped because the output mode doesn't support them, per
+	// feature (see Supports)
+	Unsupported map[Feature]int64
+}
+
+// LastSendTime returns the time, on the clock of the client, of the last
+// successful write to the socket, or the zero time if nothing was ever sent:
+// a canary for a pipeline silently broken
+func (c *StatsdClient) LastSendTime() time.Time {
+	ns := atomic.LoadInt64(&c.lastSend)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Stats returns a snapshot of the client's internal counters
+func (c *StatsdClient) Stats() ClientStats {
+	c.mu.Lock()
+	q, b := c.retry, c.burst
+	packetSize, downshifts := c.packetSize, c.downshifts
+	var mirrored, mirrorErrors, mirrorDropped int64
+	if m := c.mirror; m != nil {
+		mirrored, mirrorErrors = atomic.LoadInt64(&m.sent), atomic.LoadInt64(&m.errors)
+		mirrorDropped = atomic.LoadInt64(&m.dropped)
+	}
+	var warmup warmup
+	if c.warmup != nil {
+		warmup = *c.warmup
+	}
+	c.mu.Unlock()
+	stats := ClientStats{
+		Filtered:     atomic.LoadInt64(&c.filtered),
+		Suppressed:   atomic.LoadInt64(&c.suppressed),
+		RawLines:     atomic.LoadInt64(&c.rawLines),
+		LastSend:     c.LastSendTime(),
+		SampledOut:   make(map[MetricKind]int64),
+		Unsupported:  make(map[Feature]int64),
+		PacketSize:   packetSize,
+		Downshifts:   downshifts,
+		Mirrored:     mirrored,
+		MirrorErrors: mirrorErrors,
+	}
+	stats.MirrorDropped = mirrorDropped
+	stats.SchemaViolations = atomic.LoadInt64(&c.schemaViolations)
+	stats.Sequence = atomic.LoadInt64(&c.sequence)
+	stats.WarmupPending = len(warmup.packets)
+	stats.WarmupReplayed, stats.WarmupDropped = warmup.replayed, warmup.dropped
+	for kind := range c.sampledOut {
+		if n := atomic.LoadInt64(&c.sampledOut[kind]); n > 0 {
+			stats.SampledOut[MetricKind(kind)] = n
+		}
+	}
+	for f := range c.unsupportedDrops {
+		if n := atomic.LoadInt64(&c.unsupportedDrops[f]); n > 0 {
+			stats.Unsupported[Feature(f)] = n
+		}
+	}
+	if t := c.cardinality; t != nil {
+		t.mu.Lock()
+		stats.UniqueNames, stats.CardinalityOverflow = len(t.names), t.overflow
+		t.mu.Unlock()
+	}
+	if t := c.malformed; t != nil {
+		t.mu.Lock()
+		stats.NameRewrites, stats.NameRejections = t.rewrites, t.rejections
+		t.mu.Unlock()
+	}
+	if b != nil {
+		stats.BurstPending = int(atomic.LoadInt32(&b.parked))
+		stats.BurstRecovered = atomic.LoadInt64(&b.recovered)
+		stats.BurstDropped = atomic.LoadInt64(&b.dropped)
+	}
+	if q != nil {
+		q.mu.Lock()
+		stats.RetryPending = len(q.entries)
+		q.mu.Unlock()
+		stats.RetryDropped = atomic.LoadInt64(&q.dropped)
+	}
+	stats.RetryDropped += atomic.LoadInt64(&c.retryDropped)
+	return stats
+}
@@ -0,0 +1,141 @@
+// Package statsdhttp helps naming the metrics of HTTP servers, so that their
+// cardinality stays bounded whatever the paths requested
+package statsdhttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Other is the name of the paths which match no route, see Routes
+const Other = "other"
+
+// Routes turns request paths into metric names, by the route template they
+// match: /users/123/orders/456 matching /users/:id/orders/:oid is named
+// users._id.orders._oid, and the paths matching no template are all named
+// Other. The templates use either :name or the {name} wildcards of
+// http.ServeMux, {name...} matching the rest of the path
+type Routes struct {
+	routes []route
+}
+
+type route struct {
+	segments []string // "" for the wildcards
+	rest     bool     // the last wildcard matches the rest of the path
+	name     string
+}
+
+// NewRoutes registers the templates, the first one matching a path names it
+func NewRoutes(templates ...string) *Routes {
+	r := &Routes{}
+	for _, template := range templates {
+		r.routes = append(r.routes, parseRoute(template))
+	}
+	return r
+}
+
+func parseRoute(template string) route {
+	rt := route{name: TemplateName(template)}
+	for _, segment := range split(patternPath(template)) {
+		switch {
+		case segment == "{$}":
+			continue
+		case strings.HasSuffix(segment, "...}") && isWildcard(segment):
+			rt.rest = true
+			continue
+		case strings.HasPrefix(segment, ":"), isWildcard(segment):
+			segment = ""
+		}
+		rt.segments = append(rt.segments, segment)
+	}
+	return rt
+}
+
+// match tells whether the segments of a path match the route
+func (rt route) match(segments []string) bool {
+	if len(segments) < len(rt.segments) || len(segments) > len(rt.segments) && !rt.rest {
+		return false
+	}
+	for i, segment := range rt.segments {
+		if segment != "" && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Name returns the name of the first template matching the path, Other if none
+// does. The trailing and repeated slashes are ignored
+func (r *Routes) Name(path string) string {
+	segments := split(path)
+	for _, rt := range r.routes {
+		if rt.match(segments) {
+			return rt.name
+		}
+	}
+	return Other
+}
+
+// RequestName names a request by the http.ServeMux pattern which routed it, if
+// any, or else by the path of its URL, see Name
+func (r *Routes) RequestName(req *http.Request) string {
+	if req.Pattern != "" {
+		return TemplateName(req.Pattern)
+	}
+	return r.Name(req.URL.Path)
+}
+
+// TemplateName turns a route template, or an http.ServeMux pattern, into a
+// metric name: the method and the host are dropped, the segments are joined
+// by dots, the wildcards are prefixed by an underscore, and the characters
+// reserved in metric names are replaced by underscores. The root is named root
+func TemplateName(template string) string {
+	var names []string
+	for _, segment := range split(patternPath(template)) {
+		switch {
+		case segment == "{$}":
+			continue
+		case strings.HasPrefix(segment, ":"):
+			segment = "_" + segment[1:]
+		case isWildcard(segment):
+			segment = "_" + strings.TrimSuffix(segment[1:len(segment)-1], "...")
+		}
+		names = append(names, sanitize(segment))
+	}
+	if len(names) == 0 {
+		return "root"
+	}
+	return strings.Join(names, ".")
+}
+
+// patternPath drops the method and the host of an http.ServeMux pattern
+func patternPath(pattern string) string {
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " ")
+	}
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
+
+// split returns the segments of a path, ignoring the empty ones (the leading,
+// trailing and repeated slashes)
+func split(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
+func isWildcard(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+// sanitize replaces the characters which aren't letters, digits, '-' or '_'
+func sanitize(segment string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, segment)
+}
@@ -0,0 +1,63 @@
+package statsdhttp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	routes := NewRoutes("/users/:id/orders/:oid", "/users/:id", "/users/me", "GET /static/{file...}", "/{$}", "/v1.2/status")
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/users/123/orders/456", expected: "users._id.orders._oid"},
+		{path: "/users/123/orders/456/", expected: "users._id.orders._oid"},
+		{path: "/users/123", expected: "users._id"},
+		{path: "/users/123/", expected: "users._id"},
+		// the first template matching wins
+		{path: "/users/me", expected: "users._id"},
+		{path: "/static/css/main.css", expected: "static._file"},
+		{path: "/static", expected: "static._file"},
+		{path: "/", expected: "root"},
+		{path: "/v1.2/status", expected: "v1_2.status"},
+		{path: "/users", expected: Other},
+		{path: "/users/123/orders", expected: Other},
+		{path: "/users/123/orders/456/items", expected: Other},
+		{path: "/unknown/a.b|c", expected: Other},
+	}
+	for _, tt := range tests {
+		if actual := routes.Name(tt.path); actual != tt.expected {
+			t.Errorf("%s: expected %s, actual %s", tt.path, tt.expected, actual)
+		}
+	}
+}
+
+func TestTemplateName(t *testing.T) {
+	tests := map[string]string{
+		"/users/:id":                       "users._id",
+		"GET /users/{id}/orders/{oid}/":    "users._id.orders._oid",
+		"POST example.com/files/{path...}": "files._path",
+		"/{$}":                             "root",
+		"/a:b|c\nd/":                       "a_b_c_d",
+	}
+	for template, expected := range tests {
+		if actual := TemplateName(template); actual != expected {
+			t.Errorf("%q: expected %s, actual %s", template, expected, actual)
+		}
+	}
+}
+
+func TestRequestName(t *testing.T) {
+	routes := NewRoutes("/users/:id")
+	// as set by http.ServeMux
+	req := httptest.NewRequest("GET", "/orders/42", nil)
+	req.Pattern = "GET /orders/{id}"
+	if name := routes.RequestName(req); name != "orders._id" {
+		t.Errorf("expected the name of the pattern, actual %s", name)
+	}
+	// without a pattern, the path is matched
+	if name := routes.RequestName(httptest.NewRequest("GET", "/users/42/", nil)); name != "users._id" {
+		t.Errorf("expected the name of the template, actual %s", name)
+	}
+}
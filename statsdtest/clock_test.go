@@ -0,0 +1,48 @@
+package statsdtest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	tick, stop := clock.NewTicker(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-tick:
+		t.Fatal("ticked too early")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	select {
+	case now := <-tick:
+		if !now.Equal(start.Add(time.Second)) {
+			t.Errorf("unexpected tick time %v", now)
+		}
+	default:
+		t.Fatal("expected a tick")
+	}
+
+	// missed ticks are dropped
+	clock.Advance(5 * time.Second)
+	<-tick
+	select {
+	case <-tick:
+		t.Fatal("expected a single pending tick")
+	default:
+	}
+
+	stop()
+	clock.Advance(time.Second)
+	select {
+	case <-tick:
+		t.Fatal("ticked after stop")
+	default:
+	}
+	if !clock.Now().Equal(start.Add(7 * time.Second)) {
+		t.Errorf("unexpected time %v", clock.Now())
+	}
+}
@@ -0,0 +1,56 @@
+package statsdtest
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DiscardSender swallows everything the client writes, counting the writes and
+// the bytes, to benchmark code using the statsd client without a network:
+//
+//	sender := &statsdtest.DiscardSender{}
+//	client.SetDialer(sender.Dial)
+type DiscardSender struct {
+	writes int64 // updated atomically
+	bytes  int64 // updated atomically
+}
+
+// Dial returns a connection discarding the writes, ignoring the address
+func (d *DiscardSender) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	return discardConn{d}, nil
+}
+
+// Writes returns the number of payloads written so far
+func (d *DiscardSender) Writes() int64 {
+	return atomic.LoadInt64(&d.writes)
+}
+
+// Bytes returns the number of bytes written so far
+func (d *DiscardSender) Bytes() int64 {
+	return atomic.LoadInt64(&d.bytes)
+}
+
+// discardConn is the net.Conn returned by DiscardSender.Dial
+type discardConn struct {
+	sender *DiscardSender
+}
+
+func (c discardConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.sender.writes, 1)
+	atomic.AddInt64(&c.sender.bytes, int64(len(b)))
+	return len(b), nil
+}
+
+func (c discardConn) Read(b []byte) (int, error)         { return 0, nil }
+func (c discardConn) Close() error                       { return nil }
+func (c discardConn) LocalAddr() net.Addr                { return discardAddr{} }
+func (c discardConn) RemoteAddr() net.Addr               { return discardAddr{} }
+func (c discardConn) SetDeadline(t time.Time) error      { return nil }
+func (c discardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c discardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type discardAddr struct{}
+
+func (discardAddr) Network() string { return "discard" }
+func (discardAddr) String() string  { return "discard" }
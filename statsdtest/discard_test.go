@@ -0,0 +1,19 @@
+package statsdtest
+
+import (
+	"io"
+	"testing"
+)
+
+func TestDiscardSender(t *testing.T) {
+	sender := &DiscardSender{}
+	conn, err := sender.Dial("udp", "localhost:8125", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "a:1|c")
+	io.WriteString(conn, "b:2|g")
+	if sender.Writes() != 2 || sender.Bytes() != 10 {
+		t.Errorf("expected 2 writes and 10 bytes, actual %d and %d", sender.Writes(), sender.Bytes())
+	}
+}
@@ -0,0 +1,149 @@
+package statsdtest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjected is returned by the writes failed on purpose by a FaultySender
+var ErrInjected = errors.New("statsdtest: injected write failure")
+
+// FaultySender wraps a dialer to simulate a degraded metrics pipeline: slow
+// writes, packets lost silently, writes failing after a number of sends, and a
+// sender going down altogether. The faults can be changed while the test runs:
+//
+//	sender := statsdtest.NewFaultySender(nil)
+//	client.SetDialer(sender.Dial)
+//	sender.SetDropRate(0.5)
+type FaultySender struct {
+	dial func(network, address string, timeout time.Duration) (net.Conn, error)
+
+	mu        sync.Mutex
+	latency   time.Duration
+	dropRate  float64
+	failAfter int64 // 0 never fails
+	down      bool
+	random    *rand.Rand
+
+	// updated atomically
+	sends   int64
+	dropped int64
+	failed  int64
+}
+
+// NewFaultySender returns a sender dialing with dial, or net.DialTimeout if nil
+func NewFaultySender(dial func(network, address string, timeout time.Duration) (net.Conn, error)) *FaultySender {
+	if dial == nil {
+		dial = net.DialTimeout
+	}
+	return &FaultySender{dial: dial, random: rand.New(rand.NewSource(1))}
+}
+
+// Dial connects with the wrapped dialer, unless the sender is down
+func (f *FaultySender) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		return nil, ErrInjected
+	}
+	conn, err := f.dial(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyConn{Conn: conn, sender: f}, nil
+}
+
+// SetLatency delays every write by d
+func (f *FaultySender) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	f.latency = d
+	f.mu.Unlock()
+}
+
+// SetDropRate makes a random fraction of the writes succeed without sending
+// anything, like packets lost on the network
+func (f *FaultySender) SetDropRate(rate float64) {
+	f.mu.Lock()
+	f.dropRate = rate
+	f.mu.Unlock()
+}
+
+// FailAfter makes all the writes fail with ErrInjected once n more payloads
+// have been sent, 0 disables the failure
+func (f *FaultySender) FailAfter(n int64) {
+	f.mu.Lock()
+	f.failAfter = 0
+	if n > 0 {
+		f.failAfter = atomic.LoadInt64(&f.sends) + n
+	}
+	f.mu.Unlock()
+}
+
+// SetDown makes the writes and the dials fail with ErrInjected while down is true
+func (f *FaultySender) SetDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+// Sends returns the number of payloads actually sent
+func (f *FaultySender) Sends() int64 {
+	return atomic.LoadInt64(&f.sends)
+}
+
+// Dropped returns the number of payloads lost on purpose, see SetDropRate
+func (f *FaultySender) Dropped() int64 {
+	return atomic.LoadInt64(&f.dropped)
+}
+
+// Failed returns the number of writes failed on purpose
+func (f *FaultySender) Failed() int64 {
+	return atomic.LoadInt64(&f.failed)
+}
+
+// fault decides the fate of a write, after the latency
+func (f *FaultySender) fault() (drop bool, err error) {
+	f.mu.Lock()
+	latency := f.latency
+	f.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.down, f.failAfter > 0 && atomic.LoadInt64(&f.sends) >= f.failAfter:
+		atomic.AddInt64(&f.failed, 1)
+		return false, ErrInjected
+	case f.dropRate > 0 && f.random.Float64() < f.dropRate:
+		atomic.AddInt64(&f.dropped, 1)
+		return true, nil
+	}
+	return false, nil
+}
+
+// faultyConn is the net.Conn returned by FaultySender.Dial
+type faultyConn struct {
+	net.Conn
+	sender *FaultySender
+}
+
+func (c *faultyConn) Write(b []byte) (int, error) {
+	drop, err := c.sender.fault()
+	if err != nil {
+		return 0, err
+	}
+	if drop {
+		return len(b), nil
+	}
+	n, err := c.Conn.Write(b)
+	if err == nil {
+		atomic.AddInt64(&c.sender.sends, 1)
+	}
+	return n, err
+}
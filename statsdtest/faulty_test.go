@@ -0,0 +1,62 @@
+package statsdtest
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFaultySender(t *testing.T) {
+	discard := &DiscardSender{}
+	sender := NewFaultySender(discard.Dial)
+	conn, err := sender.Dial("udp", "localhost:8125", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender.SetLatency(10 * time.Millisecond)
+	start := time.Now()
+	io.WriteString(conn, "a:1|c")
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("write not delayed: %s", elapsed)
+	}
+	sender.SetLatency(0)
+
+	sender.SetDropRate(0.5)
+	for i := 0; i < 1000; i++ {
+		if _, err := io.WriteString(conn, "a:1|c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped := sender.Dropped(); dropped < 400 || dropped > 600 {
+		t.Errorf("%d writes dropped out of 1000 at a 50%% rate", dropped)
+	}
+	if sender.Sends()+sender.Dropped() != 1001 || discard.Writes() != sender.Sends() {
+		t.Errorf("%d sent + %d dropped != 1001 writes", sender.Sends(), sender.Dropped())
+	}
+	sender.SetDropRate(0)
+
+	sender.FailAfter(2)
+	for i := 0; i < 5; i++ {
+		_, err := io.WriteString(conn, "a:1|c")
+		if (i < 2) != (err == nil) {
+			t.Errorf("write %d: unexpected error %v", i, err)
+		}
+	}
+	sender.FailAfter(0)
+
+	sender.SetDown(true)
+	if _, err := io.WriteString(conn, "a:1|c"); err != ErrInjected {
+		t.Errorf("expected ErrInjected while down, actual %v", err)
+	}
+	if _, err := sender.Dial("udp", "localhost:8125", 0); err != ErrInjected {
+		t.Errorf("expected dial to fail while down, actual %v", err)
+	}
+	sender.SetDown(false)
+	if _, err := io.WriteString(conn, "a:1|c"); err != nil {
+		t.Error(err)
+	}
+	if failed := sender.Failed(); failed != 4 {
+		t.Errorf("expected 4 failed writes, actual %d", failed)
+	}
+}
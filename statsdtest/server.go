@@ -0,0 +1,244 @@
+// Package statsdtest provides helpers to test code using the statsd client,
+// such as an in-process StatsD server parsing the wire protocol
+package statsdtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// Metric is a single StatsD line received by the Server.
+// Malformed lines are retained with a non-nil Err and the Raw line
+type Metric struct {
+	wire.Metric
+	Raw string
+	Err error
+}
+
+// Server is an in-process StatsD server listening on an ephemeral UDP port
+// (and optionally on TCP), recording every line it receives
+type Server struct {
+	udp     *net.UDPConn
+	tcp     net.Listener
+	admin   net.Listener
+	mu      sync.Mutex
+	metrics []Metric
+	notify  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a Server listening on an ephemeral UDP port on localhost
+func NewServer() (*Server, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		udp:    conn,
+		notify: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.serveUDP()
+	return s, nil
+}
+
+// Addr returns the UDP address of the server, in host:port format
+func (s *Server) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+// ListenTCP starts accepting newline-delimited stats over TCP as well,
+// returning the address of the TCP listener
+func (s *Server) ListenTCP() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.tcp = ln
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.serveTCP(ln)
+	return ln.Addr().String(), nil
+}
+
+// ListenAdmin starts serving the health and counters commands of the admin
+// interface of the reference StatsD server over TCP, returning the address of
+// the admin listener. The counters are the sums of the counters received
+func (s *Server) ListenAdmin() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.admin = ln
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.serveAdmin(ln)
+	return ln.Addr().String(), nil
+}
+
+// Close stops the listeners
+func (s *Server) Close() error {
+	err := s.udp.Close()
+	s.mu.Lock()
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	if s.admin != nil {
+		s.admin.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Metrics returns a copy of all the metrics received so far
+func (s *Server) Metrics() []Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]Metric, len(s.metrics))
+	copy(ret, s.metrics)
+	return ret
+}
+
+// Reset forgets all the metrics received so far
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.metrics = nil
+	s.mu.Unlock()
+}
+
+// WaitFor waits until at least n metrics with the given name have been received,
+// and returns them. An error is returned if they don't arrive within the timeout
+func (s *Server) WaitFor(name string, n int, timeout time.Duration) ([]Metric, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		found := make([]Metric, 0, n)
+		for _, m := range s.metrics {
+			if m.Name == name {
+				found = append(found, m)
+			}
+		}
+		notify := s.notify
+		s.mu.Unlock()
+		if len(found) >= n {
+			return found, nil
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return found, fmt.Errorf("timed out waiting for %d metrics named %q, received %d", n, name, len(found))
+		}
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buffer := make([]byte, 65536)
+	for {
+		n, err := s.udp.Read(buffer)
+		if err != nil {
+			return
+		}
+		s.record(string(buffer[:n]))
+	}
+}
+
+func (s *Server) serveTCP(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func(c net.Conn) {
+			defer s.wg.Done()
+			defer c.Close()
+			scanner := bufio.NewScanner(c)
+			for scanner.Scan() {
+				s.record(scanner.Text())
+			}
+		}(conn)
+	}
+}
+
+func (s *Server) serveAdmin(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func(c net.Conn) {
+			defer s.wg.Done()
+			defer c.Close()
+			scanner := bufio.NewScanner(c)
+			for scanner.Scan() {
+				switch strings.TrimSpace(scanner.Text()) {
+				case "health":
+					fmt.Fprint(c, "health: up\n")
+				case "counters":
+					fmt.Fprint(c, s.counters())
+				default:
+					fmt.Fprint(c, "ERROR\n")
+				}
+			}
+		}(conn)
+	}
+}
+
+// counters renders the sums of the counters received like the admin interface
+func (s *Server) counters() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sums := make(map[string]float64)
+	var names []string
+	for _, m := range s.metrics {
+		if m.Err != nil || m.Type != "c" {
+			continue
+		}
+		v, _ := m.Float()
+		if _, ok := sums[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		sums[m.Name] += v / m.SampleRate
+	}
+	var b strings.Builder
+	b.WriteString("{ ")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",\n  ")
+		}
+		fmt.Fprintf(&b, "'%s': %v", name, sums[name])
+	}
+	b.WriteString(" }\nEND\n\n")
+	return b.String()
+}
+
+// record parses every line of a packet and wakes up the waiters
+func (s *Server) record(packet string) {
+	lines := strings.Split(packet, "\n")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		m, err := wire.ParseLine([]byte(line))
+		s.metrics = append(s.metrics, Metric{Metric: m, Raw: line, Err: err})
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
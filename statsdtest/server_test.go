@@ -0,0 +1,99 @@
+package statsdtest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	conn, err := net.Dial("udp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "a.b:1|c|@0.5|#env:prod,role:db\nbroken\na.b:2|c")
+
+	metrics, err := srv.WaitFor("a.b", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[0].Value != "1" || metrics[0].Type != "c" || metrics[0].SampleRate != 0.5 || len(metrics[0].Tags) != 2 {
+		t.Errorf("unexpected metric %+v", metrics[0])
+	}
+	all := srv.Metrics()
+	if len(all) != 3 || all[1].Err == nil || all[1].Raw != "broken" {
+		t.Errorf("malformed line not retained: %+v", all)
+	}
+
+	srv.Reset()
+	if len(srv.Metrics()) != 0 {
+		t.Error("metrics not reset")
+	}
+	if _, err := srv.WaitFor("a.b", 1, 10*time.Millisecond); err == nil {
+		t.Error("expected a timeout")
+	}
+}
+
+func TestServerTCP(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	addr, err := srv.ListenTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "x:1|g\nx:2|g\n")
+	conn.Close()
+	if _, err := srv.WaitFor("x", 2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerAdmin(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	admin, err := srv.ListenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "a:1|c\na:1|c|@0.5\nb:2|g")
+	if _, err := srv.WaitFor("b", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.Dial("tcp", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprint(c, "health\ncounters\n")
+	expected := "health: up\n{ 'a': 3 }\nEND\n\n"
+	b := make([]byte, len(expected))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != expected {
+		t.Errorf("expected %q, actual %q (%v)", expected, b, err)
+	}
+}
@@ -0,0 +1,99 @@
+package statsd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// Option configures a buffered client created by NewBufferedStatter, e.g.
+// func(sb *StatsdBuffer) { sb.SetMaxRetainedIntervals(3) }. The options are
+// applied before the client starts collecting
+type Option func(sb *StatsdBuffer)
+
+// WithLogger sets the logger of the buffered client
+func WithLogger(logger Logger) Option {
+	return func(sb *StatsdBuffer) {
+		sb.Logger = logger
+	}
+}
+
+// NewBufferedStatter creates a buffered client aggregating the stats like
+// NewStatsdBuffer, but flushing them to another client instead of owning a
+// socket, so that the aggregation composes with e.g. a Router: next applies
+// its own prefix, filters and transport, and counts the sends in its own
+// Stats. The events are flushed with the SendEvents of next if it has one,
+// or else line by line with its metric methods. CreateSocket and Close are
+// propagated to next. The options of the underlying client (e.g.
+// SetStrictNames) apply to the names before the aggregation
+func NewBufferedStatter(next Statsd, interval time.Duration, opts ...Option) *StatsdBuffer {
+	return newStatsdBuffer(interval, NewStatsdClient("", ""), next, opts...)
+}
+
+// eventSender is implemented by the clients which can send events
+type eventSender interface {
+	SendEvents(events ...event.Event) error
+}
+
+// sendEvents sends the events through client, with its SendEvents if it has
+// one, or else the lines of their stats with the methods of their types, see
+// sendMetric. The errors are reported in a MapError under the event keys
+func sendEvents(client Statsd, events []event.Event) error {
+	if s, ok := client.(eventSender); ok {
+		return s.SendEvents(events...)
+	}
+	errs := make(map[string]error)
+	for _, e := range events {
+		e, qualified := unqualified(e)
+		for _, line := range e.Stats() {
+			m, err := wire.ParseLine([]byte(line))
+			if err == nil {
+				if qualified {
+					m.Name = rawMarkerOf(client) + m.Name
+				}
+				err = sendMetric(client, m)
+			}
+			if err != nil {
+				errs[e.Key()] = err
+			}
+		}
+	}
+	return mapError(errs)
+}
+
+// withKey returns a copy of e named key, still marked by event.PreQualified if
+// e is, so that the events of the caller aren't renamed. The events which
+// aren't an event.Copier can't be copied, withKey returns an error for them
+func withKey(e event.Event, key string) (event.Event, error) {
+	c := event.Copy(e)
+	if c == nil {
+		return nil, fmt.Errorf("statsd: can't rename an event of type %T", e)
+	}
+	c.SetKey(key)
+	return c, nil
+}
+
+// forward sends the events of a flush to the downstream client. Their keys are
+// restored afterwards, for the events retained when it fails
+func (sb *buffer) forward(events []event.Event) error {
+	keys := make([]string, len(events))
+	for i, e := range events {
+		keys[i] = e.Key()
+	}
+	err := sendEvents(sb.next, events)
+	for i, e := range events {
+		e.SetKey(keys[i])
+	}
+	return err
+}
+
+// closeClient closes the underlying client, and the downstream one if any
+func (sb *buffer) closeClient() error {
+	err := sb.statsd.Close()
+	if sb.next == nil {
+		return err
+	}
+	return sb.next.Close()
+}
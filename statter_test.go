@@ -0,0 +1,115 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+func TestBufferedStatter(t *testing.T) {
+	infra, infraConn := newPacketClient(t, "infra.")
+	product, productConn := newPacketClient(t, "")
+	router := NewRouter(infra).RoutePrefix("product.", "analytics.", product)
+	buffered := NewBufferedStatter(router.WithSource("web"), time.Hour)
+	buffered.Logger = discardLogger{}
+
+	for i := 1; i <= 3; i++ {
+		buffered.Incr("requests", int64(i))
+		buffered.Incr("product.orders", 1)
+		buffered.Gauge("product.queue", int64(i))
+	}
+	buffered.Timing("latency", 10)
+	buffered.Timing("latency", 30)
+	if len(infraConn.sent())+len(productConn.sent()) != 0 {
+		t.Fatal("sent before the flush")
+	}
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the source is inserted before routing
+	expected := []string{"infra.web.latency.avg:20|a\ninfra.web.latency.min:10|a\ninfra.web.latency.max:30|a\n" +
+		"infra.web.product.orders:3|c\ninfra.web.product.queue:3|g\ninfra.web.requests:6|c"}
+	if actual := infraConn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("infra: expected %q, actual %q", expected, actual)
+	}
+	if actual := productConn.sent(); len(actual) != 0 {
+		t.Errorf("product: unexpected %q", actual)
+	}
+}
+
+func TestBufferedStatterRouting(t *testing.T) {
+	infra, infraConn := newPacketClient(t, "infra.")
+	product, productConn := newPacketClient(t, "")
+	router := NewRouter(infra).RoutePrefix("product.", "analytics.", product)
+	buffered := NewBufferedStatter(router, time.Hour)
+	buffered.Logger = discardLogger{}
+
+	for i := 1; i <= 3; i++ {
+		buffered.Incr("requests", int64(i))
+		buffered.Incr("product.orders", 1)
+		buffered.Gauge("product.queue", int64(i))
+	}
+	// the final flush goes through the router, which is closed afterwards
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if infra.Close() != ErrClosed || product.Close() != ErrClosed {
+		t.Error("expected Close to be propagated to the backends")
+	}
+	if expected := []string{"infra.requests:6|c"}; !reflect.DeepEqual(expected, infraConn.sent()) {
+		t.Errorf("infra: expected %q, actual %q", expected, infraConn.sent())
+	}
+	if expected := []string{"analytics.orders:3|c\nanalytics.queue:3|g"}; !reflect.DeepEqual(expected, productConn.sent()) {
+		t.Errorf("product: expected %q, actual %q", expected, productConn.sent())
+	}
+}
+
+// methodsOnly hides the SendEvents of a client
+type methodsOnly struct {
+	Statsd
+}
+
+func TestBufferedStatterMethods(t *testing.T) {
+	client, conn := newPacketClient(t, "infra.")
+	buffered := NewBufferedStatter(methodsOnly{client}, time.Hour, WithLogger(discardLogger{}))
+
+	buffered.Incr("requests", 2)
+	buffered.Incr("requests", 4)
+	buffered.Gauge("queue", 3)
+	buffered.GaugeDelta("level", -2)
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the lines are sent one by one with the metric methods
+	expected := []string{"infra.level:-2|g", "infra.queue:3|g", "infra.requests:6|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestSendEventsCopies(t *testing.T) {
+	infra, infraConn := newPacketClient(t, "infra.")
+	product, productConn := newPacketClient(t, "")
+	router := NewRouter(infra).RoutePrefix("product.", "analytics.", product)
+
+	orders := &event.Increment{Name: "product.orders", Value: 1}
+	requests := &event.Increment{Name: "requests", Value: 2}
+	if err := router.WithSource("web").SendEvents(orders, requests); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.SendEvents(orders, event.PreQualified(requests)); err != nil {
+		t.Fatal(err)
+	}
+	if orders.Key() != "product.orders" || requests.Key() != "requests" {
+		t.Errorf("the events were renamed: %q, %q", orders.Key(), requests.Key())
+	}
+	expected := []string{"infra.web.product.orders:1|c\ninfra.web.requests:2|c", "requests:2|c"}
+	if actual := infraConn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("infra: expected %q, actual %q", expected, actual)
+	}
+	if expected := []string{"analytics.orders:1|c"}; !reflect.DeepEqual(expected, productConn.sent()) {
+		t.Errorf("product: expected %q, actual %q", expected, productConn.sent())
+	}
+}
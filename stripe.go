@@ -0,0 +1,219 @@
+package statsd
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// SetFlushSockets stripes the packets of every flush across n sockets of their
+// own to the same address, written concurrently, for the flushes of tens of
+// thousands of keys whose packets a single socket serializes. The sockets are
+// kept open from a flush to the next until the client is closed, dialed with
+// the timeout of StatsdClient.SetDialTimeout. The packets are built as usual
+// and each one is written whole on a single socket, so the groups of lines
+// (e.g. a negative gauge) stay together. It only applies to the datagram
+// transports (udp:// and unixgram://): the lines written on parallel streams
+// would interleave out of order, and on a single stream the writes are
+// pipelined already, none of them waits for a reply. The flushes are sent on the
+// socket of the client as usual with pacing, the sequence trailer, the burst
+// buffer, retries or a mirror, which all rely on the single socket. 1 or less
+// disables it
+func (sb *buffer) SetFlushSockets(n int) {
+	if n < 1 {
+		n = 1
+	}
+	atomic.StoreInt32(&sb.flushSockets, int32(n))
+}
+
+// stripable tells whether the packets can be striped across several sockets,
+// see SetFlushSockets. The caller must hold c.mu
+func (c *StatsdClient) stripable() bool {
+	if c.closed || c.conn == nil || c.warmingUp() || c.isGraphite() {
+		return false
+	}
+	if c.burst != nil || c.retry != nil || c.mirror != nil || c.sequenceReserve() > 0 {
+		return false
+	}
+	t, err := ParseAddr(c.addr)
+	return err == nil && (t.Network == "udp" || t.Network == "unixgram")
+}
+
+// sendEventsStriped is sendEvents for the buffered client (the event keys are
+// prefixed metric names), writing the packets on n sockets at once. The packets
+// are built under the lock, then written outside of it, packet i on socket
+// i % n, so that the other sends go on meanwhile
+func (c *StatsdClient) sendEventsStriped(events []event.Event, report *FlushReport, n int) error {
+	c.mu.Lock()
+	if !c.stripable() {
+		c.mu.Unlock()
+		return c.sendEvents(events, true, report)
+	}
+	addr := c.addr
+	p := c.newPacker()
+	p.holding = true
+	for _, e := range events {
+		p.addEvent(e.Key(), e)
+		p.count(e.Key(), kindOf(e))
+	}
+	p.writeRest()
+	c.mu.Unlock()
+
+	conns, err := c.stripes.acquire(c, addr, n, len(p.held))
+	if err != nil {
+		p.failHeld(err)
+		return p.result()
+	}
+	defer c.stripes.release()
+
+	start := time.Now()
+	stripes := make([]stripe, len(conns))
+	errs := make([]error, len(p.held))
+	var wg sync.WaitGroup
+	for i := range stripes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := &stripes[i]
+			for j := i; j < len(p.held); j += len(conns) {
+				packet := p.held[j]
+				if _, err := conns[i].Write(packet.data); err != nil {
+					errs[j], s.failed = err, true
+					continue
+				}
+				s.report.written(packet.data, 0)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, s := range stripes {
+		if s.failed {
+			c.stripes.discard(i)
+		}
+	}
+	// settled in order, see packer.settle
+	for j, packet := range p.held {
+		p.settle(packet.data, packet.groups, errs[j])
+	}
+	sent := false
+	for _, s := range stripes {
+		if s.report.Packets > 0 {
+			sent = true
+		}
+		if report != nil {
+			report.Packets += s.report.Packets
+			report.Bytes += s.report.Bytes
+			report.Lines += s.report.Lines
+		}
+	}
+	if report != nil {
+		report.Sending += time.Since(start)
+	}
+	if sent {
+		c.markSent()
+	}
+	return p.result()
+}
+
+// stripe is the outcome of the writes on one of the sockets of
+// sendEventsStriped
+type stripe struct {
+	report FlushReport
+	failed bool // a write failed, the socket is reopened by the next flush
+}
+
+// stripePool keeps the sockets of the striped flushes open from a flush to
+// the next, see SetFlushSockets. A socket a write failed on is closed, and
+// dialed again by the next flush; they're all closed with the client. The
+// pool is locked from acquire to release, while a flush writes on them: the
+// client lock mustn't be taken meanwhile, Close takes it before the pool's
+type stripePool struct {
+	mu     sync.Mutex
+	addr   string
+	conns  []net.Conn // nil for the sockets to dial again
+	closed bool
+}
+
+// acquire locks the pool and returns up to used of the n sockets to addr,
+// dialing the ones missing with the timeout of the client. The sockets to a
+// previous address, and the ones beyond n, are closed. It only fails if not
+// even one socket can be dialed, the pool is unlocked then
+func (s *stripePool) acquire(c *StatsdClient, addr string, n int, used int) ([]net.Conn, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if s.addr != addr {
+		s.closeConns(0)
+		s.addr = addr
+	}
+	s.closeConns(n)
+	for len(s.conns) < n {
+		s.conns = append(s.conns, nil)
+	}
+	if used > n {
+		used = n
+	}
+	for i := 0; i < used; i++ {
+		if s.conns[i] != nil {
+			continue
+		}
+		conn, err := c.dialTarget(addr, c.connectTimeout())
+		if err != nil {
+			if i == 0 {
+				s.mu.Unlock()
+				return nil, err
+			}
+			used = i
+			break
+		}
+		s.conns[i] = conn
+	}
+	return s.conns[:used], nil
+}
+
+// release unlocks the pool after acquire
+func (s *stripePool) release() {
+	s.mu.Unlock()
+}
+
+// discard closes socket i, dialed again by the next flush. The pool must be
+// locked by acquire
+func (s *stripePool) discard(i int) {
+	if s.conns[i] != nil {
+		s.conns[i].Close()
+		s.conns[i] = nil
+	}
+}
+
+// closeConns closes the sockets from the nth, and forgets them. The pool must
+// be locked
+func (s *stripePool) closeConns(n int) {
+	for i := n; i < len(s.conns); i++ {
+		if s.conns[i] != nil {
+			s.conns[i].Close()
+		}
+	}
+	if n < len(s.conns) {
+		s.conns = s.conns[:n]
+	}
+}
+
+// close closes the sockets for good, with the client
+func (s *stripePool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeConns(0)
+	s.closed = true
+}
+
+// failHeld records the error of the keys of all the packets held
+func (p *packer) failHeld(err error) {
+	for _, packet := range p.held {
+		p.settle(packet.data, packet.groups, err)
+	}
+}
@@ -0,0 +1,231 @@
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+// flushPackets returns the packets of the final flush of the stats, with the
+// packets striped across sockets if sockets > 1
+func flushPackets(t *testing.T, sockets int) []string {
+	client, conn := newPacketClient(t, "myproject.")
+	client.SetMaxPacketSize(100)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.SetFlushSockets(sockets)
+	for i := 0; i < 300; i++ {
+		buffered.Incr(fmt.Sprintf("hits%d", i), int64(i+1))
+	}
+	for i := 0; i < 20; i++ {
+		buffered.Gauge(fmt.Sprintf("level%d", i), int64(-i-1))
+	}
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	packets := conn.sent()
+	sort.Strings(packets)
+	return packets
+}
+
+func TestFlushSockets(t *testing.T) {
+	expected := flushPackets(t, 1)
+	actual := flushPackets(t, 4)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("the striped packets differ:\nexpected %q\nactual   %q", expected, actual)
+	}
+	// the reset of a negative gauge travels with its value
+	for _, packet := range actual {
+		lines := strings.Split(packet, "\n")
+		for i, line := range lines {
+			if strings.HasPrefix(line, "myproject.level") && strings.HasSuffix(line, ":0|g") {
+				if i+1 == len(lines) || !strings.HasPrefix(lines[i+1], strings.TrimSuffix(line, "0|g")+"-") {
+					t.Errorf("negative gauge split in %q", packet)
+				}
+			}
+		}
+	}
+}
+
+func TestFlushSocketsReport(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetMaxPacketSize(100)
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.SetFlushSockets(3)
+	reports := make(chan FlushReport, 1)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+	for i := 0; i < 50; i++ {
+		buffered.Incr(fmt.Sprintf("hits%d", i), 1)
+	}
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := <-reports
+	if r.Err != nil || r.Lines != 50 || r.Packets < 3 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	// the sockets deliver in any order
+	for i := 0; i < 50; i++ {
+		if _, err := srv.WaitFor(fmt.Sprintf("myproject.hits%d", i), 1, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(srv.Metrics()); n != 50 {
+		t.Errorf("expected 50 metrics, received %d", n)
+	}
+	if client.LastSendTime().IsZero() {
+		t.Error("the striped sends aren't recorded")
+	}
+}
+
+// the streams keep writing on the socket of the client
+func TestFlushSocketsStream(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	addr, err := srv.ListenTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewStatsdClient("tcp://"+addr, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.SetFlushSockets(4)
+	if err := buffered.Config().Validate(); err == nil || !strings.Contains(err.Error(), "striped") {
+		t.Errorf("expected a problem about the flush sockets, actual %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		buffered.Incr(fmt.Sprintf("hits%02d", i), 1)
+	}
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := srv.WaitFor("myproject.hits49", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics = srv.Metrics()
+	for i, m := range metrics {
+		if expected := fmt.Sprintf("myproject.hits%02d", i); m.Name != expected {
+			t.Fatalf("expected %s, actual %s", expected, m.Name)
+		}
+	}
+}
+
+func BenchmarkFlushSockets(b *testing.B) {
+	// a listener discarding the packets
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			if _, _, err := ln.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	events := make([]event.Event, 50000)
+	for i := range events {
+		events[i] = &event.Increment{Name: fmt.Sprintf("bench.key%d", i), Value: int64(i)}
+	}
+	for _, sockets := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("sockets=%d", sockets), func(b *testing.B) {
+			client := NewStatsdClient(ln.LocalAddr().String(), "")
+			if err := client.CreateSocket(); err != nil {
+				b.Fatal(err)
+			}
+			defer client.Close()
+			var sending time.Duration
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var report FlushReport
+				if sockets == 1 {
+					err = client.sendEvents(events, true, &report)
+				} else {
+					err = client.sendEventsStriped(events, &report, sockets)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				sending += report.Sending
+			}
+			b.ReportMetric(float64(sending.Nanoseconds())/float64(b.N), "send-ns/op")
+		})
+	}
+}
+
+// stripeConns returns the sockets kept open by the striped flushes
+func stripeConns(c *StatsdClient) []net.Conn {
+	c.stripes.mu.Lock()
+	defer c.stripes.mu.Unlock()
+	return append([]net.Conn(nil), c.stripes.conns...)
+}
+
+func TestFlushSocketsPool(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetMaxPacketSize(100)
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+	buffered.SetFlushSockets(3)
+	reports := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { reports <- r })
+	flush := func() FlushReport {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			buffered.Incr(fmt.Sprintf("hits%d", i), 1)
+		}
+		waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 50 })
+		clock.Advance(time.Second)
+		return <-reports
+	}
+
+	flush()
+	conns := stripeConns(client)
+	if len(conns) != 3 {
+		t.Fatalf("expected 3 sockets, actual %d", len(conns))
+	}
+	if r := flush(); r.Err != nil || r.Lines != 50 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if !reflect.DeepEqual(conns, stripeConns(client)) {
+		t.Error("the sockets were dialed again")
+	}
+
+	// a socket a write failed on is dialed again by the next flush
+	conns[1].Close()
+	flush()
+	flush()
+	reopened := stripeConns(client)
+	if reopened[0] != conns[0] || reopened[2] != conns[2] || reopened[1] == conns[1] || reopened[1] == nil {
+		t.Errorf("expected only the second socket to be reopened: %v, then %v", conns, reopened)
+	}
+
+	if err := buffered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range reopened {
+		if _, err := conn.Write([]byte("x")); err == nil {
+			t.Error("a socket was left open")
+		}
+	}
+}
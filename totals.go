@@ -0,0 +1,103 @@
+package statsd
+
+import (
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// SetTotalDeltas makes the buffered client send every total at flush time as
+// a counter of the increase since the previous flush, so that the backend can
+// sum and rate it like any other counter. Otherwise the latest value of each
+// total is sent as is, like the direct client does.
+// The first flush of a total only records its value, and a value lower than
+// the previous one (the source was restarted) is sent as the delta. The value
+// of a total not sent for SetTotalIntervals is forgotten, its next flush is a
+// first one again
+func (sb *buffer) SetTotalDeltas(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&sb.totalDeltas, v)
+}
+
+// DefaultTotalIntervals is the number of intervals the value of a total sent
+// as a delta is kept without the total being sent again, see SetTotalIntervals
+const DefaultTotalIntervals = 10
+
+// totalValue is the latest value of a total sent as a delta
+type totalValue struct {
+	value int64
+	idle  int // the intervals since it was sent, see expireTotals
+}
+
+// SetTotalIntervals sets the number of intervals the value of a total sent as
+// a delta (see SetTotalDeltas) is kept while the total isn't sent, so that the
+// totals of the short-lived sources don't accumulate. 0 or less restores
+// DefaultTotalIntervals
+func (sb *buffer) SetTotalIntervals(n int) {
+	if n <= 0 {
+		n = DefaultTotalIntervals
+	}
+	atomic.StoreInt32(&sb.totalIntervals, int32(n))
+}
+
+// totalIntervalsValue returns the effective number of intervals of the values
+// of the totals, see SetTotalIntervals
+func (sb *buffer) totalIntervalsValue() int {
+	if n := int(atomic.LoadInt32(&sb.totalIntervals)); n > 0 {
+		return n
+	}
+	return DefaultTotalIntervals
+}
+
+// totalDelta returns the counter to send in place of a total, or nil on the
+// first flush of its key. It's only called from within the collector
+func (sb *buffer) totalDelta(e *event.Total) event.Event {
+	previous, ok := sb.totals[e.Name]
+	if !ok {
+		return nil
+	}
+	delta := e.Value - previous.value
+	if delta < 0 {
+		// counter reset
+		delta = e.Value
+	}
+	return &event.Increment{Name: e.Name, Value: delta}
+}
+
+// recordTotals remembers the value of the totals flushed as deltas, unless
+// their counter failed to be sent: the retained total is then compared to the
+// same previous value at the next flush. It's only called from within the collector
+func (sb *buffer) recordTotals(job *flushJob) {
+	if atomic.LoadInt32(&sb.totalDeltas) == 0 {
+		return
+	}
+	failed := job.failedDetached()
+	for k, e := range job.detached {
+		total, ok := e.(*event.Total)
+		if !ok || failed[k] {
+			continue
+		}
+		if sb.totals == nil {
+			sb.totals = make(map[string]*totalValue)
+		}
+		sb.totals[k] = &totalValue{value: total.Value}
+	}
+}
+
+// expireTotals forgets the values of the totals which weren't sent for
+// totalIntervals, before the pending ones are flushed. It's only called from
+// within the collector
+func (sb *buffer) expireTotals(pending map[string]event.Event) {
+	n := sb.totalIntervalsValue()
+	for key, t := range sb.totals {
+		if _, ok := pending[key]; ok {
+			continue
+		}
+		if t.idle++; t.idle >= n {
+			delete(sb.totals, key)
+		}
+	}
+}
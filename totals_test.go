@@ -0,0 +1,137 @@
+package statsd
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestBufferTotals(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	defer buffered.Close()
+
+	expect := func(name string, n int, value string, typ string) {
+		t.Helper()
+		metrics, err := srv.WaitFor(name, n, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := metrics[n-1]; m.Value != value || m.Type != typ {
+			t.Errorf("%s: expected %s|%s, actual %s", name, value, typ, m.Raw)
+		}
+	}
+
+	// the latest value is sent, not the sum
+	buffered.Total("ops", 5)
+	buffered.Total("ops", 7)
+	clock.Advance(10 * time.Second)
+	expect("myproject.ops", 1, "7", "t")
+
+	// the first flush of a total as a delta only records its value
+	buffered.SetTotalDeltas(true)
+	buffered.Total("reads", 100)
+	buffered.Gauge("flushed", 1)
+	clock.Advance(10 * time.Second)
+	expect("myproject.flushed", 1, "1", "g")
+	if _, err := srv.WaitFor("myproject.reads", 1, 10*time.Millisecond); err == nil {
+		t.Error("delta sent on the first flush")
+	}
+
+	buffered.Total("reads", 130)
+	buffered.Total("reads", 150)
+	clock.Advance(10 * time.Second)
+	expect("myproject.reads", 1, "50", "c")
+
+	// reset: the new value is the delta
+	buffered.Total("reads", 20)
+	clock.Advance(10 * time.Second)
+	expect("myproject.reads", 2, "20", "c")
+
+	buffered.Total("reads", 20)
+	clock.Advance(10 * time.Second)
+	expect("myproject.reads", 3, "0", "c")
+}
+
+// a delta which failed isn't recorded as sent, also with a prefix provider
+func TestBufferTotalsRetained(t *testing.T) {
+	conn := &flakyConn{}
+	client := NewStatsdClient("localhost:8125", "")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	client.SetPrefixProvider(func() string { return "myproject." }, time.Hour)
+	buffered := NewStatsdBuffer(10*time.Second, client)
+	buffered.Logger = discardLogger{}
+	buffered.SetTotalDeltas(true)
+	flushed := make(chan FlushReport, 10)
+	buffered.SetFlushObserver(func(r FlushReport) { flushed <- r })
+
+	buffered.Total("reads", 100)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	clock.Advance(10 * time.Second)
+	<-flushed
+	conn.mu.Lock()
+	conn.until = time.Now().Add(time.Hour)
+	conn.mu.Unlock()
+	buffered.Total("reads", 150)
+	waitUntil(t, time.Second, func() bool { return buffered.Stats().Pending == 1 })
+	clock.Advance(10 * time.Second)
+	if r := <-flushed; r.Err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	conn.mu.Lock()
+	conn.until = time.Time{}
+	conn.mu.Unlock()
+	clock.Advance(10 * time.Second)
+	<-flushed
+	buffered.Close()
+	if lines := conn.lines(); !reflect.DeepEqual([]string{"myproject.reads:50|c"}, lines) {
+		t.Errorf("expected the delta since the last total sent, actual %q", lines)
+	}
+}
+
+func TestBufferTotalIntervals(t *testing.T) {
+	buffered, flush := newContributionClient(t)
+	buffered.SetTotalDeltas(true)
+	buffered.SetTotalIntervals(2)
+	if cfg := buffered.Config(); cfg.TotalIntervals != 2 {
+		t.Errorf("expected 2 intervals, actual %d", cfg.TotalIntervals)
+	}
+	// a gauge so that every interval flushes
+	interval := func(lines ...string) {
+		t.Helper()
+		buffered.Gauge("tick", 1)
+		expectLines(t, append(lines, "myproject.tick:1|g"), flush())
+	}
+
+	buffered.Total("reads", 100)
+	interval()
+	// the value is kept while the total is idle for less than 2 intervals
+	interval()
+	buffered.Total("reads", 130)
+	interval("myproject.reads:30|c")
+
+	// then forgotten, the next flush only records the value again
+	interval()
+	interval()
+	buffered.Total("reads", 150)
+	interval()
+	buffered.Total("reads", 160)
+	interval("myproject.reads:10|c")
+
+	buffered.SetTotalIntervals(0)
+	if cfg := buffered.Config(); cfg.TotalIntervals != DefaultTotalIntervals {
+		t.Errorf("expected the default intervals, actual %d", cfg.TotalIntervals)
+	}
+}
@@ -0,0 +1,217 @@
+package statsd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target is a parsed client address, see ParseAddr
+type Target struct {
+	Scheme  string // udp, tcp, unix, unixgram, unixstream, file or stdout
+	Network string // the network to dial, empty for file and stdout
+	Address string // host:port, or the path of the socket or file
+	Framed  bool   // payloads are length-prefixed (DogStatsD stream protocol)
+}
+
+// ParseAddr parses the address of a client:
+//
+//	host:port, udp://host:port  UDP (IPv6 literals in brackets, e.g. [::1]:8125)
+//	tcp://host:port             TCP, one stat per line
+//	unix:///path                stream unix socket, one stat per line
+//	unixgram:///path            datagram unix socket
+//	unixstream:///path          stream unix socket, length-prefixed (DogStatsD)
+//	file:///path                appends one stat per line to a file
+//	stdout:                     writes one stat per line to the standard output
+func ParseAddr(addr string) (Target, error) {
+	i := strings.Index(addr, ":")
+	scheme, rest := "", addr
+	if i >= 0 && strings.HasPrefix(addr[i:], "://") {
+		scheme, rest = addr[:i], addr[i+3:]
+	} else if addr == "stdout:" {
+		return Target{Scheme: "stdout"}, nil
+	}
+	switch scheme {
+	case "", "udp", "tcp":
+		if err := checkHostPort(rest); err != nil {
+			return Target{}, fmt.Errorf("statsd: address %q: %v", addr, err)
+		}
+		network := scheme
+		if network == "" {
+			network = "udp"
+		}
+		return Target{Scheme: network, Network: network, Address: rest}, nil
+	case "unix", "unixgram", "unixstream", "file":
+		if rest == "" {
+			return Target{}, fmt.Errorf("statsd: address %q: missing path", addr)
+		}
+		if !strings.HasPrefix(rest, "/") {
+			return Target{}, fmt.Errorf("statsd: address %q: path %q is not absolute", addr, rest)
+		}
+		t := Target{Scheme: scheme, Network: scheme, Address: rest}
+		switch scheme {
+		case "unixstream":
+			t.Network, t.Framed = "unix", true
+		case "file":
+			t.Network = ""
+		}
+		return t, nil
+	}
+	return Target{}, fmt.Errorf("statsd: address %q: unsupported scheme %q", addr, scheme)
+}
+
+// checkHostPort checks the syntax of a host:port address. The host names,
+// zones and service names are left to the resolver, only the numeric ports
+// out of range are rejected here
+func checkHostPort(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if port == "" {
+		return fmt.Errorf("missing port in address %q", addr)
+	}
+	if n, err := strconv.Atoi(port); err == nil && (n <= 0 || n > 65535) {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// framedConn implements the DogStatsD stream protocol: every payload is
+// preceded by its length as a 4-byte little-endian header, so payloads are
+// not bound by the datagram size limits. If a write fails (e.g. the agent
+// restarted) it reconnects and tries once more.
+// Writes are serialized by the mutex of the client
+type framedConn struct {
+	net.Conn
+	redial func() (net.Conn, error)
+	frame  []byte
+	// the payload written first on a reestablished connection, see SetAnnounceOnStart
+	greeting func() []byte
+}
+
+func (c *framedConn) Write(b []byte) (int, error) {
+	c.frame = append(c.frame[:0], 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(c.frame, uint32(len(b)))
+	c.frame = append(c.frame, b...)
+	if _, err := c.Conn.Write(c.frame); err != nil {
+		// a partial frame would corrupt the stream: reconnect and start over
+		conn, derr := c.redial()
+		if derr != nil {
+			return 0, err
+		}
+		c.Conn.Close()
+		c.Conn = conn
+		if greeting := c.greeting(); greeting != nil {
+			c.Conn.Write(frame(greeting))
+		}
+		if _, err := c.Conn.Write(c.frame); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// frame prefixes a payload with its length, see framedConn
+func frame(b []byte) []byte {
+	f := make([]byte, 4, 4+len(b))
+	binary.LittleEndian.PutUint32(f, uint32(len(b)))
+	return append(f, b...)
+}
+
+// dialTarget opens a connection to addr
+func (c *StatsdClient) dialTarget(addr string, timeout time.Duration) (net.Conn, error) {
+	t, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Scheme {
+	case "file":
+		f, err := os.OpenFile(t.Address, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &lineConn{Conn: fileConn{f}}, nil
+	case "stdout":
+		return &lineConn{Conn: fileConn{os.Stdout}, keepOpen: true}, nil
+	}
+	network := dialNetwork(t, c.isGraphite())
+	conn, err := c.dial(network, t.Address, timeout)
+	if err != nil {
+		return conn, err
+	}
+	if t.Framed {
+		redial := func() (net.Conn, error) { return c.dial(network, t.Address, timeout) }
+		greeting := func() []byte { return c.announcement(t) }
+		return &framedConn{Conn: conn, redial: redial, greeting: greeting}, nil
+	}
+	if isStream(network) {
+		return &lineConn{Conn: c.compress(conn)}, nil
+	}
+	return conn, nil
+}
+
+// dialNetwork returns the network dialTarget opens the connections to t on:
+// Graphite mode sends over TCP what's addressed to a UDP port
+func dialNetwork(t Target, graphite bool) string {
+	if graphite && t.Network == "udp" {
+		return "tcp"
+	}
+	return t.Network
+}
+
+// isStream tells whether the payloads written on network are terminated by
+// dialTarget with a newline, and compressed (see SetCompression), unless
+// they're framed
+func isStream(network string) bool {
+	return network == "tcp" || network == "unix"
+}
+
+// compresses tells whether dialTarget compresses the connections to t, see
+// SetCompression
+func compresses(t Target, graphite bool) bool {
+	return !t.Framed && isStream(dialNetwork(t, graphite))
+}
+
+// lineConn terminates every payload with a newline, for the streams where
+// payloads aren't delimited by the datagrams
+type lineConn struct {
+	net.Conn
+	keepOpen bool // for the standard output
+	line     []byte
+}
+
+func (c *lineConn) Write(b []byte) (int, error) {
+	if len(b) > 0 && b[len(b)-1] == '\n' {
+		return c.Conn.Write(b)
+	}
+	c.line = append(append(c.line[:0], b...), '\n')
+	if _, err := c.Conn.Write(c.line); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *lineConn) Close() error {
+	if c.keepOpen {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+// fileConn is a net.Conn writing to a file
+type fileConn struct {
+	*os.File
+}
+
+func (c fileConn) LocalAddr() net.Addr  { return fileAddr(c.Name()) }
+func (c fileConn) RemoteAddr() net.Addr { return fileAddr(c.Name()) }
+
+type fileAddr string
+
+func (a fileAddr) Network() string { return "file" }
+func (a fileAddr) String() string  { return string(a) }
@@ -0,0 +1,278 @@
+package statsd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// streamServer is a fake DogStatsD agent on a stream unix socket,
+// validating the framing of the payloads it receives
+type streamServer struct {
+	ln       net.Listener
+	payloads chan string
+	errors   chan error
+	conns    chan net.Conn
+}
+
+func newStreamServer(t *testing.T) *streamServer {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "dsd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &streamServer{ln: ln, payloads: make(chan string, 100), errors: make(chan error, 10), conns: make(chan net.Conn, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *streamServer) serve(conn net.Conn) {
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			s.errors <- fmt.Errorf("truncated frame: %v", err)
+			return
+		}
+		s.payloads <- string(payload)
+	}
+}
+
+func (s *streamServer) next(t *testing.T) string {
+	select {
+	case p := <-s.payloads:
+		return p
+	case err := <-s.errors:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a payload")
+	}
+	return ""
+}
+
+func TestUnixStream(t *testing.T) {
+	srv := newStreamServer(t)
+	defer srv.ln.Close()
+
+	client := NewStatsdClient("unixstream://"+srv.ln.Addr().String(), "myproject.")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Incr("a", 1)
+	if p := srv.next(t); p != "myproject.a:1|c" {
+		t.Errorf("unexpected payload %q", p)
+	}
+
+	// payloads larger than any datagram are delivered whole
+	client.SetMaxPacketSize(1 << 20)
+	counts := make(map[string]int64)
+	for i := 0; i < 5000; i++ {
+		counts[fmt.Sprintf("key%d", i)] = int64(i + 1)
+	}
+	if err := client.IncrMap(counts); err != nil {
+		t.Fatal(err)
+	}
+	p := srv.next(t)
+	if len(p) <= 64*1024 {
+		t.Errorf("expected a payload larger than 64KB, actual %d bytes", len(p))
+	}
+	lines := strings.Split(p, "\n")
+	if len(lines) != len(counts) {
+		t.Fatalf("expected %d lines, actual %d", len(counts), len(lines))
+	}
+	for _, line := range lines {
+		var k string
+		var v int64
+		if _, err := fmt.Sscanf(strings.Replace(line, ":", " ", 1), "myproject.%s %d|c", &k, &v); err != nil || counts[k] != v {
+			t.Fatalf("corrupted line %q", line)
+		}
+	}
+}
+
+func TestUnixStreamReconnect(t *testing.T) {
+	srv := newStreamServer(t)
+	defer srv.ln.Close()
+
+	client := NewStatsdClient("unixstream://"+srv.ln.Addr().String(), "")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Incr("before", 1)
+	srv.next(t)
+	// the agent restarts, dropping the connection
+	(<-srv.conns).Close()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := client.Incr("after", 1); err != nil {
+		t.Fatal(err)
+	}
+	if p := srv.next(t); p != "after:1|c" {
+		t.Errorf("unexpected payload %q", p)
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected Target
+		fails    string // the component named by the error
+	}{
+		{addr: "localhost:8125", expected: Target{Scheme: "udp", Network: "udp", Address: "localhost:8125"}},
+		{addr: ":8125", expected: Target{Scheme: "udp", Network: "udp", Address: ":8125"}},
+		{addr: "[::1]:8125", expected: Target{Scheme: "udp", Network: "udp", Address: "[::1]:8125"}},
+		{addr: "udp://10.0.0.1:8125", expected: Target{Scheme: "udp", Network: "udp", Address: "10.0.0.1:8125"}},
+		{addr: "tcp://[2001:db8::1]:8125", expected: Target{Scheme: "tcp", Network: "tcp", Address: "[2001:db8::1]:8125"}},
+		{addr: "unix:///var/run/statsd.sock", expected: Target{Scheme: "unix", Network: "unix", Address: "/var/run/statsd.sock"}},
+		{addr: "unixgram:///var/run/statsd.sock", expected: Target{Scheme: "unixgram", Network: "unixgram", Address: "/var/run/statsd.sock"}},
+		{addr: "unixstream:///var/run/dsd.sock", expected: Target{Scheme: "unixstream", Network: "unix", Address: "/var/run/dsd.sock", Framed: true}},
+		{addr: "file:///tmp/stats.log", expected: Target{Scheme: "file", Address: "/tmp/stats.log"}},
+		{addr: "stdout:", expected: Target{Scheme: "stdout"}},
+		{addr: "statsd_exporter:8125", expected: Target{Scheme: "udp", Network: "udp", Address: "statsd_exporter:8125"}},
+		{addr: "localhost:statsd", expected: Target{Scheme: "udp", Network: "udp", Address: "localhost:statsd"}},
+		{addr: "udp://[fe80::1%eth0]:8125", expected: Target{Scheme: "udp", Network: "udp", Address: "[fe80::1%eth0]:8125"}},
+		{addr: "localhost", fails: "port"},
+		{addr: "localhost:", fails: "port"},
+		{addr: "localhost:65536", fails: "port"},
+		{addr: "::1:8125", fails: "colons"},
+		{addr: "tcp://", fails: "port"},
+		{addr: "http://localhost:80", fails: "scheme"},
+		{addr: "unixgram://", fails: "path"},
+		{addr: "file://stats.log", fails: "path"},
+		{addr: "stdout", fails: "port"},
+	}
+	for _, tt := range tests {
+		target, err := ParseAddr(tt.addr)
+		if tt.fails != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.fails) {
+				t.Errorf("%q: expected an error about the %s, actual %v", tt.addr, tt.fails, err)
+			}
+			continue
+		}
+		if err != nil || target != tt.expected {
+			t.Errorf("%q: expected %+v, actual %+v %v", tt.addr, tt.expected, target, err)
+		}
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.log")
+	client := NewStatsdClient("file://"+path, "myproject.")
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("a", 1)
+	client.Gauge("b", -1)
+	client.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "myproject.a:1|c\nmyproject.b:0|g\nmyproject.b:-1|g\n"; string(data) != expected {
+		t.Errorf("expected %q, actual %q", expected, data)
+	}
+}
+
+func TestNetDialer(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	tcpAddr, err := srv.ListenTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var controlled []string
+	control := func(network, address string, conn syscall.RawConn) error {
+		controlled = append(controlled, network+" "+address)
+		return nil
+	}
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetNetDialer(&net.Dialer{LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, Control: control})
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("udp", 1)
+	if local := client.conn.LocalAddr().(*net.UDPAddr); !local.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("source address not bound: %s", local)
+	}
+	client.Close()
+
+	client = NewStatsdClient("tcp://"+tcpAddr, "myproject.")
+	client.SetSocketControl(control)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("tcp", 1)
+	client.Close()
+
+	// the control hook composes with the dialer, whatever the order
+	var own []string
+	client = NewStatsdClient(srv.Addr(), "myproject.")
+	client.SetSocketControl(control)
+	client.SetNetDialer(&net.Dialer{LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, Control: func(network, address string, conn syscall.RawConn) error {
+		own = append(own, network+" "+address)
+		return nil
+	}})
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("both", 1)
+	if local := client.conn.LocalAddr().(*net.UDPAddr); !local.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("source address not bound: %s", local)
+	}
+	client.Close()
+	if expected := []string{"udp4 " + srv.Addr()}; !reflect.DeepEqual(expected, own) {
+		t.Errorf("expected the control of the dialer to run for %q, actual %q", expected, own)
+	}
+
+	for _, name := range []string{"myproject.udp", "myproject.tcp", "myproject.both"} {
+		if _, err := srv.WaitFor(name, 1, time.Second); err != nil {
+			t.Error(err)
+		}
+	}
+	expected := []string{"udp4 " + srv.Addr(), "tcp4 " + tcpAddr, "udp4 " + srv.Addr()}
+	if !reflect.DeepEqual(expected, controlled) {
+		t.Errorf("expected the control hook to run for %q, actual %q", expected, controlled)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	var timeouts []time.Duration
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.SetDialer(func(network, address string, timeout time.Duration) (net.Conn, error) {
+		timeouts = append(timeouts, timeout)
+		return net.DialTimeout(network, address, timeout)
+	})
+	client.CreateSocket()
+	client.SetDialTimeout(time.Second)
+	client.CreateSocket()
+	client.SetDialTimeout(0)
+	client.CreateSocket()
+	client.Close()
+	expected := []time.Duration{DefaultDialTimeout, time.Second, DefaultDialTimeout}
+	if !reflect.DeepEqual(expected, timeouts) {
+		t.Errorf("expected %v, actual %v", expected, timeouts)
+	}
+}
@@ -0,0 +1,131 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/wire"
+)
+
+// typedSender is implemented by the clients which can send the parsed metrics
+// their methods would lose the wire type or the sample rate of: the sampled
+// timings and the absolutes averaged or summed by the server
+// (wire.TypeAbsMean, wire.TypeAbsSum), see sendMetric
+type typedSender interface {
+	sendTyped(m wire.Metric, value float64) error
+}
+
+// needsTyped tells whether m can only be sent faithfully with sendTyped
+func needsTyped(m wire.Metric) bool {
+	switch m.Type {
+	case wire.TypeAbsMean, wire.TypeAbsSum:
+		return true
+	case wire.TypeTiming, wire.TypeHistogram, wire.TypeDistrib:
+		return m.SampleRate < 1
+	}
+	return false
+}
+
+// sendTyped is the sendTyped of client if it has one. The other clients get
+// the sampled timings without their rate, and can't send the other absolutes
+func sendTyped(client Statsd, m wire.Metric, value float64) error {
+	if s, ok := client.(typedSender); ok {
+		return s.sendTyped(m, value)
+	}
+	if m.Type == wire.TypeAbsMean || m.Type == wire.TypeAbsSum {
+		return fmt.Errorf("statsd: %T can't send the absolutes of type %s", client, m.Type)
+	}
+	return fTiming(client, m.Name, value)
+}
+
+// sendTyped sends the absolute with its type, or the timing with its rate as
+// a |ms line: it was already sampled at the source, the sampling of the client
+// doesn't apply again
+func (c *StatsdClient) sendTyped(m wire.Metric, value float64) error {
+	if !event.IsFinite(value) {
+		return ErrInvalidValue
+	}
+	kind, format := KindAbsolute, "%s|"+m.Type
+	if m.Type != wire.TypeAbsMean && m.Type != wire.TypeAbsSum {
+		kind, format = KindTiming, string(wire.AppendSampleRate([]byte("%s|ms"), m.SampleRate))
+	}
+	if !c.allowed(kind, m.Name) {
+		return nil
+	}
+	return c.sendSampled(kind, m.Name, format, c.formatFloat(value))
+}
+
+// sendTyped buffers the absolute with its type, or the timing as the ones not
+// sampled at the source it stands for
+func (sb *buffer) sendTyped(m wire.Metric, value float64) error {
+	return sb.sendTypedAt(m, value, PriorityNormal)
+}
+
+// sendTypedAt is sendTyped at a priority, see WithPriority
+func (sb *buffer) sendTypedAt(m wire.Metric, value float64, priority Priority) error {
+	if m.Type == wire.TypeAbsMean || m.Type == wire.TypeAbsSum {
+		return sb.enqueueFinite(value, &typedAbsolute{FAbsolute: *sb.newFAbsolute(m.Name, value), typ: m.Type}, priority)
+	}
+	e := sb.newFTiming(m.Name, value)
+	e.Count = int64(math.Max(1, math.Round(1/m.SampleRate)))
+	e.Value = value * float64(e.Count)
+	return sb.enqueueFinite(value, e, priority)
+}
+
+// typedAbsolute is an absolute sent with another type than |a, see
+// wire.TypeAbsMean and wire.TypeAbsSum. Its values are buffered like the ones
+// of an FAbsolute, and only merged with the ones of the same type
+type typedAbsolute struct {
+	event.FAbsolute
+	typ string
+}
+
+// Update the event with the values of an absolute of the same type
+func (e *typedAbsolute) Update(e2 event.Event) error {
+	if t, ok := e2.(*typedAbsolute); !ok || t.typ != e.typ {
+		return fmt.Errorf("statsd event type conflict: %s vs %s ", e.String(), e2.String())
+	}
+	return e.FAbsolute.Update(e2)
+}
+
+// Stats returns an array of StatsD events as they travel over UDP
+func (e typedAbsolute) Stats() []string {
+	ret := make([]string, 0, len(e.Values))
+	for _, v := range e.Values {
+		if event.IsFinite(v) {
+			ret = append(ret, fmt.Sprintf("%s:%s|%s", e.Name, event.FormatFloat(v), e.typ))
+		}
+	}
+	return ret
+}
+
+// AppendStats appends the lines of Stats to buf, see event.Appender
+func (e typedAbsolute) AppendStats(buf []byte, prefix string) []byte {
+	return e.AppendStatsPrecision(buf, prefix, event.DefaultFloatPrecision)
+}
+
+// AppendStatsPrecision appends the lines of Stats to buf with at most precision
+// decimal digits, see event.PrecisionAppender
+func (e typedAbsolute) AppendStatsPrecision(buf []byte, prefix string, precision int) []byte {
+	for _, v := range e.Values {
+		if event.IsFinite(v) {
+			buf = append(append(append(buf, prefix...), e.Name...), ':')
+			buf = append(append(append(buf, event.FormatFloatPrecision(v, precision)...), '|'), e.typ...)
+			buf = append(buf, '\n')
+		}
+	}
+	return buf
+}
+
+// Copy returns a deep copy of the event, see event.Copier: the one of the
+// embedded absolute would lose the type
+func (e typedAbsolute) Copy() event.Event {
+	e.Values = append([]float64(nil), e.Values...)
+	return &e
+}
+
+// String returns a debug-friendly representation of this metric
+func (e typedAbsolute) String() string {
+	return fmt.Sprintf("{Type: %s|%s, Key: %s, Values: %v}", e.TypeString(), e.typ, e.Name, e.Values)
+}
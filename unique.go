@@ -0,0 +1,158 @@
+package statsd
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// bounds of the precision of the unique estimation, see SetUniqueEstimation
+const (
+	MinUniquePrecision = 4
+	MaxUniquePrecision = 16
+)
+
+// sketch is a HyperLogLog estimating the number of distinct values of a key
+// over a flush interval, in 2^precision bytes whatever the number of values
+type sketch struct {
+	precision uint8
+	registers []uint8
+	seed      uint64 // of the hash of the values, see hashValue
+	seen      bool   // values were observed since the previous flush
+}
+
+func newSketch(precision int, seed uint64) *sketch {
+	return &sketch{precision: uint8(precision), registers: make([]uint8, 1<<uint(precision)), seed: seed}
+}
+
+// hashValue hashes a value with a seeded FNV-1a, mixed by the finalizer of
+// MurmurHash3 so that all the bits are uniform. The estimates are only computed
+// within the process, the seed of a buffered client is random, but a fixed one
+// makes them reproducible
+func hashValue(seed uint64, value string) uint64 {
+	h := 14695981039346656037 ^ seed
+	for i := 0; i < len(value); i++ {
+		h ^= uint64(value[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// observe adds a value to the sketch
+func (s *sketch) observe(value string) {
+	h := hashValue(s.seed, value)
+	// the top bits select the register, which keeps the longest run of leading
+	// zeros of the remaining bits (bounded by a sentinel bit)
+	i := h >> (64 - s.precision)
+	rho := uint8(bits.LeadingZeros64(h<<s.precision|1<<(s.precision-1))) + 1
+	if rho > s.registers[i] {
+		s.registers[i] = rho
+	}
+	s.seen = true
+}
+
+// estimate returns the estimated number of distinct values, with a relative
+// standard error of 1.04/sqrt(2^precision)
+func (s *sketch) estimate() int64 {
+	m := float64(len(s.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(s.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for the small cardinalities
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Floor(e + 0.5))
+}
+
+// reset clears the sketch for the next interval, keeping its registers
+func (s *sketch) reset() {
+	for i := range s.registers {
+		s.registers[i] = 0
+	}
+	s.seen = false
+}
+
+// SetUniqueEstimation makes the buffered client estimate the number of unique
+// values of every set with a HyperLogLog, instead of sending the distinct
+// values: at flush time the estimate is sent as a gauge named
+// stat.cardinality. Each key uses 2^precision bytes, with a relative standard
+// error of 1.04/sqrt(2^precision), e.g. 16KiB and 0.8% for a precision of 14.
+// The precision is clamped to [MinUniquePrecision, MaxUniquePrecision], and 0
+// sends the distinct values again. A change applies to the keys from their next
+// interval, the values already observed are estimated with the previous
+// precision. The keys without values over an interval are evicted
+func (sb *buffer) SetUniqueEstimation(precision int) {
+	if precision > 0 && precision < MinUniquePrecision {
+		precision = MinUniquePrecision
+	} else if precision > MaxUniquePrecision {
+		precision = MaxUniquePrecision
+	}
+	atomic.StoreInt32(&sb.uniquePrecision, int32(precision))
+}
+
+// observeUnique feeds the values of a set into the sketch of its key, and
+// tells whether they were estimated. It's only called from within the
+// collector, name is the metric name
+func (sb *buffer) observeUnique(name string, e *event.Set) bool {
+	precision := int(atomic.LoadInt32(&sb.uniquePrecision))
+	if precision == 0 {
+		return false
+	}
+	// a sketch of another precision is replaced at the next flush
+	s := sb.sketches[name]
+	if s == nil {
+		s = newSketch(precision, sb.uniqueSeed)
+		if sb.sketches == nil {
+			sb.sketches = make(map[string]*sketch)
+		}
+		sb.sketches[name] = s
+	}
+	for value := range e.Members {
+		s.observe(value)
+	}
+	return true
+}
+
+// uniqueEvents returns the estimates of the sketches observed since the
+// previous flush, resets them and evicts the idle ones, and the ones of a
+// precision changed meanwhile
+func (sb *buffer) uniqueEvents() []event.Event {
+	precision := int(atomic.LoadInt32(&sb.uniquePrecision))
+	var events []event.Event
+	for name, s := range sb.sketches {
+		if s.seen {
+			events = append(events, &event.Gauge{Name: name + ".cardinality", Value: s.estimate()})
+		}
+		if !s.seen || int(s.precision) != precision {
+			delete(sb.sketches, name)
+			continue
+		}
+		s.reset()
+	}
+	return events
+}
@@ -0,0 +1,134 @@
+package statsd
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// within tells whether the estimate is within 3 standard errors
+func within(estimate int64, n int, precision int) bool {
+	bound := 3 * 1.04 / math.Sqrt(float64(int(1)<<uint(precision)))
+	return math.Abs(float64(estimate)-float64(n)) <= bound*float64(n)
+}
+
+// the seed of the sketches of the tests, so that the estimates are reproducible
+const testSeed = 1
+
+func TestSketchEstimate(t *testing.T) {
+	for _, precision := range []int{MinUniquePrecision, 10, 14, MaxUniquePrecision} {
+		for _, n := range []int{10, 1000, 100000} {
+			s := newSketch(precision, testSeed)
+			for i := 0; i < n; i++ {
+				s.observe("user-" + strconv.Itoa(i))
+				// duplicates don't count
+				s.observe("user-" + strconv.Itoa(i/2))
+			}
+			if estimate := s.estimate(); !within(estimate, n, precision) {
+				t.Errorf("precision %d, %d values: estimated %d", precision, n, estimate)
+			}
+		}
+	}
+}
+
+func TestUnique(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	client.Unique("visitors", "joe")
+	client.Unique("visitors", "bad|value\n")
+	NewRouter(client).Unique("visitors", "ann")
+	// a Statsd without Unique can't count them
+	if err := NewRouter(methodsOnly{client}).Unique("visitors", "bob"); err == nil {
+		t.Error("expected an error without Unique")
+	}
+	expected := []string{"myproject.visitors:joe|s", "myproject.visitors:bad_value_|s", "myproject.visitors:ann|s"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestBufferUnique(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	for _, v := range []string{"joe", "ann", "joe"} {
+		buffered.Unique("visitors", v)
+	}
+	buffered.Close()
+	expected := []string{"myproject.visitors:ann|s\nmyproject.visitors:joe|s"}
+	if !reflect.DeepEqual(expected, conn.packets) {
+		t.Errorf("expected %q, actual %q", expected, conn.packets)
+	}
+}
+
+func TestUniqueEstimation(t *testing.T) {
+	const n = 100000
+	client, conn := newPacketClient(t, "myproject.")
+	buffered := NewStatsdBuffer(time.Hour, client)
+	buffered.Logger = discardLogger{}
+	buffered.uniqueSeed = testSeed
+	buffered.SetUniqueEstimation(14)
+	for i := 0; i < n; i++ {
+		buffered.Unique("visitors", strconv.Itoa(i))
+	}
+	buffered.Close()
+
+	if len(conn.packets) != 1 {
+		t.Fatalf("unexpected packets %q", conn.packets)
+	}
+	line := strings.TrimSuffix(conn.packets[0], "\n")
+	if !strings.HasPrefix(line, "myproject.visitors.cardinality:") || !strings.HasSuffix(line, "|g") {
+		t.Fatalf("unexpected line %q", line)
+	}
+	estimate, err := strconv.ParseInt(line[len("myproject.visitors.cardinality:"):len(line)-2], 10, 64)
+	if err != nil || !within(estimate, n, 14) {
+		t.Errorf("estimated %d unique values out of %d (%v)", estimate, n, err)
+	}
+}
+
+func TestUniqueEviction(t *testing.T) {
+	// the sketches are only used within the collector, there's none here
+	sb := &buffer{}
+	sb.SetUniqueEstimation(1)
+	if sb.uniquePrecision != MinUniquePrecision {
+		t.Errorf("precision not clamped: %d", sb.uniquePrecision)
+	}
+	sb.observeUnique("a", event.NewSet("a", "x"))
+	sb.observeUnique("b", event.NewSet("b", "x"))
+	if events := sb.uniqueEvents(); len(events) != 2 {
+		t.Errorf("expected 2 estimates, actual %v", events)
+	}
+	sb.observeUnique("a", event.NewSet("a", "y"))
+	events := sb.uniqueEvents()
+	if len(events) != 1 || events[0].Key() != "a.cardinality" || events[0].Payload() != int64(1) {
+		t.Errorf("expected a single estimate for a, actual %v", events)
+	}
+	if _, ok := sb.sketches["b"]; ok || len(sb.sketches) != 1 {
+		t.Errorf("idle key not evicted: %v", sb.sketches)
+	}
+	sb.SetUniqueEstimation(0)
+	if sb.observeUnique("a", event.NewSet("a", "z")) {
+		t.Error("values estimated with the estimation disabled")
+	}
+}
+
+// the values observed before a change of precision are estimated with them
+func TestUniquePrecisionChange(t *testing.T) {
+	sb := &buffer{}
+	sb.SetUniqueEstimation(10)
+	sb.observeUnique("a", event.NewSet("a", "x"))
+	sb.SetUniqueEstimation(12)
+	sb.observeUnique("a", event.NewSet("a", "y"))
+	events := sb.uniqueEvents()
+	if len(events) != 1 || events[0].Payload() != int64(2) {
+		t.Errorf("expected an estimate of 2, actual %v", events)
+	}
+	sb.observeUnique("a", event.NewSet("a", "z"))
+	if s := sb.sketches["a"]; s == nil || s.precision != 12 {
+		t.Errorf("expected a sketch of precision 12, actual %+v", s)
+	}
+}
@@ -0,0 +1,34 @@
+package statsd
+
+import (
+	"sync/atomic"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// SendNow sends the stats of an event right away through the underlying
+// client, bypassing the aggregation, e.g. for a panic counter which can't
+// wait for the next flush. It's sent and counted like StatsdClient.SendEvent:
+// in Graphite mode, which only sends aggregates, it's dropped
+func (sb *buffer) SendNow(e event.Event) error {
+	if atomic.LoadInt32(&sb.closed) != 0 {
+		return ErrClosed
+	}
+	return sb.statsd.SendEvent(e)
+}
+
+// IncrNow increments a counter right away, see SendNow
+func (sb *buffer) IncrNow(stat string, count int64) error {
+	if atomic.LoadInt32(&sb.closed) != 0 {
+		return ErrClosed
+	}
+	return sb.statsd.Incr(stat, count)
+}
+
+// GaugeNow sets a gauge right away, see SendNow
+func (sb *buffer) GaugeNow(stat string, value int64) error {
+	if atomic.LoadInt32(&sb.closed) != 0 {
+		return ErrClosed
+	}
+	return sb.statsd.Gauge(stat, value)
+}
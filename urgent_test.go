@@ -0,0 +1,45 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CrowdSurge/statsd/event"
+	"github.com/CrowdSurge/statsd/statsdtest"
+)
+
+func TestSendNow(t *testing.T) {
+	client, conn := newPacketClient(t, "myproject.")
+	clock := statsdtest.NewFakeClock(time.Unix(1000, 0))
+	client.SetClock(clock)
+	buffered := NewStatsdBuffer(time.Second, client)
+	buffered.Logger = discardLogger{}
+
+	buffered.Incr("requests", 1)
+	buffered.IncrNow("panics", 1)
+	buffered.Incr("requests", 2)
+	buffered.GaugeNow("deploying", 1)
+	buffered.SendNow(&event.Increment{Name: "panics", Value: 2})
+	buffered.Gauge("queue", 5)
+	// the urgent ones arrive before the tick, one by one
+	urgent := []string{"myproject.panics:1|c", "myproject.deploying:1|g", "myproject.panics:2|c"}
+	if actual := conn.sent(); !reflect.DeepEqual(urgent, actual) {
+		t.Errorf("expected %q, actual %q", urgent, actual)
+	}
+	if sent := buffered.StatsByKind()[KindCounter].Sent; sent != 2 {
+		t.Errorf("expected 2 counters sent, actual %d", sent)
+	}
+
+	clock.Advance(time.Second)
+	expected := append(urgent, "myproject.queue:5|g\nmyproject.requests:3|c")
+	waitUntil(t, time.Second, func() bool { return len(conn.sent()) == len(expected) })
+	if actual := conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+
+	buffered.Close()
+	if err := buffered.IncrNow("panics", 1); err != ErrClosed {
+		t.Errorf("expected ErrClosed, actual %v", err)
+	}
+}
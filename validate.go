@@ -0,0 +1,231 @@
+package statsd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// probeName is the name of the counter sent by CheckDelivery
+const probeName = "statsd.client.probe"
+
+// probeWait is how long CheckDelivery waits for a UDP probe to be refused
+const probeWait = 100 * time.Millisecond
+
+// DefaultCheckTimeout bounds CheckDelivery when its context has no deadline
+var DefaultCheckTimeout = 5 * time.Second
+
+// DeliveryReport is the outcome of CheckDelivery: what could be verified, and
+// what couldn't
+type DeliveryReport struct {
+	Target    Target
+	Connected bool // the connection (the socket, for the datagrams) was established
+	ProbeSent bool // the probe was written without error
+	Confirmed bool // the admin interface counted the probe, see SetAdminAddress
+	// the checks which couldn't be made, e.g. the receipt of a UDP probe
+	// without an admin interface
+	Unverified []string
+	Err        error // the first check which failed, nil if none did
+}
+
+// SetAdminAddress sets the host:port of the TCP admin interface of the StatsD
+// server (port 8126 of the reference implementation), which CheckDelivery asks
+// for its counters to confirm the receipt of the probe
+func (c *StatsdClient) SetAdminAddress(addr string) {
+	c.mu.Lock()
+	c.adminAddr = addr
+	c.mu.Unlock()
+}
+
+// Validate checks, on a best-effort basis, that the metrics reach the server,
+// e.g. in a readiness probe, see CheckDelivery
+func (c *StatsdClient) Validate(ctx context.Context) error {
+	return c.CheckDelivery(ctx).Err
+}
+
+// CheckDelivery checks, on a best-effort basis, that the metrics reach the
+// server, on a connection of its own: it connects to the address of the client
+// (which fails for the stream sockets without a server) and sends it a
+// statsd.client.probe counter, after the prefix. Over UDP, a port without a
+// server is only detected if the host refuses the probe (ICMP port
+// unreachable) within a short wait. With an admin address (see
+// SetAdminAddress), it then waits for the server to count the probe. The
+// report lists what couldn't be verified
+func (c *StatsdClient) CheckDelivery(ctx context.Context) DeliveryReport {
+	c.mu.Lock()
+	addr, admin := c.addr, c.adminAddr
+	c.mu.Unlock()
+	var report DeliveryReport
+	t, err := ParseAddr(addr)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	report.Target = t
+	if t.Network == "" {
+		report.Unverified = append(report.Unverified, fmt.Sprintf("the %s transport has no server to check", t.Scheme))
+		return report
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCheckTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	conn, err := c.dialTarget(addr, time.Until(deadline))
+	if err != nil {
+		report.Err = fmt.Errorf("statsd: can't connect to %s: %w", addr, err)
+		return report
+	}
+	defer conn.Close()
+	report.Connected = true
+	conn.SetDeadline(deadline)
+	prefix, _ := c.currentPrefix()
+	name := Escape(FieldName, prefix+probeName)
+	// the probes counted before this one don't confirm it
+	var baseline float64
+	if admin != "" {
+		if baseline, err = probeCount(admin, name, deadline); err != nil {
+			report.Err = fmt.Errorf("statsd: can't read the counters of %s: %w", admin, err)
+			return report
+		}
+	}
+	if _, err := conn.Write(c.probe(name)); err != nil {
+		report.Err = fmt.Errorf("statsd: can't send the probe to %s: %w", addr, err)
+		return report
+	}
+	report.ProbeSent = true
+	if t.Network == "udp" && !c.isGraphite() {
+		if err := awaitRefusal(conn, deadline); err != nil {
+			report.Err = fmt.Errorf("statsd: the probe to %s was refused: %w", addr, err)
+			return report
+		}
+	}
+	if admin == "" {
+		report.Unverified = append(report.Unverified, "the receipt of the probe, without an admin address (see SetAdminAddress)")
+		return report
+	}
+	if err := confirmProbe(ctx, admin, name, baseline); err != nil {
+		report.Err = fmt.Errorf("statsd: the server didn't count the probe: %w", err)
+		return report
+	}
+	report.Confirmed = true
+	return report
+}
+
+// probe returns the line of the probe counter, in the format of the client
+func (c *StatsdClient) probe(name string) []byte {
+	if c.isGraphite() {
+		return []byte(name + " 1 " + strconv.FormatInt(c.now().Unix(), 10) + "\n")
+	}
+	return []byte(name + ":1|c")
+}
+
+// awaitRefusal returns the error reported by a connected UDP socket when the
+// host refused a datagram, waiting for it at most probeWait
+func awaitRefusal(conn net.Conn, deadline time.Time) error {
+	if wait := time.Now().Add(probeWait); wait.Before(deadline) {
+		deadline = wait
+	}
+	conn.SetReadDeadline(deadline)
+	var b [1]byte
+	if _, err := conn.Read(b[:]); errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return nil
+}
+
+// confirmProbe asks the admin interface for its counters until the probe
+// counter exceeds its value before the probe was sent, or ctx is done
+func confirmProbe(ctx context.Context, admin, name string, baseline float64) error {
+	deadline, _ := ctx.Deadline()
+	for {
+		count, err := probeCount(admin, name, deadline)
+		if err != nil {
+			return err
+		}
+		if count > baseline {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// probeCount returns the value of the probe counter in the counters of the
+// admin interface, 0 if it isn't listed
+func probeCount(admin, name string, deadline time.Time) (float64, error) {
+	counters, err := adminCommand(admin, "counters", deadline)
+	if err != nil {
+		return 0, err
+	}
+	key := "'" + name + "':"
+	i := strings.Index(counters, key)
+	if i < 0 {
+		return 0, nil
+	}
+	value := strings.TrimSpace(counters[i+len(key):])
+	if end := strings.IndexAny(value, ", \n}"); end >= 0 {
+		value = value[:end]
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// adminCommand sends a command to the admin interface and returns its
+// response, up to the END line
+func adminCommand(admin, command string, deadline time.Time) (string, error) {
+	conn, err := net.DialTimeout("tcp", admin, time.Until(deadline))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	if _, err := fmt.Fprintf(conn, "%s\n", command); err != nil {
+		return "", err
+	}
+	var response strings.Builder
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(line) == "END" {
+			return response.String(), nil
+		}
+		response.WriteString(line)
+	}
+}
+
+// deliveryChecker is implemented by the clients which can check their delivery
+type deliveryChecker interface {
+	CheckDelivery(ctx context.Context) DeliveryReport
+}
+
+// Validate checks, on a best-effort basis, that the metrics reach the server,
+// see StatsdClient.CheckDelivery
+func (sb *buffer) Validate(ctx context.Context) error {
+	return sb.CheckDelivery(ctx).Err
+}
+
+// CheckDelivery checks the delivery of the client flushed to, see
+// StatsdClient.CheckDelivery
+func (sb *buffer) CheckDelivery(ctx context.Context) DeliveryReport {
+	if sb.next == nil {
+		return sb.statsd.CheckDelivery(ctx)
+	}
+	if next, ok := sb.next.(deliveryChecker); ok {
+		return next.CheckDelivery(ctx)
+	}
+	return DeliveryReport{Unverified: []string{fmt.Sprintf("the delivery through %T", sb.next)}}
+}
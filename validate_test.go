@@ -0,0 +1,143 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// closedPort returns the address of a port nothing listens on anymore
+func closedPort(t *testing.T, network string) string {
+	var ln interface {
+		Close() error
+	}
+	var addr string
+	switch network {
+	case "tcp":
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, addr = l, l.Addr().String()
+	default:
+		c, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, addr = c, c.LocalAddr().String()
+	}
+	ln.Close()
+	return addr
+}
+
+func TestCheckDeliveryTCP(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	addr, err := srv.ListenTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewStatsdClient("tcp://"+addr, "myproject.")
+	report := client.CheckDelivery(context.Background())
+	if report.Err != nil || !report.Connected || !report.ProbeSent || report.Confirmed || len(report.Unverified) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if _, err := srv.WaitFor("myproject."+probeName, 1, time.Second); err != nil {
+		t.Error(err)
+	}
+
+	client = NewStatsdClient("tcp://"+closedPort(t, "tcp"), "myproject.")
+	report = client.CheckDelivery(context.Background())
+	if !errors.Is(report.Err, syscall.ECONNREFUSED) || report.Connected || report.ProbeSent {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if err := client.Validate(context.Background()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestCheckDeliveryUDP(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	client := NewStatsdClient(srv.Addr(), "myproject.")
+
+	// best effort: the probe goes through, its receipt can't be verified
+	report := client.CheckDelivery(context.Background())
+	if report.Err != nil || !report.Connected || !report.ProbeSent || report.Confirmed || len(report.Unverified) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	admin, err := srv.ListenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetAdminAddress(admin)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	report = client.CheckDelivery(ctx)
+	if report.Err != nil || !report.Confirmed || len(report.Unverified) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// the probes counted before don't confirm a probe sent to another server
+	other := newTestServer(t)
+	defer other.Close()
+	client = NewStatsdClient(other.Addr(), "myproject.")
+	client.SetAdminAddress(admin)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if report = client.CheckDelivery(ctx); !errors.Is(report.Err, context.DeadlineExceeded) || !report.ProbeSent || report.Confirmed {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// the admin interface of another server never counts the probe
+	other = newTestServer(t)
+	defer other.Close()
+	admin, err = other.ListenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetAdminAddress(admin)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if report = client.CheckDelivery(ctx); !errors.Is(report.Err, context.DeadlineExceeded) || !report.ProbeSent || report.Confirmed {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestCheckDeliveryRefused(t *testing.T) {
+	client := NewStatsdClient(closedPort(t, "udp"), "myproject.")
+	report := client.CheckDelivery(context.Background())
+	if !errors.Is(report.Err, syscall.ECONNREFUSED) || !report.ProbeSent {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestCheckDeliveryUnverified(t *testing.T) {
+	client := NewStatsdClient("stdout:", "myproject.")
+	if report := client.CheckDelivery(context.Background()); report.Err != nil || report.Connected || len(report.Unverified) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	client = NewStatsdClient("localhost", "myproject.")
+	if err := client.Validate(context.Background()); err == nil {
+		t.Error("expected an error for the invalid address")
+	}
+
+	srv := newTestServer(t)
+	defer srv.Close()
+	buffered := NewStatsdBuffer(time.Hour, NewStatsdClient(srv.Addr(), "myproject."))
+	buffered.Logger = discardLogger{}
+	defer buffered.Close()
+	if report := buffered.CheckDelivery(context.Background()); report.Err != nil || !report.ProbeSent {
+		t.Errorf("unexpected report %+v", report)
+	}
+	statter := NewBufferedStatter(NewRouter(NewStatsdClient(srv.Addr(), "")), time.Hour)
+	statter.Logger = discardLogger{}
+	defer statter.Close()
+	if report := statter.CheckDelivery(context.Background()); report.Err != nil || len(report.Unverified) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,86 @@
+package statsd
+
+import "errors"
+
+// errWarmupFull is returned when a payload can't be held, see SetWarmupBuffer
+var errWarmupFull = errors.New("statsd: not sent yet and warmup buffer full, payload dropped")
+
+// warmup holds the payloads until the first successful write, see
+// SetWarmupBuffer. It's guarded by the mutex of the client
+type warmup struct {
+	maxPackets int
+	maxBytes   int
+	packets    [][]byte
+	bytes      int
+	done       bool // once replayed, the packets are released
+	replayed   int64
+	dropped    int64
+}
+
+// SetWarmupBuffer makes the client hold up to maxPackets payloads, and up to
+// maxBytes bytes, until its first successful write: the stats sent while the
+// socket can't be created yet (e.g. DNS isn't ready at startup) or while its
+// writes fail are written in order as soon as the connection works, on the
+// next send or CreateSocket. The buffer is released afterwards, later failures
+// are handled as usual; the payloads which don't fit are dropped. Unlike the
+// other buffers it only covers the startup of the process, in memory.
+// It must be called before the client is used
+func (c *StatsdClient) SetWarmupBuffer(maxPackets, maxBytes int) {
+	c.mu.Lock()
+	c.warmup = &warmup{maxPackets: maxPackets, maxBytes: maxBytes}
+	c.mu.Unlock()
+}
+
+// warmingUp tells whether the payloads are held until the first successful
+// write. The caller must hold c.mu
+func (c *StatsdClient) warmingUp() bool {
+	return c.warmup != nil && !c.warmup.done
+}
+
+// writable tells whether payloads can be written, or held while warming up.
+// The caller must hold c.mu
+func (c *StatsdClient) writable() bool {
+	return c.conn != nil || c.warmingUp()
+}
+
+// hold keeps a copy of the payload, unless the buffer is full
+func (w *warmup) hold(payload []byte) error {
+	if len(w.packets) >= w.maxPackets || w.bytes+len(payload) > w.maxBytes {
+		w.dropped++
+		return errWarmupFull
+	}
+	w.packets = append(w.packets, append([]byte(nil), payload...))
+	w.bytes += len(payload)
+	return nil
+}
+
+// replayWarmup writes the payloads held in order, until one fails: once they
+// are all written the warmup is over. The caller must hold c.mu
+func (c *StatsdClient) replayWarmup() {
+	w := c.warmup
+	if c.conn == nil || len(w.packets) == 0 {
+		// nothing proves the connection works yet
+		return
+	}
+	for len(w.packets) > 0 {
+		packet := w.packets[0]
+		if _, err := c.conn.Write(packet); err != nil {
+			return
+		}
+		c.markSent()
+		c.mirrorPacket(packet)
+		w.packets, w.bytes = w.packets[1:], w.bytes-len(packet)
+		w.replayed++
+	}
+	w.done, w.packets = true, nil
+}
+
+// writeWarmup holds the payload behind the ones already held, and replays them
+// if the client is connected. The caller must hold c.mu
+func (c *StatsdClient) writeWarmup(payload []byte) error {
+	if err := c.warmup.hold(payload); err != nil {
+		return err
+	}
+	c.replayWarmup()
+	return nil
+}
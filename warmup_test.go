@@ -0,0 +1,98 @@
+package statsd
+
+import (
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmupBeforeConnection(t *testing.T) {
+	resolver := &delayedResolver{conn: &packetConn{}}
+	client := NewStatsdClient("statsd:8125", "myproject.")
+	client.SetDialer(resolver.dial)
+	client.SetWarmupBuffer(10, 1024)
+	if err := client.CreateSocket(); err == nil {
+		t.Fatal("expected the resolution to fail")
+	}
+	// the cold-start metrics are held
+	if err := client.Incr("boot.a", 1); err != nil {
+		t.Fatal(err)
+	}
+	client.PrecisionTiming("boot.init", 2*time.Millisecond)
+	client.IncrMap(map[string]int64{"boot.b": 3})
+	if pending := client.Stats().WarmupPending; pending != 3 {
+		t.Errorf("expected 3 payloads held, actual %d", pending)
+	}
+
+	atomic.StoreInt32(&resolver.ready, 1)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	client.Incr("after", 1)
+	expected := []string{"myproject.boot.a:1|c", "myproject.boot.init:2|ms", "myproject.boot.b:3|c", "myproject.after:1|c"}
+	if actual := resolver.conn.sent(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+	if stats := client.Stats(); stats.WarmupPending != 0 || stats.WarmupReplayed != 3 {
+		t.Errorf("expected 3 payloads replayed, actual %+v", stats)
+	}
+}
+
+func TestWarmupFailingWrites(t *testing.T) {
+	// the server is offline at first
+	conn := &flakyConn{until: time.Now().Add(100 * time.Millisecond)}
+	client := NewStatsdClient("localhost:8125", "myproject.")
+	client.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return conn, nil
+	}
+	client.SetWarmupBuffer(10, 1024)
+	if err := client.CreateSocket(); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		if err := client.Incr("cold", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := conn.lines(); len(lines) != 0 {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	time.Sleep(150 * time.Millisecond)
+	client.Incr("warm", 1)
+	expected := []string{"myproject.cold:1|c", "myproject.cold:2|c", "myproject.cold:3|c", "myproject.warm:1|c"}
+	if actual := conn.lines(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+
+	// later failures aren't held anymore
+	conn.mu.Lock()
+	conn.until = time.Now().Add(time.Hour)
+	conn.mu.Unlock()
+	if err := client.Incr("lost", 1); err == nil {
+		t.Error("expected the write to fail after the warmup")
+	}
+}
+
+func TestWarmupBounds(t *testing.T) {
+	client := NewStatsdClient("statsd:8125", "")
+	client.SetDialer((&delayedResolver{}).dial)
+	client.SetWarmupBuffer(2, 1024)
+	client.Incr("a", 1)
+	client.Incr("b", 1)
+	if err := client.Incr("c", 1); err != errWarmupFull {
+		t.Errorf("expected errWarmupFull, actual %v", err)
+	}
+	client = NewStatsdClient("statsd:8125", "")
+	client.SetDialer((&delayedResolver{}).dial)
+	client.SetWarmupBuffer(10, 10)
+	client.Incr("a", 1) // 5 bytes
+	client.Incr("b", 1)
+	if err := client.Incr("c", 1); err != errWarmupFull {
+		t.Errorf("expected errWarmupFull, actual %v", err)
+	}
+	if stats := client.Stats(); stats.WarmupPending != 2 || stats.WarmupDropped != 1 {
+		t.Errorf("expected 2 payloads held and 1 dropped, actual %+v", stats)
+	}
+}
@@ -0,0 +1,93 @@
+package wire
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// Format is the dialect of the lines, which decides how the tags are sent
+type Format int
+
+// dialects of the line protocol
+const (
+	// FormatStatsD is plain StatsD, without tags: they're dropped
+	FormatStatsD Format = iota
+	// FormatDogStatsD appends the tags to the lines as |#key:value,...
+	FormatDogStatsD
+)
+
+// AppendCounter appends the line of a counter to buf, without a trailing
+// newline, exactly as the client sends it: the name must already be escaped
+// (see Escape) and prefixed. A rate below 1 is sent as the sample rate (|@)
+func AppendCounter(buf []byte, name string, value int64, rate float64, tags []event.Tag, format Format) []byte {
+	return appendEvent(buf, &event.Increment{Name: name, Value: value}, rate, tags, format)
+}
+
+// AppendGauge appends the lines of a gauge to buf, see AppendCounter. A
+// negative value takes two lines, a reset to 0 and the value as a delta, to
+// keep in the same packet (see Packer)
+func AppendGauge(buf []byte, name string, value int64, tags []event.Tag, format Format) []byte {
+	return appendEvent(buf, &event.Gauge{Name: name, Value: value}, 1, tags, format)
+}
+
+// AppendTiming appends the line of a timing in milliseconds to buf, see
+// AppendCounter
+func AppendTiming(buf []byte, name string, ms float64, rate float64, tags []event.Tag, format Format) []byte {
+	buf = append(append(buf, name...), ':')
+	buf = append(append(buf, event.FormatFloat(ms)...), "|ms"...)
+	return appendFields(buf, rate, tags, format)
+}
+
+// AppendSampleRate appends the sample rate field (|@rate) to buf, unless the
+// rate is 1 or more
+func AppendSampleRate(buf []byte, rate float64) []byte {
+	if rate >= 1 {
+		return buf
+	}
+	return strconv.AppendFloat(append(buf, "|@"...), rate, 'f', -1, 64)
+}
+
+// AppendTags appends the tags to buf in the given format, escaping their keys
+// and values (see FieldTagValue). Plain StatsD has no tags
+func AppendTags(buf []byte, tags []event.Tag, format Format) []byte {
+	if format != FormatDogStatsD || len(tags) == 0 {
+		return buf
+	}
+	buf = append(buf, "|#"...)
+	for i, t := range tags {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, Escape(FieldTagValue, t.Key)...)
+		if t.Value != "" {
+			buf = append(append(buf, ':'), Escape(FieldTagValue, t.Value)...)
+		}
+	}
+	return buf
+}
+
+// appendFields appends the sample rate and the tags of a line
+func appendFields(buf []byte, rate float64, tags []event.Tag, format Format) []byte {
+	return AppendTags(AppendSampleRate(buf, rate), tags, format)
+}
+
+// appendEvent appends the lines of an event (as serialized by the event
+// package, like the buffered client does) with their fields, separated by
+// newlines
+func appendEvent(buf []byte, e event.Event, rate float64, tags []event.Tag, format Format) []byte {
+	start := len(buf)
+	buf = event.AppendStats(buf, "", e)
+	lines := append([]byte(nil), buf[start:]...)
+	buf = buf[:start]
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n')
+		if len(buf) > start {
+			buf = append(buf, '\n')
+		}
+		buf = appendFields(append(buf, lines[:i]...), rate, tags, format)
+		lines = lines[i+1:]
+	}
+	return buf
+}
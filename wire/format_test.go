@@ -0,0 +1,33 @@
+package wire
+
+import (
+	"testing"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+func TestAppend(t *testing.T) {
+	tags := []event.Tag{{Key: "env", Value: "prod"}, {Key: "db"}, {Key: "route", Value: "a,b|c"}}
+	tests := []struct {
+		line     []byte
+		expected string
+	}{
+		{AppendCounter(nil, "hits", 3, 1, nil, FormatStatsD), "hits:3|c"},
+		{AppendCounter(nil, "hits", -3, 0.25, tags, FormatStatsD), "hits:-3|c|@0.25"},
+		{AppendCounter([]byte("x:1|c\n"), "hits", 3, 0.5, tags, FormatDogStatsD), "x:1|c\nhits:3|c|@0.5|#env:prod,db,route:a_b_c"},
+		{AppendGauge(nil, "level", 7, nil, FormatStatsD), "level:7|g"},
+		{AppendGauge(nil, "level", -7, tags[:1], FormatDogStatsD), "level:0|g|#env:prod\nlevel:-7|g|#env:prod"},
+		{AppendTiming(nil, "latency", 1.5, 1, nil, FormatStatsD), "latency:1.5|ms"},
+		{AppendTiming(nil, "latency", 2, 0.1, tags[1:2], FormatDogStatsD), "latency:2|ms|@0.1|#db"},
+	}
+	for _, tt := range tests {
+		if string(tt.line) != tt.expected {
+			t.Errorf("expected %q, actual %q", tt.expected, tt.line)
+		}
+	}
+	// the lines parse back
+	m, err := ParseLine(AppendCounter(nil, "hits", 3, 0.5, tags, FormatDogStatsD))
+	if err != nil || m.Value != "3" || m.SampleRate != 0.5 || len(m.Tags) != 3 {
+		t.Errorf("unexpected %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,71 @@
+package wire
+
+import "bytes"
+
+// Packer packs groups of lines into packets of at most MaxSize bytes in a
+// single pass, completing a packet before a group which would make it exceed
+// MaxSize. The lines of a group (e.g. the reset and the value of a negative
+// gauge) are never split across packets, a group larger than MaxSize gets a
+// packet of its own. Suffix is appended to every line, e.g. the DogStatsD
+// origin fields. The zero value is ready to use once MaxSize is set
+type Packer struct {
+	MaxSize int
+	Suffix  string
+	buf     []byte // the packet being filled
+	done    []byte // the last packet returned, whose buffer is reused
+}
+
+// Add adds a group of newline-separated lines, without its trailing newline,
+// and returns the packet completed before it if the group doesn't fit, or nil.
+// The packet is only valid until the next call
+func (p *Packer) Add(group []byte) []byte {
+	if len(group) == 0 {
+		return nil
+	}
+	size := len(group)
+	if p.Suffix != "" {
+		size += (bytes.Count(group, []byte{'\n'}) + 1) * len(p.Suffix)
+	}
+	var packet []byte
+	if len(p.buf) > 0 && len(p.buf)+1+size > p.MaxSize {
+		packet = p.swap()
+	}
+	if len(p.buf) > 0 {
+		p.buf = append(p.buf, '\n')
+	}
+	for p.Suffix != "" {
+		i := bytes.IndexByte(group, '\n')
+		if i < 0 {
+			break
+		}
+		p.buf = append(append(append(p.buf, group[:i]...), p.Suffix...), '\n')
+		group = group[i+1:]
+	}
+	p.buf = append(append(p.buf, group...), p.Suffix...)
+	return packet
+}
+
+// Flush returns the packet of the groups left, or nil if there are none. The
+// packet is only valid until the next call
+func (p *Packer) Flush() []byte {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	return p.swap()
+}
+
+// Reset discards the groups left
+func (p *Packer) Reset() {
+	p.buf = p.buf[:0]
+}
+
+// Len returns the size of the packet being filled
+func (p *Packer) Len() int {
+	return len(p.buf)
+}
+
+// swap returns the packet being filled and starts a new one
+func (p *Packer) swap() []byte {
+	p.buf, p.done = p.done[:0], p.buf
+	return p.done
+}
@@ -0,0 +1,50 @@
+package wire
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPacker(t *testing.T) {
+	p := &Packer{MaxSize: 20}
+	var packets []string
+	add := func(group string) {
+		if packet := p.Add([]byte(group)); packet != nil {
+			packets = append(packets, string(packet))
+		}
+	}
+	add("a:1|c")
+	add("b:2|c")
+	add("c:0|g\nc:-3|g") // a group doesn't fit after the first two lines
+	add("")
+	add("a-very-long-name:1|c") // larger than the packet: a packet of its own
+	add("d:4|c")
+	if packet := p.Flush(); packet != nil {
+		packets = append(packets, string(packet))
+	}
+	expected := []string{"a:1|c\nb:2|c", "c:0|g\nc:-3|g", "a-very-long-name:1|c", "d:4|c"}
+	if !reflect.DeepEqual(expected, packets) {
+		t.Errorf("expected %q, actual %q", expected, packets)
+	}
+	if p.Flush() != nil || p.Len() != 0 {
+		t.Error("the packer isn't empty")
+	}
+}
+
+func TestPackerSuffix(t *testing.T) {
+	p := &Packer{MaxSize: 30, Suffix: "|c:abc"}
+	p.Add([]byte("a:1|c"))
+	if packet := p.Add([]byte("b:0|g\nb:-1|g")); packet == nil || string(packet) != "a:1|c|c:abc" {
+		t.Errorf("unexpected packet %q", packet)
+	}
+	packet := string(p.Flush())
+	if packet != "b:0|g|c:abc\nb:-1|g|c:abc" {
+		t.Errorf("unexpected packet %q", packet)
+	}
+	for _, line := range strings.Split(packet, "\n") {
+		if m, err := ParseLine([]byte(line)); err != nil || m.ContainerID != "abc" {
+			t.Errorf("%q: %+v, %v", line, m, err)
+		}
+	}
+}
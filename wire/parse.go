@@ -0,0 +1,153 @@
+// Package wire implements the StatsD line protocol as written by the statsd client,
+// so that proxies, tailers, exporters and tests can share the client's exact
+// semantics: the parser, the formatting of the lines (see AppendCounter), the
+// packing of the lines into packets (see Packer) and the sanitization of the
+// names (see Escape) the client itself uses
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+// metric types known to the parser
+const (
+	TypeCounter   = "c"
+	TypeGauge     = "g"
+	TypeTiming    = "ms"
+	TypeSet       = "s"
+	TypeHistogram = "h"
+	TypeDistrib   = "d"
+	TypeAbsolute  = "a"
+	TypeAbsMean   = "am"
+	TypeAbsSum    = "asum"
+	TypeTotal     = "t"
+)
+
+var knownTypes = map[string]bool{
+	TypeCounter:   true,
+	TypeGauge:     true,
+	TypeTiming:    true,
+	TypeSet:       true,
+	TypeHistogram: true,
+	TypeDistrib:   true,
+	TypeAbsolute:  true,
+	TypeAbsMean:   true,
+	TypeAbsSum:    true,
+	TypeTotal:     true,
+}
+
+// Metric is a single parsed StatsD line
+type Metric struct {
+	Name       string
+	Value      string // the value exactly as it was sent
+	Type       string
+	SampleRate float64 // 1 when not specified
+	Tags       []string
+	// DogStatsD origin detection fields
+	ContainerID  string
+	ExternalData string
+}
+
+// Float returns the numeric value of the metric.
+// A gauge delta (see IsDelta) is returned with its sign
+func (m Metric) Float() (float64, error) {
+	return strconv.ParseFloat(m.Value, 64)
+}
+
+// Key returns the aggregation key of the metric with its tags, the one the
+// client computes, see event.KeyFor. A tag without a ':' has an empty value
+func (m Metric) Key() string {
+	tags := make([]event.Tag, len(m.Tags))
+	for i, tag := range m.Tags {
+		tags[i].Key, tags[i].Value, _ = strings.Cut(tag, ":")
+	}
+	return event.KeyFor(m.Name, tags)
+}
+
+// IsDelta tells whether the metric is a gauge update rather than an absolute value,
+// i.e. its value has a leading '+' or '-'
+func (m Metric) IsDelta() bool {
+	return m.Type == TypeGauge && (strings.HasPrefix(m.Value, "+") || strings.HasPrefix(m.Value, "-"))
+}
+
+// ParseLine parses a single line in the name:value|type[|@rate][|#tags][|c:id][|e:data] format.
+// Names may contain colons: the value starts after the last one
+func ParseLine(line []byte) (Metric, error) {
+	m := Metric{SampleRate: 1}
+	s := strings.TrimRight(string(line), "\r\n")
+	fields := strings.Split(s, "|")
+	if len(fields) < 2 {
+		return m, fmt.Errorf("statsd line %q: missing type", s)
+	}
+	sep := strings.LastIndex(fields[0], ":")
+	if sep < 0 {
+		return m, fmt.Errorf("statsd line %q: missing value", s)
+	}
+	m.Name, m.Value, m.Type = fields[0][:sep], fields[0][sep+1:], fields[1]
+	if m.Name == "" {
+		return m, fmt.Errorf("statsd line %q: empty name", s)
+	}
+	if m.Value == "" {
+		return m, fmt.Errorf("statsd line %q: empty value", s)
+	}
+	if !knownTypes[m.Type] {
+		return m, fmt.Errorf("statsd line %q: unknown type %q", s, m.Type)
+	}
+	if m.Type != TypeSet {
+		if _, err := m.Float(); err != nil {
+			return m, fmt.Errorf("statsd line %q: invalid value %q", s, m.Value)
+		}
+	}
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			rate, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return m, fmt.Errorf("statsd line %q: invalid sample rate %q", s, f[1:])
+			}
+			m.SampleRate = rate
+		case strings.HasPrefix(f, "#"):
+			m.Tags = strings.Split(f[1:], ",")
+		case strings.HasPrefix(f, "c:"):
+			m.ContainerID = f[2:]
+		case strings.HasPrefix(f, "e:"):
+			m.ExternalData = f[2:]
+		default:
+			return m, fmt.Errorf("statsd line %q: unknown field %q", s, f)
+		}
+	}
+	return m, nil
+}
+
+// ParseDatagram parses a packet holding one or more newline-separated lines.
+// All the well-formed metrics are returned, together with an error describing
+// the first malformed line, if any
+func ParseDatagram(packet []byte) ([]Metric, error) {
+	lines := bytes.Split(packet, []byte("\n"))
+	metrics := make([]Metric, 0, len(lines))
+	var err error
+	bad := 0
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		m, err2 := ParseLine(line)
+		if err2 != nil {
+			if err == nil {
+				err = err2
+			}
+			bad++
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	if bad > 1 {
+		err = fmt.Errorf("%d malformed lines, first: %v", bad, err)
+	}
+	return metrics, err
+}
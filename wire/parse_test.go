@@ -0,0 +1,128 @@
+package wire
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	"github.com/CrowdSurge/statsd/event"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected Metric
+		fails    bool
+	}{
+		{line: "a.b:1|c", expected: Metric{Name: "a.b", Value: "1", Type: "c", SampleRate: 1}},
+		{line: "a:b:c:-5|g", expected: Metric{Name: "a:b:c", Value: "-5", Type: "g", SampleRate: 1}},
+		{line: "t:0.314000|ms|@0.1", expected: Metric{Name: "t", Value: "0.314000", Type: "ms", SampleRate: 0.1}},
+		{line: "u:joe|s|#env:prod,db", expected: Metric{Name: "u", Value: "joe", Type: "s", SampleRate: 1, Tags: []string{"env:prod", "db"}}},
+		{line: "x:3|asum\r\n", expected: Metric{Name: "x", Value: "3", Type: "asum", SampleRate: 1}},
+		{line: "x:1|c|@0.5|#env:prod|c:abc|e:it-false,cn-app", expected: Metric{Name: "x", Value: "1", Type: "c", SampleRate: 0.5,
+			Tags: []string{"env:prod"}, ContainerID: "abc", ExternalData: "it-false,cn-app"}},
+		{line: "nocolon|c", fails: true},
+		{line: "notype:1", fails: true},
+		{line: ":1|c", fails: true},
+		{line: "x:|c", fails: true},
+		{line: "x:1|zz", fails: true},
+		{line: "x:abc|c", fails: true},
+		{line: "x:1|c|@2", fails: true},
+		{line: "x:1|c|junk", fails: true},
+	}
+	for _, tt := range tests {
+		m, err := ParseLine([]byte(tt.line))
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.expected, m) {
+			t.Errorf("%q: expected %+v, actual %+v", tt.line, tt.expected, m)
+		}
+	}
+}
+
+func TestParseDatagram(t *testing.T) {
+	metrics, err := ParseDatagram([]byte("a:1|c\nbroken\nb:2|g\n"))
+	if err == nil {
+		t.Error("expected an error for the malformed line")
+	}
+	if len(metrics) != 2 || metrics[0].Name != "a" || metrics[1].Name != "b" {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+	if metrics[1].IsDelta() {
+		t.Error("absolute gauge detected as a delta")
+	}
+	m, _ := ParseLine([]byte("b:+2|g"))
+	if !m.IsDelta() {
+		t.Error("gauge delta not detected")
+	}
+}
+
+func TestMetricKey(t *testing.T) {
+	m1, _ := ParseLine([]byte("x:1|c|#env:prod,db,region:eu"))
+	m2, _ := ParseLine([]byte("x:2|c|#region:eu,env:prod,db"))
+	if m1.Key() != m2.Key() || m1.Key() != `x,db=,env=prod,region=eu` {
+		t.Errorf("unexpected keys %q and %q", m1.Key(), m2.Key())
+	}
+}
+
+// randomName generates metric names made of the characters the client emits
+func randomName(r *rand.Rand) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789._-"
+	b := make([]byte, 1+r.Intn(30))
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// every line serialized by the event types must parse back to the same value
+func TestRoundTrip(t *testing.T) {
+	check := func(e event.Event, expected []float64) bool {
+		for i, line := range e.Stats() {
+			m, err := ParseLine([]byte(line))
+			if err != nil {
+				t.Log(err)
+				return false
+			}
+			v, err := m.Float()
+			if err != nil || v != expected[i] {
+				t.Logf("%q: expected %v, actual %v", line, expected[i], v)
+				return false
+			}
+		}
+		return true
+	}
+	r := rand.New(rand.NewSource(1))
+	f := func(i int64, fl float64) bool {
+		name := randomName(r)
+		// values are rendered with limited precision
+		fl, _ = strconv.ParseFloat(strconv.FormatFloat(fl, 'f', 3, 64), 64)
+		return check(&event.Increment{Name: name, Value: i}, []float64{float64(i)}) &&
+			check(&event.Total{Name: name, Value: i}, []float64{float64(i)}) &&
+			check(&event.Gauge{Name: name, Value: i}, gaugeValues(float64(i))) &&
+			check(&event.FGauge{Name: name, Value: fl}, gaugeValues(fl)) &&
+			check(&event.Absolute{Name: name, Values: []int64{i, -i}}, []float64{float64(i), float64(-i)}) &&
+			check(&event.FAbsolute{Name: name, Values: []float64{fl}}, []float64{fl})
+	}
+	if err := quick.Check(f, &quick.Config{Rand: r}); err != nil {
+		t.Error(err)
+	}
+}
+
+// negative gauges are sent as a reset to 0 followed by a negative delta
+func gaugeValues(v float64) []float64 {
+	if v < 0 {
+		return []float64{0, v}
+	}
+	return []float64{v}
+}
@@ -0,0 +1,112 @@
+package wire
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidName is returned by Validate when a string-valued field contains
+// characters reserved by the wire format
+var ErrInvalidName = errors.New("statsd: reserved characters in metric field")
+
+// Field identifies a string-valued field of a metric line:
+// each one has its own set of reserved characters
+type Field int
+
+// string-valued fields known to the serializer
+const (
+	FieldName Field = iota
+	FieldSetMember
+	FieldTagValue
+	FieldEventText
+)
+
+// reserved characters per field, and how they are escaped.
+// Newlines would split the packet into several (possibly forged) metrics,
+// '|' starts a new section of the line, ':' and ',' separate tags
+var escapers = map[Field]*strings.Replacer{
+	FieldName:      strings.NewReplacer("|", "_", "\n", "_", "\r", "_"),
+	FieldSetMember: strings.NewReplacer("|", "_", "\n", "_", "\r", "_", ":", "_"),
+	FieldTagValue:  strings.NewReplacer("|", "_", "\n", "_", "\r", "_", ",", "_", "#", "_"),
+	FieldEventText: strings.NewReplacer("|", "_", "\n", `\n`, "\r", ""),
+}
+
+var reserved = map[Field]string{
+	FieldName:      "|\n\r",
+	FieldSetMember: "|\n\r:",
+	FieldTagValue:  "|\n\r,#",
+	FieldEventText: "|\n\r",
+}
+
+// Escape replaces the characters reserved for the given field
+func Escape(field Field, s string) string {
+	if !strings.ContainsAny(s, reserved[field]) {
+		return s
+	}
+	return escapers[field].Replace(s)
+}
+
+// Validate returns ErrInvalidName if s contains characters reserved for the given field
+func Validate(field Field, s string) error {
+	if strings.ContainsAny(s, reserved[field]) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// lookalikes maps common unicode lookalikes of ASCII characters, which sneak into
+// names copied from documents and chat messages, to their ASCII counterpart
+var lookalikes = strings.NewReplacer(
+	"‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-", "−", "-",
+	"․", ".", "。", ".", "．", ".", "·", ".",
+	"＿", "_", "\u00a0", "_", "\u200b", "", "\ufeff", "",
+	// Cyrillic letters rendered like Latin ones
+	"а", "a", "е", "e", "о", "o", "р", "p", "с", "c", "х", "x",
+	"А", "a", "Е", "e", "О", "o", "Р", "p", "С", "c", "Х", "x",
+)
+
+// NormalizeName lowercases a stat name, replaces the common unicode lookalikes
+// and whitespace, collapses the runs of separators ('.', '-' and '_', which
+// whitespace becomes) and trims the leading and trailing ones, so that e.g.
+// "HTTP..Requests." becomes "http.requests" and "Queue  Depth__Orders"
+// becomes "queue_depth_orders". A run of different separators collapses into
+// the strongest one, '.' before '-' before '_': "a_.b" becomes "a.b"
+func NormalizeName(stat string) string {
+	stat = strings.ToLower(lookalikes.Replace(stat))
+	var b strings.Builder
+	b.Grow(len(stat))
+	var separator rune // of the current run, written before the next segment
+	for _, r := range stat {
+		if unicode.IsSpace(r) {
+			r = '_'
+		}
+		if rank := separatorRank(r); rank > 0 {
+			if rank > separatorRank(separator) {
+				separator = r
+			}
+			continue
+		}
+		if separator != 0 && b.Len() > 0 {
+			// the leading separators are skipped
+			b.WriteRune(separator)
+		}
+		separator = 0
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// separatorRank orders the separators NormalizeName collapses, 0 for the
+// other characters
+func separatorRank(r rune) int {
+	switch r {
+	case '.':
+		return 3
+	case '-':
+		return 2
+	case '_':
+		return 1
+	}
+	return 0
+}